@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/romrossi/authz-rebac/pkg/authz"
+	"github.com/romrossi/authz-rebac/pkg/db"
+)
+
+// runCheck evaluates a permission of a subject on a resource, and prints the evaluation as JSON.
+// It exits with exitOK if the permission is granted, and exitFalse if it is denied.
+func runCheck(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	showPaths := fs.Bool("show-paths", false, "Print the paths granting the permission")
+	explain := fs.Bool("explain", false, "Print the reasoning behind the result")
+	caveatContext := fs.String("caveat-context", "", "JSON object of the caveat parameters not set by relationships")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: authzctl check [flags] <resource> <permission> <subject>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitError
+	}
+	if fs.NArg() != 3 {
+		fs.Usage()
+		return exitError
+	}
+
+	// Validate arguments against the schema
+	meta := authz.LoadMetadata()
+	resource, permission, subject := parseObject(fs.Arg(0)), fs.Arg(1), parseObject(fs.Arg(2))
+	if err := meta.IsValidObject(resource); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid resource: %v\n", err)
+		return exitError
+	}
+	if err := meta.IsValidObject(subject); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid subject: %v\n", err)
+		return exitError
+	}
+	if err := meta.IsValidPermission(resource, permission); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid permission: %v\n", err)
+		return exitError
+	}
+	var caveatParams map[string]interface{}
+	if *caveatContext != "" {
+		if err := json.Unmarshal([]byte(*caveatContext), &caveatParams); err != nil || caveatParams == nil {
+			fmt.Fprintln(os.Stderr, "invalid caveat context: must be a JSON object")
+			return exitError
+		}
+	}
+
+	// Check the permission
+	connect()
+	service := authz.NewService(newRepository(), meta)
+	permissionCheck, _, err := service.CheckPermissions(context.Background(), authz.TraversalRequest{
+		StartOn:       resource,
+		Forward:       true,
+		StopOn:        subject,
+		CaveatContext: caveatParams,
+	}, authz.CheckOptions{
+		ShowMatchingPaths: *showPaths,
+		Explain:           *explain,
+		Permissions:       []string{permission},
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "check failed: %v\n", err)
+		return exitError
+	}
+
+	// No path between the resource and the subject: the permission is denied
+	var permissionEval authz.PermissionEval
+	if len(permissionCheck) > 0 {
+		permissionEval = permissionCheck[0].PermissionEvals[permission]
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(permissionEval); err != nil {
+		fmt.Fprintf(os.Stderr, "print failed: %v\n", err)
+		return exitError
+	}
+
+	if !permissionEval.Allowed {
+		return exitFalse
+	}
+	return exitOK
+}
+
+// parseObject parses a "type:id" argument.
+func parseObject(raw string) authz.Object {
+	objectType, id, _ := strings.Cut(raw, ":")
+	return authz.Object{Type: objectType, ID: id}
+}
+
+// newRepository creates the repository of the connected database driver.
+func newRepository() authz.AuthzRepository {
+	switch db.Driver {
+	case db.DriverMySQL:
+		return authz.NewMySQLRepository()
+	case db.DriverSQLite:
+		return authz.NewSQLiteRepository()
+	default:
+		return authz.NewPGRepository()
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/romrossi/authz-rebac/pkg/authz"
+)
+
+func TestCheck(t *testing.T) {
+	useSQLite(t,
+		relation("project:1", "owner", "group:eng"),
+		relation("group:eng", "member", "user:alice"),
+	)
+
+	tests := []struct {
+		args      []string
+		code      int
+		allowed   bool
+		withPaths bool
+	}{
+		{args: []string{"project:1", "edit", "user:alice"}, code: exitOK, allowed: true},
+		{args: []string{"--show-paths", "project:1", "edit", "user:alice"}, code: exitOK, allowed: true, withPaths: true},
+		{args: []string{"project:1", "edit", "user:bob"}, code: exitFalse},
+	}
+	for _, tt := range tests {
+		code, stdout, stderr := run(t, append([]string{"check"}, tt.args...)...)
+		if code != tt.code {
+			t.Errorf("check %v: exit code = %d, want %d (stderr: %s)", tt.args, code, tt.code, stderr)
+			continue
+		}
+		var eval authz.PermissionEval
+		if err := json.Unmarshal([]byte(stdout), &eval); err != nil {
+			t.Errorf("check %v: output is not a permission evaluation: %v (%s)", tt.args, err, stdout)
+			continue
+		}
+		if eval.Allowed != tt.allowed || (len(eval.MatchingPaths) == 1) != tt.withPaths {
+			t.Errorf("check %v: eval = %+v, want allowed %v with paths %v", tt.args, eval, tt.allowed, tt.withPaths)
+		}
+	}
+}
+
+func TestCheckExplain(t *testing.T) {
+	useSQLite(t,
+		relation("project:1", "owner", "user:alice"),
+		relation("project:1", "forbidden", "user:alice"),
+	)
+
+	code, stdout, _ := run(t, "check", "--explain", "project:1", "edit", "user:alice")
+	var eval authz.PermissionEval
+	if err := json.Unmarshal([]byte(stdout), &eval); err != nil || code != exitFalse {
+		t.Fatalf("exit code = %d, output = %s, want a denied evaluation", code, stdout)
+	}
+	if eval.Explanation == nil || eval.Explanation.ExcludedBy != "forbidden" {
+		t.Errorf("explanation = %+v, want excluded by forbidden", eval.Explanation)
+	}
+}
+
+func TestCheckInvalidArguments(t *testing.T) {
+	useSQLite(t)
+
+	tests := []struct {
+		args   []string
+		stderr string
+	}{
+		{args: []string{"project:1", "edit"}, stderr: "Usage: authzctl check"},
+		{args: []string{"folder:1", "edit", "user:alice"}, stderr: "invalid resource"},
+		{args: []string{"project:1", "edit", "robot:1"}, stderr: "invalid subject"},
+		{args: []string{"project:1", "fly", "user:alice"}, stderr: "invalid permission"},
+		{args: []string{"--caveat-context", "[]", "project:1", "edit", "user:alice"}, stderr: "invalid caveat context"},
+	}
+	for _, tt := range tests {
+		code, _, stderr := run(t, append([]string{"check"}, tt.args...)...)
+		if code != exitError || !strings.Contains(stderr, tt.stderr) {
+			t.Errorf("check %v: exit code = %d, stderr = %q, want %d and %q", tt.args, code, stderr, exitError, tt.stderr)
+		}
+	}
+}
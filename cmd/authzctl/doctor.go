@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/romrossi/authz-rebac/pkg/authz"
+)
+
+// runDoctor reports the stored relationships the schema rejects, as JSON, and deletes them with --fix.
+// The schema defaults to the embedded one, which the server uses. The exit code is exitFalse if invalid
+// relationships are left in the database.
+func runDoctor(args []string) int {
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	fix := fs.Bool("fix", false, "Delete the invalid relationships")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: authzctl doctor [flags] [schema]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitError
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return exitError
+	}
+
+	meta := authz.LoadMetadata()
+	if fs.NArg() == 1 {
+		var err error
+		if meta, err = readMetadata(fs.Arg(0)); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid schema: %v\n", err)
+			return exitError
+		}
+	}
+
+	connect()
+	service := authz.NewService(newRepository(), meta)
+	diagnosis, err := service.DiagnoseRelationships(context.Background(), *fix)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "doctor failed after %d relationship(s): %v\n", diagnosis.Scanned, err)
+		return exitError
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(diagnosis); err != nil {
+		fmt.Fprintf(os.Stderr, "print failed: %v\n", err)
+		return exitError
+	}
+	if len(diagnosis.Invalid) > 0 && !*fix {
+		return exitFalse
+	}
+	return exitOK
+}
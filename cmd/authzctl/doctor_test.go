@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/romrossi/authz-rebac/pkg/authz"
+)
+
+func TestDoctor(t *testing.T) {
+	useSQLite(t,
+		relation("project:1", "reader", "user:alice"),
+		relation("project:1", "admin", "user:bob"),       // unknown relation
+		relation("group:eng", "member", "application:1"), // subject type not allowed
+		relation("team:qa", "member", "user:carol"),      // unknown resource type
+		relation("project:1", "reader", "group:eng"),
+	)
+	before := strings.Join(stored(t), " ")
+
+	doctor := func(wantCode int, args ...string) authz.RelationshipDiagnosis {
+		t.Helper()
+		code, stdout, stderr := run(t, append([]string{"doctor"}, args...)...)
+		if code != wantCode {
+			t.Fatalf("exit code = %d, want %d (stderr: %s)", code, wantCode, stderr)
+		}
+		var diagnosis authz.RelationshipDiagnosis
+		if err := json.Unmarshal([]byte(stdout), &diagnosis); err != nil {
+			t.Fatalf("invalid report %q: %v", stdout, err)
+		}
+		return diagnosis
+	}
+
+	diagnosis := doctor(exitFalse)
+	want := "map[group#member@application:1 project#admin@user:1 team#member@user:1]"
+	if diagnosis.Fixed || diagnosis.Scanned != 5 || fmt.Sprint(diagnosis.Counts) != want || len(diagnosis.Invalid) != 3 {
+		t.Errorf("diagnosis = %+v, want 5 scanned and counts %s", diagnosis, want)
+	}
+	if invalid := diagnosis.Invalid[1]; invalid.Relationship.String() != "group:eng#member@application:1" ||
+		!strings.Contains(invalid.Error, `subject type "application" not allowed`) {
+		t.Errorf("invalid[1] = %+v, want the application member with its error (in key order)", invalid)
+	}
+	if after := strings.Join(stored(t), " "); after != before {
+		t.Errorf("relationships = %s without --fix, want unchanged %s", after, before)
+	}
+
+	diagnosis = doctor(exitOK, "--fix")
+	if !diagnosis.Fixed || len(diagnosis.Invalid) != 3 {
+		t.Errorf("fix = %+v, want the 3 invalid relationships deleted", diagnosis)
+	}
+	wantRels := "project:1#reader@group:eng project:1#reader@user:alice"
+	if got := strings.Join(stored(t), " "); got != wantRels {
+		t.Errorf("relationships = %s, want %s", got, wantRels)
+	}
+
+	// Once fixed, nothing is left to report
+	if diagnosis = doctor(exitOK); diagnosis.Scanned != 2 || len(diagnosis.Invalid) != 0 {
+		t.Errorf("diagnosis after fix = %+v, want 2 scanned and none invalid", diagnosis)
+	}
+
+	// Against another schema
+	diagnosis = doctor(exitFalse, writeFile(t, "schema.yaml", newSchema))
+	if len(diagnosis.Invalid) != 2 {
+		t.Errorf("diagnosis = %+v, want every relationship invalid in another schema", diagnosis)
+	}
+}
+
+func TestDoctorInvalidArguments(t *testing.T) {
+	useSQLite(t)
+	for _, args := range [][]string{
+		{"doctor", "a.yaml", "b.yaml"},
+		{"doctor", writeFile(t, "invalid.yaml", "objects: [")},
+	} {
+		if code, _, _ := run(t, args...); code != exitError {
+			t.Errorf("%v: exit code = %d, want %d", args, code, exitError)
+		}
+	}
+}
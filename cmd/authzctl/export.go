@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/romrossi/authz-rebac/pkg/authz"
+)
+
+// runExport writes all relationships as newline-delimited JSON (the format read by runImport),
+// to the given file or to the standard output.
+func runExport(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: authzctl export [file.jsonl]")
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitError
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return exitError
+	}
+
+	var out io.Writer = os.Stdout
+	if fs.NArg() == 1 {
+		file, err := os.Create(fs.Arg(0))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "create failed: %v\n", err)
+			return exitError
+		}
+		defer file.Close()
+		out = file
+	}
+	buf := bufio.NewWriter(out)
+
+	connect()
+	service := authz.NewService(newRepository(), authz.LoadMetadata())
+
+	count := 0
+	enc := json.NewEncoder(buf)
+	err := service.ExportRelationships(context.Background(), func(rel authz.Relationship) error {
+		count++
+		return enc.Encode(rel)
+	})
+	if err == nil {
+		err = buf.Flush()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "export failed: %v\n", err)
+		return exitError
+	}
+
+	fmt.Fprintf(os.Stderr, "%d relationship(s) exported\n", count)
+	return exitOK
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/romrossi/authz-rebac/pkg/authz"
+)
+
+func TestExportImportRoundTrip(t *testing.T) {
+	// More relationships than an export page, with an expiry and a caveat
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	relationships := []authz.Relationship{
+		{Resource: parseObject("project:1"), Relation: "reader", Subject: parseObject("user:alice"), ExpiresAt: &expiresAt},
+		{Resource: parseObject("project:1"), Relation: "contributor", Subject: parseObject("group:eng"),
+			Caveat: &authz.RelationshipCaveat{Name: "business_hours", Context: map[string]interface{}{"hour": 10.0}}},
+	}
+	for i := 0; i < 2500; i++ {
+		relationships = append(relationships, relation("group:eng", "member", "user:"+strconv.Itoa(i)))
+	}
+	useSQLite(t, relationships...)
+
+	dir := t.TempDir()
+	exported := filepath.Join(dir, "exported.jsonl")
+	code, _, stderr := run(t, "export", exported)
+	if code != exitOK || stderr != "2502 relationship(s) exported\n" {
+		t.Fatalf("export: exit code = %d, stderr = %q, want 2502 relationships exported", code, stderr)
+	}
+
+	useSQLite(t)
+	if code, stdout, stderr := run(t, "import", exported); code != exitOK || stdout != "2502 relationship(s) imported, 0 skipped (already exist)\n" {
+		t.Fatalf("import: exit code = %d, output = %q (stderr: %s), want 2502 imported", code, stdout, stderr)
+	}
+
+	reexported := filepath.Join(dir, "reexported.jsonl")
+	if code, _, stderr := run(t, "export", reexported); code != exitOK {
+		t.Fatalf("export of the import: exit code = %d (stderr: %s)", code, stderr)
+	}
+	want, _ := os.ReadFile(exported)
+	got, _ := os.ReadFile(reexported)
+	if string(got) != string(want) {
+		t.Errorf("export of the import differs from the original export")
+	}
+	if !strings.Contains(string(got), `"expires_at":"`+expiresAt.Format(time.RFC3339)+`"`) || !strings.Contains(string(got), `"business_hours"`) {
+		t.Errorf("export lost the expiry or the caveat:\n%.300s", got)
+	}
+}
+
+func TestExportToStdout(t *testing.T) {
+	useSQLite(t, relation("project:1", "reader", "user:alice"))
+
+	code, stdout, _ := run(t, "export")
+	want := `{"resource":"project:1","subject":"user:alice","relation":"reader"}` + "\n"
+	if code != exitOK || stdout != want {
+		t.Errorf("exit code = %d, output = %q, want %d and %q", code, stdout, exitOK, want)
+	}
+}
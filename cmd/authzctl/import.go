@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/romrossi/authz-rebac/pkg/actor"
+	"github.com/romrossi/authz-rebac/pkg/authz"
+	"github.com/romrossi/authz-rebac/pkg/db"
+)
+
+// importActor is recorded in the audit log for imported relationships.
+const importActor = "authzctl-import"
+
+// maxLineSize is the maximum size of a line of an import file.
+const maxLineSize = 1024 * 1024
+
+// runImport imports relationships from a newline-delimited JSON file, one Relationship per line.
+// The whole file is validated before any write, then imported in one transaction per batch.
+// Relationships which already exist are skipped.
+func runImport(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "Validate and count the relationships to import, without writing them")
+	batchSize := fs.Int("batch-size", 1000, "Number of relationships imported per transaction")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: authzctl import [flags] <file.jsonl>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitError
+	}
+	if fs.NArg() != 1 || *batchSize <= 0 {
+		fs.Usage()
+		return exitError
+	}
+
+	file, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "open failed: %v\n", err)
+		return exitError
+	}
+	defer file.Close()
+
+	// Step 1: Validate every relationship
+	meta := authz.LoadMetadata()
+	now := time.Now()
+	total := 0
+	invalid := 0
+	err = readRelationships(file, func(line int, rel authz.Relationship) error {
+		total++
+		if err := meta.IsValidRelation(rel); err != nil {
+			fmt.Fprintf(os.Stderr, "line %d: %v\n", line, err)
+			invalid++
+		} else if rel.ExpiresAt != nil && !rel.ExpiresAt.After(now) {
+			fmt.Fprintf(os.Stderr, "line %d: expires_at must be in the future: %s\n", line, rel)
+			invalid++
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "read failed: %v\n", err)
+		return exitError
+	}
+	if invalid > 0 {
+		fmt.Fprintf(os.Stderr, "%d invalid relationship(s), nothing imported\n", invalid)
+		return exitFalse
+	}
+
+	// Step 2: Import relationships by batch
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		fmt.Fprintf(os.Stderr, "read failed: %v\n", err)
+		return exitError
+	}
+	connect()
+	repo := newRepository()
+	service := authz.NewService(repo, meta)
+	ctx := actor.NewContext(context.Background(), importActor)
+
+	imported, skipped, processed := 0, 0, 0
+	importBatch := func(batch []authz.Relationship) error {
+		created, err := importRelationships(ctx, repo, service, batch, *dryRun)
+		if err != nil {
+			return err
+		}
+		imported += created
+		skipped += len(batch) - created
+		processed += len(batch)
+		fmt.Fprintf(os.Stderr, "%d/%d relationships processed\n", processed, total)
+		return nil
+	}
+
+	batch := make([]authz.Relationship, 0, *batchSize)
+	err = readRelationships(file, func(line int, rel authz.Relationship) error {
+		batch = append(batch, rel)
+		if len(batch) < *batchSize {
+			return nil
+		}
+		err := importBatch(batch)
+		batch = batch[:0]
+		return err
+	})
+	if err == nil && len(batch) > 0 {
+		err = importBatch(batch)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "import failed after %d relationship(s): %v\n", processed, err)
+		return exitError
+	}
+
+	if *dryRun {
+		fmt.Printf("dry run: %d relationship(s) would be imported, %d skipped (already exist)\n", imported, skipped)
+	} else {
+		fmt.Printf("%d relationship(s) imported, %d skipped (already exist)\n", imported, skipped)
+	}
+	return exitOK
+}
+
+// importRelationships creates the relationships of a batch which do not exist yet, in a single transaction,
+// and returns how many were (or would be, in a dry run) created.
+func importRelationships(
+	ctx context.Context,
+	repo authz.AuthzRepository,
+	service authz.AuthzService,
+	batch []authz.Relationship,
+	dryRun bool,
+) (int, error) {
+
+	var created []authz.Relationship
+	err := db.WithTransaction(ctx, func(txCtx context.Context) error {
+		found, err := repo.FindRelationships(txCtx, batch)
+		if err != nil {
+			return err
+		}
+		exists := make(map[string]bool, len(batch))
+		for _, rel := range found {
+			exists[rel.String()] = true
+		}
+
+		// Skip existing relationships, and duplicates within the batch
+		for _, rel := range batch {
+			if !exists[rel.String()] {
+				created = append(created, rel)
+				exists[rel.String()] = true
+			}
+		}
+
+		if dryRun || len(created) == 0 {
+			return nil
+		}
+		_, err = service.CreateRelationships(txCtx, created)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(created), nil
+}
+
+// readRelationships decodes each non-blank line of r as a Relationship, and calls fn with its line number.
+func readRelationships(r io.Reader, fn func(line int, rel authz.Relationship) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var rel authz.Relationship
+		if err := json.Unmarshal([]byte(text), &rel); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if err := fn(line, rel); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
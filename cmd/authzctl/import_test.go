@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/romrossi/authz-rebac/pkg/authz"
+	"github.com/romrossi/authz-rebac/pkg/db"
+)
+
+const importFile = `{"resource": "project:1", "relation": "reader", "subject": "user:alice"}
+
+{"resource": "project:1", "relation": "owner", "subject": "group:eng"}
+{"resource": "group:eng", "relation": "member", "subject": "user:bob"}
+{"resource": "project:1", "relation": "reader", "subject": "user:alice"}
+`
+
+func TestImport(t *testing.T) {
+	useSQLite(t, relation("group:eng", "member", "user:bob"))
+	path := writeFile(t, "relations.jsonl", importFile)
+
+	code, stdout, stderr := run(t, "import", "--batch-size", "2", path)
+	if code != exitOK {
+		t.Fatalf("exit code = %d, want %d (stderr: %s)", code, exitOK, stderr)
+	}
+	if want := "2 relationship(s) imported, 2 skipped (already exist)\n"; stdout != want {
+		t.Errorf("output = %q, want %q", stdout, want)
+	}
+	if !strings.Contains(stderr, "2/4 relationships processed") || !strings.Contains(stderr, "4/4 relationships processed") {
+		t.Errorf("stderr = %q, want the progress of each batch", stderr)
+	}
+	want := "group:eng#member@user:bob project:1#owner@group:eng project:1#reader@user:alice"
+	if got := strings.Join(stored(t), " "); got != want {
+		t.Errorf("relationships = %s, want %s", got, want)
+	}
+
+	// Imported relationships are recorded in the audit log on behalf of the import
+	connect()
+	defer db.DB.Close()
+	entries, err := authz.NewSQLiteRepository().ListAuditEntries(context.Background(), authz.AuditFilter{Resource: parseObject("project:1")})
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("audit entries = %v, %v, want 2", entries, err)
+	}
+	for _, entry := range entries {
+		if entry.Actor != importActor {
+			t.Errorf("audit entry actor = %q, want %q", entry.Actor, importActor)
+		}
+	}
+}
+
+func TestImportDryRun(t *testing.T) {
+	useSQLite(t)
+	path := writeFile(t, "relations.jsonl", importFile)
+
+	code, stdout, _ := run(t, "import", "--dry-run", path)
+	if want := "dry run: 3 relationship(s) would be imported, 1 skipped (already exist)\n"; code != exitOK || stdout != want {
+		t.Errorf("exit code = %d, output = %q, want %d and %q", code, stdout, exitOK, want)
+	}
+	if rels := stored(t); len(rels) != 0 {
+		t.Errorf("relationships = %v, want none after a dry run", rels)
+	}
+}
+
+func TestImportInvalid(t *testing.T) {
+	useSQLite(t)
+
+	tests := []struct {
+		name    string
+		content string
+		code    int
+		stderr  string
+	}{
+		{
+			name:    "relation not in the schema",
+			content: `{"resource": "project:1", "relation": "reader", "subject": "user:alice"}` + "\n" + `{"resource": "project:1", "relation": "admin", "subject": "user:bob"}`,
+			code:    exitFalse,
+			stderr:  "line 2: ",
+		},
+		{
+			name:    "expired relationship",
+			content: `{"resource": "project:1", "relation": "reader", "subject": "user:alice", "expires_at": "2000-01-01T00:00:00Z"}`,
+			code:    exitFalse,
+			stderr:  "line 1: expires_at must be in the future",
+		},
+		{
+			name:    "malformed JSON",
+			content: `{"resource": "project:1"` + "\n",
+			code:    exitError,
+			stderr:  "read failed: line 1: ",
+		},
+	}
+	for _, tt := range tests {
+		path := writeFile(t, "relations.jsonl", tt.content)
+		code, _, stderr := run(t, "import", path)
+		if code != tt.code || !strings.Contains(stderr, tt.stderr) {
+			t.Errorf("%s: exit code = %d, stderr = %q, want %d and %q", tt.name, code, stderr, tt.code, tt.stderr)
+		}
+	}
+	if rels := stored(t); len(rels) != 0 {
+		t.Errorf("relationships = %v, want none imported from invalid files", rels)
+	}
+}
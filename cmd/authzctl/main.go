@@ -0,0 +1,104 @@
+// Command authzctl is an operator tool to query the authorization graph from the command line.
+//
+// Usage:
+//
+//	authzctl [db flags] <command> [args]
+//
+// Commands:
+//
+//	check <resource> <permission> <subject>   evaluate a permission, e.g. check project:1 edit user:alice
+//	schema lint <file>                        validate a schema file
+//	import <file.jsonl>                       import relationships, one JSON object per line
+//	export [file.jsonl]                       export all relationships, one JSON object per line
+//	migrate                                   create or upgrade the database schema
+//	migrate-tuples <old-schema> [new-schema]  rewrite or delete the relationships orphaned by a schema change
+//	doctor [schema]                           report (or delete with --fix) the relationships the schema rejects
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/romrossi/authz-rebac/pkg/db"
+)
+
+// Exit codes
+const (
+	exitOK    = 0
+	exitFalse = 1 // the command ran, but its outcome is negative (e.g. permission denied)
+	exitError = 2
+)
+
+// commands maps command names to their implementation, which returns an exit code.
+var commands = map[string]func(args []string) int{
+	"check":          runCheck,
+	"schema":         runSchema,
+	"import":         runImport,
+	"export":         runExport,
+	"migrate":        runMigrate,
+	"migrate-tuples": runMigrateTuples,
+	"doctor":         runDoctor,
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	command, ok := commands[flag.Arg(0)]
+	if !ok {
+		usage()
+		os.Exit(exitError)
+	}
+	os.Exit(command(flag.Args()[1:]))
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `Usage: authzctl [db flags] <command> [args]
+
+Commands:
+  check <resource> <permission> <subject>   evaluate a permission, e.g. check project:1 edit user:alice
+  schema lint <file>                        validate a schema file
+  import <file.jsonl>                       import relationships, one JSON object per line
+  export [file.jsonl]                       export all relationships, one JSON object per line
+  migrate                                   create or upgrade the database schema
+  migrate-tuples <old-schema> [new-schema]  rewrite or delete the relationships orphaned by a schema change
+  doctor [schema]                           report (or delete with --fix) the relationships the schema rejects
+
+DB flags:
+`)
+	flag.PrintDefaults()
+}
+
+// Database flags, shared by the commands which need a connection
+var (
+	dbDriver   = flag.String("db-driver", envOrDefault("DB_DRIVER", db.DriverPostgres), "Database driver: postgres, mysql or sqlite")
+	dbHost     = flag.String("db-host", envOrFileOrDefault("DB_HOST", "localhost"), "Hostname for the database (or DB_HOST_FILE)")
+	dbPort     = flag.String("db-port", envOrDefault("DB_PORT", "5432"), "Port for the database")
+	dbName     = flag.String("db-name", envOrDefault("DB_NAME", "postgres"), "Name for the database (file path or :memory: with sqlite)")
+	dbUser     = flag.String("db-user", envOrFileOrDefault("DB_USER", "postgres"), "User for the database (or DB_USER_FILE)")
+	dbPassword = flag.String("db-password", envOrFileOrDefault("DB_PASSWORD", "mochigome"), "Password for the database (or DB_PASSWORD_FILE)")
+)
+
+// connect opens the database connection configured by the db flags.
+func connect() {
+	db.Connect(*dbDriver, *dbHost, *dbPort, *dbName, *dbUser, *dbPassword)
+}
+
+// envOrDefault checks for an environment variable, and if not found, uses a default value.
+func envOrDefault(envKey, defaultVal string) string {
+	if val, exists := os.LookupEnv(envKey); exists {
+		return val
+	}
+	return defaultVal
+}
+
+// envOrFileOrDefault reads a setting with db.EnvOrFile. An unreadable file stops the program.
+func envOrFileOrDefault(envKey, defaultVal string) string {
+	val, err := db.EnvOrFile(envKey, defaultVal)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitError)
+	}
+	return val
+}
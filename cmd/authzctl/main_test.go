@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/romrossi/authz-rebac/pkg/authz"
+	"github.com/romrossi/authz-rebac/pkg/db"
+)
+
+// useSQLite points the db flags to a new SQLite database file, seeded with relationships,
+// as every command opens its own connection (an in-memory database would be empty).
+func useSQLite(t *testing.T, relationships ...authz.Relationship) {
+	t.Helper()
+	driver, name := *dbDriver, *dbName
+	t.Cleanup(func() { *dbDriver, *dbName = driver, name })
+	*dbDriver, *dbName = db.DriverSQLite, filepath.Join(t.TempDir(), "authz.db")
+
+	connect()
+	defer db.DB.Close()
+	if _, err := authz.NewSQLiteRepository().InsertBulk(context.Background(), relationships, authz.ConflictIgnore); err != nil {
+		t.Fatalf("seed relationships failed: %v", err)
+	}
+}
+
+// run runs a command with its arguments, and returns its exit code along with what it printed.
+func run(t *testing.T, args ...string) (code int, stdout, stderr string) {
+	t.Helper()
+	capture := func(f **os.File) func() string {
+		tmp, err := os.CreateTemp(t.TempDir(), "out")
+		if err != nil {
+			t.Fatal(err)
+		}
+		saved := *f
+		*f = tmp
+		return func() string {
+			*f = saved
+			tmp.Seek(0, io.SeekStart)
+			out, _ := io.ReadAll(tmp)
+			tmp.Close()
+			return string(out)
+		}
+	}
+	restoreStdout, restoreStderr := capture(&os.Stdout), capture(&os.Stderr)
+	code = commands[args[0]](args[1:])
+	if db.DB != nil {
+		db.DB.Close()
+	}
+	return code, restoreStdout(), restoreStderr()
+}
+
+func relation(resource, relation, subject string) authz.Relationship {
+	return authz.Relationship{Resource: parseObject(resource), Relation: relation, Subject: parseObject(subject)}
+}
+
+// stored returns the relationships of the database configured by the db flags, as strings.
+func stored(t *testing.T) []string {
+	t.Helper()
+	connect()
+	defer db.DB.Close()
+	relationships, err := authz.NewSQLiteRepository().ListAllRelationships(context.Background(), nil, 1000)
+	if err != nil {
+		t.Fatalf("list relationships failed: %v", err)
+	}
+	var rels []string
+	for _, rel := range relationships {
+		rels = append(rels, rel.String())
+	}
+	sort.Strings(rels)
+	return rels
+}
+
+// writeFile writes a file in a temporary directory, and returns its path.
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/romrossi/authz-rebac/pkg/db"
+)
+
+// runMigrate creates or upgrades the database schema, by applying the pending migrations.
+func runMigrate(args []string) int {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: authzctl migrate")
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitError
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return exitError
+	}
+
+	connect()
+	if err := db.Migrate(context.Background()); err != nil {
+		fmt.Fprintf(os.Stderr, "migrate failed: %v\n", err)
+		return exitError
+	}
+	fmt.Fprintln(os.Stderr, "database schema is up to date")
+	return exitOK
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/romrossi/authz-rebac/pkg/authz"
+)
+
+// oldSchema is the schema the relationships of the migration tests were written with.
+const oldSchema = `
+schema_version: "1.0"
+objects:
+  user:
+    relations: {}
+  team:
+    relations: {}
+  doc:
+    relations:
+      owner:
+        subject_types: [user]
+      commenter:
+        subject_types: [user]
+      viewer:
+        subject_types: [user, team]
+    permissions:
+      view:
+        any_of: [owner, commenter, viewer]
+`
+
+// newSchema renames owner to admin, keeping owner as an alias, and removes the commenter relation and the team type.
+const newSchema = `
+schema_version: "1.1"
+objects:
+  user:
+    relations: {}
+  doc:
+    relations:
+      admin:
+        subject_types: [user]
+      viewer:
+        subject_types: [user]
+    aliases:
+      owner: admin
+    permissions:
+      view:
+        any_of: [admin, viewer]
+`
+
+// migrateTuplesSeed has a relationship to rename, two to delete, one to keep and one defined by neither schema.
+var migrateTuplesSeed = []authz.Relationship{
+	relation("doc:1", "owner", "user:alice"),
+	relation("doc:1", "commenter", "user:bob"),
+	relation("doc:1", "viewer", "team:qa"),
+	relation("doc:1", "viewer", "user:carol"),
+	relation("doc:1", "stray", "user:dan"),
+}
+
+func TestMigrateTuples(t *testing.T) {
+	useSQLite(t, migrateTuplesSeed...)
+	oldPath, newPath := writeFile(t, "old.yaml", oldSchema), writeFile(t, "new.yaml", newSchema)
+	before := strings.Join(stored(t), " ")
+
+	migrate := func(args ...string) authz.TupleMigration {
+		t.Helper()
+		code, stdout, stderr := run(t, append([]string{"migrate-tuples"}, args...)...)
+		if code != exitOK {
+			t.Fatalf("exit code = %d, want %d (stderr: %s)", code, exitOK, stderr)
+		}
+		var migration authz.TupleMigration
+		if err := json.Unmarshal([]byte(stdout), &migration); err != nil {
+			t.Fatalf("invalid report %q: %v", stdout, err)
+		}
+		return migration
+	}
+	counts := func(m authz.TupleMigration) string {
+		return fmt.Sprint(m.Renamed, m.Deleted, m.Unknown)
+	}
+	want := "map[doc#owner->admin:1] map[doc#commenter:1 doc#viewer@team:1] map[doc#stray:1]"
+
+	migration := migrate("--dry-run", oldPath, newPath)
+	if !migration.DryRun || migration.Scanned != 5 || counts(migration) != want {
+		t.Errorf("dry run = %+v, want 5 scanned and counts %s", migration, want)
+	}
+	if after := strings.Join(stored(t), " "); after != before {
+		t.Errorf("relationships = %s after a dry run, want unchanged %s", after, before)
+	}
+
+	migration = migrate(oldPath, newPath)
+	if migration.DryRun || migration.Scanned != 5 || counts(migration) != want {
+		t.Errorf("migration = %+v, want 5 scanned and counts %s", migration, want)
+	}
+	wantRels := "doc:1#admin@user:alice doc:1#stray@user:dan doc:1#viewer@user:carol"
+	if got := strings.Join(stored(t), " "); got != wantRels {
+		t.Errorf("relationships = %s, want %s", got, wantRels)
+	}
+
+	// Once migrated, only the relationship defined by neither schema is left as an orphan
+	migration = migrate("--dry-run", oldPath, newPath)
+	if want := "map[] map[] map[doc#stray:1]"; migration.Scanned != 3 || counts(migration) != want {
+		t.Errorf("second dry run = %+v, want 3 scanned and counts %s", migration, want)
+	}
+}
+
+func TestMigrateTuplesInvalidArguments(t *testing.T) {
+	useSQLite(t)
+	invalid := writeFile(t, "invalid.yaml", "objects: [")
+	for _, args := range [][]string{
+		{"migrate-tuples"},
+		{"migrate-tuples", "a.yaml", "b.yaml", "c.yaml"},
+		{"migrate-tuples", invalid},
+		{"migrate-tuples", writeFile(t, "old.yaml", oldSchema), invalid},
+	} {
+		if code, _, _ := run(t, args...); code != exitError {
+			t.Errorf("%v: exit code = %d, want %d", args, code, exitError)
+		}
+	}
+}
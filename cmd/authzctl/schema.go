@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/romrossi/authz-rebac/pkg/authz"
+	"gopkg.in/yaml.v3"
+)
+
+// runSchema dispatches the schema subcommands.
+func runSchema(args []string) int {
+	if len(args) == 0 || args[0] != "lint" {
+		fmt.Fprintln(os.Stderr, "Usage: authzctl schema lint <file>")
+		return exitError
+	}
+	return runSchemaLint(args[1:])
+}
+
+// runSchemaLint validates a schema file, and prints every error found with its line.
+// It exits with exitOK if the schema is valid, and exitFalse otherwise.
+func runSchemaLint(args []string) int {
+	fs := flag.NewFlagSet("schema lint", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: authzctl schema lint <file>")
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitError
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitError
+	}
+	file := fs.Arg(0)
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "read failed: %v\n", err)
+		return exitError
+	}
+
+	// Parse as a node tree first, to locate errors
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		fmt.Printf("%s: %v\n", file, err)
+		return exitFalse
+	}
+	var meta authz.Metadata
+	if err := root.Decode(&meta); err != nil {
+		fmt.Printf("%s: %v\n", file, err)
+		return exitFalse
+	}
+
+	err = meta.Validate()
+	var schemaErrs authz.SchemaErrors
+	if !errors.As(err, &schemaErrs) {
+		fmt.Printf("%s: OK\n", file)
+		return exitOK
+	}
+
+	lines := strings.Split(string(data), "\n")
+	for _, schemaErr := range schemaErrs {
+		line := findLine(&root, schemaErr.Path)
+		fmt.Printf("%s:%d: %v\n", file, line, schemaErr)
+		if line > 0 && line <= len(lines) {
+			fmt.Printf("    %s\n", strings.TrimSpace(lines[line-1]))
+		}
+	}
+	fmt.Printf("%s: %d error(s)\n", file, len(schemaErrs))
+	return exitFalse
+}
+
+// findLine returns the line of the node at the given path, or of its closest existing ancestor.
+func findLine(node *yaml.Node, path []string) int {
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	line := node.Line
+	for _, key := range path {
+		var next *yaml.Node
+		switch node.Kind {
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == key {
+					line = node.Content[i].Line
+					next = node.Content[i+1]
+					break
+				}
+			}
+		case yaml.SequenceNode:
+			if i, err := strconv.Atoi(key); err == nil && i >= 0 && i < len(node.Content) {
+				next = node.Content[i]
+				line = next.Line
+			}
+		}
+		if next == nil {
+			break
+		}
+		node = next
+	}
+	return line
+}
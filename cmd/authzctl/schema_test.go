@@ -0,0 +1,94 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSchemaLintValid(t *testing.T) {
+	code, stdout, _ := run(t, "schema", "lint", "../../pkg/authz/schema.yaml")
+	if code != exitOK || !strings.HasSuffix(stdout, ": OK\n") {
+		t.Errorf("exit code = %d, output = %q, want OK", code, stdout)
+	}
+}
+
+func TestSchemaLintErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		want   []string // lines expected in the output, after the file name
+	}{
+		{
+			name: "undefined relation in any_of",
+			schema: `schema_version: "1.0"
+objects:
+  user:
+    relations: {}
+  doc:
+    relations:
+      owner:
+        subject_types: [user]
+    permissions:
+      edit:
+        any_of: [owner, editor]
+`,
+			want: []string{":11: ", "any_of: [owner, editor]", ": 1 error(s)"},
+		},
+		{
+			name: "unknown subject type",
+			schema: `schema_version: "1.0"
+objects:
+  user:
+    relations: {}
+  doc:
+    relations:
+      owner:
+        subject_types: [user, robot]
+`,
+			want: []string{":8: ", "robot", ": 1 error(s)"},
+		},
+		{
+			name: "unknown precedence rule and relation",
+			schema: `schema_version: "1.0"
+objects:
+  user:
+    relations: {}
+  doc:
+    relations:
+      owner:
+        subject_types: [user]
+    precedence_rules:
+      - rule: path_shorter
+        relation: owner
+      - rule: path_with
+        relation: editor
+`,
+			want: []string{":10: ", ":13: ", ": 2 error(s)"},
+		},
+		{
+			name:   "invalid YAML",
+			schema: "objects: [\n",
+			want:   []string{"yaml:"},
+		},
+	}
+	for _, tt := range tests {
+		path := writeFile(t, "schema.yaml", tt.schema)
+		code, stdout, _ := run(t, "schema", "lint", path)
+		if code != exitFalse {
+			t.Errorf("%s: exit code = %d, want %d", tt.name, code, exitFalse)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(stdout, want) {
+				t.Errorf("%s: output does not contain %q:\n%s", tt.name, want, stdout)
+			}
+		}
+	}
+}
+
+func TestSchemaLintUsage(t *testing.T) {
+	for _, args := range [][]string{{"schema"}, {"schema", "check"}, {"schema", "lint"}, {"schema", "lint", "missing.yaml"}} {
+		if code, _, _ := run(t, args...); code != exitError {
+			t.Errorf("%v: exit code = %d, want %d", args, code, exitError)
+		}
+	}
+}
@@ -1,52 +1,203 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
-	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/romrossi/authz-rebac/pkg/authz"
 	"github.com/romrossi/authz-rebac/pkg/db"
+	"github.com/romrossi/authz-rebac/pkg/openapi"
 	"github.com/romrossi/authz-rebac/pkg/router"
 )
 
 func main() {
 	// Args
+	var dbDriver string
 	var dbHost string
 	var dbPort string
 	var dbName string
 	var dbUser string
 	var dbPassword string
-	flag.StringVar(&dbHost, "db-host", envOrDefault("DB_HOST", "localhost"), "Hostname for the database")
+	var maxBatchSize int
+	var maxBodyBytes int
+	var readAPIKeys string
+	var writeAPIKeys string
+	var rateLimitRPS int
+	var rateLimitBurst int
+	var expirySweepInterval time.Duration
+	var expiryGrace time.Duration
+	var dbQueryTimeout time.Duration
+	var dbBreakerThreshold int
+	var dbBreakerCooldown time.Duration
+	var requestTimeout time.Duration
+	var migrate bool
+	var schemaDir string
+	var listenAddr string
+	var tlsCert string
+	var tlsKey string
+	var tlsMinVersion string
+	var objectFormat string
+	flag.StringVar(&dbDriver, "db-driver", envOrDefault("DB_DRIVER", db.DriverPostgres), "Database driver: postgres, mysql or sqlite")
+	flag.StringVar(&dbHost, "db-host", envOrFileOrDefault("DB_HOST", "localhost"), "Hostname for the database (or DB_HOST_FILE)")
 	flag.StringVar(&dbPort, "db-port", envOrDefault("DB_PORT", "5432"), "Port for the database")
-	flag.StringVar(&dbName, "db-name", envOrDefault("DB_NAME", "postgres"), "Name for the database")
-	flag.StringVar(&dbUser, "db-user", envOrDefault("DB_USER", "postgres"), "User for the database")
-	flag.StringVar(&dbPassword, "db-password", envOrDefault("DB_PASSWORD", "mochigome"), "Password for the database")
+	flag.StringVar(&dbName, "db-name", envOrDefault("DB_NAME", "postgres"), "Name for the database (file path or :memory: with sqlite)")
+	flag.StringVar(&dbUser, "db-user", envOrFileOrDefault("DB_USER", "postgres"), "User for the database (or DB_USER_FILE)")
+	flag.StringVar(&dbPassword, "db-password", envOrFileOrDefault("DB_PASSWORD", "mochigome"), "Password for the database (or DB_PASSWORD_FILE)")
+	flag.DurationVar(&dbQueryTimeout, "db-query-timeout", envOrDefaultDuration("DB_QUERY_TIMEOUT", 30*time.Second), "Maximum duration of a database operation (0 = unlimited)")
+	flag.IntVar(&dbBreakerThreshold, "db-breaker-threshold", envOrDefaultInt("DB_BREAKER_THRESHOLD", 5), "Consecutive failed database operations suspending the next ones (0 = never)")
+	flag.DurationVar(&dbBreakerCooldown, "db-breaker-cooldown", envOrDefaultDuration("DB_BREAKER_COOLDOWN", 10*time.Second), "Duration database operations stay suspended before one is tried again")
+	flag.DurationVar(&requestTimeout, "request-timeout", envOrDefaultDuration("REQUEST_TIMEOUT", time.Minute), "Maximum duration of a request, except streams (0 = unlimited)")
+	flag.BoolVar(&migrate, "migrate", envOrDefaultBool("DB_MIGRATE", false), "Apply pending database migrations on startup")
+	flag.IntVar(&maxBatchSize, "max-batch-size", envOrDefaultInt("MAX_BATCH_SIZE", 10000), "Maximum number of relationships per write request (0 = unlimited)")
+	flag.IntVar(&maxBodyBytes, "max-body-bytes", envOrDefaultInt("MAX_BODY_BYTES", 10<<20), "Maximum size of a request body in bytes (0 = unlimited)")
+	flag.StringVar(&readAPIKeys, "read-api-keys", envOrDefault("READ_API_KEYS", ""), "Comma-separated API keys allowed on read endpoints")
+	flag.StringVar(&writeAPIKeys, "write-api-keys", envOrDefault("WRITE_API_KEYS", ""), "Comma-separated API keys allowed on all endpoints, including writes")
+	flag.IntVar(&rateLimitRPS, "rate-limit-rps", envOrDefaultInt("RATE_LIMIT_RPS", 0), "Requests per second allowed per client (0 = unlimited)")
+	flag.IntVar(&rateLimitBurst, "rate-limit-burst", envOrDefaultInt("RATE_LIMIT_BURST", 20), "Burst of requests allowed per client above the rate limit")
+	flag.DurationVar(&expirySweepInterval, "expiry-sweep-interval", envOrDefaultDuration("EXPIRY_SWEEP_INTERVAL", time.Hour), "Interval between deletions of expired relationships")
+	flag.DurationVar(&expiryGrace, "expiry-grace", envOrDefaultDuration("EXPIRY_GRACE", 24*time.Hour), "Delay after expiry before a relationship is deleted")
+	flag.StringVar(&schemaDir, "schema-dir", envOrDefault("SCHEMA_DIR", ""), "Directory of YAML schema files merged into one schema (default: embedded schema.yaml)")
+	flag.StringVar(&listenAddr, "listen-addr", envOrDefault("LISTEN_ADDR", ":8080"), "Address the server listens on, as [host]:port")
+	flag.StringVar(&tlsCert, "tls-cert", envOrDefault("TLS_CERT", ""), "TLS certificate file, to serve HTTPS (requires -tls-key)")
+	flag.StringVar(&tlsKey, "tls-key", envOrDefault("TLS_KEY", ""), "TLS private key file, to serve HTTPS (requires -tls-cert)")
+	flag.StringVar(&tlsMinVersion, "tls-min-version", envOrDefault("TLS_MIN_VERSION", "1.2"), "Minimum TLS version accepted with HTTPS: 1.2 or 1.3")
+	flag.StringVar(&objectFormat, "object-format", envOrDefault("OBJECT_FORMAT", authz.ObjectFormatCompact), "JSON form of objects in responses: compact (\"type:id\") or expanded ({\"type\", \"id\"})")
 	flag.Parse()
 
+	// Serialize objects in the configured form (requests are accepted in both)
+	if err := authz.SetObjectFormat(objectFormat); err != nil {
+		log.Fatal("config error:", err)
+	}
+
 	// Setup DB connection
-	db.Connect(dbHost, dbPort, dbName, dbUser, dbPassword)
+	db.Connect(dbDriver, dbHost, dbPort, dbName, dbUser, dbPassword)
+	if migrate {
+		if err := db.Migrate(context.Background()); err != nil {
+			log.Fatal("db migration error:", err)
+		}
+	}
+	if err := db.VerifySchema(context.Background()); err != nil {
+		log.Fatal("db schema error:", err)
+	}
 
 	// Initialize Authz metadata, repo, service, handler
 	meta := authz.LoadMetadata()
-	authzRepo := authz.NewPGRepository()
+	if schemaDir != "" {
+		var err error
+		if meta, err = authz.LoadMetadataDir(schemaDir); err != nil {
+			log.Fatal("schema error:", err)
+		}
+	}
+	var authzRepo authz.AuthzRepository
+	switch dbDriver {
+	case db.DriverMySQL:
+		authzRepo = authz.NewMySQLRepository()
+	case db.DriverSQLite:
+		authzRepo = authz.NewSQLiteRepository()
+	default:
+		authzRepo = authz.NewPGRepository()
+	}
+	if dbQueryTimeout > 0 {
+		authzRepo = authz.NewTimeoutRepository(authzRepo, dbQueryTimeout)
+	}
+	if dbBreakerThreshold > 0 {
+		// Outside the timeouts, so that they count as failures
+		authzRepo = authz.NewBreakerRepository(authzRepo, dbBreakerThreshold, dbBreakerCooldown)
+	}
 	authzService := authz.NewService(authzRepo, meta)
-	authzHandler := authz.NewAuthzHandler(authzService, meta)
+	authzHandler := authz.NewAuthzHandler(authzService, meta, maxBatchSize)
+
+	// Start background deletion of expired relationships
+	go authz.RunExpirySweeper(context.Background(), authzRepo, expirySweepInterval, expiryGrace)
 
 	// Initialize HTTP router
 	v1Prefix := "/api/v1"
 	r := router.NewRouter()
 
+	// Log one line per routed request, with the status and size of the response as sent
+	r.AddGlobalMiddleware(router.AccessLog(log.Default()))
+
+	// Compress large responses (e.g. with matching paths) for clients accepting gzip
+	r.AddGlobalMiddleware(router.Gzip())
+
+	// Respond 500 to requests whose handler panics, instead of dropping the connection
+	// (inside compression, which would otherwise send an empty response while unwinding)
+	r.AddGlobalMiddleware(router.Recover())
+
+	// Reject request bodies over the size limit with 413, before they are read into memory
+	if maxBodyBytes > 0 {
+		r.AddGlobalMiddleware(router.MaxBodyBytes(int64(maxBodyBytes)))
+	}
+
+	// Setup rate limiting per valid API key (or client IP, for requests without one, and without authentication)
+	readKeys, writeKeys := splitList(readAPIKeys), splitList(writeAPIKeys)
+	if rateLimitRPS > 0 {
+		r.AddGlobalMiddleware(router.RateLimit(float64(rateLimitRPS), rateLimitBurst, router.APIKeyKey(append(readKeys, writeKeys...)...)))
+	}
+
+	// Setup API key authentication:
+	// - read and write keys are accepted on every endpoint
+	// - only write keys are accepted on write endpoints
+	var writeAuth []router.Middleware
+	if len(readKeys) > 0 || len(writeKeys) > 0 {
+		r.AddGlobalMiddleware(router.APIKeyAuth(append(readKeys, writeKeys...)...))
+		writeAuth = append(writeAuth, router.APIKeyAuth(writeKeys...))
+	} else {
+		log.Println("[WARN] No API keys configured: authentication is disabled")
+	}
+
+	// Identify the actor of each request (recorded in the audit log)
+	r.AddGlobalMiddleware(router.Actor(router.APIKeyActor))
+
+	// Respond 503 to requests running longer than the timeout, cancelling their database queries
+	// (except streams, which last as long as their client listens)
+	var timeout []router.Middleware
+	if requestTimeout > 0 {
+		timeout = append(timeout, router.Timeout(requestTimeout))
+	}
+
 	// Register routes
-	r.Handle("GET", v1Prefix+"/permissions/{permission}", authzHandler.CheckPermission())
-	r.Handle("GET", v1Prefix+"/permissions", authzHandler.CheckPermissions())
-	r.Handle("GET", v1Prefix+"/resources/{resource}/relations", authzHandler.ListResourceRelations())
-	r.Handle("POST", v1Prefix+"/relations", authzHandler.ManageRelationships())
+	r.Handle("GET", v1Prefix+"/permissions/{permission}", authzHandler.CheckPermission(), timeout...)
+	r.Handle("GET", v1Prefix+"/permissions/{permission}/count", authzHandler.CountPermission(), timeout...)
+	r.Handle("GET", v1Prefix+"/permissions", authzHandler.CheckPermissions(), timeout...)
+	r.Handle("POST", v1Prefix+"/permissions:warm", authzHandler.WarmPaths(), timeout...)
+	r.Handle("POST", v1Prefix+"/permissions/{permission}:checkSubjects", authzHandler.CheckSubjects(), timeout...)
+	r.Handle("GET", v1Prefix+"/paths", authzHandler.ListPaths(), timeout...)
+	r.Handle("GET", v1Prefix+"/resources/{resource}/relations", authzHandler.ListResourceRelations(), timeout...)
+	r.Handle("GET", v1Prefix+"/resources/{resource}/relations/count", authzHandler.CountResourceRelations(), timeout...)
+	r.Handle("GET", v1Prefix+"/resources/{resource}/relations/{relation}/subjects", authzHandler.ListResourceSubjects(), timeout...)
+	r.Handle("GET", v1Prefix+"/subjects/{subject}/resources", authzHandler.ListSubjectResources(), timeout...)
+	r.Handle("POST", v1Prefix+"/relations", authzHandler.ManageRelationships(), append(writeAuth, timeout...)...)
+	r.Handle("GET", v1Prefix+"/relations/watch", authzHandler.WatchRelations())
+	r.Handle("GET", v1Prefix+"/relations/export", authzHandler.ExportRelations())
+	r.Handle("GET", v1Prefix+"/relations/{relation}/check", authzHandler.CheckRelation(), timeout...)
+	r.Handle("GET", v1Prefix+"/audit", authzHandler.ListAuditEntries(), timeout...)
+	r.Handle("GET", v1Prefix+"/schema/objects", authzHandler.ListObjectTypes(), timeout...)
+	r.Handle("GET", v1Prefix+"/schema/objects/{type}", authzHandler.GetObjectDefinition(), timeout...)
+	r.Handle("GET", v1Prefix+"/schema/objects/{type}/relations/{relation}", authzHandler.GetRelationDefinition(), timeout...)
+	r.Handle("POST", v1Prefix+"/schema/validate", authzHandler.ValidateSchema(), timeout...)
+	r.Handle("GET", "/openapi.json", openapi.Handler())
 
 	// Start HTTP server
-	log.Println("Server started on :8080")
-	log.Fatal(http.ListenAndServe(":8080", r))
+	srv, err := newServer(listenAddr, r)
+	if err != nil {
+		log.Fatal("server error:", err)
+	}
+	useTLS, err := configureTLS(srv, tlsCert, tlsKey, tlsMinVersion)
+	if err != nil {
+		log.Fatal("server error:", err)
+	}
+	if useTLS {
+		log.Printf("Server started on %s (HTTPS)", srv.Addr)
+		log.Fatal(srv.ListenAndServeTLS(tlsCert, tlsKey))
+	}
+	log.Printf("Server started on %s", srv.Addr)
+	log.Fatal(srv.ListenAndServe())
 }
 
 // envOrDefault checks for an environment variable, and if not found, uses a default value.
@@ -56,3 +207,56 @@ func envOrDefault(envKey, defaultVal string) string {
 	}
 	return defaultVal
 }
+
+// envOrFileOrDefault reads a setting with db.EnvOrFile. An unreadable file stops the program.
+func envOrFileOrDefault(envKey, defaultVal string) string {
+	val, err := db.EnvOrFile(envKey, defaultVal)
+	if err != nil {
+		log.Fatalf("config error: %v", err)
+	}
+	return val
+}
+
+// envOrDefaultInt checks for an integer environment variable, and if not found or invalid, uses a default value.
+func envOrDefaultInt(envKey string, defaultVal int) int {
+	if val, exists := os.LookupEnv(envKey); exists {
+		if i, err := strconv.Atoi(val); err == nil {
+			return i
+		}
+		log.Printf("[WARN] invalid integer for %s: %q, using default %d", envKey, val, defaultVal)
+	}
+	return defaultVal
+}
+
+// envOrDefaultBool checks for a boolean environment variable, and if not found or invalid, uses a default value.
+func envOrDefaultBool(envKey string, defaultVal bool) bool {
+	if val, exists := os.LookupEnv(envKey); exists {
+		if b, err := strconv.ParseBool(val); err == nil {
+			return b
+		}
+		log.Printf("[WARN] invalid boolean for %s: %q, using default %t", envKey, val, defaultVal)
+	}
+	return defaultVal
+}
+
+// splitList splits a comma-separated list, ignoring blank items.
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// envOrDefaultDuration checks for a duration environment variable, and if not found or invalid, uses a default value.
+func envOrDefaultDuration(envKey string, defaultVal time.Duration) time.Duration {
+	if val, exists := os.LookupEnv(envKey); exists {
+		if d, err := time.ParseDuration(val); err == nil {
+			return d
+		}
+		log.Printf("[WARN] invalid duration for %s: %q, using default %s", envKey, val, defaultVal)
+	}
+	return defaultVal
+}
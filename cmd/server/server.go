@@ -0,0 +1,51 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// newServer returns an HTTP server serving handler on addr, given as "[host]:port" (e.g. ":8080", "127.0.0.1:9000").
+func newServer(addr string, handler http.Handler) (*http.Server, error) {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid listen address %q: %w", addr, err)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return nil, fmt.Errorf("invalid listen address %q: port must be a number between 0 and 65535", addr)
+	}
+	return &http.Server{Addr: addr, Handler: handler}, nil
+}
+
+// tlsVersions are the accepted minimum TLS versions.
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// configureTLS sets the minimum TLS version ("1.2" or "1.3") of a server serving HTTPS with the given certificate
+// and key files, which must both be set, and be loadable. It returns false if neither is set: the server then
+// serves plain HTTP.
+func configureTLS(srv *http.Server, certFile, keyFile, minVersion string) (bool, error) {
+	if certFile == "" && keyFile == "" {
+		return false, nil
+	}
+	if certFile == "" {
+		return false, fmt.Errorf("a TLS key requires a TLS certificate")
+	}
+	if keyFile == "" {
+		return false, fmt.Errorf("a TLS certificate requires a TLS key")
+	}
+	version, ok := tlsVersions[minVersion]
+	if !ok {
+		return false, fmt.Errorf("invalid minimum TLS version %q: must be 1.2 or 1.3", minVersion)
+	}
+	if _, err := tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+		return false, fmt.Errorf("invalid TLS certificate or key: %w", err)
+	}
+	srv.TLSConfig = &tls.Config{MinVersion: version}
+	return true, nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/romrossi/authz-rebac/pkg/authz"
+	"github.com/romrossi/authz-rebac/pkg/db"
+	"github.com/romrossi/authz-rebac/pkg/router"
+)
+
+func TestNewServer(t *testing.T) {
+	hello := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "hello") })
+	srv, err := newServer("127.0.0.1:0", hello)
+	if err != nil {
+		t.Fatalf("newServer() failed: %v", err)
+	}
+	if srv.Addr != "127.0.0.1:0" {
+		t.Errorf("Addr = %q, want the configured address", srv.Addr)
+	}
+
+	// The server serves the handler on the address
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		t.Fatalf("listen on %s failed: %v", srv.Addr, err)
+	}
+	go srv.Serve(ln)
+	defer srv.Close()
+	resp, err := http.Get("http://" + ln.Addr().String())
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "hello" {
+		t.Errorf("body = %q, want hello", body)
+	}
+
+	for _, addr := range []string{"8080", "localhost", ":http", ":70000", "127.0.0.1:-1"} {
+		if _, err := newServer(addr, hello); err == nil {
+			t.Errorf("newServer(%q) = nil error, want an invalid address", addr)
+		}
+	}
+	for _, addr := range []string{":8080", "0.0.0.0:9000", "[::1]:8080"} {
+		if _, err := newServer(addr, hello); err != nil {
+			t.Errorf("newServer(%q) = %v, want nil", addr, err)
+		}
+	}
+}
+
+// writeSelfSignedCert writes a self-signed certificate for 127.0.0.1 and its key into a temporary directory,
+// and returns their files along with the certificate.
+func writeSelfSignedCert(t *testing.T) (certFile, keyFile string, cert *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "authz-test"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile, cert
+}
+
+func TestServeHTTPS(t *testing.T) {
+	db.Connect(db.DriverSQLite, "", "", ":memory:", "", "")
+	defer db.DB.Close()
+	repo := authz.NewSQLiteRepository()
+	if _, err := repo.InsertBulk(context.Background(), []authz.Relationship{{
+		Resource: authz.Object{Type: "project", ID: "1"}, Relation: "reader", Subject: authz.Object{Type: "user", ID: "alice"},
+	}}, authz.ConflictIgnore); err != nil {
+		t.Fatal(err)
+	}
+	meta := authz.LoadMetadata()
+	h := authz.NewAuthzHandler(authz.NewService(repo, meta), meta, 0)
+	r := router.NewRouter()
+	r.Handle("GET", "/api/v1/permissions/{permission}", h.CheckPermission())
+
+	certFile, keyFile, cert := writeSelfSignedCert(t)
+	srv, err := newServer("127.0.0.1:0", r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useTLS, err := configureTLS(srv, certFile, keyFile, "1.3")
+	if err != nil || !useTLS {
+		t.Fatalf("configureTLS() = %v, %v, want TLS", useTLS, err)
+	}
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.ServeTLS(ln, certFile, keyFile)
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	resp, err := client.Get("https://" + ln.Addr().String() + "/api/v1/permissions/read?resource=project:1&subject=user:alice")
+	if err != nil {
+		t.Fatalf("HTTPS check failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var eval authz.PermissionEval
+	if err := json.NewDecoder(resp.Body).Decode(&eval); err != nil || !eval.Allowed {
+		t.Errorf("check = %+v, %v, want allowed", eval, err)
+	}
+	if resp.TLS == nil || resp.TLS.Version != tls.VersionTLS13 {
+		t.Errorf("TLS state = %+v, want TLS 1.3", resp.TLS)
+	}
+
+	// A TLS 1.2 client is refused by the minimum version
+	client = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, MaxVersion: tls.VersionTLS12}}}
+	if resp, err := client.Get("https://" + ln.Addr().String() + "/api/v1/permissions/read"); err == nil {
+		resp.Body.Close()
+		t.Error("TLS 1.2 request succeeded, want refused below the minimum version")
+	}
+}
+
+func TestConfigureTLSErrors(t *testing.T) {
+	certFile, keyFile, _ := writeSelfSignedCert(t)
+	for _, tt := range []struct {
+		name, cert, key, minVersion string
+	}{
+		{"certificate only", certFile, "", "1.2"},
+		{"key only", "", keyFile, "1.2"},
+		{"unsupported version", certFile, keyFile, "1.1"},
+		{"missing file", certFile, keyFile + ".missing", "1.2"},
+	} {
+		if _, err := configureTLS(&http.Server{}, tt.cert, tt.key, tt.minVersion); err == nil {
+			t.Errorf("%s: configureTLS() = nil error, want an error", tt.name)
+		}
+	}
+	if useTLS, err := configureTLS(&http.Server{}, "", "", "1.2"); useTLS || err != nil {
+		t.Errorf("configureTLS() without files = %v, %v, want plain HTTP", useTLS, err)
+	}
+}
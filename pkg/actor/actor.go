@@ -0,0 +1,19 @@
+// Package actor carries who performs a request in its context: the HTTP middleware (see router.Actor)
+// or a tool acting on behalf of someone sets it, and the service records it in the audit log.
+package actor
+
+import "context"
+
+// contextKey is a private type for the actor context key.
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the actor.
+func NewContext(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, contextKey{}, actor)
+}
+
+// FromContext returns the actor carried by ctx, or "" if none.
+func FromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(contextKey{}).(string)
+	return actor
+}
@@ -0,0 +1,510 @@
+package authz
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Caveat parameter types
+const (
+	caveatTypeNumber = "number"
+	caveatTypeString = "string"
+	caveatTypeBool   = "bool"
+	caveatTypeList   = "list"
+)
+
+// CaveatDefinition declares a condition that relationships may carry, evaluated at check time.
+// The expression is evaluated over the typed parameters, whose values come from the relationship's caveat context,
+// or else from the context supplied with the check. It is written in a restricted language:
+//   - literals: numbers, "strings", true, false, and lists [a, b, ...]
+//   - parameters, by name
+//   - comparisons: == != < <= > >=, and "x in list" (or "x in string", for a substring)
+//   - logical operators: ! (applied to a whole comparison), && and ||, and parentheses
+//
+// For example: "hour >= 9 && hour < 18", or "country in allowed_countries".
+type CaveatDefinition struct {
+	Parameters map[string]string `yaml:"parameters" json:"parameters"` // parameter name -> type: number, string, bool or list
+	Expression string            `yaml:"expression" json:"expression"`
+
+	expr caveatExpr
+}
+
+// compile parses the expression of the caveat.
+func (d *CaveatDefinition) compile() error {
+	expr, _, err := parseCaveatExpression(d.Expression)
+	if err != nil {
+		return err
+	}
+	d.expr = expr
+	return nil
+}
+
+// IsValidCaveat checks that a relationship caveat is declared in the schema,
+// and that its context only holds declared parameters of the declared types.
+func (m Metadata) IsValidCaveat(caveat RelationshipCaveat) error {
+	def, ok := m.Caveats[caveat.Name]
+	if !ok {
+		return fmt.Errorf("caveat is invalid: %q%s", caveat.Name, didYouMean(caveat.Name, sortedKeys(m.Caveats)))
+	}
+	for _, name := range sortedKeys(caveat.Context) {
+		if err := def.checkParameter(name, caveat.Context[name]); err != nil {
+			return fmt.Errorf("caveat %s is invalid: %w", caveat.Name, err)
+		}
+	}
+	return nil
+}
+
+// EvaluateCaveat evaluates a relationship caveat: parameters are read from the relationship's caveat context,
+// or else from the given context (parameters the caveat does not declare are ignored).
+// It returns an error wrapping ErrCaveatContext if a parameter the expression needs is missing or mistyped.
+func (m Metadata) EvaluateCaveat(caveat RelationshipCaveat, context map[string]interface{}) (bool, error) {
+	def, ok := m.Caveats[caveat.Name]
+	if !ok || def.expr == nil {
+		return false, fmt.Errorf("%w: undefined caveat %q", ErrCaveatContext, caveat.Name)
+	}
+	params := make(map[string]interface{}, len(def.Parameters))
+	for name := range def.Parameters {
+		value, ok := caveat.Context[name]
+		if !ok {
+			value, ok = context[name]
+		}
+		if !ok {
+			continue
+		}
+		if err := def.checkParameter(name, value); err != nil {
+			return false, fmt.Errorf("%w: caveat %s: %v", ErrCaveatContext, caveat.Name, err)
+		}
+		params[name] = value
+	}
+
+	value, err := def.expr.eval(params)
+	if err != nil {
+		return false, fmt.Errorf("%w: caveat %s: %v", ErrCaveatContext, caveat.Name, err)
+	}
+	allowed, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("%w: caveat %s: expression is not a condition", ErrCaveatContext, caveat.Name)
+	}
+	return allowed, nil
+}
+
+// checkParameter checks that a parameter is declared, and that the value has its type.
+func (d CaveatDefinition) checkParameter(name string, value interface{}) error {
+	paramType, ok := d.Parameters[name]
+	if !ok {
+		return fmt.Errorf("undefined parameter %q", name)
+	}
+	if valueType(value) != paramType {
+		return fmt.Errorf("parameter %q must be a %s", name, paramType)
+	}
+	return nil
+}
+
+// valueType returns the caveat type of a value decoded from JSON ("" if unsupported).
+func valueType(value interface{}) string {
+	switch value.(type) {
+	case float64:
+		return caveatTypeNumber
+	case string:
+		return caveatTypeString
+	case bool:
+		return caveatTypeBool
+	case []interface{}:
+		return caveatTypeList
+	}
+	return ""
+}
+
+// caveatExpr is a node of a parsed caveat expression.
+type caveatExpr interface {
+	eval(params map[string]interface{}) (interface{}, error)
+}
+
+type (
+	literalExpr struct{ value interface{} }
+	paramExpr   struct{ name string }
+	listExpr    struct{ items []caveatExpr }
+	notExpr     struct{ operand caveatExpr }
+	binaryExpr  struct {
+		op          string
+		left, right caveatExpr
+	}
+)
+
+func (e literalExpr) eval(map[string]interface{}) (interface{}, error) {
+	return e.value, nil
+}
+
+func (e paramExpr) eval(params map[string]interface{}) (interface{}, error) {
+	value, ok := params[e.name]
+	if !ok {
+		return nil, fmt.Errorf("missing parameter %q", e.name)
+	}
+	return value, nil
+}
+
+func (e listExpr) eval(params map[string]interface{}) (interface{}, error) {
+	items := make([]interface{}, len(e.items))
+	for i, item := range e.items {
+		value, err := item.eval(params)
+		if err != nil {
+			return nil, err
+		}
+		items[i] = value
+	}
+	return items, nil
+}
+
+func (e notExpr) eval(params map[string]interface{}) (interface{}, error) {
+	value, err := e.operand.eval(params)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := value.(bool)
+	if !ok {
+		return nil, fmt.Errorf("operand of ! is not a condition")
+	}
+	return !b, nil
+}
+
+func (e binaryExpr) eval(params map[string]interface{}) (interface{}, error) {
+	left, err := e.left.eval(params)
+	if err != nil {
+		return nil, err
+	}
+
+	// Logical operators short-circuit
+	if e.op == "&&" || e.op == "||" {
+		l, ok := left.(bool)
+		if !ok {
+			return nil, fmt.Errorf("operand of %s is not a condition", e.op)
+		}
+		if l == (e.op == "||") {
+			return l, nil
+		}
+		right, err := e.right.eval(params)
+		if err != nil {
+			return nil, err
+		}
+		r, ok := right.(bool)
+		if !ok {
+			return nil, fmt.Errorf("operand of %s is not a condition", e.op)
+		}
+		return r, nil
+	}
+
+	right, err := e.right.eval(params)
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case "==":
+		return equalValues(left, right), nil
+	case "!=":
+		return !equalValues(left, right), nil
+	case "in":
+		switch r := right.(type) {
+		case []interface{}:
+			for _, item := range r {
+				if equalValues(left, item) {
+					return true, nil
+				}
+			}
+			return false, nil
+		case string:
+			if l, ok := left.(string); ok {
+				return strings.Contains(r, l), nil
+			}
+		}
+		return nil, fmt.Errorf("operands of in are not a value and a list or string")
+	}
+
+	// Ordering operators: numbers or strings
+	var cmp int
+	switch l := left.(type) {
+	case float64:
+		r, ok := right.(float64)
+		if !ok {
+			return nil, fmt.Errorf("operands of %s have different types", e.op)
+		}
+		cmp = compareOrdered(l, r)
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			return nil, fmt.Errorf("operands of %s have different types", e.op)
+		}
+		cmp = compareOrdered(l, r)
+	default:
+		return nil, fmt.Errorf("operands of %s are not numbers or strings", e.op)
+	}
+	switch e.op {
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	default: // ">="
+		return cmp >= 0, nil
+	}
+}
+
+// equalValues reports whether two values are equal (lists are compared item by item).
+func equalValues(a, b interface{}) bool {
+	if la, ok := a.([]interface{}); ok {
+		lb, ok := b.([]interface{})
+		if !ok || len(la) != len(lb) {
+			return false
+		}
+		for i := range la {
+			if !equalValues(la[i], lb[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	if _, ok := b.([]interface{}); ok {
+		return false
+	}
+	return a == b
+}
+
+// compareOrdered returns -1, 0 or 1 as a is less than, equal to, or greater than b.
+func compareOrdered[T float64 | string](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// parseCaveatExpression parses a caveat expression (see CaveatDefinition),
+// and returns it with the names of the parameters it uses.
+func parseCaveatExpression(expression string) (caveatExpr, []string, error) {
+	tokens, err := tokenizeCaveat(expression)
+	if err != nil {
+		return nil, nil, err
+	}
+	p := &caveatParser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, nil, fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+	return expr, p.params, nil
+}
+
+// caveatParser is a recursive descent parser of caveat expressions:
+//
+//	or         = and { "||" and }
+//	and        = not { "&&" not }
+//	not        = "!" not | comparison
+//	comparison = operand [ ( "==" | "!=" | "<" | "<=" | ">" | ">=" | "in" ) operand ]
+//	operand    = number | string | "true" | "false" | parameter | "[" [ or { "," or } ] "]" | "(" or ")"
+type caveatParser struct {
+	tokens []string
+	pos    int
+	params []string
+}
+
+func (p *caveatParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *caveatParser) next() string {
+	token := p.peek()
+	p.pos++
+	return token
+}
+
+func (p *caveatParser) expect(token string) error {
+	if got := p.next(); got != token {
+		if got == "" {
+			return fmt.Errorf("expected %q, found end of expression", token)
+		}
+		return fmt.Errorf("expected %q, found %q", token, got)
+	}
+	return nil
+}
+
+func (p *caveatParser) parseOr() (caveatExpr, error) {
+	return p.parseBinary([]string{"||"}, p.parseAnd)
+}
+
+func (p *caveatParser) parseAnd() (caveatExpr, error) {
+	return p.parseBinary([]string{"&&"}, p.parseNot)
+}
+
+// parseBinary parses a left-associative sequence of operands separated by the given operators.
+func (p *caveatParser) parseBinary(ops []string, parseOperand func() (caveatExpr, error)) (caveatExpr, error) {
+	left, err := parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	for containsString(ops, p.peek()) {
+		op := p.next()
+		right, err := parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryExpr{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *caveatParser) parseNot() (caveatExpr, error) {
+	if p.peek() == "!" {
+		p.next()
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *caveatParser) parseComparison() (caveatExpr, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if op := p.peek(); containsString([]string{"==", "!=", "<", "<=", ">", ">=", "in"}, op) {
+		p.next()
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return binaryExpr{op: op, left: left, right: right}, nil
+	}
+	return left, nil
+}
+
+func (p *caveatParser) parseOperand() (caveatExpr, error) {
+	token := p.next()
+	switch {
+	case token == "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	case token == "(":
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return expr, p.expect(")")
+	case token == "[":
+		var items []caveatExpr
+		for p.peek() != "]" {
+			if len(items) > 0 {
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+			item, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		p.next()
+		return listExpr{items: items}, nil
+	case token == "true" || token == "false":
+		return literalExpr{value: token == "true"}, nil
+	case token[0] == '"':
+		value, err := strconv.Unquote(token)
+		if err != nil {
+			return nil, fmt.Errorf("invalid string %s", token)
+		}
+		return literalExpr{value: value}, nil
+	case token[0] == '-' || token[0] == '.' || unicode.IsDigit(rune(token[0])):
+		value, err := strconv.ParseFloat(token, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s", token)
+		}
+		return literalExpr{value: value}, nil
+	case isCaveatIdentifier(token) && token != "in":
+		if !containsString(p.params, token) {
+			p.params = append(p.params, token)
+		}
+		return paramExpr{name: token}, nil
+	}
+	return nil, fmt.Errorf("unexpected %q", token)
+}
+
+// tokenizeCaveat splits a caveat expression into tokens: operators, punctuation, literals and identifiers.
+func tokenizeCaveat(expression string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(expression); {
+		c := expression[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case strings.HasPrefix(expression[i:], "&&") || strings.HasPrefix(expression[i:], "||") ||
+			strings.HasPrefix(expression[i:], "==") || strings.HasPrefix(expression[i:], "!=") ||
+			strings.HasPrefix(expression[i:], "<=") || strings.HasPrefix(expression[i:], ">="):
+			tokens = append(tokens, expression[i:i+2])
+			i += 2
+		case strings.ContainsRune("!<>()[],", rune(c)):
+			tokens = append(tokens, string(c))
+			i++
+		case c == '"':
+			j := i + 1
+			for j < len(expression) && expression[j] != '"' {
+				if expression[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(expression) {
+				return nil, fmt.Errorf("unterminated string at offset %d", i)
+			}
+			tokens = append(tokens, expression[i:j+1])
+			i = j + 1
+		case c == '-' || c == '.' || (c >= '0' && c <= '9'):
+			j := i + 1
+			for j < len(expression) && (expression[j] == '.' || (expression[j] >= '0' && expression[j] <= '9')) {
+				j++
+			}
+			tokens = append(tokens, expression[i:j])
+			i = j
+		case c == '_' || unicode.IsLetter(rune(c)):
+			j := i + 1
+			for j < len(expression) && (expression[j] == '_' || unicode.IsLetter(rune(expression[j])) || unicode.IsDigit(rune(expression[j]))) {
+				j++
+			}
+			tokens = append(tokens, expression[i:j])
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected character %q at offset %d", c, i)
+		}
+	}
+	return tokens, nil
+}
+
+// isCaveatIdentifier reports whether a token is a parameter name (or keyword).
+func isCaveatIdentifier(token string) bool {
+	for i, r := range token {
+		if r != '_' && !unicode.IsLetter(r) && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return token != ""
+}
+
+// containsString reports whether the list contains the value.
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
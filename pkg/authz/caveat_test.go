@@ -0,0 +1,144 @@
+package authz_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/romrossi/authz-rebac/pkg/authz"
+)
+
+// caveatSchema declares caveats using each kind of expression, and a permission granted by a relation they may carry.
+const caveatSchema = `
+schema_version: "1.0"
+objects:
+  user:
+    relations: {}
+  doc:
+    relations:
+      editor:
+        subject_types: [user]
+    permissions:
+      edit:
+        any_of: [editor]
+caveats:
+  business_hours:
+    parameters:
+      hour: number
+    expression: "hour >= 9 && hour < 18"
+  region:
+    parameters:
+      country: string
+      allowed: list
+    expression: "country in allowed"
+  trusted:
+    parameters:
+      verified: bool
+      level: number
+      email: string
+    expression: "verified == true && !(level < 3) || \"@example.com\" in email"
+`
+
+func TestEvaluateCaveat(t *testing.T) {
+	meta := loadSchema(t, caveatSchema)
+	tests := []struct {
+		caveat  string
+		stored  map[string]interface{} // relationship context
+		context map[string]interface{} // check context
+		want    bool
+		wantErr bool
+	}{
+		{caveat: "business_hours", context: map[string]interface{}{"hour": 10.0}, want: true},
+		{caveat: "business_hours", context: map[string]interface{}{"hour": 18.0}, want: false},
+		{caveat: "business_hours", stored: map[string]interface{}{"hour": 20.0}, context: map[string]interface{}{"hour": 10.0}, want: false},
+		{caveat: "business_hours", wantErr: true},                                                       // missing parameter
+		{caveat: "business_hours", context: map[string]interface{}{"hour": "10"}, wantErr: true},        // mistyped parameter
+		{caveat: "business_hours", context: map[string]interface{}{"hour": 10.0, "x": 1.0}, want: true}, // undeclared parameters are ignored
+		{caveat: "region", stored: map[string]interface{}{"allowed": []interface{}{"FR", "DE"}}, context: map[string]interface{}{"country": "FR"}, want: true},
+		{caveat: "region", stored: map[string]interface{}{"allowed": []interface{}{"FR", "DE"}}, context: map[string]interface{}{"country": "US"}, want: false},
+		{caveat: "trusted", context: map[string]interface{}{"verified": true, "level": 3.0, "email": ""}, want: true},
+		{caveat: "trusted", context: map[string]interface{}{"verified": true, "level": 2.0, "email": "bob@other.org"}, want: false},
+		{caveat: "trusted", context: map[string]interface{}{"verified": false, "level": 5.0, "email": "alice@example.com"}, want: true},
+		{caveat: "undeclared", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := meta.EvaluateCaveat(authz.RelationshipCaveat{Name: tt.caveat, Context: tt.stored}, tt.context)
+		if tt.wantErr {
+			if !errors.Is(err, authz.ErrCaveatContext) {
+				t.Errorf("%s %v %v: error = %v, want ErrCaveatContext", tt.caveat, tt.stored, tt.context, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s %v %v = %v, %v, want %v", tt.caveat, tt.stored, tt.context, got, err, tt.want)
+		}
+	}
+}
+
+func TestCaveatSchemaValidation(t *testing.T) {
+	for _, tt := range []struct {
+		replace, with, wantErr string
+	}{
+		{`expression: "hour >= 9 && hour < 18"`, `expression: "hour >= 9 &&"`, "invalid expression"},
+		{`expression: "hour >= 9 && hour < 18"`, `expression: "minute >= 9"`, `undefined parameter "minute"`},
+		{"hour: number", "hour: time", `unknown parameter type "time"`},
+	} {
+		_, err := authz.ParseMetadata([]byte(strings.Replace(caveatSchema, tt.replace, tt.with, 1)))
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: ParseMetadata() = %v, want %q", tt.with, err, tt.wantErr)
+		}
+	}
+
+	meta := loadSchema(t, caveatSchema)
+	for _, caveat := range []authz.RelationshipCaveat{
+		{Name: "business_hour"},
+		{Name: "business_hours", Context: map[string]interface{}{"minute": 1.0}},
+		{Name: "business_hours", Context: map[string]interface{}{"hour": true}},
+	} {
+		if err := meta.IsValidCaveat(caveat); err == nil {
+			t.Errorf("IsValidCaveat(%+v) = nil, want an error", caveat)
+		}
+	}
+}
+
+// TestCaveatFlipsPermission checks that a caveated relationship grants the permission only if its caveat holds
+// in the context supplied with the check.
+func TestCaveatFlipsPermission(t *testing.T) {
+	meta := loadSchema(t, caveatSchema)
+	h, _, repo := newTestServer(t, meta)
+	seedRelations(t, repo, authz.Relationship{
+		Resource: obj("doc:1"), Relation: "editor", Subject: obj("user:alice"),
+		Caveat: &authz.RelationshipCaveat{Name: "business_hours"},
+	})
+	check := func(caveatContext string) *httptest.ResponseRecorder {
+		target := v1Prefix + "/permissions/edit?resource=doc:1&subject=user:alice"
+		if caveatContext != "" {
+			target += "&caveat_context=" + url.QueryEscape(caveatContext)
+		}
+		return serve(h, "GET", target, "")
+	}
+
+	for _, tt := range []struct {
+		context string
+		allowed bool
+	}{
+		{`{"hour": 10}`, true},
+		{`{"hour": 22}`, false},
+	} {
+		var eval authz.PermissionEval
+		decode(t, check(tt.context), http.StatusOK, &eval)
+		if eval.Allowed != tt.allowed {
+			t.Errorf("caveat context %s: allowed = %v, want %v", tt.context, eval.Allowed, tt.allowed)
+		}
+	}
+
+	if rec := check(""); rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400 without the caveat parameter", rec.Code)
+	}
+	if rec := check(`[10]`); rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400 for a caveat context which is not an object", rec.Code)
+	}
+}
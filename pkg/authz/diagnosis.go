@@ -0,0 +1,50 @@
+package authz
+
+import (
+	"context"
+
+	"github.com/romrossi/authz-rebac/pkg/db"
+)
+
+// diagnosisPageSize is the number of relationships read, and fixed within one transaction, by DiagnoseRelationships.
+const diagnosisPageSize = 1000
+
+// DiagnoseRelationships pages through all unexpired relationships by keyset, and reports those the service
+// schema rejects (see Metadata.IsValidRelation). With fix, the invalid relationships of each page are deleted
+// within its own transaction, so that no transaction grows with the graph.
+func (s *serviceImpl) DiagnoseRelationships(ctx context.Context, fix bool) (RelationshipDiagnosis, error) {
+	diagnosis := RelationshipDiagnosis{Fixed: fix, Counts: map[string]int64{}, Invalid: []InvalidRelationship{}}
+
+	var after *Relationship
+	for {
+		page, err := s.authzRepo.ListAllRelationships(ctx, after, diagnosisPageSize)
+		if err != nil {
+			return diagnosis, err
+		}
+
+		var invalid []Relationship
+		for _, rel := range page {
+			diagnosis.Scanned++
+			if err := s.meta.IsValidRelation(rel); err != nil {
+				diagnosis.Counts[rel.Resource.Type+"#"+rel.Relation+"@"+rel.Subject.Type]++
+				diagnosis.Invalid = append(diagnosis.Invalid, InvalidRelationship{Relationship: rel, Error: err.Error()})
+				invalid = append(invalid, rel)
+			}
+		}
+
+		if fix && len(invalid) > 0 {
+			err := db.WithTransaction(ctx, func(txCtx context.Context) error {
+				_, err := s.delete(txCtx, invalid)
+				return err
+			})
+			if err != nil {
+				return diagnosis, err
+			}
+		}
+
+		if len(page) < diagnosisPageSize {
+			return diagnosis, nil
+		}
+		after = &page[len(page)-1]
+	}
+}
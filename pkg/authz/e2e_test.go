@@ -0,0 +1,54 @@
+package authz_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/romrossi/authz-rebac/pkg/authz"
+)
+
+// TestEndToEndInMemory writes relationships and checks permissions through the HTTP handlers,
+// over an in-memory SQLite database, including the recursive traversal of nested groups.
+func TestEndToEndInMemory(t *testing.T) {
+	h, _, _ := newTestServer(t, authz.LoadMetadata())
+	allowed := func(permission, resource, subject string) bool {
+		t.Helper()
+		var eval authz.PermissionEval
+		rec := serve(h, "GET", v1Prefix+"/permissions/"+permission+"?resource="+resource+"&subject="+subject, "")
+		decode(t, rec, http.StatusOK, &eval)
+		return eval.Allowed
+	}
+
+	body := `{"create": [
+		{"resource": "project:1", "relation": "contributor", "subject": "group:eng"},
+		{"resource": "group:eng", "member", "subject": "group:core"}]}`
+	if rec := serve(h, "POST", v1Prefix+"/relations", body); rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400 for a malformed body", rec.Code)
+	}
+
+	body = `{"create": [
+		{"resource": "project:1", "relation": "contributor", "subject": "group:eng"},
+		{"resource": "group:eng", "relation": "member", "subject": "group:core"},
+		{"resource": "group:core", "relation": "member", "subject": "user:alice"}]}`
+	var result authz.WriteResult
+	decode(t, serve(h, "POST", v1Prefix+"/relations", body), http.StatusOK, &result)
+	if result.Created != 3 {
+		t.Errorf("created = %d, want 3", result.Created)
+	}
+
+	if !allowed("create", "project:1", "user:alice") {
+		t.Error("alice is denied create, want allowed through group:core and group:eng")
+	}
+	if allowed("delete", "project:1", "user:alice") {
+		t.Error("alice is allowed delete, want denied to a contributor")
+	}
+	if allowed("read", "project:1", "user:bob") {
+		t.Error("bob is allowed read, want denied without relationships")
+	}
+
+	body = `{"delete": [{"resource": "group:eng", "relation": "member", "subject": "group:core"}]}`
+	decode(t, serve(h, "POST", v1Prefix+"/relations", body), http.StatusOK, &result)
+	if allowed("create", "project:1", "user:alice") {
+		t.Error("alice is allowed create, want denied once group:core left group:eng")
+	}
+}
@@ -0,0 +1,17 @@
+package authz
+
+// SQLiteListPathsQuery exposes the traversal query of the SQLite repository to the tests of its query plan.
+var SQLiteListPathsQuery = sqliteListPathsQuery
+
+// ParseBoolParam exposes the parsing of boolean query parameters to the handler tests.
+var ParseBoolParam = parseBoolParam
+
+// SQLiteListAuditEntriesQuery exposes the audit query of the SQLite repository to the tests of its query plan.
+var SQLiteListAuditEntriesQuery = sqliteListAuditEntriesQuery
+
+// InChunks exposes the chunked writes of the repositories to the tests of their ordering.
+var InChunks = inChunks
+
+// SQLiteMaxBulkRows exposes the largest chunk of the bulk operations of the SQLite repository (its deletions),
+// so that the tests of the chunked writes span several chunks.
+const SQLiteMaxBulkRows = sqliteMaxBulkRows
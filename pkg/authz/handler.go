@@ -2,9 +2,14 @@ package authz
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"mime"
 	"net/http"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -12,20 +17,42 @@ import (
 	"github.com/romrossi/authz-rebac/pkg/router"
 )
 
+// watchKeepaliveInterval is the delay between keepalive pings on idle watch streams.
+const watchKeepaliveInterval = 15 * time.Second
+
+// resultsTruncatedHeader is set to "true" on traversal responses cut at their maximum number of results.
+const resultsTruncatedHeader = "Results-Truncated"
+
+// resourceExistsHeader is set to "true" or "false" on listings of the relationships of a resource, telling whether
+// the resource is in any relationship (see AuthzService.ObjectExists): an empty listing of a resource which is not
+// may come from a mistyped ID.
+const resourceExistsHeader = "Resource-Exists"
+
+// schemaVersionHeader carries the active schema version on check and write responses.
+// On writes, it may also carry the schema version expected by the client: the write is rejected if it differs.
+const schemaVersionHeader = "X-Authz-Schema-Version"
+
 // Handler provides HTTP handlers for authz operations.
 type AuthzHandler struct {
 	authzService AuthzService
 	meta         Metadata
+	maxBatchSize int // maximum number of relationships per write request (0 = unlimited)
 }
 
-func NewAuthzHandler(authzService AuthzService, meta Metadata) *AuthzHandler {
-	return &AuthzHandler{authzService: authzService, meta: meta}
+func NewAuthzHandler(authzService AuthzService, meta Metadata, maxBatchSize int) *AuthzHandler {
+	return &AuthzHandler{authzService: authzService, meta: meta, maxBatchSize: maxBatchSize}
 }
 
 // CheckPermission handles GET /permissions/<permission>?resource=<type:id>&subject=<type:id>
+// <permission> may list several comma-separated permissions: the response is then allowed if any of them is,
+// and details each evaluation in its "permissions" field (keyed by permission name).
+// Optional: at_least_as_fresh=<consistency token>, as_of=<RFC 3339 time> to check as of a past time,
+// caveat_context=<JSON object of caveat parameters>, max_paths=<n> to cap the matching paths shown, path_format=compact to encode them as CompactPaths,
+// exclude_relations=<relation>,<relation> to evaluate as if these relations did not exist;
+// flags show_matching_paths, show_hops (hop metadata on the edges of full paths), explain, show_reason.
 func (h *AuthzHandler) CheckPermission() router.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
-		start := time.Now()
+		w.Header().Set(schemaVersionHeader, h.meta.SchemaVersion)
 
 		// Get query parameter 'resource'
 		resource, err := parseObjectParam(params, "resource")
@@ -48,20 +75,93 @@ func (h *AuthzHandler) CheckPermission() router.HandlerFunc {
 			writeError(w, http.StatusBadRequest, err)
 			return
 		}
+		if err := rejectSubjectRelations("subject", *subject); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
 
-		// Get query parameter 'permission'
-		permission, err := parseStringParam(params, "permission")
+		// Get query parameter 'permission' (several comma-separated permissions are OR-ed)
+		permissions, err := parseListParam(params, "permission")
 		if err != nil {
 			writeError(w, http.StatusBadRequest, err)
 			return
 		}
-		if err := h.meta.IsValidPermission(*resource, permission); err != nil {
-			writeError(w, http.StatusBadRequest, err)
+		if len(permissions) == 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("missing parameter 'permission'"))
 			return
 		}
+		for _, permission := range permissions {
+			if err := h.meta.IsValidPermission(*resource, permission); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+		}
 
 		// Get query parameter 'show_matching_paths'
-		showMatchingPaths, err := parseBoolParam(params, "show_matching_paths")
+		showMatchingPaths, err := parseBoolParam(params, "show_matching_paths", false)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// Get optional query parameter 'path_format'
+		compactPaths, err := parsePathFormatParam(params, "path_format")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// Get query parameter 'show_hops'
+		showHops, err := parseShowHopsParam(params, compactPaths)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// Get optional query parameter 'max_paths'
+		maxPaths, err := parsePositiveIntParam(params, "max_paths")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// Get query parameter 'explain'
+		explain, err := parseBoolParam(params, "explain", false)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// Get query parameter 'show_reason'
+		showReason, err := parseBoolParam(params, "show_reason", false)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// Get optional query parameter 'at_least_as_fresh'
+		atLeastAsFresh, err := parseConsistencyTokenParam(params, "at_least_as_fresh")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// Get optional query parameter 'as_of'
+		asOf, err := parseAsOfParam(params, "as_of", "at_least_as_fresh")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// Get optional query parameter 'caveat_context'
+		caveatContext, err := parseJSONObjectParam(params, "caveat_context")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// Get optional query parameter 'exclude_relations'
+		excludeRelations, err := h.parseExcludedRelationsParam(params, "exclude_relations")
 		if err != nil {
 			writeError(w, http.StatusBadRequest, err)
 			return
@@ -69,210 +169,1801 @@ func (h *AuthzHandler) CheckPermission() router.HandlerFunc {
 
 		// Build traversal request
 		tRequest := TraversalRequest{
-			StartOn: *resource,
-			Forward: true,
-			StopOn:  *subject,
+			StartOn:          *resource,
+			Forward:          true,
+			StopOn:           *subject,
+			AtLeastAsFresh:   atLeastAsFresh,
+			AsOf:             asOf,
+			CaveatContext:    caveatContext,
+			ExcludeRelations: excludeRelations,
+		}
+
+		// A single permission without details only needs a boolean
+		if len(permissions) == 1 && !showMatchingPaths && !explain && !showReason {
+			allowed, err := h.authzService.IsPermitted(r.Context(), tRequest, permissions[0])
+			if writeServiceError(w, err) {
+				return
+			}
+			if err != nil {
+				log.Printf("[ERROR] AuthzHandler.CheckPermission: s.IsPermitted failed: %v", err)
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+			write(w, http.StatusOK, PermissionEval{Allowed: allowed})
+			return
 		}
 
-		// Check single permission
-		permissionCheck, err := h.authzService.CheckPermissions(r.Context(), tRequest, showMatchingPaths)
+		// Check the permissions
+		permissionCheck, _, err := h.authzService.CheckPermissions(r.Context(), tRequest, CheckOptions{
+			ShowMatchingPaths: showMatchingPaths,
+			MaxPaths:          maxPaths,
+			Explain:           explain,
+			ShowReason:        showReason,
+			Permissions:       permissions,
+		})
+		if writeServiceError(w, err) {
+			return
+		}
 		if err != nil {
 			log.Printf("[ERROR] AuthzHandler.CheckPermission: s.CheckPermissions failed: %v", err)
 			writeError(w, http.StatusInternalServerError, err)
 			return
 		}
 
-		// Get single evaluation
-		permissionEval := permissionCheck[0].PermissionEvals[permission]
+		// Get evaluations (denied if no path connects the resource to the subject)
+		evals := map[string]PermissionEval{}
+		if len(permissionCheck) > 0 {
+			evals = permissionCheck[0].PermissionEvals
+		} else if showReason {
+			for _, permission := range permissions {
+				evals[permission] = PermissionEval{Reason: DenyReasonNoPath}
+			}
+		}
+		permissionEval := evals[permissions[0]]
+		if len(permissions) > 1 {
+			// Several permissions: allowed if any is, with the evaluation of each
+			permissionEval = PermissionEval{Permissions: make(map[string]PermissionEval, len(permissions))}
+			for _, permission := range permissions {
+				permissionEval.Permissions[permission] = evals[permission]
+				permissionEval.Allowed = permissionEval.Allowed || evals[permission].Allowed
+			}
+		}
+
+		if showHops {
+			permissionEval = hopMatchingPaths(permissionEval)
+		}
+		if compactPaths {
+			permissionEval = compactMatchingPaths(permissionEval)
+		}
 
 		// Build OK response
-		log.Printf("[INFO] AuthzHandler.CheckPermission: executed in %v", time.Since(start))
 		write(w, http.StatusOK, permissionEval)
 	}
 }
 
-// CheckPermission handles GET /permissions?resource_filter=<type:id>&subject_filter=<type:id>
-func (h *AuthzHandler) CheckPermissions() router.HandlerFunc {
+// CheckRelation handles GET /relations/<relation>/check?resource=<type:id>&subject=<type:id>
+// It checks whether an effective path from the resource to the subject contains the relation, directly or
+// transitively, without evaluating permissions.
+// Optional: at_least_as_fresh=<consistency token>, as_of=<RFC 3339 time> to check as of a past time,
+// path_format=compact to encode the matching paths as CompactPaths; flags show_matching_paths,
+// show_hops (hop metadata on the edges of full paths).
+func (h *AuthzHandler) CheckRelation() router.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
-		start := time.Now()
+		w.Header().Set(schemaVersionHeader, h.meta.SchemaVersion)
 
-		// Get query parameter 'resource_filter'
-		resourceFilter, err := parseObjectParam(params, "resource_filter")
+		// Get path parameter 'relation'
+		relation := params["relation"]
+		if err := h.meta.IsKnownRelation(relation); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// Get query parameters 'resource' and 'subject'
+		resource, err := parseObjectParam(params, "resource")
 		if err != nil {
 			writeError(w, http.StatusBadRequest, err)
 			return
 		}
-		if err := h.meta.IsValidObjectType(*resourceFilter); err != nil {
+		if err := h.meta.IsValidObject(*resource); err != nil {
 			writeError(w, http.StatusBadRequest, err)
 			return
 		}
-
-		// Get query parameter 'subject_filter'
-		subjectFilter, err := parseObjectParam(params, "subject_filter")
+		subject, err := parseObjectParam(params, "subject")
 		if err != nil {
 			writeError(w, http.StatusBadRequest, err)
 			return
 		}
-		if err := h.meta.IsValidObjectType(*subjectFilter); err != nil {
+		if err := h.meta.IsValidObject(*subject); err != nil {
 			writeError(w, http.StatusBadRequest, err)
 			return
 		}
-		if resourceFilter.ID == "" && subjectFilter.ID == "" {
-			writeError(w, http.StatusBadRequest, fmt.Errorf("either a resource ID or a subject ID must be provided"))
+		if err := rejectSubjectRelations("subject", *subject); err != nil {
+			writeError(w, http.StatusBadRequest, err)
 			return
 		}
 
 		// Get query parameter 'show_matching_paths'
-		showMatchingPaths, err := parseBoolParam(params, "show_matching_paths")
+		showMatchingPaths, err := parseBoolParam(params, "show_matching_paths", false)
 		if err != nil {
 			writeError(w, http.StatusBadRequest, err)
 			return
 		}
 
-		// Build traversal request
-		// Rules for performance:
-		// - If resource filter is specific (type:id), traverse forward (resource → subject).
-		// - If subject filters is specific (type:id), traverse backward (subject → resource).
-		var tRequest TraversalRequest
-		if resourceFilter.ID != "" {
-			tRequest.StartOn = *resourceFilter
-			tRequest.Forward = true
-			tRequest.StopOn = *subjectFilter
-		} else {
-			tRequest.StartOn = *subjectFilter
-			tRequest.Forward = false
-			tRequest.StopOn = *resourceFilter
+		// Get optional query parameter 'path_format'
+		compactPaths, err := parsePathFormatParam(params, "path_format")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
 		}
 
-		// Check permissions
-		permissionEvals, err := h.authzService.CheckPermissions(r.Context(), tRequest, showMatchingPaths)
+		// Get query parameter 'show_hops'
+		showHops, err := parseShowHopsParam(params, compactPaths)
 		if err != nil {
-			log.Printf("[ERROR] AuthzHandler.CheckPermissions: s.CheckPermissions failed: %v", err)
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// Get optional query parameter 'at_least_as_fresh'
+		atLeastAsFresh, err := parseConsistencyTokenParam(params, "at_least_as_fresh")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// Get optional query parameter 'as_of'
+		asOf, err := parseAsOfParam(params, "as_of", "at_least_as_fresh")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// Check the relation
+		tRequest := TraversalRequest{
+			StartOn:        *resource,
+			Forward:        true,
+			StopOn:         *subject,
+			AtLeastAsFresh: atLeastAsFresh,
+			AsOf:           asOf,
+		}
+		check, err := h.authzService.CheckRelation(r.Context(), tRequest, relation, showMatchingPaths)
+		if writeServiceError(w, err) {
+			return
+		}
+		if err != nil {
+			log.Printf("[ERROR] AuthzHandler.CheckRelation: s.CheckRelation failed: %v", err)
 			writeError(w, http.StatusInternalServerError, err)
 			return
 		}
 
+		if showHops {
+			check.MatchingPaths = withHops(check.MatchingPaths)
+		}
+		if compactPaths {
+			check.MatchingPathsCompact = NewCompactPaths(check.MatchingPaths)
+			check.MatchingPaths = nil
+		}
+
 		// Build OK response
-		log.Printf("[INFO] AuthzHandler.CheckPermissions: executed in %v", time.Since(start))
-		write(w, http.StatusOK, permissionEvals)
+		write(w, http.StatusOK, check)
 	}
 }
 
-// GetRelations handles GET /resources/{resource}/relations
-func (h *AuthzHandler) ListResourceRelations() router.HandlerFunc {
+// CountPermission handles GET /permissions/<permission>/count?subject=<type:id>&resource_type=<type>
+// It counts the resources of the type on which the subject is granted the permission.
+// Optional: at_least_as_fresh=<consistency token>, max_results=<n> to cap the number of resources evaluated
+// (see resultsTruncatedHeader), caveat_context=<JSON object of caveat parameters>.
+func (h *AuthzHandler) CountPermission() router.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
-		start := time.Now()
+		w.Header().Set(schemaVersionHeader, h.meta.SchemaVersion)
 
-		// Get query parameter 'resource'
-		resource, err := parseObjectParam(params, "resource")
+		// Get query parameter 'subject'
+		subject, err := parseObjectParam(params, "subject")
 		if err != nil {
 			writeError(w, http.StatusBadRequest, err)
 			return
 		}
+		if err := h.meta.IsValidObject(*subject); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
 
-		// Get all relationships of the resource and all its parents
-		relationships, err := h.authzService.ListRelationships(r.Context(), *resource)
+		// Get query parameter 'resource_type'
+		resourceType, err := parseStringParam(params, "resource_type")
 		if err != nil {
-			log.Printf("[ERROR] AuthzHandler.ListResourceRelations: s.ListRelationships failed: %v", err)
-			writeError(w, http.StatusInternalServerError, err)
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := h.meta.IsValidObjectType(Object{Type: resourceType}); err != nil {
+			writeError(w, http.StatusBadRequest, err)
 			return
 		}
 
-		// Build OK response
-		log.Printf("[INFO] AuthzHandler.ListResourceRelations: executed in %v", time.Since(start))
-		write(w, http.StatusOK, relationships)
-	}
-}
+		// Get query parameter 'permission'
+		permission, err := parseStringParam(params, "permission")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if _, ok := h.meta.Objects[resourceType].Permissions[permission]; !ok {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("permission %q is invalid for resource type %q", permission, resourceType))
+			return
+		}
 
-// ManageRelationship handles POST /relations
-func (h *AuthzHandler) ManageRelationships() router.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
-		// Decode JSON request body
-		var req map[string][]Relationship
-		err := json.NewDecoder(r.Body).Decode(&req)
+		// Get optional query parameter 'at_least_as_fresh'
+		atLeastAsFresh, err := parseConsistencyTokenParam(params, "at_least_as_fresh")
 		if err != nil {
-			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %s", err))
+			writeError(w, http.StatusBadRequest, err)
 			return
 		}
 
-		// Validate all creation/delete requests
-		for _, rels := range req {
-			for _, rel := range rels {
-				if err := h.meta.IsValidRelation(rel); err != nil {
-					writeError(w, http.StatusBadRequest, err)
-					return
-				}
-			}
+		// Get optional query parameter 'max_results'
+		maxResults, err := parsePositiveIntParam(params, "max_results")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
 		}
 
-		// Execute all deletions
-		if relationshipsToDelete, ok := req["delete"]; ok {
-			if err := h.authzService.DeleteRelationships(r.Context(), relationshipsToDelete); err != nil {
-				writeError(w, http.StatusInternalServerError, err)
-				return
-			}
+		// Get optional query parameter 'caveat_context'
+		caveatContext, err := parseJSONObjectParam(params, "caveat_context")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
 		}
 
-		// Execute all creations
-		if relationshipsToCreate, ok := req["create"]; ok {
-			if err := h.authzService.CreateRelationships(r.Context(), relationshipsToCreate); err != nil {
-				writeError(w, http.StatusInternalServerError, err)
-				return
-			}
+		// Build traversal request: from the subject back to resources of the type
+		tRequest := buildTraversalRequest(Object{Type: resourceType}, []Object{*subject})
+		tRequest.AtLeastAsFresh = atLeastAsFresh
+		tRequest.MaxResults = maxResults
+		tRequest.CaveatContext = caveatContext
+
+		// Count resources
+		count, truncated, err := h.authzService.CountPermitted(r.Context(), tRequest, permission)
+		if writeServiceError(w, err) {
+			return
+		}
+		if err != nil {
+			log.Printf("[ERROR] AuthzHandler.CountPermission: s.CountPermitted failed: %v", err)
+			writeError(w, http.StatusInternalServerError, err)
+			return
 		}
 
 		// Build OK response
-		write(w, http.StatusOK, nil)
+		if truncated {
+			w.Header().Set(resultsTruncatedHeader, "true")
+		}
+		write(w, http.StatusOK, PermissionCount{Count: count})
 	}
 }
 
-func parseStringParam(params map[string]string, paramName string) (string, error) {
-	raw, ok := params[paramName]
-	if !ok || raw == "" {
-		return "", fmt.Errorf("missing parameter '%s'", paramName)
-	}
-	return raw, nil
-}
+// CheckPermission handles GET /permissions?resource_filter=<type:id>&subject_filter=<type:id>
+// subject_filter may list several comma-separated subjects if resource_filter has an ID.
+// With resource=<type:id>&subject=<type:id> instead (both with IDs), it evaluates this exact pair, and responds
+// with its evaluations rather than a list, including denied permissions if no path connects the pair.
+// Optional: permission=<name> to evaluate a single permission, or permissions=<name>,<name> to evaluate only these
+// (both must be defined on the resource type), at_least_as_fresh=<consistency token>,
+// as_of=<RFC 3339 time> to check as of a past time,
+// max_results=<n> to cap the number of resource-subject pairs (see resultsTruncatedHeader),
+// caveat_context=<JSON object of caveat parameters>, max_paths=<n> to cap the matching paths shown per permission,
+// path_format=compact to encode them as CompactPaths, exclude_relations=<relation>,<relation> to evaluate as if
+// these relations did not exist; flags show_matching_paths, show_hops (hop metadata on the edges of full paths),
+// explain, show_reason.
+func (h *AuthzHandler) CheckPermissions() router.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		w.Header().Set(schemaVersionHeader, h.meta.SchemaVersion)
 
-func parseBoolParam(params map[string]string, paramName string) (bool, error) {
-	raw, ok := params[paramName]
-	if !ok || raw == "" {
-		return false, nil
-	}
+		// Get query parameters 'resource' and 'subject', or else 'resource_filter' and 'subject_filter'
+		resourceFilter, subjectFilters, exactPair, err := h.parseCheckTargets(params)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
 
-	val, err := strconv.ParseBool(raw)
-	if err != nil {
-		return false, fmt.Errorf("invalid parameter '%s': must be a boolean ('true' or 'false')", paramName)
-	}
+		// Get query parameter 'show_matching_paths'
+		showMatchingPaths, err := parseBoolParam(params, "show_matching_paths", false)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
 
-	return val, nil
-}
+		// Get optional query parameter 'path_format'
+		compactPaths, err := parsePathFormatParam(params, "path_format")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
 
-func parseObjectParam(params map[string]string, paramName string) (*Object, error) {
-	raw, ok := params[paramName]
-	if !ok || raw == "" {
-		return nil, fmt.Errorf("required parameter '%s'", paramName)
-	}
+		// Get query parameter 'show_hops'
+		showHops, err := parseShowHopsParam(params, compactPaths)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
 
-	object_parts := strings.SplitN(raw, ":", 2)
-	object := &Object{Type: object_parts[0]}
+		// Get optional query parameter 'max_paths'
+		maxPaths, err := parsePositiveIntParam(params, "max_paths")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
 
-	if len(object_parts) == 2 {
-		object.ID = object_parts[1]
-	}
+		// Get query parameter 'explain'
+		explain, err := parseBoolParam(params, "explain", false)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
 
-	return object, nil
-}
+		// Get query parameter 'show_reason'
+		showReason, err := parseBoolParam(params, "show_reason", false)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
 
-func write(w http.ResponseWriter, statusCode int, payload interface{}) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(statusCode)
-	if payload != nil {
-		json.NewEncoder(w).Encode(payload)
-	}
-}
+		// Get optional query parameters 'permission' and 'permissions'
+		var permissions []string
+		if permission := params["permission"]; permission != "" {
+			if _, ok := h.meta.Objects[resourceFilter.Type].Permissions[permission]; !ok {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("permission %q is invalid for resource type %q", permission, resourceFilter.Type))
+				return
+			}
+			permissions = append(permissions, permission)
+		}
+		selected, err := parseListParam(params, "permissions")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		for _, permission := range selected {
+			defined := h.meta.Objects[resourceFilter.Type].Permissions
+			if _, ok := defined[permission]; !ok {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid parameter 'permissions': permission %q is invalid for resource type %q%s",
+					permission, resourceFilter.Type, didYouMean(permission, sortedKeys(defined))))
+				return
+			}
+			if !slices.Contains(permissions, permission) {
+				permissions = append(permissions, permission)
+			}
+		}
 
-func writeError(w http.ResponseWriter, statusCode int, err error) {
-	w.WriteHeader(statusCode)
-	w.Write([]byte(err.Error()))
+		// Get optional query parameter 'at_least_as_fresh'
+		atLeastAsFresh, err := parseConsistencyTokenParam(params, "at_least_as_fresh")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// Get optional query parameter 'as_of'
+		asOf, err := parseAsOfParam(params, "as_of", "at_least_as_fresh")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// Get optional query parameter 'max_results'
+		maxResults, err := parsePositiveIntParam(params, "max_results")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// Get optional query parameter 'caveat_context'
+		caveatContext, err := parseJSONObjectParam(params, "caveat_context")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// Get optional query parameter 'exclude_relations'
+		excludeRelations, err := h.parseExcludedRelationsParam(params, "exclude_relations")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// Build traversal request
+		tRequest := buildTraversalRequest(*resourceFilter, subjectFilters)
+		tRequest.AtLeastAsFresh = atLeastAsFresh
+		tRequest.AsOf = asOf
+		tRequest.CaveatContext = caveatContext
+		tRequest.MaxResults = maxResults
+		tRequest.ExcludeRelations = excludeRelations
+
+		// Check permissions
+		permissionEvals, truncated, err := h.authzService.CheckPermissions(r.Context(), tRequest, CheckOptions{
+			ShowMatchingPaths: showMatchingPaths,
+			MaxPaths:          maxPaths,
+			Explain:           explain,
+			ShowReason:        showReason,
+			Permissions:       permissions,
+		})
+		if writeServiceError(w, err) {
+			return
+		}
+		if err != nil {
+			log.Printf("[ERROR] AuthzHandler.CheckPermissions: s.CheckPermissions failed: %v", err)
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		if showHops {
+			for _, item := range permissionEvals {
+				for permission, eval := range item.PermissionEvals {
+					item.PermissionEvals[permission] = hopMatchingPaths(eval)
+				}
+			}
+		}
+		if compactPaths {
+			for _, item := range permissionEvals {
+				for permission, eval := range item.PermissionEvals {
+					item.PermissionEvals[permission] = compactMatchingPaths(eval)
+				}
+			}
+		}
+
+		// An exact pair gets its evaluations, all denied if no path connects the resource to the subject
+		if exactPair {
+			write(w, http.StatusOK, h.pairEvaluations(*resourceFilter, subjectFilters[0], permissionEvals, permissions, showReason))
+			return
+		}
+
+		// Build OK response
+		if truncated {
+			w.Header().Set(resultsTruncatedHeader, "true")
+		}
+		write(w, http.StatusOK, permissionEvals)
+	}
+}
+
+// pairEvaluations returns the evaluations of the permissions (or else all permissions of the resource type)
+// for an exact resource-subject pair, given the items checked for it: none if no path connects them.
+func (h *AuthzHandler) pairEvaluations(resource, subject Object, items []PermissionCheckItem, permissions []string, showReason bool) PermissionCheckItem {
+	if len(items) > 0 {
+		return items[0]
+	}
+	if len(permissions) == 0 {
+		permissions = sortedKeys(h.meta.Objects[resource.Type].Permissions)
+	}
+	item := PermissionCheckItem{Resource: resource, Subject: subject, PermissionEvals: make(map[string]PermissionEval, len(permissions))}
+	for _, permission := range permissions {
+		var eval PermissionEval
+		if showReason {
+			eval.Reason = DenyReasonNoPath
+		}
+		item.PermissionEvals[permission] = eval
+	}
+	return item
+}
+
+// parseCheckTargets parses the resource and subjects of GET /permissions: an exact pair of objects with IDs
+// (resource and subject), or else filters (resource_filter and subject_filter), of which one at least has an ID.
+func (h *AuthzHandler) parseCheckTargets(params map[string]string) (*Object, []Object, bool, error) {
+	if params["resource"] != "" || params["subject"] != "" {
+		if params["resource_filter"] != "" || params["subject_filter"] != "" {
+			return nil, nil, false, fmt.Errorf("parameters 'resource' and 'subject' cannot be combined with 'resource_filter' and 'subject_filter'")
+		}
+		resource, err := parseObjectParam(params, "resource")
+		if err != nil {
+			return nil, nil, false, err
+		}
+		if err := h.meta.IsValidObject(*resource); err != nil {
+			return nil, nil, false, fmt.Errorf("resource %w", err)
+		}
+		subject, err := parseObjectParam(params, "subject")
+		if err != nil {
+			return nil, nil, false, err
+		}
+		if err := h.meta.IsValidObject(*subject); err != nil {
+			return nil, nil, false, fmt.Errorf("subject %w", err)
+		}
+		if err := rejectSubjectRelations("subject", *subject); err != nil {
+			return nil, nil, false, err
+		}
+		return resource, []Object{*subject}, true, nil
+	}
+
+	// Get query parameter 'resource_filter'
+	resourceFilter, err := parseObjectParam(params, "resource_filter")
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if err := h.meta.IsValidObjectType(*resourceFilter); err != nil {
+		return nil, nil, false, err
+	}
+
+	// Get query parameter 'subject_filter' (several comma-separated subjects are accepted with a resource ID)
+	subjectFilters, err := parseObjectListParam(params, "subject_filter")
+	if err != nil {
+		return nil, nil, false, err
+	}
+	for _, subjectFilter := range subjectFilters {
+		if err := h.meta.IsValidObjectType(subjectFilter); err != nil {
+			return nil, nil, false, err
+		}
+	}
+	if err := rejectSubjectRelations("subject_filter", subjectFilters...); err != nil {
+		return nil, nil, false, err
+	}
+	if resourceFilter.ID == "" && len(subjectFilters) > 1 {
+		return nil, nil, false, fmt.Errorf("a resource ID must be provided with several subject filters")
+	}
+	if resourceFilter.ID == "" && subjectFilters[0].ID == "" {
+		return nil, nil, false, fmt.Errorf("either a resource ID or a subject ID must be provided")
+	}
+	return resourceFilter, subjectFilters, false, nil
+}
+
+// CheckSubjects handles POST /permissions/<permission>:checkSubjects, evaluating the permission on a resource for each
+// subject of the body (see SubjectsCheckRequest) with a single traversal, e.g. to render an access matrix.
+// It responds with the evaluation of each subject, in the order of the body.
+// The number of subjects is limited like the relationships of a write (see maxBatchSize).
+// Optional: at_least_as_fresh=<consistency token>.
+func (h *AuthzHandler) CheckSubjects() router.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		w.Header().Set(schemaVersionHeader, h.meta.SchemaVersion)
+
+		// Decode JSON request body
+		var req SubjectsCheckRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		if err := h.meta.IsValidObject(req.Resource); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("resource %w", err))
+			return
+		}
+		if len(req.Subjects) == 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("subjects are required"))
+			return
+		}
+		if h.maxBatchSize > 0 && len(req.Subjects) > h.maxBatchSize {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("too many subjects: %d exceeds maximum of %d", len(req.Subjects), h.maxBatchSize))
+			return
+		}
+		for i, subject := range req.Subjects {
+			if err := h.meta.IsValidObject(subject); err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("subjects[%d] %w", i, err))
+				return
+			}
+		}
+		if err := rejectSubjectRelations("subjects", req.Subjects...); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// Get path parameter 'permission'
+		permission := params["permission"]
+		if err := h.meta.IsValidPermission(req.Resource, permission); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// Get optional query parameter 'at_least_as_fresh'
+		atLeastAsFresh, err := parseConsistencyTokenParam(params, "at_least_as_fresh")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// Check the permission of all subjects with one traversal, stopping on any of them
+		tRequest := buildTraversalRequest(req.Resource, req.Subjects)
+		tRequest.AtLeastAsFresh = atLeastAsFresh
+		tRequest.MaxResults = len(req.Subjects)
+		items, _, err := h.authzService.CheckPermissions(r.Context(), tRequest, CheckOptions{Permissions: []string{permission}})
+		if writeServiceError(w, err) {
+			return
+		}
+		if err != nil {
+			log.Printf("[ERROR] AuthzHandler.CheckSubjects: s.CheckPermissions failed: %v", err)
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		// Build OK response: subjects no path connects to the resource are denied
+		allowed := make(map[Object]bool, len(items))
+		for _, item := range items {
+			allowed[item.Subject] = item.PermissionEvals[permission].Allowed
+		}
+		checks := make([]SubjectCheck, len(req.Subjects))
+		for i, subject := range req.Subjects {
+			checks[i] = SubjectCheck{Subject: subject, Allowed: allowed[subject]}
+		}
+		write(w, http.StatusOK, checks)
+	}
+}
+
+// WarmPaths handles POST /permissions:warm, which primes the effective paths of the resources of the body
+// (see WarmRequest), e.g. after a deploy. No path cache is enabled, as checks always traverse the database:
+// the resources are validated, and the response reports that none was warmed.
+// The number of resources is limited like the relationships of a write (see maxBatchSize).
+func (h *AuthzHandler) WarmPaths() router.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		w.Header().Set(schemaVersionHeader, h.meta.SchemaVersion)
+
+		// Decode JSON request body
+		var req WarmRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		if len(req.Resources) == 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("resources are required"))
+			return
+		}
+		if h.maxBatchSize > 0 && len(req.Resources) > h.maxBatchSize {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("too many resources: %d exceeds maximum of %d", len(req.Resources), h.maxBatchSize))
+			return
+		}
+		for i, resource := range req.Resources {
+			if err := h.meta.IsValidObject(resource); err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("resources[%d] %w", i, err))
+				return
+			}
+		}
+
+		// Build OK response: nothing to warm without a path cache
+		write(w, http.StatusOK, WarmResult{Warmed: 0, CacheEnabled: false})
+	}
+}
+
+// GetRelations handles GET /resources/{resource}/relations
+// Optional: subject_types=<type>,<type> to only list relations to subjects of these types;
+// flag must_exist, to respond 404 rather than an empty list if the resource is in no relationship.
+// The Resource-Exists header tells whether it is (see resourceExistsHeader).
+// Responds with one relationship per line if the Accept header requests application/x-ndjson.
+func (h *AuthzHandler) ListResourceRelations() router.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		// Get query parameter 'resource'
+		resource, err := parseObjectParam(params, "resource")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// Get optional query parameter 'subject_types'
+		subjectTypes, err := parseListParam(params, "subject_types")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		for _, subjectType := range subjectTypes {
+			if err := h.meta.IsValidObjectType(Object{Type: subjectType}); err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("subject_types: %w", err))
+				return
+			}
+		}
+
+		// Get query parameter 'must_exist'
+		mustExist, err := parseBoolParam(params, "must_exist", false)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// Get all relationships of the resource and all its parents
+		relationships, err := h.authzService.ListRelationships(r.Context(), *resource, subjectTypes)
+		if writeServiceError(w, err) {
+			return
+		}
+		if err != nil {
+			log.Printf("[ERROR] AuthzHandler.ListResourceRelations: s.ListRelationships failed: %v", err)
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		// An empty listing may be that of a resource in no relationship at all, or whose relationships are filtered out
+		exists := len(relationships) > 0
+		if !exists {
+			exists, err = h.authzService.ObjectExists(r.Context(), *resource)
+			if writeServiceError(w, err) {
+				return
+			}
+			if err != nil {
+				log.Printf("[ERROR] AuthzHandler.ListResourceRelations: s.ObjectExists failed: %v", err)
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+		}
+		w.Header().Set(resourceExistsHeader, strconv.FormatBool(exists))
+		if !exists && mustExist {
+			writeError(w, http.StatusNotFound, fmt.Errorf("resource %s:%s is in no relationship", resource.Type, resource.ID))
+			return
+		}
+
+		// Build OK response
+		writeList(w, r, http.StatusOK, relationships)
+	}
+}
+
+// CountResourceRelations handles GET /resources/{resource}/relations/count, counting the relationships of the resource
+// itself (without listing them, nor those of its parents).
+// Optional: relation=<relation> to only count the relationships of that relation (or its aliases).
+func (h *AuthzHandler) CountResourceRelations() router.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		// Get path parameter 'resource'
+		resource, err := parseObjectParam(params, "resource")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := h.meta.IsValidObject(*resource); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// Get optional query parameter 'relation'
+		relation := params["relation"]
+		if relation != "" {
+			if err := h.meta.IsValidRelationName(*resource, relation); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+
+		count, err := h.authzService.CountRelationships(r.Context(), *resource, relation)
+		if writeServiceError(w, err) {
+			return
+		}
+		if err != nil {
+			log.Printf("[ERROR] AuthzHandler.CountResourceRelations: s.CountRelationships failed: %v", err)
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		// Build OK response
+		write(w, http.StatusOK, RelationshipCount{Count: count})
+	}
+}
+
+// ListResourceSubjects handles GET /resources/{resource}/relations/{relation}/subjects
+// It lists the subjects directly related to the resource by the relation, without traversing groups or parents.
+// Responds with one subject per line if the Accept header requests application/x-ndjson.
+func (h *AuthzHandler) ListResourceSubjects() router.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		// Get path parameters 'resource' and 'relation'
+		resource, err := parseObjectParam(params, "resource")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		relation := params["relation"]
+		if err := h.meta.IsValidRelationName(*resource, relation); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// Get the direct subjects of the relation
+		subjects, err := h.authzService.ListSubjects(r.Context(), *resource, relation)
+		if writeServiceError(w, err) {
+			return
+		}
+		if err != nil {
+			log.Printf("[ERROR] AuthzHandler.ListResourceSubjects: s.ListSubjects failed: %v", err)
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if subjects == nil {
+			subjects = []Object{}
+		}
+
+		// Build OK response
+		writeList(w, r, http.StatusOK, subjects)
+	}
+}
+
+// ListSubjectResources handles GET /subjects/{subject}/resources
+// It traverses relationships back from the subject to the resources of every type defining permissions
+// (or only of the comma-separated resource_types), and lists the permissions granted on each resource reached,
+// omitting resources on which none is.
+// Optional: at_least_as_fresh=<consistency token>, max_results=<n> to cap the number of resources traversed
+// (see resultsTruncatedHeader), caveat_context=<JSON object of caveat parameters>.
+// Responds with one resource per line if the Accept header requests application/x-ndjson.
+func (h *AuthzHandler) ListSubjectResources() router.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		w.Header().Set(schemaVersionHeader, h.meta.SchemaVersion)
+
+		// Get path parameter 'subject'
+		subject, err := parseObjectParam(params, "subject")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := h.meta.IsValidObject(*subject); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := rejectSubjectRelations("subject", *subject); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// Get optional query parameter 'resource_types' (default: all types defining permissions)
+		resourceTypes, err := parseListParam(params, "resource_types")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		for _, resourceType := range resourceTypes {
+			if err := h.meta.IsValidObjectType(Object{Type: resourceType}); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+		if len(resourceTypes) == 0 {
+			for _, objectType := range sortedKeys(h.meta.Objects) {
+				if len(h.meta.Objects[objectType].Permissions) > 0 {
+					resourceTypes = append(resourceTypes, objectType)
+				}
+			}
+		}
+
+		// Get optional query parameter 'at_least_as_fresh'
+		atLeastAsFresh, err := parseConsistencyTokenParam(params, "at_least_as_fresh")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// Get optional query parameter 'max_results'
+		maxResults, err := parsePositiveIntParam(params, "max_results")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// Get optional query parameter 'caveat_context'
+		caveatContext, err := parseJSONObjectParam(params, "caveat_context")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// Build reverse traversal request, stopping on any resource of the selected types
+		accesses := []ResourceAccess{}
+		if len(resourceTypes) == 0 {
+			writeList(w, r, http.StatusOK, accesses) // no type defines permissions
+			return
+		}
+		tRequest := TraversalRequest{
+			StartOn:        *subject,
+			Forward:        false,
+			StopOn:         Object{Type: resourceTypes[0]},
+			AtLeastAsFresh: atLeastAsFresh,
+			MaxResults:     maxResults,
+			CaveatContext:  caveatContext,
+		}
+		if len(resourceTypes) > 1 {
+			for _, resourceType := range resourceTypes {
+				tRequest.StopOnAny = append(tRequest.StopOnAny, Object{Type: resourceType})
+			}
+		}
+
+		// Evaluate all permissions of each resource reached
+		items, truncated, err := h.authzService.CheckPermissions(r.Context(), tRequest, CheckOptions{})
+		if writeServiceError(w, err) {
+			return
+		}
+		if err != nil {
+			log.Printf("[ERROR] AuthzHandler.ListSubjectResources: s.CheckPermissions failed: %v", err)
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		for _, item := range items {
+			access := ResourceAccess{Resource: item.Resource}
+			for _, permission := range sortedKeys(item.PermissionEvals) {
+				if item.PermissionEvals[permission].Allowed {
+					access.Permissions = append(access.Permissions, permission)
+				}
+			}
+			if len(access.Permissions) > 0 {
+				accesses = append(accesses, access)
+			}
+		}
+		sort.Slice(accesses, func(i, j int) bool {
+			a, b := accesses[i].Resource, accesses[j].Resource
+			return a.Type < b.Type || (a.Type == b.Type && a.ID < b.ID)
+		})
+
+		// Build OK response
+		if truncated {
+			w.Header().Set(resultsTruncatedHeader, "true")
+		}
+		writeList(w, r, http.StatusOK, accesses)
+	}
+}
+
+// ListPaths handles GET /paths?resource_filter=<type:id>&subject_filter=<type:id>
+// subject_filter may list several comma-separated subjects if resource_filter has an ID.
+// Optional: at_least_as_fresh=<consistency token>, max_results=<n> to cap the number of resource-subject pairs
+// (see resultsTruncatedHeader), caveat_context=<JSON object of caveat parameters>,
+// exclude_relations=<relation>,<relation> to traverse as if these relations did not exist; flags show_eliminated_paths,
+// show_hops (hop metadata on the edges of paths), skip_precedence (every path, not only the effective ones).
+// Responds with one item per line if the Accept header requests application/x-ndjson.
+func (h *AuthzHandler) ListPaths() router.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		// Get query parameter 'resource_filter'
+		resourceFilter, err := parseObjectParam(params, "resource_filter")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := h.meta.IsValidObjectType(*resourceFilter); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// Get query parameter 'subject_filter' (several comma-separated subjects are accepted with a resource ID)
+		subjectFilters, err := parseObjectListParam(params, "subject_filter")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		for _, subjectFilter := range subjectFilters {
+			if err := h.meta.IsValidObjectType(subjectFilter); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+		if resourceFilter.ID == "" && len(subjectFilters) > 1 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("a resource ID must be provided with several subject filters"))
+			return
+		}
+		if resourceFilter.ID == "" && subjectFilters[0].ID == "" {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("either a resource ID or a subject ID must be provided"))
+			return
+		}
+
+		// Get query parameter 'show_eliminated_paths'
+		showEliminatedPaths, err := parseBoolParam(params, "show_eliminated_paths", false)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// Get query parameter 'skip_precedence'
+		skipPrecedence, err := parseBoolParam(params, "skip_precedence", false)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// Get query parameter 'show_hops'
+		showHops, err := parseShowHopsParam(params, false)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// Get optional query parameter 'at_least_as_fresh'
+		atLeastAsFresh, err := parseConsistencyTokenParam(params, "at_least_as_fresh")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// Get optional query parameter 'max_results'
+		maxResults, err := parsePositiveIntParam(params, "max_results")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// Get optional query parameter 'caveat_context'
+		caveatContext, err := parseJSONObjectParam(params, "caveat_context")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// Get optional query parameter 'exclude_relations'
+		excludeRelations, err := h.parseExcludedRelationsParam(params, "exclude_relations")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// Build traversal request
+		tRequest := buildTraversalRequest(*resourceFilter, subjectFilters)
+		tRequest.AtLeastAsFresh = atLeastAsFresh
+		tRequest.CaveatContext = caveatContext
+		tRequest.KeepEliminated = showEliminatedPaths
+		tRequest.SkipPrecedence = skipPrecedence
+		tRequest.MaxResults = maxResults
+		tRequest.ExcludeRelations = excludeRelations
+
+		// List effective paths
+		paths, truncated, err := h.authzService.ListEffectivePaths(r.Context(), tRequest)
+		if writeServiceError(w, err) {
+			return
+		}
+		if err != nil {
+			log.Printf("[ERROR] AuthzHandler.ListPaths: s.ListEffectivePaths failed: %v", err)
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		if showHops {
+			for i := range paths {
+				paths[i].Paths = withHops(paths[i].Paths)
+				for j := range paths[i].EliminatedPaths {
+					paths[i].EliminatedPaths[j].Path = withPathHops(paths[i].EliminatedPaths[j].Path)
+				}
+			}
+		}
+
+		// Build OK response
+		if truncated {
+			w.Header().Set(resultsTruncatedHeader, "true")
+		}
+		writeList(w, r, http.StatusOK, paths)
+	}
+}
+
+// ManageRelationship handles POST /relations
+// Body: {"delete": [...], "create": [...], "precondition": {"must_exist": [...], "must_not_exist": [...]}, "on_conflict": "ignore"}
+// A relationship may not appear in both delete and create.
+// on_conflict tells how creations of existing relationships are handled: "ignore" (the default) leaves them
+// unchanged, "upsert" replaces their expiry and caveat, and "error" fails the write with 409.
+// An optional Idempotency-Key header makes retries of the same request apply only once: a replay responds with the
+// result of the original write (and an Idempotent-Replayed header), and a key reused for another body with 422.
+// Keys are scoped to the actor (see router.Actor).
+// An optional X-Authz-Schema-Version header makes the write fail with 409 if the active schema version differs.
+// With dry_run=true, nothing is persisted: the response summarizes the changes the request would make.
+// With schema_hints=true, a relationship rejected by the schema is reported as a JSON InvalidRelationshipError,
+// listing what the schema allows on its resource type, instead of a plain text error.
+// With best_effort=true, the relationships are written one by one, each in its own transaction, instead of all or
+// nothing: invalid or failing relationships do not prevent the others from being written, and the response details
+// the outcome of each (see WriteResult.Items). Preconditions, idempotency keys and dry runs require an atomic write.
+// Responds with a consistency token: reads passing it as at_least_as_fresh are guaranteed to observe the write
+// (or fail with 503 if the data they read is not yet that fresh), and with the number of relationships created and deleted.
+func (h *AuthzHandler) ManageRelationships() router.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		w.Header().Set(schemaVersionHeader, h.meta.SchemaVersion)
+
+		// Reject writes prepared against another schema version
+		if expected := r.Header.Get(schemaVersionHeader); expected != "" && expected != h.meta.SchemaVersion {
+			writeError(w, http.StatusConflict, fmt.Errorf("schema version mismatch: expected %s, active %s", expected, h.meta.SchemaVersion))
+			return
+		}
+
+		// Decode JSON request body
+		var req WriteRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		rels := req.Relationships()
+
+		// Get query parameter 'dry_run'
+		req.DryRun, err = parseBoolParam(params, "dry_run", false)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// Get query parameter 'schema_hints'
+		schemaHints, err := parseBoolParam(params, "schema_hints", false)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// Get query parameter 'best_effort'
+		req.BestEffort, err = parseBoolParam(params, "best_effort", false)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if req.BestEffort && (req.DryRun || req.Precondition != nil || r.Header.Get("Idempotency-Key") != "") {
+			writeError(w, http.StatusBadRequest, errors.New("best_effort writes support no dry run, precondition nor idempotency key"))
+			return
+		}
+
+		// Enforce batch size limit
+		if h.maxBatchSize > 0 && len(rels) > h.maxBatchSize {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("too many relationships: %d exceeds maximum of %d", len(rels), h.maxBatchSize))
+			return
+		}
+
+		// Validate all creation/delete requests and preconditions
+		// (best-effort writes validate each relationship when writing it, and report the invalid ones)
+		for _, rel := range rels {
+			err := h.meta.IsValidRelation(rel)
+			if err == nil || req.BestEffort {
+				continue
+			}
+			if schemaHints {
+				write(w, http.StatusBadRequest, InvalidRelationshipError{
+					Error:        err.Error(),
+					Relationship: rel,
+					Relations:    h.relationHints(rel.Resource.Type),
+				})
+				return
+			}
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// Reject contradictory requests: the outcome would depend on deletions being applied before creations
+		deleted := make(map[string]bool, len(req.Delete))
+		for _, rel := range req.Delete {
+			deleted[rel.String()] = true
+		}
+		for _, rel := range req.Create {
+			if deleted[rel.String()] {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("relationship is both deleted and created: %s", rel))
+				return
+			}
+		}
+
+		// Validate expiry of all creation requests
+		now := time.Now()
+		for _, rel := range req.Create {
+			if rel.ExpiresAt != nil && !rel.ExpiresAt.After(now) && !req.BestEffort {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("expires_at must be in the future: %s", rel))
+				return
+			}
+		}
+
+		// Check preconditions, execute all deletions, then all creations, at most once per idempotency key
+		idempotencyKey := r.Header.Get("Idempotency-Key")
+		result, err := h.authzService.ApplyRelationships(r.Context(), idempotencyKey, req)
+		if writeServiceError(w, err) {
+			return
+		}
+		if err != nil {
+			log.Printf("[ERROR] AuthzHandler.ManageRelationships: s.ApplyRelationships failed: %v", err)
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if result.Replayed {
+			w.Header().Set("Idempotent-Replayed", "true")
+		}
+
+		// Build OK response
+		write(w, http.StatusOK, result)
+	}
+}
+
+// relationHints returns the relations of an object type with the subject types each allows, or nil if the type is unknown.
+func (h *AuthzHandler) relationHints(objectType string) map[string][]string {
+	objDef, ok := h.meta.Objects[objectType]
+	if !ok {
+		return nil
+	}
+	hints := make(map[string][]string, len(objDef.Relations))
+	for name, relDef := range objDef.Relations {
+		hints[name] = relDef.SubjectTypes
+	}
+	return hints
+}
+
+// ListAuditEntries handles GET /audit, listing audit entries oldest first.
+// Optional filters, combined: resource=<type or type:id>, subject=<type or type:id>, actor=<actor>,
+// action=<create|delete>, since=<RFC 3339 time> (inclusive), until=<RFC 3339 time> (exclusive).
+// Pages hold at most limit=<n> entries (default 1000, see resultsTruncatedHeader): the next page is requested
+// with after=<ID of the last entry>.
+func (h *AuthzHandler) ListAuditEntries() router.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		// Get optional query parameters 'resource' and 'subject'
+		var filter AuditFilter
+		for _, object := range []struct {
+			param  string
+			target *Object
+		}{{"resource", &filter.Resource}, {"subject", &filter.Subject}} {
+			if params[object.param] == "" {
+				continue
+			}
+			parsed, err := parseObjectParam(params, object.param)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			if err := h.meta.IsValidObjectType(*parsed); err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid parameter '%s': %w", object.param, err))
+				return
+			}
+			*object.target = *parsed
+		}
+
+		// Get optional query parameters 'actor' and 'action'
+		filter.Actor = params["actor"]
+		filter.Action = params["action"]
+		if filter.Action != "" && filter.Action != "create" && filter.Action != "delete" {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid parameter 'action': must be 'create' or 'delete'"))
+			return
+		}
+
+		// Get optional query parameters 'since' and 'until'
+		var err error
+		if filter.Since, err = parseTimeParam(params, "since"); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if filter.Until, err = parseTimeParam(params, "until"); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// Get optional query parameters 'after' and 'limit'
+		if raw := params["after"]; raw != "" {
+			if filter.AfterID, err = strconv.ParseInt(raw, 10, 64); err != nil || filter.AfterID < 0 {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid parameter 'after': must be an audit entry ID"))
+				return
+			}
+		}
+		if filter.Limit, err = parsePositiveIntParam(params, "limit"); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// Get audit entries
+		entries, truncated, err := h.authzService.ListAuditEntries(r.Context(), filter)
+		if writeServiceError(w, err) {
+			return
+		}
+		if err != nil {
+			log.Printf("[ERROR] AuthzHandler.ListAuditEntries: s.ListAuditEntries failed: %v", err)
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if entries == nil {
+			entries = []AuditEntry{}
+		}
+
+		// Build OK response
+		if truncated {
+			w.Header().Set(resultsTruncatedHeader, "true")
+		}
+		write(w, http.StatusOK, entries)
+	}
+}
+
+// ListObjectTypes handles GET /schema/objects, listing the object types of the schema in alphabetical order.
+func (h *AuthzHandler) ListObjectTypes() router.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		write(w, http.StatusOK, sortedKeys(h.meta.Objects))
+	}
+}
+
+// GetObjectDefinition handles GET /schema/objects/{type}, returning the relations and permissions of an object type.
+// Optional: include_rules=true to include its precedence rules.
+func (h *AuthzHandler) GetObjectDefinition() router.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		// Get path parameter 'type'
+		objectType, err := parseStringParam(params, "type")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		objDef, ok := h.meta.Objects[objectType]
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("unknown object type: %q", objectType))
+			return
+		}
+
+		// Get query parameter 'include_rules'
+		includeRules, err := parseBoolParam(params, "include_rules", false)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if !includeRules {
+			objDef.PrecedenceRules = nil
+			objDef.PrecedenceMode = ""
+		}
+
+		write(w, http.StatusOK, objDef)
+	}
+}
+
+// GetRelationDefinition handles GET /schema/objects/{type}/relations/{relation}, returning the definition
+// of a relation of an object type, with the subject types it allows. An alias returns the aliased relation.
+func (h *AuthzHandler) GetRelationDefinition() router.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		// Get path parameters 'type' and 'relation'
+		objectType, err := parseStringParam(params, "type")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		relation, err := parseStringParam(params, "relation")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		objDef, ok := h.meta.Objects[objectType]
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("unknown object type: %q", objectType))
+			return
+		}
+		if aliased, ok := objDef.Aliases[relation]; ok {
+			relation = aliased
+		}
+		relDef, ok := objDef.Relations[relation]
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("unknown relation %q on type %q", relation, objectType))
+			return
+		}
+
+		write(w, http.StatusOK, relDef)
+	}
+}
+
+// ValidateSchema handles POST /schema/validate, validating the YAML schema of the body as on load, without applying it.
+// Responds with a SchemaValidation: 200 if the schema is valid, 422 with every error found otherwise.
+func (h *AuthzHandler) ValidateSchema() router.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		// Read YAML request body
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeBodyError(w, err)
+			return
+		}
+
+		validation := ValidateSchema(data)
+		if !validation.Valid {
+			write(w, http.StatusUnprocessableEntity, validation)
+			return
+		}
+		write(w, http.StatusOK, validation)
+	}
+}
+
+// ExportRelations handles GET /relations/export, streaming all relationships as newline-delimited JSON
+// (the format accepted by authzctl import). The relationships are read from a consistent snapshot.
+func (h *AuthzHandler) ExportRelations() router.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		start := time.Now()
+
+		// Headers are written with the first relationship, so that an early failure can still be reported
+		streaming := false
+		startStream := func() {
+			w.Header().Set("Content-Type", ndjsonContentType)
+			w.WriteHeader(http.StatusOK)
+			streaming = true
+		}
+
+		count := 0
+		enc := json.NewEncoder(w)
+		err := h.authzService.ExportRelationships(r.Context(), func(rel Relationship) error {
+			if !streaming {
+				startStream()
+			}
+			count++
+			return enc.Encode(rel)
+		})
+		if !streaming && writeServiceError(w, err) {
+			return
+		}
+		if err != nil && !streaming {
+			log.Printf("[ERROR] AuthzHandler.ExportRelations: s.ExportRelationships failed: %v", err)
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if err != nil {
+			// The response is truncated: the client sees a premature end of stream
+			log.Printf("[ERROR] AuthzHandler.ExportRelations: s.ExportRelationships failed after %d relationships: %v", count, err)
+			return
+		}
+		if !streaming {
+			startStream() // no relationship
+		}
+
+		log.Printf("[INFO] AuthzHandler.ExportRelations: exported %d relationships in %v", count, time.Since(start))
+	}
+}
+
+// WatchRelations handles GET /relations/watch, streaming relationship changes as Server-Sent Events.
+// Optional: since=<cursor> (or the Last-Event-ID header) to resume after a given change;
+// without it, only changes occurring after the connection are streamed.
+func (h *AuthzHandler) WatchRelations() router.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming is not supported"))
+			return
+		}
+
+		// Get query parameter 'since', falling back to the Last-Event-ID header on reconnection
+		if _, ok := params["since"]; !ok && r.Header.Get("Last-Event-ID") != "" {
+			params["since"] = r.Header.Get("Last-Event-ID")
+		}
+		since := int64(-1)
+		if raw := params["since"]; raw != "" {
+			cursor, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil || cursor < 0 {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid parameter 'since': must be a change cursor"))
+				return
+			}
+			since = cursor
+		}
+
+		// Subscribe to changes until the client disconnects
+		changes, err := h.authzService.WatchChanges(r.Context(), since)
+		if writeServiceError(w, err) {
+			return
+		}
+		if err != nil {
+			log.Printf("[ERROR] AuthzHandler.WatchRelations: s.WatchChanges failed: %v", err)
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		keepalive := time.NewTicker(watchKeepaliveInterval)
+		defer keepalive.Stop()
+		for {
+			select {
+			case change, ok := <-changes:
+				if !ok {
+					return // client disconnected or feed failed
+				}
+				data, err := json.Marshal(change)
+				if err != nil {
+					log.Printf("[ERROR] AuthzHandler.WatchRelations: marshal change failed: %v", err)
+					return
+				}
+				fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", change.ID, change.Action, data)
+			case <-keepalive.C:
+				fmt.Fprint(w, ": ping\n\n")
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// buildTraversalRequest builds a traversal request from resource and subject filters.
+// Rules for performance:
+// - If resource filter is specific (type:id), traverse forward (resource → subject), stopping on any subject filter.
+// - If subject filters is specific (type:id), traverse backward (subject → resource).
+// Several subject filters require a specific resource filter.
+func buildTraversalRequest(resourceFilter Object, subjectFilters []Object) TraversalRequest {
+	if resourceFilter.ID != "" {
+		request := TraversalRequest{
+			StartOn: resourceFilter,
+			Forward: true,
+			StopOn:  subjectFilters[0],
+		}
+		if len(subjectFilters) > 1 {
+			request.StopOnAny = subjectFilters
+		}
+		return request
+	}
+	return TraversalRequest{
+		StartOn: subjectFilters[0],
+		Forward: false,
+		StopOn:  resourceFilter,
+	}
+}
+
+func parseStringParam(params map[string]string, paramName string) (string, error) {
+	raw, ok := params[paramName]
+	if !ok || raw == "" {
+		return "", fmt.Errorf("missing parameter '%s'", paramName)
+	}
+	return raw, nil
+}
+
+func parseConsistencyTokenParam(params map[string]string, paramName string) (string, error) {
+	raw, ok := params[paramName]
+	if !ok || raw == "" {
+		return "", nil
+	}
+
+	if _, err := strconv.ParseUint(raw, 10, 64); err != nil {
+		return "", fmt.Errorf("invalid parameter '%s': must be a consistency token returned by a write", paramName)
+	}
+
+	return raw, nil
+}
+
+// parseTimeParam parses an optional RFC 3339 time, returning the zero time if absent.
+func parseTimeParam(params map[string]string, paramName string) (time.Time, error) {
+	raw, ok := params[paramName]
+	if !ok || raw == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid parameter '%s': must be an RFC 3339 time", paramName)
+	}
+	return t, nil
+}
+
+// parseAsOfParam parses an optional past RFC 3339 time (see TraversalRequest.AsOf), returning the zero time
+// if absent. It may not be combined with a consistency token (freshParamName): past data is never stale.
+func parseAsOfParam(params map[string]string, paramName, freshParamName string) (time.Time, error) {
+	asOf, err := parseTimeParam(params, paramName)
+	if err != nil || asOf.IsZero() {
+		return asOf, err
+	}
+	if asOf.After(time.Now()) {
+		return time.Time{}, fmt.Errorf("invalid parameter '%s': must not be in the future", paramName)
+	}
+	if params[freshParamName] != "" {
+		return time.Time{}, fmt.Errorf("parameter '%s' cannot be combined with '%s'", paramName, freshParamName)
+	}
+	return asOf, nil
+}
+
+func parseJSONObjectParam(params map[string]string, paramName string) (map[string]interface{}, error) {
+	raw, ok := params[paramName]
+	if !ok || raw == "" {
+		return nil, nil
+	}
+
+	var val map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &val); err != nil || val == nil {
+		return nil, fmt.Errorf("invalid parameter '%s': must be a JSON object", paramName)
+	}
+
+	return val, nil
+}
+
+func parseListParam(params map[string]string, paramName string) ([]string, error) {
+	raw, ok := params[paramName]
+	if !ok || raw == "" {
+		return nil, nil
+	}
+
+	var items []string
+	for _, item := range strings.Split(raw, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			return nil, fmt.Errorf("invalid parameter '%s': empty item in list", paramName)
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// parseExcludedRelationsParam parses a comma-separated list of relations to exclude from traversals,
+// each defined (possibly as an alias) on some object type.
+func (h *AuthzHandler) parseExcludedRelationsParam(params map[string]string, paramName string) ([]string, error) {
+	relations, err := parseListParam(params, paramName)
+	if err != nil {
+		return nil, err
+	}
+	for _, relation := range relations {
+		if err := h.meta.IsKnownRelation(relation); err != nil {
+			return nil, fmt.Errorf("invalid parameter '%s': %w", paramName, err)
+		}
+	}
+	return relations, nil
+}
+
+// parsePathFormatParam parses the format of the paths of a response: "full" (the default) or "compact"
+// (see CompactPaths). It reports whether paths are to be compacted.
+func parsePathFormatParam(params map[string]string, paramName string) (bool, error) {
+	switch params[paramName] {
+	case "", "full":
+		return false, nil
+	case "compact":
+		return true, nil
+	}
+	return false, fmt.Errorf("invalid parameter '%s': must be 'full' or 'compact'", paramName)
+}
+
+// compactMatchingPaths moves the matching paths of an evaluation (and of the evaluations it details) to their compact form.
+func compactMatchingPaths(eval PermissionEval) PermissionEval {
+	eval.MatchingPathsCompact = NewCompactPaths(eval.MatchingPaths)
+	eval.MatchingPaths = nil
+	for permission, detail := range eval.Permissions {
+		eval.Permissions[permission] = compactMatchingPaths(detail)
+	}
+	return eval
+}
+
+// parseShowHopsParam parses the show_hops flag, which annotates the edges of the paths of a response with their
+// hop metadata (see Relationship.Hop). It requires the full path format, as compact paths have no edges to annotate.
+func parseShowHopsParam(params map[string]string, compactPaths bool) (bool, error) {
+	showHops, err := parseBoolParam(params, "show_hops", false)
+	if err != nil {
+		return false, err
+	}
+	if showHops && compactPaths {
+		return false, fmt.Errorf("invalid parameter 'show_hops': requires path_format=full")
+	}
+	return showHops, nil
+}
+
+// withHops returns a copy of paths whose edges carry their hop metadata (see withPathHops).
+func withHops(paths [][]Relationship) [][]Relationship {
+	if paths == nil {
+		return nil
+	}
+	annotated := make([][]Relationship, len(paths))
+	for i, path := range paths {
+		annotated[i] = withPathHops(path)
+	}
+	return annotated
+}
+
+// withPathHops returns a copy of path whose edges carry their hop metadata, in traversal order from the resource.
+// The path is copied rather than annotated in place, as it may be shared with cached results.
+func withPathHops(path []Relationship) []Relationship {
+	annotated := make([]Relationship, len(path))
+	for hop, edge := range path {
+		edge.Hop = &hop
+		edge.Terminal = hop == len(path)-1
+		annotated[hop] = edge
+	}
+	return annotated
+}
+
+// hopMatchingPaths annotates the matching paths of an evaluation (and of the evaluations it details) with their hop metadata.
+func hopMatchingPaths(eval PermissionEval) PermissionEval {
+	eval.MatchingPaths = withHops(eval.MatchingPaths)
+	for permission, detail := range eval.Permissions {
+		eval.Permissions[permission] = hopMatchingPaths(detail)
+	}
+	return eval
+}
+
+// parseBoolParam parses a boolean flag: "true" or "1", "false" or "0" (in any case).
+// An absent flag takes the default value; a flag present without a value is rejected,
+// rather than silently read as false whatever its default.
+func parseBoolParam(params map[string]string, paramName string, defaultVal bool) (bool, error) {
+	raw, ok := params[paramName]
+	if !ok {
+		return defaultVal, nil
+	}
+
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case "true", "1":
+		return true, nil
+	case "false", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid parameter '%s': must be a boolean ('true' or 'false'), got %q", paramName, raw)
+}
+
+func parsePositiveIntParam(params map[string]string, paramName string) (int, error) {
+	raw, ok := params[paramName]
+	if !ok || raw == "" {
+		return 0, nil
+	}
+
+	val, err := strconv.Atoi(raw)
+	if err != nil || val <= 0 {
+		return 0, fmt.Errorf("invalid parameter '%s': must be a positive integer", paramName)
+	}
+
+	return val, nil
+}
+
+func parseObjectListParam(params map[string]string, paramName string) ([]Object, error) {
+	raw, err := parseListParam(params, paramName)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) == 0 {
+		return nil, fmt.Errorf("required parameter '%s'", paramName)
+	}
+
+	objects := make([]Object, 0, len(raw))
+	for _, item := range raw {
+		objectType, id, _ := strings.Cut(item, ":")
+		objects = append(objects, Object{Type: objectType, ID: id})
+	}
+
+	return objects, nil
+}
+
+// rejectSubjectRelations returns an error if a subject names a subject relation ("type:id#relation", a userset):
+// relationships only relate concrete subjects, so the traversal could never stop on it, and the check
+// would silently deny instead of failing.
+func rejectSubjectRelations(paramName string, subjects ...Object) error {
+	for _, subject := range subjects {
+		if id, relation, ok := strings.Cut(subject.ID, "#"); ok {
+			return fmt.Errorf("invalid parameter '%s': subject relations are not supported (%s:%s#%s)", paramName, subject.Type, id, relation)
+		}
+	}
+	return nil
+}
+
+// parseObjectParam parses a "type:id" (or type only) query parameter, see splitObject.
+func parseObjectParam(params map[string]string, paramName string) (*Object, error) {
+	raw := strings.TrimSpace(params[paramName])
+	if raw == "" {
+		return nil, fmt.Errorf("required parameter '%s'", paramName)
+	}
+
+	object, _ := splitObject(raw)
+	return &object, nil
+}
+
+func write(w http.ResponseWriter, statusCode int, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if payload != nil {
+		json.NewEncoder(w).Encode(payload)
+	}
+}
+
+// ndjsonContentType is the newline-delimited JSON media type, which list endpoints return if the request accepts it.
+const ndjsonContentType = "application/x-ndjson"
+
+// writeList writes a list as a JSON array, or as newline-delimited JSON if the Accept header lists ndjsonContentType:
+// each item is then encoded on its own line. The list is computed before the response is written, which is not
+// flushed item by item: streaming items as they are computed is out of scope (see ExportRelations for a stream).
+func writeList[T any](w http.ResponseWriter, r *http.Request, statusCode int, items []T) {
+	if !acceptsNDJSON(r) {
+		write(w, statusCode, items)
+		return
+	}
+	w.Header().Set("Content-Type", ndjsonContentType)
+	w.WriteHeader(statusCode)
+	enc := json.NewEncoder(w)
+	for _, item := range items {
+		if err := enc.Encode(item); err != nil {
+			return // the client is gone
+		}
+	}
+}
+
+// acceptsNDJSON returns true if the Accept header of the request lists ndjsonContentType.
+func acceptsNDJSON(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			if mediaType, _, err := mime.ParseMediaType(mediaRange); err == nil && mediaType == ndjsonContentType {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func writeError(w http.ResponseWriter, statusCode int, err error) {
+	w.WriteHeader(statusCode)
+	w.Write([]byte(err.Error()))
+}
+
+// writeBodyError responds to a request whose body failed to decode: with 413 if it exceeds the limit
+// set by router.MaxBodyBytes, with 400 otherwise.
+func writeBodyError(w http.ResponseWriter, err error) {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("request body too large: limit is %d bytes", maxErr.Limit))
+		return
+	}
+	writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %s", err))
+}
+
+// serviceErrorStatuses maps the errors the service may return to the status of their response.
+var serviceErrorStatuses = []struct {
+	err        error
+	statusCode int
+}{
+	{ErrPreconditionFailed, http.StatusConflict},
+	{ErrRelationshipExists, http.StatusConflict},
+	{ErrIdempotencyKeyReused, http.StatusUnprocessableEntity},
+	{ErrStaleRead, http.StatusServiceUnavailable},
+	{ErrCaveatContext, http.StatusBadRequest},
+	{ErrInvalidTraversal, http.StatusBadRequest},
+	{ErrHistoryUnavailable, http.StatusBadRequest},
+	{ErrQueryTimeout, http.StatusGatewayTimeout},
+	{ErrCircuitOpen, http.StatusServiceUnavailable},
+	{errors.ErrUnsupported, http.StatusNotImplemented},
+}
+
+// writeServiceError writes the response of a service error listed in serviceErrorStatuses,
+// and reports whether it did: other errors (and nil) are left to the caller.
+func writeServiceError(w http.ResponseWriter, err error) bool {
+	for _, mapping := range serviceErrorStatuses {
+		if errors.Is(err, mapping.err) {
+			writeError(w, mapping.statusCode, err)
+			return true
+		}
+	}
+	return false
 }
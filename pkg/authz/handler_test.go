@@ -0,0 +1,1876 @@
+package authz_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/romrossi/authz-rebac/pkg/authz"
+	"github.com/romrossi/authz-rebac/pkg/db"
+	"github.com/romrossi/authz-rebac/pkg/router"
+)
+
+func TestCheckPermissionExplain(t *testing.T) {
+	h, _, repo := newTestServer(t, authz.LoadMetadata())
+	seedRelations(t, repo,
+		rel("project:1", "owner", "user:alice"),
+		rel("project:1", "forbidden", "user:alice"),
+	)
+
+	var eval authz.PermissionEval
+	rec := serve(h, "GET", v1Prefix+"/permissions/edit?resource=project:1&subject=user:alice&explain=true", "")
+	decode(t, rec, http.StatusOK, &eval)
+	if eval.Allowed || eval.Explanation == nil || eval.Explanation.ExcludedBy != "forbidden" {
+		t.Errorf("eval = %+v, want denied and excluded by forbidden", eval)
+	}
+
+	eval = authz.PermissionEval{}
+	rec = serve(h, "GET", v1Prefix+"/permissions/edit?resource=project:1&subject=user:alice", "")
+	decode(t, rec, http.StatusOK, &eval)
+	if eval.Explanation != nil {
+		t.Errorf("explanation = %+v, want none without explain", eval.Explanation)
+	}
+}
+
+func TestListPathsShowEliminatedPaths(t *testing.T) {
+	h, _, repo := newTestServer(t, authz.LoadMetadata())
+	seedRelations(t, repo,
+		rel("project:1", "owner", "group:eng"),
+		rel("group:eng", "member", "user:alice"),
+		rel("project:1", "reader", "user:alice"),
+	)
+
+	var items []authz.TraversalResponseItem
+	rec := serve(h, "GET", v1Prefix+"/paths?resource_filter=project:1&subject_filter=user:alice&show_eliminated_paths=true", "")
+	decode(t, rec, http.StatusOK, &items)
+	if len(items) != 1 || len(items[0].Paths) != 1 || len(items[0].EliminatedPaths) != 1 {
+		t.Fatalf("items = %+v, want one effective and one eliminated path", items)
+	}
+	if items[0].EliminatedPaths[0].Path[0].Relation != "owner" {
+		t.Errorf("eliminated path = %v, want the path through group:eng", items[0].EliminatedPaths[0].Path)
+	}
+
+	items = nil
+	rec = serve(h, "GET", v1Prefix+"/paths?resource_filter=project:1&subject_filter=user:alice", "")
+	decode(t, rec, http.StatusOK, &items)
+	if len(items) != 1 || items[0].EliminatedPaths != nil {
+		t.Errorf("items = %+v, want no eliminated paths without show_eliminated_paths", items)
+	}
+}
+
+func TestListPathsSkipPrecedence(t *testing.T) {
+	h, svc, repo := newTestServer(t, authz.LoadMetadata())
+	seedRelations(t, repo,
+		rel("project:1", "owner", "group:eng"),
+		rel("group:eng", "member", "user:alice"),
+		rel("project:1", "reader", "user:alice"),
+	)
+
+	// Without reduction, the path eliminated by precedence rules is listed along with the effective one
+	var items []authz.TraversalResponseItem
+	rec := serve(h, "GET", v1Prefix+"/paths?resource_filter=project:1&subject_filter=user:alice&skip_precedence=true&show_eliminated_paths=true", "")
+	decode(t, rec, http.StatusOK, &items)
+	if len(items) != 1 || len(items[0].Paths) != 2 || len(items[0].EliminatedPaths) != 0 {
+		t.Fatalf("items = %+v, want both paths, none eliminated", items)
+	}
+	relations := []string{items[0].Paths[0][0].Relation, items[0].Paths[1][0].Relation}
+	if !slices.Contains(relations, "owner") || !slices.Contains(relations, "reader") {
+		t.Errorf("paths start with %v, want the owner and reader paths", relations)
+	}
+
+	// Checks always evaluate effective paths: the owner path still does not grant delete
+	request := authz.TraversalRequest{StartOn: obj("project:1"), Forward: true, StopOn: obj("user:alice"), SkipPrecedence: true}
+	checked, _, err := svc.CheckPermissions(context.Background(), request, authz.CheckOptions{Permissions: []string{"delete"}})
+	if err != nil || len(checked) != 1 || checked[0].PermissionEvals["delete"].Allowed {
+		t.Errorf("CheckPermissions() = %+v, %v, want delete denied", checked, err)
+	}
+}
+
+func TestManageRelationshipsRejectsMalformedIDs(t *testing.T) {
+	h, _, _ := newTestServer(t, authz.LoadMetadata())
+	for _, id := range []string{"a:b", "alice smith", strings.Repeat("x", 257)} {
+		body := `{"create": [{"resource": "project:1", "relation": "reader", "subject": ` + strconv.Quote("user:"+id) + `}]}`
+		if rec := serve(h, "POST", v1Prefix+"/relations", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("create with subject id %q: status = %d, want 400 (body: %s)", id, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestManageRelationshipsBatchLimit(t *testing.T) {
+	meta := authz.LoadMetadata()
+	svc, _ := newService(t, meta)
+	h := routes(authz.NewAuthzHandler(svc, meta, 2))
+
+	body := `{"create": [{"resource": "project:1", "relation": "reader", "subject": "user:a"},
+		{"resource": "project:1", "relation": "reader", "subject": "user:b"}],
+		"delete": [{"resource": "project:1", "relation": "reader", "subject": "user:c"}]}`
+	rec := serve(h, "POST", v1Prefix+"/relations", body)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "too many relationships: 3 exceeds maximum of 2") {
+		t.Errorf("status = %d (body: %s), want 400 too many relationships", rec.Code, rec.Body)
+	}
+
+	body = `{"create": [{"resource": "project:1", "relation": "reader", "subject": "user:a"},
+		{"resource": "project:1", "relation": "reader", "subject": "user:b"}]}`
+	if rec := serve(h, "POST", v1Prefix+"/relations", body); rec.Code != http.StatusOK {
+		t.Errorf("status = %d (body: %s), want 200 at the limit", rec.Code, rec.Body)
+	}
+}
+
+func TestManageRelationshipsReplay(t *testing.T) {
+	h, _, _ := newTestServer(t, authz.LoadMetadata())
+	body := `{"create": [{"resource": "project:1", "relation": "reader", "subject": "user:alice"}]}`
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", v1Prefix+"/relations", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Idempotency-Key", "retry-1")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := post(body)
+	if first.Code != http.StatusOK || first.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("status = %d, replayed = %q, want 200 not replayed", first.Code, first.Header().Get("Idempotent-Replayed"))
+	}
+	replay := post(body)
+	if replay.Code != http.StatusOK || replay.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("status = %d, replayed = %q, want 200 replayed", replay.Code, replay.Header().Get("Idempotent-Replayed"))
+	}
+	if replay.Body.String() != first.Body.String() {
+		t.Errorf("replay body = %s, want the original %s", replay.Body, first.Body)
+	}
+
+	rec := post(`{"create": [{"resource": "project:1", "relation": "owner", "subject": "user:alice"}]}`)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("status = %d (body: %s), want 422 for a key reused with another body", rec.Code, rec.Body)
+	}
+}
+
+func TestManageRelationshipsPreconditionFailed(t *testing.T) {
+	h, _, _ := newTestServer(t, authz.LoadMetadata())
+	body := `{"create": [{"resource": "project:1", "relation": "reader", "subject": "user:bob"}],
+		"precondition": {"must_exist": [{"resource": "project:1", "relation": "owner", "subject": "user:alice"}]}}`
+	rec := serve(h, "POST", v1Prefix+"/relations", body)
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "precondition failed") {
+		t.Errorf("status = %d (body: %s), want 409 precondition failed", rec.Code, rec.Body)
+	}
+}
+
+func TestCheckPermissionsPermissionFilter(t *testing.T) {
+	h, _, repo := newTestServer(t, authz.LoadMetadata())
+	seedRelations(t, repo, rel("project:1", "reader", "user:alice"))
+
+	var items []authz.PermissionCheckItem
+	rec := serve(h, "GET", v1Prefix+"/permissions?resource_filter=project:1&subject_filter=user:alice&permission=edit", "")
+	decode(t, rec, http.StatusOK, &items)
+	if len(items) != 1 || len(items[0].PermissionEvals) != 1 {
+		t.Fatalf("items = %+v, want edit only", items)
+	}
+	if eval, ok := items[0].PermissionEvals["edit"]; !ok || eval.Allowed {
+		t.Errorf("edit = %+v, want denied", eval)
+	}
+
+	rec = serve(h, "GET", v1Prefix+"/permissions?resource_filter=project:1&subject_filter=user:alice&permission=fly", "")
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400 for an unknown permission", rec.Code)
+	}
+
+	// Several permissions, also when listing the resources of a type
+	for _, query := range []string{"resource_filter=project:1&subject_filter=user:alice", "resource_filter=project&subject_filter=user:alice"} {
+		items = nil
+		decode(t, serve(h, "GET", v1Prefix+"/permissions?"+query+"&permissions=read,edit", ""), http.StatusOK, &items)
+		if len(items) != 1 || len(items[0].PermissionEvals) != 2 || !items[0].PermissionEvals["read"].Allowed || items[0].PermissionEvals["edit"].Allowed {
+			t.Errorf("%s: items = %+v, want read allowed and edit denied only", query, items)
+		}
+	}
+
+	rec = serve(h, "GET", v1Prefix+"/permissions?resource_filter=project&subject_filter=user:alice&permissions=read,edt", "")
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `did you mean "edit"?`) {
+		t.Errorf("status = %d (body: %s), want 400 for an unknown permission in the list", rec.Code, rec.Body)
+	}
+}
+
+func TestCheckPermissionsExactPair(t *testing.T) {
+	meta := authz.LoadMetadata()
+	h, _, repo := newTestServer(t, meta)
+	seedRelations(t, repo, rel("project:1", "reader", "user:alice"))
+	all := len(meta.Objects["project"].Permissions)
+
+	// Every permission of the pair, as a single item
+	var item authz.PermissionCheckItem
+	decode(t, serve(h, "GET", v1Prefix+"/permissions?resource=project:1&subject=user:alice", ""), http.StatusOK, &item)
+	if item.Resource != obj("project:1") || item.Subject != obj("user:alice") || len(item.PermissionEvals) != all {
+		t.Fatalf("item = %+v, want the %d permissions of project:1 for user:alice", item, all)
+	}
+	if !item.PermissionEvals["read"].Allowed || item.PermissionEvals["edit"].Allowed {
+		t.Errorf("permissions = %+v, want read allowed and edit denied", item.PermissionEvals)
+	}
+
+	// Denied permissions are listed too when no path connects the pair
+	item = authz.PermissionCheckItem{}
+	decode(t, serve(h, "GET", v1Prefix+"/permissions?resource=project:1&subject=user:bob&show_reason=true", ""), http.StatusOK, &item)
+	if item.Subject != obj("user:bob") || len(item.PermissionEvals) != all {
+		t.Fatalf("item = %+v, want the %d permissions of project:1 for user:bob", item, all)
+	}
+	for permission, eval := range item.PermissionEvals {
+		if eval.Allowed || eval.Reason != authz.DenyReasonNoPath {
+			t.Errorf("%s = %+v, want denied without path", permission, eval)
+		}
+	}
+	item = authz.PermissionCheckItem{}
+	decode(t, serve(h, "GET", v1Prefix+"/permissions?resource=project:1&subject=user:bob&permissions=read,edit", ""), http.StatusOK, &item)
+	if len(item.PermissionEvals) != 2 {
+		t.Errorf("permissions = %+v, want read and edit only", item.PermissionEvals)
+	}
+
+	for _, query := range []string{
+		"resource=project:1",                   // no subject
+		"resource=project&subject=user:alice",  // no resource ID
+		"resource=project:1&subject=user",      // no subject ID
+		"resource=projet:1&subject=user:alice", // unknown type
+		"resource=project:1&subject=user:alice&subject_filter=user:bob",
+	} {
+		if rec := serve(h, "GET", v1Prefix+"/permissions?"+query, ""); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d (body: %s), want 400", query, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestCheckSubjects(t *testing.T) {
+	h, _, repo := newTestServer(t, authz.LoadMetadata())
+	seedRelations(t, repo,
+		rel("project:1", "reader", "group:devs"),
+		rel("group:devs", "member", "user:alice"),
+		rel("group:devs", "member", "user:carol"),
+		rel("project:1", "forbidden", "user:carol"),
+		rel("project:1", "owner", "user:bob"),
+	)
+
+	var checks []authz.SubjectCheck
+	body := `{"resource": "project:1", "subjects": ["user:dave", "user:alice", "user:bob", "user:carol", "group:devs"]}`
+	decode(t, serve(h, "POST", v1Prefix+"/permissions/read:checkSubjects", body), http.StatusOK, &checks)
+	want := []authz.SubjectCheck{
+		{Subject: obj("user:dave"), Allowed: false},  // no path
+		{Subject: obj("user:alice"), Allowed: true},  // through group:devs
+		{Subject: obj("user:bob"), Allowed: true},    // owner
+		{Subject: obj("user:carol"), Allowed: false}, // through group:devs, but forbidden
+		{Subject: obj("group:devs"), Allowed: true},
+	}
+	if !reflect.DeepEqual(checks, want) {
+		t.Errorf("checks = %+v, want %+v", checks, want)
+	}
+
+	// Each subject gets the evaluation of the permission, as checked one by one
+	decode(t, serve(h, "POST", v1Prefix+"/permissions/edit:checkSubjects", body), http.StatusOK, &checks)
+	for _, check := range checks {
+		var eval authz.PermissionEval
+		target := v1Prefix + "/permissions/edit?resource=project:1&subject=" + check.Subject.Type + ":" + check.Subject.ID
+		decode(t, serve(h, "GET", target, ""), http.StatusOK, &eval)
+		if check.Allowed != eval.Allowed {
+			t.Errorf("edit of %s:%s allowed = %v, want %v as checked alone", check.Subject.Type, check.Subject.ID, check.Allowed, eval.Allowed)
+		}
+	}
+
+	for _, tt := range []struct{ permission, body string }{
+		{"fly", body},
+		{"read", `{"resource": "project:1", "subjects": []}`},
+		{"read", `{"resource": "project", "subjects": ["user:alice"]}`},
+		{"read", `{"resource": "project:1", "subjects": ["user:alice", "user"]}`},
+		{"read", `{"resource": "project:1", "subjects": ["group:devs#member"]}`},
+	} {
+		if rec := serve(h, "POST", v1Prefix+"/permissions/"+tt.permission+":checkSubjects", tt.body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s %s: status = %d (body: %s), want 400", tt.permission, tt.body, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestListResourceRelationsSubjectTypes(t *testing.T) {
+	meta := loadSchema(t, `
+schema_version: "1.0"
+objects:
+  user:
+    relations: {}
+  service_account:
+    relations: {}
+  team:
+    relations:
+      member:
+        subject_types: [user]
+  doc:
+    relations:
+      viewer:
+        subject_types: [user, service_account, team]
+`)
+	h, _, repo := newTestServer(t, meta)
+	seedRelations(t, repo,
+		rel("doc:1", "viewer", "user:alice"),
+		rel("doc:1", "viewer", "service_account:ci"),
+		rel("doc:1", "viewer", "team:ops"),
+	)
+
+	var relationships []authz.Relationship
+	rec := serve(h, "GET", v1Prefix+"/resources/doc:1/relations?subject_types=service_account,team", "")
+	decode(t, rec, http.StatusOK, &relationships)
+	if len(relationships) != 2 {
+		t.Fatalf("relationships = %v, want those to service_account:ci and team:ops", relationships)
+	}
+	for _, relationship := range relationships {
+		if relationship.Subject.Type == "user" {
+			t.Errorf("relationship %s has a subject type not requested", relationship)
+		}
+	}
+
+	relationships = nil
+	rec = serve(h, "GET", v1Prefix+"/resources/doc:1/relations", "")
+	decode(t, rec, http.StatusOK, &relationships)
+	if len(relationships) != 3 {
+		t.Errorf("relationships = %v, want every relationship without subject_types", relationships)
+	}
+
+	rec = serve(h, "GET", v1Prefix+"/resources/doc:1/relations?subject_types=user,robot", "")
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "subject_types") {
+		t.Errorf("status = %d (body: %s), want 400 for an unknown subject type", rec.Code, rec.Body)
+	}
+}
+
+// tokenRepository issues consistency tokens, which SQLite does not: the token of a write is its sequence number,
+// and reads see the writes whose token was issued.
+type tokenRepository struct {
+	authz.AuthzRepository
+	issued atomic.Int64
+}
+
+func (r *tokenRepository) ConsistencyToken(ctx context.Context) (string, error) {
+	return strconv.FormatInt(r.issued.Add(1), 10), nil
+}
+
+func (r *tokenRepository) IsConsistencyTokenVisible(ctx context.Context, token string) (bool, error) {
+	n, err := strconv.ParseInt(token, 10, 64)
+	return n <= r.issued.Load(), err
+}
+
+func TestListResourceRelationsResourceExists(t *testing.T) {
+	h, _, repo := newTestServer(t, authz.LoadMetadata())
+	seedRelations(t, repo, rel("project:1", "reader", "group:a"))
+
+	for _, tt := range []struct {
+		name     string
+		resource string
+		query    string
+		count    int
+		exists   string
+	}{
+		{"with relationships", "project:1", "", 1, "true"},
+		{"relationships filtered out", "project:1", "?subject_types=user", 0, "true"},
+		{"subject only, nobody has access", "group:a", "", 0, "true"},
+		{"in no relationship", "project:2", "", 0, "false"},
+		{"with relationships, must exist", "project:1", "?must_exist=true", 1, "true"},
+		{"subject only, must exist", "group:a", "?must_exist=true", 0, "true"},
+	} {
+		var relationships []authz.Relationship
+		rec := serve(h, "GET", v1Prefix+"/resources/"+tt.resource+"/relations"+tt.query, "")
+		decode(t, rec, http.StatusOK, &relationships)
+		if len(relationships) != tt.count || rec.Header().Get("Resource-Exists") != tt.exists {
+			t.Errorf("%s: %d relationships, Resource-Exists %q, want %d and %q",
+				tt.name, len(relationships), rec.Header().Get("Resource-Exists"), tt.count, tt.exists)
+		}
+	}
+
+	rec := serve(h, "GET", v1Prefix+"/resources/project:2/relations?must_exist=true", "")
+	if rec.Code != http.StatusNotFound || rec.Header().Get("Resource-Exists") != "false" {
+		t.Errorf("status = %d, Resource-Exists %q (body: %s), want 404 for a resource in no relationship",
+			rec.Code, rec.Header().Get("Resource-Exists"), rec.Body)
+	}
+}
+
+func TestCheckAtLeastAsFresh(t *testing.T) {
+	meta := authz.LoadMetadata()
+	_, sqliteRepo := newService(t, meta)
+	h := routes(authz.NewAuthzHandler(authz.NewService(&tokenRepository{AuthzRepository: sqliteRepo}, meta), meta, 0))
+
+	var result authz.WriteResult
+	rec := serve(h, "POST", v1Prefix+"/relations", `{"create": [{"resource": "project:1", "relation": "reader", "subject": "user:alice"}]}`)
+	decode(t, rec, http.StatusOK, &result)
+	if result.ConsistencyToken == "" {
+		t.Fatal("write returned no consistency token")
+	}
+
+	var eval authz.PermissionEval
+	rec = serve(h, "GET", v1Prefix+"/permissions/read?resource=project:1&subject=user:alice&at_least_as_fresh="+result.ConsistencyToken, "")
+	decode(t, rec, http.StatusOK, &eval)
+	if !eval.Allowed {
+		t.Error("read denied with the token of the write granting it")
+	}
+
+	rec = serve(h, "GET", v1Prefix+"/permissions/read?resource=project:1&subject=user:alice&at_least_as_fresh=99", "")
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want 503 for a token not yet visible", rec.Code)
+	}
+	rec = serve(h, "GET", v1Prefix+"/permissions/read?resource=project:1&subject=user:alice&at_least_as_fresh=latest", "")
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400 for a malformed token", rec.Code)
+	}
+}
+
+func TestCheckAsOf(t *testing.T) {
+	h, _, repo := newTestServer(t, authz.LoadMetadata())
+	ctx := context.Background()
+	// Changes are timed to the millisecond: times are taken a few milliseconds apart from them
+	tick := func() time.Time {
+		time.Sleep(5 * time.Millisecond)
+		now := time.Now()
+		time.Sleep(5 * time.Millisecond)
+		return now
+	}
+	asOf := func(at time.Time) string { return "&as_of=" + at.UTC().Format(time.RFC3339Nano) }
+
+	beforeGrant := tick()
+	seedRelations(t, repo, rel("project:1", "reader", "user:alice"))
+	expiry := time.Now().Add(100 * time.Millisecond)
+	expiring := rel("project:1", "reviewer", "user:bob")
+	expiring.ExpiresAt = &expiry
+	seedRelations(t, repo, expiring)
+	afterGrant := tick()
+	if _, err := repo.DeleteBulk(ctx, []authz.Relationship{rel("project:1", "reader", "user:alice")}); err != nil {
+		t.Fatal(err)
+	}
+	afterRevoke := tick()
+	time.Sleep(time.Until(expiry))
+	afterExpiry := tick()
+
+	for _, tt := range []struct {
+		name    string
+		subject string
+		asOf    string
+		allowed bool
+	}{
+		{"before the grant", "user:alice", asOf(beforeGrant), false},
+		{"after the grant", "user:alice", asOf(afterGrant), true},
+		{"after the revocation", "user:alice", asOf(afterRevoke), false},
+		{"now", "user:alice", "", false},
+		{"before the expiry", "user:bob", asOf(afterRevoke), true},
+		{"after the expiry", "user:bob", asOf(afterExpiry), false},
+	} {
+		var eval authz.PermissionEval
+		decode(t, serve(h, "GET", v1Prefix+"/permissions/read?resource=project:1&subject="+tt.subject+tt.asOf, ""), http.StatusOK, &eval)
+		if eval.Allowed != tt.allowed {
+			t.Errorf("%s: read of %s allowed = %v, want %v", tt.name, tt.subject, eval.Allowed, tt.allowed)
+		}
+	}
+
+	// The other check endpoints also evaluate the past relationships
+	var items []authz.PermissionCheckItem
+	decode(t, serve(h, "GET", v1Prefix+"/permissions?resource_filter=project:1&subject_filter=user:alice&permission=read"+asOf(afterGrant), ""), http.StatusOK, &items)
+	if len(items) != 1 || !items[0].PermissionEvals["read"].Allowed {
+		t.Errorf("permissions as of the grant = %+v, want read allowed", items)
+	}
+	var check authz.RelationCheck
+	decode(t, serve(h, "GET", v1Prefix+"/relations/reader/check?resource=project:1&subject=user:alice"+asOf(afterGrant), ""), http.StatusOK, &check)
+	if !check.Related {
+		t.Error("reader relation as of the grant not found")
+	}
+
+	for _, query := range []string{
+		"&as_of=yesterday",
+		asOf(time.Now().Add(time.Hour)),
+		asOf(afterGrant) + "&at_least_as_fresh=1",
+		asOf(time.Now().Add(-time.Hour)), // before the history starts, with the migrations of the test database
+	} {
+		if rec := serve(h, "GET", v1Prefix+"/permissions/read?resource=project:1&subject=user:alice"+query, ""); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d (body: %s), want 400", query, rec.Code, rec.Body)
+		}
+	}
+	rec := serve(h, "GET", v1Prefix+"/relations/reader/check?resource=project:1&subject=user:alice"+asOf(time.Now().Add(-time.Hour)), "")
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "before the start of the history") {
+		t.Errorf("status = %d (body: %s), want 400 before the start of the history", rec.Code, rec.Body)
+	}
+}
+
+func TestWatchRelationsSince(t *testing.T) {
+	h, svc, _ := newTestServer(t, authz.LoadMetadata())
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	// Changes made before the connection are streamed from the cursor
+	if _, err := svc.CreateRelationships(context.Background(), []authz.Relationship{rel("project:1", "reader", "user:alice")}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+v1Prefix+"/relations/watch?since=0", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, want text/event-stream", resp.Header.Get("Content-Type"))
+	}
+
+	var event []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() && scanner.Text() != "" {
+		event = append(event, scanner.Text())
+	}
+	if len(event) != 3 || event[0] != "id: 1" || event[1] != "event: create" || !strings.Contains(event[2], `"project:1"`) {
+		t.Errorf("event = %q, want the creation with id 1", event)
+	}
+
+	if rec := serve(h, "GET", v1Prefix+"/relations/watch?since=-1", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400 for an invalid cursor", rec.Code)
+	}
+}
+
+func TestManageRelationshipsExpiresAt(t *testing.T) {
+	h, _, _ := newTestServer(t, authz.LoadMetadata())
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	body := `{"create": [{"resource": "project:1", "relation": "reader", "subject": "user:alice", "expires_at": "` + past + `"}]}`
+	if rec := serve(h, "POST", v1Prefix+"/relations", body); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "expires_at must be in the future") {
+		t.Errorf("status = %d (body: %s), want 400 for a past expiry", rec.Code, rec.Body)
+	}
+
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	body = `{"create": [{"resource": "project:1", "relation": "reader", "subject": "user:alice", "expires_at": "` + future + `"}]}`
+	if rec := serve(h, "POST", v1Prefix+"/relations", body); rec.Code != http.StatusOK {
+		t.Errorf("status = %d (body: %s), want 200 for a future expiry", rec.Code, rec.Body)
+	}
+}
+
+func TestManageRelationshipsDryRun(t *testing.T) {
+	h, svc, repo := newTestServer(t, authz.LoadMetadata())
+	seedRelations(t, repo, rel("project:1", "reader", "user:alice"), rel("project:1", "owner", "user:bob"))
+
+	body := `{"delete": [{"resource": "project:1", "relation": "owner", "subject": "user:bob"},
+			{"resource": "project:1", "relation": "owner", "subject": "user:carol"}],
+		"create": [{"resource": "project:1", "relation": "reader", "subject": "user:alice"},
+			{"resource": "project:1", "relation": "reader", "subject": "user:dan"}]}`
+	var result authz.WriteResult
+	rec := serve(h, "POST", v1Prefix+"/relations?dry_run=true", body)
+	decode(t, rec, http.StatusOK, &result)
+	summary := result.Summary
+	if summary == nil {
+		t.Fatal("no summary")
+	}
+	if len(summary.Deleted) != 1 || summary.Deleted[0].Subject.ID != "bob" ||
+		len(summary.NotFound) != 1 || summary.NotFound[0].Subject.ID != "carol" ||
+		len(summary.AlreadyExists) != 1 || summary.AlreadyExists[0].Subject.ID != "alice" ||
+		len(summary.Created) != 1 || summary.Created[0].Subject.ID != "dan" {
+		t.Errorf("summary = %+v, want bob deleted, carol not found, alice existing, dan created", summary)
+	}
+	if result.Created != 1 || result.Deleted != 1 {
+		t.Errorf("result = %+v, want 1 created and 1 deleted", result)
+	}
+
+	// Nothing is persisted, not even in the audit log
+	assertAllowed(t, svc, "project:1", "user:bob", "edit")
+	assertDenied(t, svc, "project:1", "user:dan", "read")
+	if entries, _, err := svc.ListAuditEntries(context.Background(), authz.AuditFilter{Resource: obj("project:1")}); err != nil || len(entries) != 0 {
+		t.Errorf("audit entries = %v, %v, want none", entries, err)
+	}
+}
+
+func TestExportRelations(t *testing.T) {
+	h, _, repo := newTestServer(t, authz.LoadMetadata())
+	rec := serve(h, "GET", v1Prefix+"/relations/export", "")
+	if rec.Code != http.StatusOK || rec.Body.Len() != 0 {
+		t.Errorf("status = %d, body = %q, want 200 and no relationships", rec.Code, rec.Body)
+	}
+
+	seeded := readers(1500)
+	seedRelations(t, repo, seeded...)
+	rec = serve(h, "GET", v1Prefix+"/relations/export", "")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("status = %d, Content-Type = %q, want 200 NDJSON", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	exported := map[string]bool{}
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		var rel authz.Relationship
+		if err := json.Unmarshal(scanner.Bytes(), &rel); err != nil {
+			t.Fatalf("line %q is not a relationship: %v", scanner.Text(), err)
+		}
+		exported[rel.String()] = true
+	}
+	if len(exported) != len(seeded) {
+		t.Errorf("exported %d relationships, want %d", len(exported), len(seeded))
+	}
+	for _, rel := range seeded {
+		if !exported[rel.String()] {
+			t.Errorf("%s is not exported", rel)
+		}
+	}
+}
+
+func TestManageRelationshipsCounts(t *testing.T) {
+	h, _, _ := newTestServer(t, authz.LoadMetadata())
+	write := func(body string) authz.WriteResult {
+		t.Helper()
+		var result authz.WriteResult
+		decode(t, serve(h, "POST", v1Prefix+"/relations", body), http.StatusOK, &result)
+		return result
+	}
+
+	create := `{"create": [{"resource": "project:1", "relation": "reader", "subject": "user:alice"}]}`
+	if result := write(create); result.Created != 1 || result.Deleted != 0 {
+		t.Errorf("create: result = %+v, want 1 created", result)
+	}
+	if result := write(create); result.Created != 0 || result.Deleted != 0 {
+		t.Errorf("create of an existing relationship: result = %+v, want 0 created", result)
+	}
+
+	remove := `{"delete": [{"resource": "project:1", "relation": "reader", "subject": "user:alice"}]}`
+	if result := write(remove); result.Created != 0 || result.Deleted != 1 {
+		t.Errorf("delete: result = %+v, want 1 deleted", result)
+	}
+	if result := write(remove); result.Created != 0 || result.Deleted != 0 {
+		t.Errorf("delete of a missing relationship: result = %+v, want 0 deleted", result)
+	}
+}
+
+func TestManageRelationshipsConflictPolicy(t *testing.T) {
+	future := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	body := func(policy string) string {
+		return `{"on_conflict": "` + policy + `", "create": [
+			{"resource": "project:1", "relation": "reader", "subject": "user:alice", "expires_at": "` + future.Format(time.RFC3339) + `"},
+			{"resource": "project:1", "relation": "reader", "subject": "user:bob"}]}`
+	}
+
+	tests := []struct {
+		policy     string
+		status     int
+		created    int64
+		wantExpiry bool // whether the existing relationship of alice gets the expiry of the request
+		wantBob    bool
+	}{
+		{policy: "upsert", status: http.StatusOK, created: 2, wantExpiry: true, wantBob: true},
+		{policy: "ignore", status: http.StatusOK, created: 1, wantBob: true},
+		{policy: "", status: http.StatusOK, created: 1, wantBob: true},
+		{policy: "error", status: http.StatusConflict},
+		{policy: "replace", status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			h, _, repo := newTestServer(t, authz.LoadMetadata())
+			seedRelations(t, repo, rel("project:1", "reader", "user:alice"))
+
+			rec := serve(h, "POST", v1Prefix+"/relations", body(tt.policy))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d (body: %s), want %d", rec.Code, rec.Body, tt.status)
+			}
+			if rec.Code == http.StatusOK {
+				var result authz.WriteResult
+				decode(t, rec, http.StatusOK, &result)
+				if result.Created != tt.created {
+					t.Errorf("created = %d, want %d", result.Created, tt.created)
+				}
+			}
+
+			found, err := repo.FindRelationships(context.Background(), []authz.Relationship{
+				rel("project:1", "reader", "user:alice"), rel("project:1", "reader", "user:bob"),
+			})
+			if err != nil {
+				t.Fatalf("FindRelationships() failed: %v", err)
+			}
+			var alice, bob bool
+			for _, r := range found {
+				switch r.Subject.ID {
+				case "alice":
+					alice = true
+					if hasExpiry := r.ExpiresAt != nil && r.ExpiresAt.Equal(future); hasExpiry != tt.wantExpiry {
+						t.Errorf("alice expires at %v, want the expiry of the request: %v", r.ExpiresAt, tt.wantExpiry)
+					}
+				case "bob":
+					bob = true
+				}
+			}
+			if !alice || bob != tt.wantBob {
+				t.Errorf("alice found: %v, bob found: %v, want true, %v", alice, bob, tt.wantBob)
+			}
+		})
+	}
+}
+
+func TestObjectInputNormalization(t *testing.T) {
+	h, svc, _ := newTestServer(t, authz.LoadMetadata())
+	body := `{"create": [{"resource": " project:1", "relation": "reader ", "subject": "user : alice "}]}`
+	if rec := serve(h, "POST", v1Prefix+"/relations", body); rec.Code != http.StatusOK {
+		t.Fatalf("create with surrounding spaces: status = %d (body: %s), want 200", rec.Code, rec.Body)
+	}
+	assertAllowed(t, svc, "project:1", "user:alice", "read")
+
+	// The expanded form is trimmed alike
+	body = `{"create": [{"resource": {"type": " project", "id": "2 "}, "relation": "reader", "subject": {"type": "user ", "id": " alice"}}]}`
+	if rec := serve(h, "POST", v1Prefix+"/relations", body); rec.Code != http.StatusOK {
+		t.Fatalf("create of expanded objects with surrounding spaces: status = %d (body: %s), want 200", rec.Code, rec.Body)
+	}
+	assertAllowed(t, svc, "project:2", "user:alice", "read")
+	body = `{"create": [{"resource": {"type": " ", "id": "3"}, "relation": "reader", "subject": "user:alice"}]}`
+	if rec := serve(h, "POST", v1Prefix+"/relations", body); rec.Code != http.StatusBadRequest {
+		t.Errorf("create of an expanded object with a blank type: status = %d (body: %s), want 400", rec.Code, rec.Body)
+	}
+
+	for _, resource := range []string{"project:1", "%20project:1", "project:1%20", "%20project%20:%201%09"} {
+		var eval authz.PermissionEval
+		rec := serve(h, "GET", v1Prefix+"/permissions/read?resource="+resource+"&subject=user:alice", "")
+		decode(t, rec, http.StatusOK, &eval)
+		if !eval.Allowed {
+			t.Errorf("resource %q: eval = %+v, want allowed", resource, eval)
+		}
+	}
+
+	// Types are case-sensitive: a mixed-case type is rejected, with the type of the schema as a hint
+	rec := serve(h, "GET", v1Prefix+"/permissions/read?resource=Project:1&subject=user:alice", "")
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `did you mean "project"`) {
+		t.Errorf("mixed-case type: status = %d (body: %s), want 400 suggesting project", rec.Code, rec.Body)
+	}
+	body = `{"create": [{"resource": "PROJECT:1", "relation": "reader", "subject": "user:alice"}]}`
+	if rec := serve(h, "POST", v1Prefix+"/relations", body); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `did you mean "project"`) {
+		t.Errorf("create with a mixed-case type: status = %d (body: %s), want 400 suggesting project", rec.Code, rec.Body)
+	}
+}
+
+func TestObjectFormats(t *testing.T) {
+	t.Cleanup(func() { authz.SetObjectFormat(authz.ObjectFormatCompact) })
+	relationship := rel("doc:a:1", "viewer", "user:alice") // the ID holds a colon
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{authz.ObjectFormatCompact, `{"resource":"doc:a:1","subject":"user:alice","relation":"viewer"}`},
+		{authz.ObjectFormatExpanded, `{"resource":{"type":"doc","id":"a:1"},"subject":{"type":"user","id":"alice"},"relation":"viewer"}`},
+	}
+	for _, tt := range tests {
+		if err := authz.SetObjectFormat(tt.format); err != nil {
+			t.Fatal(err)
+		}
+		data, err := json.Marshal(relationship)
+		if err != nil || string(data) != tt.want {
+			t.Errorf("%s: Marshal() = %s, %v, want %s", tt.format, data, err, tt.want)
+		}
+
+		// Both forms are accepted, whatever the output format
+		for _, input := range []string{tests[0].want, tests[1].want} {
+			var decoded authz.Relationship
+			if err := json.Unmarshal([]byte(input), &decoded); err != nil || decoded != relationship {
+				t.Errorf("%s: Unmarshal(%s) = %v, %v, want %v", tt.format, input, decoded, err, relationship)
+			}
+		}
+	}
+
+	for _, input := range []string{`{"id": "1"}`, `{"type": 1, "id": "1"}`, `"doc"`} {
+		var object authz.Object
+		if err := json.Unmarshal([]byte(input), &object); err == nil {
+			t.Errorf("Unmarshal(%s) = %v, want an error", input, object)
+		}
+	}
+	if err := authz.SetObjectFormat("verbose"); err == nil {
+		t.Error("SetObjectFormat(verbose) = nil, want an error")
+	}
+}
+
+func TestObjectFormatsInResponses(t *testing.T) {
+	t.Cleanup(func() { authz.SetObjectFormat(authz.ObjectFormatCompact) })
+	h, _, _ := newTestServer(t, authz.LoadMetadata())
+
+	// An expanded write is read back in either form
+	body := `{"create": [{"resource": {"type": "project", "id": "1"}, "relation": "reader", "subject": {"type": "user", "id": "alice"}}]}`
+	if rec := serve(h, "POST", v1Prefix+"/relations", body); rec.Code != http.StatusOK {
+		t.Fatalf("expanded write: status = %d (body: %s), want 200", rec.Code, rec.Body)
+	}
+	for format, want := range map[string]string{
+		authz.ObjectFormatCompact:  `"resource":"project:1"`,
+		authz.ObjectFormatExpanded: `"resource":{"type":"project","id":"1"}`,
+	} {
+		authz.SetObjectFormat(format)
+		rec := serve(h, "GET", v1Prefix+"/resources/project:1/relations", "")
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("%s: status = %d (body: %s), want %s", format, rec.Code, rec.Body, want)
+		}
+	}
+}
+
+func TestManageRelationshipsBodyTooLarge(t *testing.T) {
+	h, _, _ := newTestServer(t, authz.LoadMetadata())
+	h.AddGlobalMiddleware(router.MaxBodyBytes(1024))
+	body := `{"create": [` + strings.Repeat(`{"resource": "project:1", "relation": "reader", "subject": "user:alice"},`, 20) + `]}`
+
+	rec := serve(h, "POST", v1Prefix+"/relations", body)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d (body: %s), want 413", rec.Code, rec.Body)
+	}
+
+	// Without Content-Length, the body is cut while the handler decodes it
+	req := httptest.NewRequest("POST", v1Prefix+"/relations", strings.NewReader(body))
+	req.ContentLength = -1
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge || !strings.Contains(rec.Body.String(), "request body too large") {
+		t.Errorf("status = %d (body: %s), want 413 without Content-Length", rec.Code, rec.Body)
+	}
+}
+
+func TestManageRelationshipsSchemaHints(t *testing.T) {
+	h, _, _ := newTestServer(t, authz.LoadMetadata())
+	body := `{"create": [{"resource": "group:eng", "relation": "member", "subject": "application:1"}]}`
+
+	// Without hints, the error is plain text
+	rec := serve(h, "POST", v1Prefix+"/relations", body)
+	if rec.Code != http.StatusBadRequest || strings.HasPrefix(rec.Body.String(), "{") {
+		t.Fatalf("status = %d (body: %s), want 400 with a plain text error", rec.Code, rec.Body)
+	}
+
+	var invalid authz.InvalidRelationshipError
+	decode(t, serve(h, "POST", v1Prefix+"/relations?schema_hints=true", body), http.StatusBadRequest, &invalid)
+	if invalid.Error != rec.Body.String() {
+		t.Errorf("error = %q, want %q", invalid.Error, rec.Body)
+	}
+	if invalid.Relationship != rel("group:eng", "member", "application:1") {
+		t.Errorf("relationship = %v, want the rejected one", invalid.Relationship)
+	}
+	if want := map[string][]string{"member": {"user", "group"}}; !reflect.DeepEqual(invalid.Relations, want) {
+		t.Errorf("relations = %v, want %v", invalid.Relations, want)
+	}
+
+	// No relations to suggest on an unknown type
+	invalid = authz.InvalidRelationshipError{}
+	body = `{"create": [{"resource": "team:eng", "relation": "member", "subject": "user:alice"}]}`
+	decode(t, serve(h, "POST", v1Prefix+"/relations?schema_hints=true", body), http.StatusBadRequest, &invalid)
+	if invalid.Error == "" || invalid.Relations != nil {
+		t.Errorf("error = %q, relations = %v, want an error without relations", invalid.Error, invalid.Relations)
+	}
+}
+
+func TestManageRelationshipsContradiction(t *testing.T) {
+	h, svc, repo := newTestServer(t, authz.LoadMetadata())
+	seedRelations(t, repo, rel("project:1", "reader", "user:alice"))
+
+	for _, body := range []string{
+		`{"delete": [{"resource": "project:1", "relation": "reader", "subject": "user:alice"}],
+			"create": [{"resource": "project:1", "relation": "reader", "subject": "user:alice"}]}`,
+		`{"create": [{"resource": "project:1", "relation": "reader", "subject": "user:bob"},
+			{"resource": "project:1", "relation": "reader", "subject": "user:alice"}],
+			"delete": [{"resource": "project:1", "relation": "reader", "subject": "user:alice"}]}`,
+	} {
+		rec := serve(h, "POST", v1Prefix+"/relations", body)
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "relationship is both deleted and created: project:1#reader@user:alice") {
+			t.Errorf("status = %d (body: %s), want 400 both deleted and created", rec.Code, rec.Body)
+		}
+	}
+	// Nothing is applied
+	assertAllowed(t, svc, "project:1", "user:alice", "read")
+	assertDenied(t, svc, "project:1", "user:bob", "read")
+
+	// Replacing a relation of the subject is not a contradiction
+	body := `{"delete": [{"resource": "project:1", "relation": "reader", "subject": "user:alice"}],
+		"create": [{"resource": "project:1", "relation": "owner", "subject": "user:alice"}]}`
+	if rec := serve(h, "POST", v1Prefix+"/relations", body); rec.Code != http.StatusOK {
+		t.Errorf("status = %d (body: %s), want 200", rec.Code, rec.Body)
+	}
+	assertAllowed(t, svc, "project:1", "user:alice", "edit")
+}
+
+func TestManageRelationshipsBestEffort(t *testing.T) {
+	h, svc, repo := newTestServer(t, authz.LoadMetadata())
+	seedRelations(t, repo, rel("project:1", "reader", "user:alice"))
+
+	body := `{"delete": [{"resource": "project:1", "relation": "reader", "subject": "user:alice"},
+			{"resource": "project:1", "relation": "reader", "subject": "user:dave"}],
+		"create": [{"resource": "project:1", "relation": "reader", "subject": "user:bob"},
+			{"resource": "group:eng", "relation": "member", "subject": "application:1"},
+			{"resource": "project:1", "relation": "owner", "subject": "user:carol"}]}`
+
+	// All or nothing by default: the invalid relationship rejects the whole write
+	if rec := serve(h, "POST", v1Prefix+"/relations", body); rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d (body: %s), want 400", rec.Code, rec.Body)
+	}
+	assertAllowed(t, svc, "project:1", "user:alice", "read")
+
+	var result authz.WriteResult
+	decode(t, serve(h, "POST", v1Prefix+"/relations?best_effort=true", body), http.StatusOK, &result)
+	if result.Created != 2 || result.Deleted != 1 {
+		t.Errorf("created = %d, deleted = %d, want 2 and 1", result.Created, result.Deleted)
+	}
+	want := []struct{ operation, relationship, status string }{
+		{"delete", "project:1#reader@user:alice", authz.WriteSucceeded},
+		{"delete", "project:1#reader@user:dave", authz.WriteSkipped},
+		{"create", "project:1#reader@user:bob", authz.WriteSucceeded},
+		{"create", "group:eng#member@application:1", authz.WriteFailed},
+		{"create", "project:1#owner@user:carol", authz.WriteSucceeded},
+	}
+	if len(result.Items) != len(want) {
+		t.Fatalf("items = %+v, want %d", result.Items, len(want))
+	}
+	for i, item := range result.Items {
+		if item.Operation != want[i].operation || item.Relationship.String() != want[i].relationship || item.Status != want[i].status {
+			t.Errorf("items[%d] = %+v, want %s %s %s", i, item, want[i].status, want[i].operation, want[i].relationship)
+		}
+		if (item.Status == authz.WriteSucceeded) != (item.Reason == "") {
+			t.Errorf("items[%d] reason = %q, want one unless succeeded", i, item.Reason)
+		}
+	}
+	if reason := result.Items[3].Reason; !strings.Contains(reason, `subject type "application" not allowed`) {
+		t.Errorf("failure reason = %q, want the schema error", reason)
+	}
+
+	// The rest of the batch is applied
+	assertDenied(t, svc, "project:1", "user:alice", "read")
+	assertAllowed(t, svc, "project:1", "user:bob", "read")
+	assertAllowed(t, svc, "project:1", "user:carol", "edit")
+
+	// Best-effort writes cannot be conditional, replayed or simulated
+	for _, query := range []string{"best_effort=true&dry_run=true", "best_effort=maybe"} {
+		if rec := serve(h, "POST", v1Prefix+"/relations?"+query, body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d (body: %s), want 400", query, rec.Code, rec.Body)
+		}
+	}
+	conditional := `{"create": [{"resource": "project:1", "relation": "reader", "subject": "user:eve"}],
+		"precondition": {"must_exist": [{"resource": "project:1", "relation": "reader", "subject": "user:bob"}]}}`
+	if rec := serve(h, "POST", v1Prefix+"/relations?best_effort=true", conditional); rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d (body: %s), want 400 with a precondition", rec.Code, rec.Body)
+	}
+}
+
+func TestCheckPermissionSuggestsPermission(t *testing.T) {
+	h, _, _ := newTestServer(t, authz.LoadMetadata())
+	rec := serve(h, "GET", v1Prefix+"/permissions/Edit?resource=project:1&subject=user:alice", "")
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `did you mean "edit"?`) {
+		t.Errorf("status = %d (body: %s), want 400 suggesting edit", rec.Code, rec.Body)
+	}
+}
+
+func TestListPathsMaxResults(t *testing.T) {
+	h, _, repo := newTestServer(t, authz.LoadMetadata())
+	seedRelations(t, repo, rel("project:1", "reader", "user:alice"), rel("project:2", "reader", "user:alice"))
+
+	var items []authz.TraversalResponseItem
+	rec := serve(h, "GET", v1Prefix+"/paths?subject_filter=user:alice&resource_filter=project&max_results=1", "")
+	decode(t, rec, http.StatusOK, &items)
+	if len(items) != 1 || rec.Header().Get("Results-Truncated") != "true" {
+		t.Errorf("%d items, Results-Truncated = %q, want 1 item truncated", len(items), rec.Header().Get("Results-Truncated"))
+	}
+
+	rec = serve(h, "GET", v1Prefix+"/paths?subject_filter=user:alice&resource_filter=project&max_results=2", "")
+	decode(t, rec, http.StatusOK, &items)
+	if len(items) != 2 || rec.Header().Get("Results-Truncated") != "" {
+		t.Errorf("%d items, Results-Truncated = %q, want 2 items not truncated", len(items), rec.Header().Get("Results-Truncated"))
+	}
+
+	if rec := serve(h, "GET", v1Prefix+"/paths?subject_filter=user:alice&resource_filter=project&max_results=0", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400 for max_results=0", rec.Code)
+	}
+}
+
+func TestCheckPermissionsSeveralSubjectFilters(t *testing.T) {
+	h, _, repo := newTestServer(t, authz.LoadMetadata())
+	seedRelations(t, repo,
+		rel("project:1", "reader", "user:alice"),
+		rel("project:1", "owner", "group:eng"),
+		rel("project:1", "reader", "user:bob"),
+	)
+
+	var items []authz.PermissionCheckItem
+	rec := serve(h, "GET", v1Prefix+"/permissions?resource_filter=project:1&subject_filter=user:alice,group:eng&permission=edit", "")
+	decode(t, rec, http.StatusOK, &items)
+	edit := map[string]bool{}
+	for _, item := range items {
+		edit[item.Subject.Type+":"+item.Subject.ID] = item.PermissionEvals["edit"].Allowed
+	}
+	if len(edit) != 2 || edit["user:alice"] || !edit["group:eng"] {
+		t.Errorf("edit = %v, want user:alice denied and group:eng allowed, and no other subject", edit)
+	}
+
+	rec = serve(h, "GET", v1Prefix+"/permissions?resource_filter=project&subject_filter=user:alice,group:eng", "")
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "a resource ID must be provided with several subject filters") {
+		t.Errorf("status = %d (body: %s), want 400 without a resource ID", rec.Code, rec.Body)
+	}
+}
+
+func TestSchemaVersionHeader(t *testing.T) {
+	h, _, _ := newTestServer(t, authz.LoadMetadata())
+	for _, target := range []string{
+		"/permissions/read?resource=project:1&subject=user:alice",
+		"/permissions?resource_filter=project:1&subject_filter=user:alice",
+	} {
+		if rec := serve(h, "GET", v1Prefix+target, ""); rec.Header().Get("X-Authz-Schema-Version") != "1.0" {
+			t.Errorf("GET %s: X-Authz-Schema-Version = %q, want 1.0", target, rec.Header().Get("X-Authz-Schema-Version"))
+		}
+	}
+
+	write := func(version string) *httptest.ResponseRecorder {
+		body := `{"create": [{"resource": "project:1", "relation": "reader", "subject": "user:alice"}]}`
+		req := httptest.NewRequest("POST", v1Prefix+"/relations", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if version != "" {
+			req.Header.Set("X-Authz-Schema-Version", version)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	rec := write("0.9")
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "schema version mismatch: expected 0.9, active 1.0") {
+		t.Errorf("status = %d (body: %s), want 409 for another schema version", rec.Code, rec.Body)
+	}
+	if rec.Header().Get("X-Authz-Schema-Version") != "1.0" {
+		t.Errorf("X-Authz-Schema-Version = %q, want 1.0 on the rejected write", rec.Header().Get("X-Authz-Schema-Version"))
+	}
+	for _, version := range []string{"1.0", ""} {
+		if rec := write(version); rec.Code != http.StatusOK {
+			t.Errorf("version %q: status = %d (body: %s), want 200", version, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestCountPermission(t *testing.T) {
+	h, _, repo := newTestServer(t, authz.LoadMetadata())
+	count := func() int {
+		t.Helper()
+		var result authz.PermissionCount
+		rec := serve(h, "GET", v1Prefix+"/permissions/read/count?subject=user:alice&resource_type=project", "")
+		decode(t, rec, http.StatusOK, &result)
+		return result.Count
+	}
+
+	seedRelations(t, repo,
+		rel("project:1", "reader", "user:alice"),
+		rel("project:2", "reader", "user:alice"),
+	)
+	if n := count(); n != 2 {
+		t.Errorf("count = %d, want 2 direct readers", n)
+	}
+
+	seedRelations(t, repo,
+		rel("project:3", "reader", "group:eng"),
+		rel("project:4", "contributor", "group:eng"),
+		rel("group:eng", "member", "user:alice"),
+	)
+	if n := count(); n != 4 {
+		t.Errorf("count = %d, want 4 with the projects inherited from group:eng", n)
+	}
+
+	seedRelations(t, repo, rel("project:2", "forbidden", "user:alice"))
+	if n := count(); n != 3 {
+		t.Errorf("count = %d, want 3 once project:2 forbids alice", n)
+	}
+
+	rec := serve(h, "GET", v1Prefix+"/permissions/unknown/count?subject=user:alice&resource_type=project", "")
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400 for an unknown permission", rec.Code)
+	}
+}
+
+// TestTruncatedResultsAreStable checks that the resources kept under max_results are the first ones
+// in type and ID order, whichever order their relationships were written in.
+func TestTruncatedResultsAreStable(t *testing.T) {
+	h, _, repo := newTestServer(t, authz.LoadMetadata())
+	for i := 19; i >= 0; i-- {
+		seedRelations(t, repo, rel(fmt.Sprintf("project:%02d", i), "reader", "user:alice"))
+	}
+	seedRelations(t, repo, rel("project:01", "forbidden", "user:alice"), rel("project:03", "forbidden", "user:alice"))
+
+	// The first 5 projects are traversed, of which alice may read 00, 02 and 04
+	for call := 1; call <= 2; call++ {
+		var accesses []authz.ResourceAccess
+		rec := serve(h, "GET", v1Prefix+"/subjects/user:alice/resources?resource_types=project&max_results=5", "")
+		decode(t, rec, http.StatusOK, &accesses)
+		var resources []string
+		for _, access := range accesses {
+			resources = append(resources, access.Resource.Type+":"+access.Resource.ID)
+		}
+		if want := []string{"project:00", "project:02", "project:04"}; !reflect.DeepEqual(resources, want) {
+			t.Errorf("call %d: resources = %v, want %v", call, resources, want)
+		}
+
+		var result authz.PermissionCount
+		rec = serve(h, "GET", v1Prefix+"/permissions/read/count?subject=user:alice&resource_type=project&max_results=5", "")
+		decode(t, rec, http.StatusOK, &result)
+		if result.Count != 3 {
+			t.Errorf("call %d: count = %d, want 3", call, result.Count)
+		}
+	}
+}
+
+func TestCheckPermissionAnyOf(t *testing.T) {
+	h, _, repo := newTestServer(t, authz.LoadMetadata())
+	seedRelations(t, repo,
+		rel("project:1", "reader", "user:alice"),
+		rel("project:1", "owner", "user:bob"),
+	)
+
+	tests := []struct {
+		subject string
+		allowed bool
+		edit    bool
+		read    bool
+	}{
+		{subject: "user:carol"},
+		{subject: "user:alice", allowed: true, read: true},
+		{subject: "user:bob", allowed: true, edit: true, read: true},
+	}
+	for _, tt := range tests {
+		var eval authz.PermissionEval
+		rec := serve(h, "GET", v1Prefix+"/permissions/edit,read?resource=project:1&subject="+tt.subject, "")
+		decode(t, rec, http.StatusOK, &eval)
+		if eval.Allowed != tt.allowed || len(eval.Permissions) != 2 ||
+			eval.Permissions["edit"].Allowed != tt.edit || eval.Permissions["read"].Allowed != tt.read {
+			t.Errorf("%s: eval = %+v, want allowed %t with edit %t and read %t", tt.subject, eval, tt.allowed, tt.edit, tt.read)
+		}
+	}
+
+	var eval authz.PermissionEval
+	decode(t, serve(h, "GET", v1Prefix+"/permissions/edit?resource=project:1&subject=user:bob", ""), http.StatusOK, &eval)
+	if !eval.Allowed || eval.Permissions != nil {
+		t.Errorf("eval = %+v, want allowed without breakdown for a single permission", eval)
+	}
+	if rec := serve(h, "GET", v1Prefix+"/permissions/edit,unknown?resource=project:1&subject=user:bob", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400 when any permission is unknown", rec.Code)
+	}
+}
+
+func TestWarmPaths(t *testing.T) {
+	h, _, _ := newTestServer(t, authz.LoadMetadata())
+
+	var result map[string]interface{}
+	rec := serve(h, "POST", v1Prefix+"/permissions:warm", `{"resources": ["project:1", "group:eng"]}`)
+	decode(t, rec, http.StatusOK, &result)
+	if len(result) != 2 || result["warmed"] != 0.0 || result["cache_enabled"] != false {
+		t.Errorf("result = %v, want 0 warmed and cache_enabled false", result)
+	}
+
+	for _, body := range []string{
+		`{"resources": []}`,
+		`{"resources": ["unknown:1"]}`,
+		`{"resources": `,
+	} {
+		if rec := serve(h, "POST", v1Prefix+"/permissions:warm", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("body %s: status = %d, want 400", body, rec.Code)
+		}
+	}
+}
+
+func TestManageRelationshipsLocatesMalformedFields(t *testing.T) {
+	h, _, _ := newTestServer(t, authz.LoadMetadata())
+	tests := []struct {
+		body string
+		want string
+	}{
+		{
+			body: `{"create": [
+				{"resource": "project:1", "relation": "reader", "subject": "user:alice"},
+				{"resource": "project:2", "relation": "reader", "subject": "user:alice"},
+				{"resource": "project3", "relation": "reader", "subject": "user:alice"}]}`,
+			want: `create[2].resource: invalid object format: "project3" is not "type:id"`,
+		},
+		{
+			body: `{"delete": [{"resource": "project:1", "relation": "reader", "subject": "alice"}]}`,
+			want: `delete[0].subject: invalid object format: "alice" is not "type:id"`,
+		},
+		{
+			body: `{"precondition": {"must_exist": [{"resource": "project:1", "relation": 1, "subject": "user:alice"}]}}`,
+			want: `precondition.must_exist[0].relation: json: cannot unmarshal number`,
+		},
+		{
+			body: `{"create": ["project:1#reader@user:alice"]}`,
+			want: `create[0]: relationship must be a JSON object`,
+		},
+	}
+	for _, tt := range tests {
+		rec := serve(h, "POST", v1Prefix+"/relations", tt.body)
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "invalid request body: "+tt.want) {
+			t.Errorf("status = %d (body: %s), want 400 with %q", rec.Code, rec.Body, tt.want)
+		}
+	}
+}
+
+func TestListNDJSON(t *testing.T) {
+	h, _, repo := newTestServer(t, authz.LoadMetadata())
+	seedRelations(t, repo,
+		rel("project:1", "reader", "user:alice"),
+		rel("project:1", "owner", "user:bob"),
+		rel("project:1", "reader", "group:eng"),
+	)
+
+	for _, tt := range []struct {
+		target string
+		item   func() interface{}
+	}{
+		{"/resources/project:1/relations", func() interface{} { return &authz.Relationship{} }},
+		{"/paths?resource_filter=project:1&subject_filter=user", func() interface{} { return &authz.TraversalResponseItem{} }},
+	} {
+		req := httptest.NewRequest("GET", v1Prefix+tt.target, nil)
+		req.Header.Set("Accept", "application/json;q=0.5, application/x-ndjson")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/x-ndjson" {
+			t.Fatalf("GET %s: status = %d, Content-Type = %q, want 200 and application/x-ndjson", tt.target, rec.Code, rec.Header().Get("Content-Type"))
+		}
+		lines := 0
+		scanner := bufio.NewScanner(rec.Body)
+		for scanner.Scan() {
+			lines++
+			if err := json.Unmarshal(scanner.Bytes(), tt.item()); err != nil {
+				t.Errorf("GET %s: line %d %q is not a JSON item: %v", tt.target, lines, scanner.Text(), err)
+			}
+		}
+
+		// Without the Accept header, the same items are returned as a JSON array
+		var items []json.RawMessage
+		decode(t, serve(h, "GET", v1Prefix+tt.target, ""), http.StatusOK, &items)
+		if lines == 0 || lines != len(items) {
+			t.Errorf("GET %s: %d lines, want one per item of the JSON array (%d)", tt.target, lines, len(items))
+		}
+	}
+}
+
+func TestExcludeRelations(t *testing.T) {
+	meta := loadSchema(t, `
+schema_version: "1.0"
+objects:
+  user:
+    relations: {}
+  group:
+    relations:
+      member:
+        subject_types: [user]
+  doc:
+    relations:
+      viewer:
+        subject_types: [user, group]
+      owner:
+        subject_types: [user]
+    aliases:
+      emergency_admin: owner
+    permissions:
+      view:
+        any_of: [viewer, owner]
+      edit:
+        any_of: [owner]
+`)
+	h, _, repo := newTestServer(t, meta)
+	seedRelations(t, repo,
+		rel("doc:1", "viewer", "group:eng"),
+		rel("group:eng", "member", "user:alice"),
+		rel("doc:1", "emergency_admin", "user:alice"),
+	)
+
+	tests := []struct {
+		target  string
+		allowed bool
+	}{
+		{"/permissions/edit?resource=doc:1&subject=user:alice", true},
+		// Excluding a relation excludes the relationships written under its aliases
+		{"/permissions/edit?resource=doc:1&subject=user:alice&exclude_relations=owner", false},
+		{"/permissions/edit?resource=doc:1&subject=user:alice&exclude_relations=emergency_admin", false},
+		{"/permissions/view?resource=doc:1&subject=user:alice&exclude_relations=owner", true},
+		{"/permissions/view?resource=doc:1&subject=user:alice&exclude_relations=owner,member", false},
+	}
+	for _, tt := range tests {
+		var eval authz.PermissionEval
+		decode(t, serve(h, "GET", v1Prefix+tt.target, ""), http.StatusOK, &eval)
+		if eval.Allowed != tt.allowed {
+			t.Errorf("GET %s: allowed = %v, want %v", tt.target, eval.Allowed, tt.allowed)
+		}
+	}
+
+	// The path through the excluded relation disappears
+	var items []authz.TraversalResponseItem
+	decode(t, serve(h, "GET", v1Prefix+"/paths?resource_filter=doc:1&subject_filter=user:alice&exclude_relations=owner", ""), http.StatusOK, &items)
+	if got := pathRelations(items); strings.Join(got, "|") != "doc:1 viewer>member" {
+		t.Errorf("paths = %q, want only doc:1 viewer>member", got)
+	}
+
+	var checks []authz.PermissionCheckItem
+	decode(t, serve(h, "GET", v1Prefix+"/permissions?resource_filter=doc:1&subject_filter=user:alice&exclude_relations=owner", ""), http.StatusOK, &checks)
+	if len(checks) != 1 || checks[0].PermissionEvals["edit"].Allowed || !checks[0].PermissionEvals["view"].Allowed {
+		t.Errorf("checks = %+v, want view allowed and edit denied", checks)
+	}
+
+	if rec := serve(h, "GET", v1Prefix+"/paths?resource_filter=doc:1&subject_filter=user:alice&exclude_relations=admin", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400 for an unknown relation", rec.Code)
+	}
+}
+
+func TestCheckPermissionMaxPaths(t *testing.T) {
+	h, _, repo := newTestServer(t, authz.LoadMetadata())
+	seedRelations(t, repo,
+		rel("project:1", "reader", "group:a"),
+		rel("project:1", "reader", "group:b"),
+		rel("group:a", "member", "user:alice"),
+		rel("group:b", "member", "user:alice"),
+	)
+
+	for _, tt := range []struct {
+		maxPaths       string
+		paths          int
+		pathsTruncated bool
+	}{
+		{maxPaths: "1", paths: 1, pathsTruncated: true},
+		{maxPaths: "2", paths: 2, pathsTruncated: false},
+		{maxPaths: "", paths: 2, pathsTruncated: false},
+	} {
+		var eval authz.PermissionEval
+		rec := serve(h, "GET", v1Prefix+"/permissions/read?resource=project:1&subject=user:alice&show_matching_paths=true&max_paths="+tt.maxPaths, "")
+		decode(t, rec, http.StatusOK, &eval)
+		if !eval.Allowed || len(eval.MatchingPaths) != tt.paths || eval.PathsTruncated != tt.pathsTruncated {
+			t.Errorf("max_paths=%s: allowed %v, %d paths, truncated %v, want allowed, %d paths, truncated %v",
+				tt.maxPaths, eval.Allowed, len(eval.MatchingPaths), eval.PathsTruncated, tt.paths, tt.pathsTruncated)
+		}
+	}
+
+	var items []authz.PermissionCheckItem
+	rec := serve(h, "GET", v1Prefix+"/permissions?resource_filter=project:1&subject_filter=user:alice&show_matching_paths=true&max_paths=1", "")
+	decode(t, rec, http.StatusOK, &items)
+	if len(items) != 1 {
+		t.Fatalf("%d items, want 1", len(items))
+	}
+	if eval := items[0].PermissionEvals["read"]; len(eval.MatchingPaths) != 1 || !eval.PathsTruncated {
+		t.Errorf("read: %d paths, truncated %v, want 1 path, truncated", len(eval.MatchingPaths), eval.PathsTruncated)
+	}
+
+	rec = serve(h, "GET", v1Prefix+"/permissions/read?resource=project:1&subject=user:alice&max_paths=0", "")
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400 for max_paths=0", rec.Code)
+	}
+}
+
+func TestCompactPathsRoundTrip(t *testing.T) {
+	caveated := rel("group:a", "member", "user:alice")
+	caveated.Caveat = &authz.RelationshipCaveat{Name: "business_hours", Context: map[string]interface{}{"tz": "UTC"}}
+	paths := [][]authz.Relationship{
+		{rel("project:1", "reader", "group:a"), caveated},
+		{rel("project:1", "parent", "project:2"), rel("project:2", "reader", "group:a"), caveated},
+		{rel("project:1", "owner", "user:alice")},
+	}
+
+	compact := authz.NewCompactPaths(paths)
+	if len(compact.Nodes) != 4 {
+		t.Errorf("nodes = %v, want each of the 4 objects once", compact.Nodes)
+	}
+	// Through JSON, as a client would
+	raw, err := json.Marshal(compact)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded authz.CompactPaths
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		t.Fatalf("invalid compact paths %s: %v", raw, err)
+	}
+	got, err := decoded.Relationships()
+	if err != nil {
+		t.Fatalf("Relationships() failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, paths) {
+		t.Errorf("round trip = %v, want %v", got, paths)
+	}
+
+	if authz.NewCompactPaths(nil) != nil {
+		t.Error("NewCompactPaths(nil) is not nil")
+	}
+	decoded.Paths[0][0].Subject = len(decoded.Nodes)
+	if _, err := decoded.Relationships(); err == nil {
+		t.Error("Relationships() succeeded with a node index out of range")
+	}
+}
+
+func TestCheckPermissionCompactPaths(t *testing.T) {
+	h, _, repo := newTestServer(t, authz.LoadMetadata())
+	seedRelations(t, repo,
+		rel("project:1", "reader", "group:a"),
+		rel("project:1", "reader", "group:b"),
+		rel("group:a", "member", "user:alice"),
+		rel("group:b", "member", "user:alice"),
+	)
+
+	var full, compacted authz.PermissionEval
+	target := v1Prefix + "/permissions/read?resource=project:1&subject=user:alice&show_matching_paths=true"
+	decode(t, serve(h, "GET", target, ""), http.StatusOK, &full)
+	decode(t, serve(h, "GET", target+"&path_format=compact", ""), http.StatusOK, &compacted)
+	assertCompactPaths(t, target, full.MatchingPaths, compacted.MatchingPaths, compacted.MatchingPathsCompact)
+
+	var fullItems, compactedItems []authz.PermissionCheckItem
+	target = v1Prefix + "/permissions?resource_filter=project:1&subject_filter=user:alice&show_matching_paths=true"
+	decode(t, serve(h, "GET", target, ""), http.StatusOK, &fullItems)
+	decode(t, serve(h, "GET", target+"&path_format=compact", ""), http.StatusOK, &compactedItems)
+	if len(fullItems) != 1 || len(compactedItems) != 1 {
+		t.Fatalf("%d and %d items, want 1", len(fullItems), len(compactedItems))
+	}
+	read := compactedItems[0].PermissionEvals["read"]
+	assertCompactPaths(t, target, fullItems[0].PermissionEvals["read"].MatchingPaths, read.MatchingPaths, read.MatchingPathsCompact)
+
+	var fullCheck, compactedCheck authz.RelationCheck
+	target = v1Prefix + "/relations/member/check?resource=project:1&subject=user:alice&show_matching_paths=true"
+	decode(t, serve(h, "GET", target, ""), http.StatusOK, &fullCheck)
+	decode(t, serve(h, "GET", target+"&path_format=compact", ""), http.StatusOK, &compactedCheck)
+	assertCompactPaths(t, target, fullCheck.MatchingPaths, compactedCheck.MatchingPaths, compactedCheck.MatchingPathsCompact)
+
+	if rec := serve(h, "GET", v1Prefix+"/permissions/read?resource=project:1&subject=user:alice&path_format=graph", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400 for an unknown path format", rec.Code)
+	}
+}
+
+// assertCompactPaths checks that a response in the compact path format only has compact paths, decoding to the
+// paths of the response in the full format.
+func assertCompactPaths(t *testing.T, target string, want, fullPaths [][]authz.Relationship, compact *authz.CompactPaths) {
+	t.Helper()
+	if len(want) == 0 {
+		t.Fatalf("GET %s: no matching paths", target)
+	}
+	if fullPaths != nil || compact == nil {
+		t.Fatalf("GET %s&path_format=compact: matching paths %v, compact %v, want only compact paths", target, fullPaths, compact)
+	}
+	got, err := compact.Relationships()
+	if err != nil {
+		t.Fatalf("GET %s&path_format=compact: %v", target, err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GET %s&path_format=compact: paths %v, want %v", target, got, want)
+	}
+}
+
+func TestListResourceSubjects(t *testing.T) {
+	h, _, repo := newTestServer(t, authz.LoadMetadata())
+	seedRelations(t, repo,
+		rel("project:1", "reader", "user:bob"),
+		rel("project:1", "reader", "group:a"),
+		rel("project:1", "owner", "user:carol"),
+		rel("group:a", "member", "user:alice"),
+	)
+
+	var subjects []authz.Object
+	rec := serve(h, "GET", v1Prefix+"/resources/project:1/relations/reader/subjects", "")
+	decode(t, rec, http.StatusOK, &subjects)
+	if len(subjects) != 2 || subjects[0] != obj("group:a") || subjects[1] != obj("user:bob") {
+		t.Errorf("subjects = %v, want [group:a user:bob] (direct readers only)", subjects)
+	}
+
+	rec = serve(h, "GET", v1Prefix+"/resources/project:2/relations/reader/subjects", "")
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != "[]" {
+		t.Errorf("status = %d (body: %s), want 200 and an empty list", rec.Code, rec.Body)
+	}
+
+	rec = serve(h, "GET", v1Prefix+"/resources/project:1/relations/readr/subjects", "")
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "reader") {
+		t.Errorf("status = %d (body: %s), want 400 suggesting reader", rec.Code, rec.Body)
+	}
+}
+
+func TestCountResourceRelations(t *testing.T) {
+	h, _, repo := newTestServer(t, authz.LoadMetadata())
+	expired := rel("project:1", "reader", "user:dave")
+	past := time.Now().Add(-time.Hour)
+	expired.ExpiresAt = &past
+	seedRelations(t, repo,
+		rel("project:1", "reader", "user:bob"),
+		rel("project:1", "reader", "group:a"),
+		rel("project:1", "owner", "user:carol"),
+		rel("project:1", "parent", "project:0"),
+		rel("project:0", "owner", "user:erin"), // of the parent
+		rel("group:a", "member", "user:alice"),
+		expired,
+	)
+
+	tests := []struct {
+		path string
+		want int64
+	}{
+		{"/resources/project:1/relations/count", 4},
+		{"/resources/project:1/relations/count?relation=reader", 2},
+		{"/resources/project:1/relations/count?relation=owner", 1},
+		{"/resources/project:2/relations/count", 0},
+		{"/resources/project:2/relations/count?relation=reader", 0},
+	}
+	for _, tt := range tests {
+		var count authz.RelationshipCount
+		decode(t, serve(h, "GET", v1Prefix+tt.path, ""), http.StatusOK, &count)
+		if count.Count != tt.want {
+			t.Errorf("%s: count = %d, want %d", tt.path, count.Count, tt.want)
+		}
+	}
+
+	for _, path := range []string{"/resources/project:1/relations/count?relation=readr", "/resources/folder:1/relations/count"} {
+		if rec := serve(h, "GET", v1Prefix+path, ""); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d (body: %s), want 400", path, rec.Code, rec.Body)
+		}
+	}
+}
+
+// The types traversals stop on come from the filters, which are validated against the schema:
+// a filter naming a type the schema lacks (e.g. after a rename) is rejected rather than matching nothing.
+func TestTraversalStopTypesValidated(t *testing.T) {
+	h, _, repo := newTestServer(t, authz.LoadMetadata())
+	seedRelations(t, repo, rel("project:1", "reader", "group:a"))
+
+	for _, endpoint := range []string{"/paths", "/permissions"} {
+		var items []json.RawMessage
+		rec := serve(h, "GET", v1Prefix+endpoint+"?resource_filter=project:1&subject_filter=user,group", "")
+		decode(t, rec, http.StatusOK, &items)
+		if len(items) != 1 {
+			t.Errorf("%s: %d items, want 1 for group:a", endpoint, len(items))
+		}
+
+		rec = serve(h, "GET", v1Prefix+endpoint+"?resource_filter=project:1&subject_filter=user,team", "")
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `"team"`) {
+			t.Errorf("%s: status = %d (body: %s), want 400 for the unknown type team", endpoint, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestCheckRejectsSubjectRelation(t *testing.T) {
+	h, _, repo := newTestServer(t, authz.LoadMetadata())
+	seedRelations(t, repo, rel("project:1", "reader", "group:eng"))
+
+	for _, target := range []string{
+		"/permissions/read?resource=project:1&subject=group:eng%23member",
+		"/permissions?resource_filter=project:1&subject_filter=group:eng%23member",
+		"/relations/reader/check?resource=project:1&subject=group:eng%23member",
+		"/relations/reader/check?resource=project:1&subject=user:alice%23member",
+	} {
+		rec := serve(h, "GET", v1Prefix+target, "")
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "#member") {
+			t.Errorf("%s: status = %d (body: %s), want 400 for a subject relation", target, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestCheckPermissionDenyReason(t *testing.T) {
+	h, _, repo := newTestServer(t, authz.LoadMetadata())
+	seedRelations(t, repo,
+		rel("project:1", "owner", "user:alice"),
+		rel("project:1", "forbidden", "user:alice"),
+		rel("project:1", "reader", "user:bob"),
+		rel("project:1", "owner", "user:carol"),
+	)
+
+	for _, tt := range []struct {
+		subject, reason string
+		allowed         bool
+	}{
+		{"user:alice", authz.DenyReasonExcluded, false},
+		{"user:bob", authz.DenyReasonNoMatchingRelation, false},
+		{"user:dave", authz.DenyReasonNoPath, false},
+		{"user:carol", "", true},
+	} {
+		var eval authz.PermissionEval
+		rec := serve(h, "GET", v1Prefix+"/permissions/edit?resource=project:1&subject="+tt.subject+"&show_reason=true", "")
+		decode(t, rec, http.StatusOK, &eval)
+		if eval.Allowed != tt.allowed || eval.Reason != tt.reason {
+			t.Errorf("%s: allowed %v, reason %q, want allowed %v, reason %q", tt.subject, eval.Allowed, eval.Reason, tt.allowed, tt.reason)
+		}
+	}
+
+	// Reasons are only returned on request
+	var eval authz.PermissionEval
+	rec := serve(h, "GET", v1Prefix+"/permissions/edit?resource=project:1&subject=user:alice", "")
+	decode(t, rec, http.StatusOK, &eval)
+	if eval.Reason != "" {
+		t.Errorf("reason = %q, want none without show_reason", eval.Reason)
+	}
+
+	var items []authz.PermissionCheckItem
+	rec = serve(h, "GET", v1Prefix+"/permissions?resource_filter=project:1&subject_filter=user:bob&permission=edit&show_reason=true", "")
+	decode(t, rec, http.StatusOK, &items)
+	if len(items) != 1 || items[0].PermissionEvals["edit"].Reason != authz.DenyReasonNoMatchingRelation {
+		t.Errorf("items = %+v, want edit denied for no matching relation", items)
+	}
+}
+
+func TestCheckRelation(t *testing.T) {
+	h, _, repo := newTestServer(t, authz.LoadMetadata())
+	seedRelations(t, repo,
+		rel("project:1", "owner", "group:a"),
+		rel("group:a", "member", "user:alice"),
+		rel("project:1", "reader", "user:bob"),
+	)
+
+	for _, tt := range []struct {
+		relation, subject string
+		related           bool
+	}{
+		{"reader", "user:bob", true},    // direct
+		{"owner", "user:alice", true},   // transitive, through group:a
+		{"member", "user:alice", true},  // relation of another type along the path
+		{"owner", "user:bob", false},    // connected by another relation
+		{"reader", "user:carol", false}, // not connected
+	} {
+		var check authz.RelationCheck
+		rec := serve(h, "GET", v1Prefix+"/relations/"+tt.relation+"/check?resource=project:1&subject="+tt.subject+"&show_matching_paths=true", "")
+		decode(t, rec, http.StatusOK, &check)
+		if check.Related != tt.related || (len(check.MatchingPaths) > 0) != tt.related {
+			t.Errorf("%s of %s: related %v with %d paths, want related %v", tt.relation, tt.subject, check.Related, len(check.MatchingPaths), tt.related)
+		}
+	}
+
+	rec := serve(h, "GET", v1Prefix+"/relations/ownr/check?resource=project:1&subject=user:alice", "")
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `did you mean "owner"?`) {
+		t.Errorf("status = %d (body: %s), want 400 for an unknown relation", rec.Code, rec.Body)
+	}
+}
+
+func TestShowHops(t *testing.T) {
+	h, _, repo := newTestServer(t, authz.LoadMetadata())
+	seedRelations(t, repo,
+		rel("project:1", "owner", "group:a"),
+		rel("group:a", "member", "user:alice"),
+	)
+
+	// Hops follow the traversal order, from the resource to the subject reached by the terminal edge
+	assertHops := func(name string, paths [][]authz.Relationship) {
+		t.Helper()
+		if len(paths) != 1 || len(paths[0]) != 2 {
+			t.Fatalf("%s: paths %+v, want a single path of 2 edges", name, paths)
+		}
+		for i, want := range []struct {
+			resource, relation string
+			terminal           bool
+		}{{"project:1", "owner", false}, {"group:a", "member", true}} {
+			edge := paths[0][i]
+			if edge.Hop == nil || *edge.Hop != i || edge.Terminal != want.terminal ||
+				edge.Resource != obj(want.resource) || edge.Relation != want.relation {
+				t.Errorf("%s: edge %d = %+v (hop %v), want %s %s at hop %d, terminal %v",
+					name, i, edge, edge.Hop, want.resource, want.relation, i, want.terminal)
+			}
+		}
+	}
+
+	var items []authz.TraversalResponseItem
+	decode(t, serve(h, "GET", v1Prefix+"/paths?resource_filter=project:1&subject_filter=user:alice&show_hops=true", ""), http.StatusOK, &items)
+	if len(items) != 1 {
+		t.Fatalf("got %d items, want 1", len(items))
+	}
+	assertHops("/paths", items[0].Paths)
+
+	var eval authz.PermissionEval
+	decode(t, serve(h, "GET", v1Prefix+"/permissions/read?resource=project:1&subject=user:alice&show_matching_paths=true&show_hops=true", ""), http.StatusOK, &eval)
+	assertHops("/permissions/read", eval.MatchingPaths)
+
+	var check authz.RelationCheck
+	decode(t, serve(h, "GET", v1Prefix+"/relations/owner/check?resource=project:1&subject=user:alice&show_matching_paths=true&show_hops=true", ""), http.StatusOK, &check)
+	assertHops("/relations/owner/check", check.MatchingPaths)
+
+	// Without the flag, paths are unchanged
+	rec := serve(h, "GET", v1Prefix+"/paths?resource_filter=project:1&subject_filter=user:alice", "")
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), `"hop"`) || strings.Contains(rec.Body.String(), `"terminal"`) {
+		t.Errorf("status = %d (body: %s), want paths without hop metadata", rec.Code, rec.Body)
+	}
+
+	// Compact paths have no edges to annotate
+	rec = serve(h, "GET", v1Prefix+"/permissions/read?resource=project:1&subject=user:alice&show_matching_paths=true&show_hops=true&path_format=compact", "")
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "show_hops") {
+		t.Errorf("status = %d (body: %s), want 400 for show_hops with compact paths", rec.Code, rec.Body)
+	}
+}
+
+func TestParseBoolParam(t *testing.T) {
+	tests := []struct {
+		name       string
+		params     map[string]string
+		defaultVal bool
+		want       bool
+		wantErr    bool
+	}{
+		{name: "absent", params: map[string]string{}, want: false},
+		{name: "absent with default true", params: map[string]string{}, defaultVal: true, want: true},
+		{name: "empty", params: map[string]string{"flag": ""}, defaultVal: true, wantErr: true},
+		{name: "invalid", params: map[string]string{"flag": "yes"}, wantErr: true},
+		{name: "true", params: map[string]string{"flag": "true"}, want: true},
+		{name: "TRUE", params: map[string]string{"flag": "TRUE"}, want: true},
+		{name: "1", params: map[string]string{"flag": "1"}, want: true},
+		{name: "false", params: map[string]string{"flag": "false"}, defaultVal: true, want: false},
+		{name: "0", params: map[string]string{"flag": "0"}, defaultVal: true, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := authz.ParseBoolParam(tt.params, "flag", tt.defaultVal)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseBoolParam() error = %v, want error: %v", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("ParseBoolParam() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	h, _, _ := newTestServer(t, authz.LoadMetadata())
+	rec := serve(h, "GET", v1Prefix+"/permissions/read?resource=project:1&subject=user:alice&show_matching_paths=", "")
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "show_matching_paths") {
+		t.Errorf("status = %d (body: %s), want 400 for an empty flag", rec.Code, rec.Body)
+	}
+}
+
+func TestListSubjectResources(t *testing.T) {
+	meta := loadSchema(t, `
+schema_version: "1.0"
+objects:
+  user:
+    relations: {}
+  group:
+    relations:
+      member:
+        subject_types: [user]
+  doc:
+    relations:
+      viewer:
+        subject_types: [user, group]
+      editor:
+        subject_types: [user]
+    permissions:
+      view:
+        any_of: [viewer, editor]
+      edit:
+        any_of: [editor]
+  folder:
+    relations:
+      owner:
+        subject_types: [user]
+    permissions:
+      browse:
+        any_of: [owner]
+`)
+	h, _, repo := newTestServer(t, meta)
+	seedRelations(t, repo,
+		rel("doc:1", "viewer", "group:eng"),
+		rel("group:eng", "member", "user:alice"),
+		rel("doc:2", "editor", "user:alice"),
+		rel("folder:1", "owner", "user:alice"),
+		rel("doc:3", "viewer", "user:bob"),
+	)
+
+	var accesses []authz.ResourceAccess
+	decode(t, serve(h, "GET", v1Prefix+"/subjects/user:alice/resources", ""), http.StatusOK, &accesses)
+	want := []authz.ResourceAccess{
+		{Resource: obj("doc:1"), Permissions: []string{"view"}},
+		{Resource: obj("doc:2"), Permissions: []string{"edit", "view"}},
+		{Resource: obj("folder:1"), Permissions: []string{"browse"}},
+	}
+	if !reflect.DeepEqual(accesses, want) {
+		t.Errorf("accesses = %+v, want %+v", accesses, want)
+	}
+
+	accesses = nil
+	decode(t, serve(h, "GET", v1Prefix+"/subjects/user:alice/resources?resource_types=folder", ""), http.StatusOK, &accesses)
+	if !reflect.DeepEqual(accesses, want[2:]) {
+		t.Errorf("accesses to folders = %+v, want %+v", accesses, want[2:])
+	}
+
+	accesses = nil
+	decode(t, serve(h, "GET", v1Prefix+"/subjects/user:carol/resources", ""), http.StatusOK, &accesses)
+	if accesses == nil || len(accesses) != 0 {
+		t.Errorf("accesses of a subject without relationships = %#v, want []", accesses)
+	}
+
+	if rec := serve(h, "GET", v1Prefix+"/subjects/user:alice/resources?resource_types=file", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400 for an unknown resource type", rec.Code)
+	}
+}
+
+func TestListAuditEntriesFilters(t *testing.T) {
+	h, _, _ := newTestServer(t, authz.LoadMetadata())
+	for _, entry := range []struct{ actor, action, resource, subject, createdAt string }{
+		{"api-key:admin", "create", "project:1", "user:alice", "2026-01-01 10:00:00"},
+		{"api-key:admin", "create", "project:2", "user:bob", "2026-01-02 10:00:00"},
+		{"api-key:other", "delete", "project:1", "user:alice", "2026-01-03 10:00:00"},
+		{"api-key:other", "create", "group:eng", "user:alice", "2026-01-04 10:00:00"},
+		{"api-key:admin", "delete", "project:2", "user:bob", "2026-01-05 10:00:00"},
+	} {
+		resource, subject := obj(entry.resource), obj(entry.subject)
+		if _, err := db.DB.Exec(`
+            INSERT INTO audit_log (actor, action, resource_type, resource_id, subject_type, subject_id, relation, created_at)
+            VALUES (?, ?, ?, ?, ?, ?, 'member', ?)
+        `, entry.actor, entry.action, resource.Type, resource.ID, subject.Type, subject.ID, entry.createdAt); err != nil {
+			t.Fatalf("seed audit log failed: %v", err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		ids   []int64
+	}{
+		{"", []int64{1, 2, 3, 4, 5}},
+		{"resource=project", []int64{1, 2, 3, 5}},
+		{"resource=project:2", []int64{2, 5}},
+		{"subject=user:alice", []int64{1, 3, 4}},
+		{"actor=api-key:other", []int64{3, 4}},
+		{"action=delete", []int64{3, 5}},
+		{"since=2026-01-02T10:00:00Z&until=2026-01-04T10:00:00Z", []int64{2, 3}},
+		{"since=2026-01-03T11:00:00%2B01:00", []int64{3, 4, 5}},
+		{"resource=project&subject=user:alice&action=create", []int64{1}},
+		{"after=3", []int64{4, 5}},
+	}
+	for _, tt := range tests {
+		var entries []authz.AuditEntry
+		decode(t, serve(h, "GET", v1Prefix+"/audit?"+tt.query, ""), http.StatusOK, &entries)
+		ids := []int64{}
+		for _, entry := range entries {
+			ids = append(ids, entry.ID)
+		}
+		if !reflect.DeepEqual(ids, tt.ids) {
+			t.Errorf("%q: entries %v, want %v", tt.query, ids, tt.ids)
+		}
+	}
+
+	// Pages of 2 entries, each after the last entry of the previous one
+	var ids []int64
+	for after, pages := int64(0), 0; ; pages++ {
+		if pages == 3 {
+			t.Fatalf("still truncated after %d pages", pages)
+		}
+		var entries []authz.AuditEntry
+		rec := serve(h, "GET", v1Prefix+"/audit?limit=2&after="+strconv.FormatInt(after, 10), "")
+		decode(t, rec, http.StatusOK, &entries)
+		for _, entry := range entries {
+			ids = append(ids, entry.ID)
+			after = entry.ID
+		}
+		if rec.Header().Get("Results-Truncated") != "true" {
+			break
+		}
+	}
+	if want := []int64{1, 2, 3, 4, 5}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("paged entries %v, want %v", ids, want)
+	}
+
+	for _, query := range []string{"resource=file", "subject=file:1", "action=update", "since=yesterday", "after=-1", "limit=0"} {
+		if rec := serve(h, "GET", v1Prefix+"/audit?"+query, ""); rec.Code != http.StatusBadRequest {
+			t.Errorf("%q: status = %d, want 400", query, rec.Code)
+		}
+	}
+}
+
+// TestTimeoutCancelsQuery checks that a request timing out cancels the database query of its handler.
+func TestTimeoutCancelsQuery(t *testing.T) {
+	newService(t, authz.LoadMetadata())
+	queryErr := make(chan error, 1)
+	slow := func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		var n int64
+		err := db.DB.QueryRowContext(r.Context(), `
+            WITH RECURSIVE counter(n) AS (SELECT 1 UNION ALL SELECT n + 1 FROM counter)
+            SELECT max(n) FROM counter
+        `).Scan(&n)
+		queryErr <- err
+	}
+	r := router.NewRouter()
+	r.Handle("GET", v1Prefix+"/slow", slow, router.Timeout(50*time.Millisecond))
+
+	if rec := serve(r, "GET", v1Prefix+"/slow", ""); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d (body: %s), want 503", rec.Code, rec.Body)
+	}
+	select {
+	case err := <-queryErr:
+		if err == nil {
+			t.Error("query succeeded, want it cancelled")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("query still running 5s after the request timed out")
+	}
+}
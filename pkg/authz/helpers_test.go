@@ -0,0 +1,140 @@
+package authz_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/romrossi/authz-rebac/pkg/authz"
+	"github.com/romrossi/authz-rebac/pkg/db"
+	"github.com/romrossi/authz-rebac/pkg/router"
+)
+
+// v1Prefix is the prefix of the API routes, as served by cmd/server.
+const v1Prefix = "/api/v1"
+
+// newTestServer serves the API over a new in-memory SQLite database with the schema,
+// and returns the router along with the service and its repository.
+func newTestServer(t *testing.T, meta authz.Metadata) (*router.Router, authz.AuthzService, authz.AuthzRepository) {
+	t.Helper()
+	svc, repo := newService(t, meta)
+	return routes(authz.NewAuthzHandler(svc, meta, 0)), svc, repo
+}
+
+// routes registers the handlers on the routes of cmd/server, without middleware.
+func routes(h *authz.AuthzHandler) *router.Router {
+	r := router.NewRouter()
+	r.Handle("GET", v1Prefix+"/permissions/{permission}", h.CheckPermission())
+	r.Handle("GET", v1Prefix+"/permissions/{permission}/count", h.CountPermission())
+	r.Handle("GET", v1Prefix+"/permissions", h.CheckPermissions())
+	r.Handle("POST", v1Prefix+"/permissions:warm", h.WarmPaths())
+	r.Handle("POST", v1Prefix+"/permissions/{permission}:checkSubjects", h.CheckSubjects())
+	r.Handle("GET", v1Prefix+"/paths", h.ListPaths())
+	r.Handle("GET", v1Prefix+"/resources/{resource}/relations", h.ListResourceRelations())
+	r.Handle("GET", v1Prefix+"/resources/{resource}/relations/count", h.CountResourceRelations())
+	r.Handle("GET", v1Prefix+"/resources/{resource}/relations/{relation}/subjects", h.ListResourceSubjects())
+	r.Handle("GET", v1Prefix+"/subjects/{subject}/resources", h.ListSubjectResources())
+	r.Handle("POST", v1Prefix+"/relations", h.ManageRelationships())
+	r.Handle("GET", v1Prefix+"/relations/watch", h.WatchRelations())
+	r.Handle("GET", v1Prefix+"/relations/export", h.ExportRelations())
+	r.Handle("GET", v1Prefix+"/relations/{relation}/check", h.CheckRelation())
+	r.Handle("GET", v1Prefix+"/audit", h.ListAuditEntries())
+	r.Handle("GET", v1Prefix+"/schema/objects", h.ListObjectTypes())
+	r.Handle("GET", v1Prefix+"/schema/objects/{type}", h.GetObjectDefinition())
+	r.Handle("GET", v1Prefix+"/schema/objects/{type}/relations/{relation}", h.GetRelationDefinition())
+	r.Handle("POST", v1Prefix+"/schema/validate", h.ValidateSchema())
+	return r
+}
+
+// serve sends a request to the handler, with a JSON body unless empty, and returns the recorded response.
+func serve(h http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// decode unmarshals a JSON response, failing the test unless its status is the expected one.
+func decode(t *testing.T, rec *httptest.ResponseRecorder, status int, v interface{}) {
+	t.Helper()
+	if rec.Code != status {
+		t.Fatalf("status = %d, want %d (body: %s)", rec.Code, status, rec.Body)
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("invalid JSON response %q: %v", rec.Body, err)
+	}
+}
+
+// rel builds a relationship from "type:id" objects.
+func rel(resource, relation, subject string) authz.Relationship {
+	return authz.Relationship{Resource: obj(resource), Relation: relation, Subject: obj(subject)}
+}
+
+// obj builds an object from "type:id".
+func obj(raw string) authz.Object {
+	objectType, id, _ := strings.Cut(raw, ":")
+	return authz.Object{Type: objectType, ID: id}
+}
+
+// loadSchema parses and validates a YAML schema, failing the test if it is invalid.
+func loadSchema(t testing.TB, schema string) authz.Metadata {
+	t.Helper()
+	meta, err := authz.ParseMetadata([]byte(schema))
+	if err != nil {
+		t.Fatalf("invalid schema: %v", err)
+	}
+	return meta
+}
+
+// newService connects a new in-memory SQLite database, closed when the test ends,
+// and returns a service over it with the schema, along with its repository.
+func newService(t testing.TB, meta authz.Metadata) (authz.AuthzService, authz.AuthzRepository) {
+	t.Helper()
+	db.Connect(db.DriverSQLite, "", "", ":memory:", "", "")
+	conn := db.DB
+	t.Cleanup(func() { conn.Close() })
+
+	repo := authz.NewSQLiteRepository()
+	return authz.NewService(repo, meta), repo
+}
+
+// seedRelations inserts relationships into the repository, without validating them against the schema.
+func seedRelations(t testing.TB, repo authz.AuthzRepository, relationships ...authz.Relationship) {
+	t.Helper()
+	if _, err := repo.InsertBulk(context.Background(), relationships, authz.ConflictIgnore); err != nil {
+		t.Fatalf("seed relationships failed: %v", err)
+	}
+}
+
+// assertAllowed fails the test unless the permission of the subject on the resource is granted.
+func assertAllowed(t *testing.T, svc authz.AuthzService, resource, subject, permission string) {
+	t.Helper()
+	if !permitted(t, svc, resource, subject, permission) {
+		t.Errorf("%s is denied %s on %s, want allowed", subject, permission, resource)
+	}
+}
+
+// assertDenied fails the test if the permission of the subject on the resource is granted.
+func assertDenied(t *testing.T, svc authz.AuthzService, resource, subject, permission string) {
+	t.Helper()
+	if permitted(t, svc, resource, subject, permission) {
+		t.Errorf("%s is allowed %s on %s, want denied", subject, permission, resource)
+	}
+}
+
+// permitted evaluates a permission of a subject on a resource, denied if no path connects them.
+func permitted(t *testing.T, svc authz.AuthzService, resource, subject, permission string) bool {
+	t.Helper()
+	request := authz.TraversalRequest{StartOn: obj(resource), Forward: true, StopOn: obj(subject)}
+	items, _, err := svc.CheckPermissions(context.Background(), request, authz.CheckOptions{Permissions: []string{permission}})
+	if err != nil {
+		t.Fatalf("check %s %s %s failed: %v", resource, permission, subject, err)
+	}
+	return len(items) == 1 && items[0].PermissionEvals[permission].Allowed
+}
@@ -1,8 +1,18 @@
 package authz
 
 import (
+	"bytes"
 	_ "embed"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
@@ -10,37 +20,320 @@ import (
 //go:embed schema.yaml
 var Schema []byte
 
+// Default object ID validation, used when the schema does not override it.
+const (
+	defaultIDMaxLength = 256
+	defaultIDPattern   = `^[A-Za-z0-9_.@|/+=-]+$`
+)
+
+// Supported precedence rules
+const (
+	rulePathWith      = "path_with"
+	rulePathWithout   = "path_without"
+	rulePathWithFewer = "path_with_fewer"
+
+	// ruleDenyOverrides marks the paths eliminated by a path containing a deny override relation (see DenyOverrides)
+	ruleDenyOverrides = "deny_overrides"
+)
+
+// Supported precedence modes
+const (
+	precedenceLexicographic = "lexicographic" // default: the first rule differentiating two paths decides
+	precedenceWeighted      = "weighted"      // the path with the lowest total of weighted rule penalties wins
+)
+
 // LoadMetadata loads the schema metadata on startup and panics if schema loading fails.
 func LoadMetadata() Metadata {
-	var meta Metadata
-	if err := yaml.Unmarshal(Schema, &meta); err != nil {
+	meta, err := ParseMetadata(Schema)
+	if err != nil {
 		panic(fmt.Sprintf("failed to load authz metadata: %v", err))
 	}
 	return meta
 }
 
+// ParseMetadata parses and validates a YAML schema.
+func ParseMetadata(data []byte) (Metadata, error) {
+	var meta Metadata
+	if err := yaml.Unmarshal(data, &meta); err != nil {
+		return Metadata{}, err
+	}
+	return meta.compile()
+}
+
+// LoadMetadataDir parses the YAML schema files (*.yaml, *.yml) of a directory, merged into one schema,
+// and validates it. Each object type and caveat must be defined in a single file; schema_version and
+// id_validation may be set in several files, but only to the same value.
+func LoadMetadataDir(dir string) (Metadata, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return Metadata{}, err
+	}
+	var merged Metadata
+	objectFiles := map[string]string{} // file defining each object type
+	caveatFiles := map[string]string{} // file defining each caveat
+	var versionFile, idValidationFile string
+	for _, entry := range entries { // sorted by file name
+		ext := filepath.Ext(entry.Name())
+		if entry.IsDir() || (ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+		file := entry.Name()
+		data, err := os.ReadFile(filepath.Join(dir, file))
+		if err != nil {
+			return Metadata{}, err
+		}
+		var meta Metadata
+		if err := yaml.Unmarshal(data, &meta); err != nil {
+			return Metadata{}, fmt.Errorf("%s: %w", file, err)
+		}
+
+		if meta.SchemaVersion != "" {
+			if versionFile != "" && meta.SchemaVersion != merged.SchemaVersion {
+				return Metadata{}, fmt.Errorf("schema_version %q in %s conflicts with %q in %s",
+					meta.SchemaVersion, file, merged.SchemaVersion, versionFile)
+			}
+			merged.SchemaVersion, versionFile = meta.SchemaVersion, file
+		}
+		if meta.IDValidation != (IDValidation{}) {
+			if idValidationFile != "" && meta.IDValidation != merged.IDValidation {
+				return Metadata{}, fmt.Errorf("id_validation in %s conflicts with id_validation in %s", file, idValidationFile)
+			}
+			merged.IDValidation, idValidationFile = meta.IDValidation, file
+		}
+		for name, objDef := range meta.Objects {
+			if other, ok := objectFiles[name]; ok {
+				return Metadata{}, fmt.Errorf("object type %q is defined in both %s and %s", name, other, file)
+			}
+			if merged.Objects == nil {
+				merged.Objects = map[string]ObjectDefinition{}
+			}
+			merged.Objects[name], objectFiles[name] = objDef, file
+		}
+		for name, caveat := range meta.Caveats {
+			if other, ok := caveatFiles[name]; ok {
+				return Metadata{}, fmt.Errorf("caveat %q is defined in both %s and %s", name, other, file)
+			}
+			if merged.Caveats == nil {
+				merged.Caveats = map[string]CaveatDefinition{}
+			}
+			merged.Caveats[name], caveatFiles[name] = caveat, file
+		}
+	}
+	if len(objectFiles) == 0 {
+		return Metadata{}, fmt.Errorf("no schema file (*.yaml, *.yml) in %s", dir)
+	}
+	return merged.compile()
+}
+
+// compile validates a parsed schema, then compiles its ID pattern and caveat expressions.
+func (m Metadata) compile() (Metadata, error) {
+	if err := m.Validate(); err != nil {
+		return Metadata{}, err
+	}
+	if err := m.IDValidation.compile(); err != nil {
+		return Metadata{}, err
+	}
+	for name, caveat := range m.Caveats {
+		if err := caveat.compile(); err != nil {
+			return Metadata{}, fmt.Errorf("invalid caveat %s: %w", name, err)
+		}
+		m.Caveats[name] = caveat
+	}
+	return m, nil
+}
+
 // Metadata represents the authorization schema, including version and object definitions.
+// Names of object types, relations and permissions are case-sensitive: they are never normalized.
 type Metadata struct {
 	SchemaVersion string                      `yaml:"schema_version"`
+	IDValidation  IDValidation                `yaml:"id_validation"`
 	Objects       map[string]ObjectDefinition `yaml:"objects"`
+	Caveats       map[string]CaveatDefinition `yaml:"caveats"`
+}
+
+// IDValidation defines the constraints on object IDs written to the graph.
+// IDs may never contain a colon, which is reserved for the "type:id" encoding.
+type IDValidation struct {
+	MaxLength int    `yaml:"max_length"` // maximum ID length in bytes
+	Pattern   string `yaml:"pattern"`    // regular expression IDs must match
+
+	pattern *regexp.Regexp
+}
+
+// compile applies defaults and compiles the ID pattern.
+func (v *IDValidation) compile() error {
+	if v.MaxLength <= 0 {
+		v.MaxLength = defaultIDMaxLength
+	}
+	if v.Pattern == "" {
+		v.Pattern = defaultIDPattern
+	}
+	pattern, err := regexp.Compile(v.Pattern)
+	if err != nil {
+		return fmt.Errorf("invalid id_validation pattern: %w", err)
+	}
+	v.pattern = pattern
+	return nil
 }
 
 // ObjectDefinition defines the relations and permissions for a given object type.
 type ObjectDefinition struct {
-	Relations       map[string]RelationDefinition   `yaml:"relations"`
-	Permissions     map[string]PermissionDefinition `yaml:"permissions"`
-	PrecedenceRules []PrecedenceRule                `yaml:"precedence_rules"`
+	Relations       map[string]RelationDefinition   `yaml:"relations" json:"relations"`
+	Permissions     map[string]PermissionDefinition `yaml:"permissions" json:"permissions"`
+	PrecedenceRules []PrecedenceRule                `yaml:"precedence_rules" json:"precedence_rules,omitempty"`
+
+	// DenyOverrides lists relations denying every permission of the type to a subject reached by any path
+	// containing one of them, whatever its other paths: these paths are then the only effective ones,
+	// before precedence rules apply (which cannot eliminate them). This acts as an Except of every permission,
+	// which, unlike Except, also shapes the effective paths.
+	DenyOverrides []string `yaml:"deny_overrides" json:"deny_overrides,omitempty"`
+
+	// PrecedenceMode is how precedence rules combine: "lexicographic" (default) or "weighted" (see PrecedenceRule).
+	PrecedenceMode string `yaml:"precedence_mode" json:"precedence_mode,omitempty"`
+
+	// Aliases maps other names of relations to the relations they stand for (e.g. {owner: admin} after a rename).
+	// Relationships may be written under an alias, and are evaluated as relationships of the aliased relation.
+	Aliases map[string]string `yaml:"aliases" json:"aliases,omitempty"`
 }
 
 // RelationDefinition defines the allowed subject types for a specific relation.
 type RelationDefinition struct {
-	SubjectTypes []string `yaml:"subject_types"`
+	SubjectTypes []string `yaml:"subject_types" json:"subject_types"`
+
+	// RejectSelf rejects relationships whose subject is their resource (e.g. project:1#parent@project:1),
+	// which some models legitimately use, but which otherwise only add cycles to traversals.
+	RejectSelf bool `yaml:"reject_self" json:"reject_self,omitempty"`
+
+	// Hierarchical marks a relation linking an object to an object of another level of a hierarchy
+	// (e.g. a folder to its organization): its subject types must not include the resource type.
+	Hierarchical bool `yaml:"hierarchical" json:"hierarchical,omitempty"`
 }
 
-// PermissionDefinition defines how a permission is composed, including inclusions (AnyOf) and exclusions (Except).
+// PermissionDefinition defines how a permission is composed, including inclusions (AnyOf), requirements (AllOf)
+// and exclusions (Except). AnyOf entries name relations, or other permissions of the same object type
+// (e.g. "read" includes "edit"), which are granted if the named permission is, or "relation->permission" to delegate
+// to the objects related by a relation of the type (e.g. "parent->view": view the parent).
+// AllOf entries are named the same way. Except entries name relations only.
+//
+// DefaultDecision is the decision reached when no Except entry (or deny override of the type) is found on a path:
+//   - "deny" (the default): the permission is granted only if every AllOf entry is, and an AnyOf entry is
+//     (AnyOf may be omitted if AllOf is not: e.g. all_of: [member, trained] grants members who completed training);
+//   - "allow": the permission is granted even without any path (e.g. on public documents), and AnyOf entries,
+//     which may then be omitted, only select the matching paths shown. AllOf is not allowed.
+//
+// Expr optionally requires a nested boolean expression (see PermissionExpr), like an AllOf entry:
+// e.g. expr: {or: [owner, {and: [editor, {not: suspended}]}]}. The flat fields are sugar for the common shapes
+// of expressions (any_of: [a, b] is {or: [a, b]}, all_of: [a, b] is {and: [a, b]}), and may be combined with it.
+//
+// Either way, an Except entry found on a path denies the permission, whatever its AllOf and AnyOf entries.
 type PermissionDefinition struct {
-	AnyOf  []string `yaml:"any_of"`
-	Except []string `yaml:"except"`
+	AnyOf           []string        `yaml:"any_of" json:"any_of"`
+	AllOf           []string        `yaml:"all_of" json:"all_of,omitempty"`
+	Except          []string        `yaml:"except" json:"except,omitempty"`
+	Expr            *PermissionExpr `yaml:"expr" json:"expr,omitempty"`
+	DefaultDecision string          `yaml:"default_decision" json:"default_decision,omitempty"`
+}
+
+// entries returns the AnyOf entries of the permission, then its AllOf entries.
+func (d PermissionDefinition) entries() []string {
+	return append(append([]string(nil), d.AnyOf...), d.AllOf...)
+}
+
+// references returns the entries of the permission, then the entries its expression references.
+func (d PermissionDefinition) references() []string {
+	references := d.entries()
+	if d.Expr != nil {
+		references = append(references, d.Expr.references()...)
+	}
+	return references
+}
+
+// PermissionExpr is a node of a boolean expression over the entries of a permission, named as AnyOf entries are
+// (relations, permissions of the same type, or "relation->permission"). A node is exactly one of:
+//   - an entry (Ref), written as a plain string: it holds if the entry is granted;
+//   - {or: [...]}: it holds if any of its operands does;
+//   - {and: [...]}: it holds if all of its operands do;
+//   - {not: ...}: it holds if its operand does not, e.g. {not: suspended} if no path contains "suspended".
+//
+// Like the flat fields, expressions are evaluated on the paths connecting the resource to the subject.
+// Without AnyOf or AllOf entries, a non-negated entry must hold the expression to grant the permission:
+// {not: suspended} alone grants nothing, rather than granting every subject, connected or not.
+type PermissionExpr struct {
+	Ref string           `yaml:"-" json:"-"`
+	Or  []PermissionExpr `yaml:"or,omitempty" json:"or,omitempty"`
+	And []PermissionExpr `yaml:"and,omitempty" json:"and,omitempty"`
+	Not *PermissionExpr  `yaml:"not,omitempty" json:"not,omitempty"`
+}
+
+// permissionExprNode has the fields of a PermissionExpr node, decoded without its custom decoding.
+type permissionExprNode PermissionExpr
+
+// UnmarshalYAML decodes an entry from a plain string, or else an or, and or not node.
+func (e *PermissionExpr) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*e = PermissionExpr{Ref: value.Value}
+		return nil
+	}
+	return value.Decode((*permissionExprNode)(e))
+}
+
+// MarshalJSON encodes an entry as a plain string, and other nodes as objects.
+func (e PermissionExpr) MarshalJSON() ([]byte, error) {
+	if e.Ref != "" {
+		return json.Marshal(e.Ref)
+	}
+	return json.Marshal(permissionExprNode(e))
+}
+
+// UnmarshalJSON decodes an entry from a plain string, or else an or, and or not node.
+func (e *PermissionExpr) UnmarshalJSON(data []byte) error {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '"' {
+		*e = PermissionExpr{}
+		return json.Unmarshal(trimmed, &e.Ref)
+	}
+	return json.Unmarshal(data, (*permissionExprNode)(e))
+}
+
+// operators returns the number of operators set on the node: exactly one is expected, counting an entry as one.
+func (e PermissionExpr) operators() int {
+	n := 0
+	for _, set := range []bool{e.Ref != "", e.Or != nil, e.And != nil, e.Not != nil} {
+		if set {
+			n++
+		}
+	}
+	return n
+}
+
+// references returns the entries referenced by the expression, in order.
+func (e PermissionExpr) references() []string {
+	if e.Ref != "" {
+		return []string{e.Ref}
+	}
+	var references []string
+	for _, operand := range append(append([]PermissionExpr(nil), e.Or...), e.And...) {
+		references = append(references, operand.references()...)
+	}
+	if e.Not != nil {
+		references = append(references, e.Not.references()...)
+	}
+	return references
+}
+
+// Default decisions of a permission (see PermissionDefinition).
+const (
+	DecisionAllow = "allow"
+	DecisionDeny  = "deny"
+)
+
+// hasDefaultAllow reports whether a permission of the type is granted by default.
+func (d ObjectDefinition) hasDefaultAllow() bool {
+	for _, def := range d.Permissions {
+		if def.DefaultDecision == DecisionAllow {
+			return true
+		}
+	}
+	return false
 }
 
 // PrecedenceRule defines how to rank traversal paths when multiple valid paths exist between a subject and a resource.
@@ -49,9 +342,347 @@ type PermissionDefinition struct {
 //   - "path_with": prefer paths that contain the given relation.
 //   - "path_without": prefer paths that do NOT contain the given relation.
 //   - "path_with_fewer": prefer paths that contain fewer occurrences of the given relation (e.g., closer in hierarchy).
+//
+// In the "weighted" precedence mode, rules are instead additive: each rule penalizes a path it does not prefer
+// by its weight (1 if unset), times the number of occurrences for "path_with_fewer", and the path with the lowest
+// total penalty is the most effective. E.g. a path_with_fewer rule of weight 3 outweighs a path_without rule of weight 1.
 type PrecedenceRule struct {
-	Rule     string
-	Relation string
+	Rule     string `yaml:"rule" json:"rule"`
+	Relation string `yaml:"relation" json:"relation"`
+	Weight   int    `yaml:"weight" json:"weight,omitempty"` // weighted mode only
+}
+
+// SchemaError is a schema inconsistency, located by the path of the faulty YAML node
+// (mapping keys and sequence indexes, e.g. ["objects", "project", "permissions", "read", "any_of", "1"]).
+type SchemaError struct {
+	Path    []string `json:"path,omitempty"`
+	Message string   `json:"message"`
+}
+
+// Error formats the error as "path: message", with a dotted path.
+func (e SchemaError) Error() string {
+	return strings.Join(e.Path, ".") + ": " + e.Message
+}
+
+// SchemaErrors lists every inconsistency found in a schema.
+type SchemaErrors []SchemaError
+
+// Error formats the errors one per line.
+func (e SchemaErrors) Error() string {
+	lines := make([]string, len(e))
+	for i, err := range e {
+		lines[i] = err.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// SchemaValidation is the result of validating a candidate schema (see ValidateSchema).
+type SchemaValidation struct {
+	Valid         bool         `json:"valid"`
+	SchemaVersion string       `json:"schema_version,omitempty"` // version of the schema, if valid
+	Errors        SchemaErrors `json:"errors,omitempty"`
+}
+
+// ValidateSchema parses and validates a YAML schema as ParseMetadata does to load one, without using it.
+// Errors other than inconsistencies (e.g. invalid YAML) are reported as a SchemaError without path.
+func ValidateSchema(data []byte) SchemaValidation {
+	meta, err := ParseMetadata(data)
+	if err == nil {
+		return SchemaValidation{Valid: true, SchemaVersion: meta.SchemaVersion}
+	}
+	var schemaErrs SchemaErrors
+	if !errors.As(err, &schemaErrs) {
+		schemaErrs = SchemaErrors{{Message: err.Error()}}
+	}
+	return SchemaValidation{Errors: schemaErrs}
+}
+
+// Validate checks the consistency of the schema: subject types, relations referenced by permissions
+// and precedence rules must be defined, precedence rules must be supported, and caveats must be well-formed.
+// It returns nil, or SchemaErrors listing every inconsistency in a stable order.
+func (m Metadata) Validate() error {
+	var errs SchemaErrors
+	report := func(message string, path ...string) {
+		errs = append(errs, SchemaError{Path: path, Message: message})
+	}
+
+	if m.SchemaVersion == "" {
+		report("schema version is required", "schema_version")
+	}
+	if m.IDValidation.MaxLength < 0 {
+		report("must not be negative", "id_validation", "max_length")
+	}
+	if m.IDValidation.Pattern != "" {
+		if _, err := regexp.Compile(m.IDValidation.Pattern); err != nil {
+			report(fmt.Sprintf("invalid pattern: %v", err), "id_validation", "pattern")
+		}
+	}
+	if len(m.Objects) == 0 {
+		report("at least one object type is required", "objects")
+	}
+
+	// Permissions and precedence rules may reference relations of any object type along a path
+	relations := map[string]bool{}
+	for _, objDef := range m.Objects {
+		for relation := range objDef.Relations {
+			relations[relation] = true
+		}
+	}
+
+	for _, objType := range sortedKeys(m.Objects) {
+		objDef := m.Objects[objType]
+
+		for _, relation := range sortedKeys(objDef.Relations) {
+			relDef := objDef.Relations[relation]
+			if len(relDef.SubjectTypes) == 0 {
+				report("at least one subject type is required", "objects", objType, "relations", relation, "subject_types")
+			}
+			for i, subjectType := range relDef.SubjectTypes {
+				if _, ok := m.Objects[subjectType]; !ok {
+					report(fmt.Sprintf("undefined object type %q", subjectType), "objects", objType, "relations", relation, "subject_types", strconv.Itoa(i))
+				}
+				if relDef.Hierarchical && subjectType == objType {
+					report("a hierarchical relation must not have its resource type as subject type", "objects", objType, "relations", relation, "subject_types", strconv.Itoa(i))
+				}
+			}
+		}
+
+		for _, permission := range sortedKeys(objDef.Permissions) {
+			permDef := objDef.Permissions[permission]
+			switch permDef.DefaultDecision {
+			case "", DecisionDeny:
+				if len(permDef.AnyOf) == 0 && len(permDef.AllOf) == 0 && permDef.Expr == nil {
+					report("at least one relation is required", "objects", objType, "permissions", permission, "any_of")
+				}
+			case DecisionAllow:
+				if len(permDef.AllOf) > 0 {
+					report("not allowed with default decision \"allow\"", "objects", objType, "permissions", permission, "all_of")
+				}
+				if permDef.Expr != nil {
+					report("not allowed with default decision \"allow\"", "objects", objType, "permissions", permission, "expr")
+				}
+			default:
+				report(fmt.Sprintf("unsupported default decision %q (allow or deny)", permDef.DefaultDecision), "objects", objType, "permissions", permission, "default_decision")
+			}
+			// at returns the path of a field of the permission
+			at := func(path ...string) []string {
+				return append([]string{"objects", objType, "permissions", permission}, path...)
+			}
+			validateEntry := func(name string, path ...string) {
+				path = at(path...)
+				if relation, targetPermission, ok := parseArrow(name); ok {
+					if err := m.validateArrow(objDef, relation, targetPermission); err != "" {
+						report(err, path...)
+					}
+					return
+				}
+				_, isPermission := objDef.Permissions[name]
+				switch {
+				case isPermission && relations[name]:
+					report(fmt.Sprintf("ambiguous name %q: both a relation and a permission", name), path...)
+				case !isPermission && !relations[name]:
+					report(fmt.Sprintf("undefined relation or permission %q", name), path...)
+				}
+			}
+			for i, name := range permDef.AnyOf {
+				validateEntry(name, "any_of", strconv.Itoa(i))
+			}
+			for i, name := range permDef.AllOf {
+				validateEntry(name, "all_of", strconv.Itoa(i))
+			}
+			var validateExpr func(expr PermissionExpr, path ...string)
+			validateExpr = func(expr PermissionExpr, path ...string) {
+				if expr.operators() != 1 {
+					report("exactly one of a relation or permission, or, and, not is required", at(path...)...)
+					return
+				}
+				switch {
+				case expr.Ref != "":
+					validateEntry(expr.Ref, path...)
+				case expr.Not != nil:
+					validateExpr(*expr.Not, append(path, "not")...)
+				default:
+					operator, operands := "or", expr.Or
+					if expr.And != nil {
+						operator, operands = "and", expr.And
+					}
+					if len(operands) == 0 {
+						report("at least one operand is required", at(append(path, operator)...)...)
+					}
+					for i, operand := range operands {
+						validateExpr(operand, append(path, operator, strconv.Itoa(i))...)
+					}
+				}
+			}
+			if permDef.Expr != nil {
+				validateExpr(*permDef.Expr, "expr")
+			}
+			if cycle := objDef.permissionCycle(permission); cycle != nil {
+				report(fmt.Sprintf("permission cycle: %s", strings.Join(cycle, " -> ")), "objects", objType, "permissions", permission, "any_of")
+			}
+			for i, relation := range permDef.Except {
+				if !relations[relation] {
+					report(fmt.Sprintf("undefined relation %q", relation), "objects", objType, "permissions", permission, "except", strconv.Itoa(i))
+				}
+			}
+		}
+
+		for _, alias := range sortedKeys(objDef.Aliases) {
+			if _, ok := objDef.Relations[alias]; ok {
+				report(fmt.Sprintf("alias %q is already a relation", alias), "objects", objType, "aliases", alias)
+			}
+			if _, ok := objDef.Relations[objDef.Aliases[alias]]; !ok {
+				report(fmt.Sprintf("undefined relation %q", objDef.Aliases[alias]), "objects", objType, "aliases", alias)
+			}
+		}
+
+		for i, relation := range objDef.DenyOverrides {
+			if !relations[relation] {
+				report(fmt.Sprintf("undefined relation %q", relation), "objects", objType, "deny_overrides", strconv.Itoa(i))
+			}
+		}
+		switch objDef.PrecedenceMode {
+		case "", precedenceLexicographic, precedenceWeighted:
+		default:
+			report(fmt.Sprintf("unknown precedence mode %q", objDef.PrecedenceMode), "objects", objType, "precedence_mode")
+		}
+		for i, rule := range objDef.PrecedenceRules {
+			switch rule.Rule {
+			case rulePathWith, rulePathWithout, rulePathWithFewer:
+			default:
+				report(fmt.Sprintf("unknown precedence rule %q", rule.Rule), "objects", objType, "precedence_rules", strconv.Itoa(i), "rule")
+			}
+			if rule.Weight < 0 {
+				report(fmt.Sprintf("negative weight %d", rule.Weight), "objects", objType, "precedence_rules", strconv.Itoa(i), "weight")
+			}
+			if !relations[rule.Relation] {
+				report(fmt.Sprintf("undefined relation %q", rule.Relation), "objects", objType, "precedence_rules", strconv.Itoa(i), "relation")
+			}
+		}
+	}
+
+	for _, name := range sortedKeys(m.Caveats) {
+		caveat := m.Caveats[name]
+		for _, param := range sortedKeys(caveat.Parameters) {
+			switch caveat.Parameters[param] {
+			case caveatTypeNumber, caveatTypeString, caveatTypeBool, caveatTypeList:
+			default:
+				report(fmt.Sprintf("unknown parameter type %q", caveat.Parameters[param]), "caveats", name, "parameters", param)
+			}
+		}
+		_, params, err := parseCaveatExpression(caveat.Expression)
+		if err != nil {
+			report(fmt.Sprintf("invalid expression: %v", err), "caveats", name, "expression")
+		}
+		for _, param := range params {
+			if _, ok := caveat.Parameters[param]; !ok {
+				report(fmt.Sprintf("undefined parameter %q", param), "caveats", name, "expression")
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// arrowSeparator separates the relation and the permission of a delegating AnyOf entry ("relation->permission").
+const arrowSeparator = "->"
+
+// parseArrow splits a delegating AnyOf entry "relation->permission", and reports whether the entry is one.
+func parseArrow(name string) (relation, permission string, ok bool) {
+	return strings.Cut(name, arrowSeparator)
+}
+
+// validateArrow checks a delegating AnyOf entry "relation->permission" of an object type:
+// the relation must be defined on the type, and the permission on at least one of its subject types.
+// It returns the error message, or "" if the entry is valid.
+func (m Metadata) validateArrow(objDef ObjectDefinition, relation, permission string) string {
+	relDef, ok := objDef.Relations[relation]
+	if !ok {
+		return fmt.Sprintf("undefined relation %q%s", relation, didYouMean(relation, sortedKeys(objDef.Relations)))
+	}
+	for _, subjectType := range relDef.SubjectTypes {
+		if _, ok := m.Objects[subjectType].Permissions[permission]; ok {
+			return ""
+		}
+	}
+	return fmt.Sprintf("permission %q is not defined on any subject type of relation %q", permission, relation)
+}
+
+// excludedRelations returns the relations denying a permission of the type when found on a path:
+// the deny overrides of the type, then the exclusions of the permission.
+func (d ObjectDefinition) excludedRelations(permission string) []string {
+	return append(append([]string(nil), d.DenyOverrides...), d.Permissions[permission].Except...)
+}
+
+// permissionCycle returns the permissions of a cycle going through the given permission by references of its entries
+// or expression, starting and ending with it (e.g. [read edit read]), or nil if there is none.
+func (d ObjectDefinition) permissionCycle(permission string) []string {
+	visited := map[string]bool{}
+	var find func(path []string) []string
+	find = func(path []string) []string {
+		for _, name := range d.Permissions[path[len(path)-1]].references() {
+			if _, ok := d.Permissions[name]; !ok {
+				continue
+			}
+			if name == permission {
+				return append(path, name)
+			}
+			if !visited[name] {
+				visited[name] = true
+				if cycle := find(append(path, name)); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		return nil
+	}
+	return find([]string{permission})
+}
+
+// permissionRelations returns the relations searched or excluded by a permission of an object type,
+// including those of the permissions it references in its entries or expression, recursively (through delegations too).
+func (m Metadata) permissionRelations(objectType, permission string) []string {
+	var relations []string
+	visited := map[string]bool{}
+	var collect func(objectType, permission string)
+	collect = func(objectType, permission string) {
+		objDef := m.Objects[objectType]
+		if visited[objectType+"#"+permission] {
+			return
+		}
+		visited[objectType+"#"+permission] = true
+		permDef := objDef.Permissions[permission]
+		for _, name := range permDef.references() {
+			if _, ok := objDef.Permissions[name]; ok {
+				collect(objectType, name)
+			} else if relation, targetPermission, ok := parseArrow(name); ok {
+				relations = append(relations, relation)
+				for _, subjectType := range objDef.Relations[relation].SubjectTypes {
+					if _, ok := m.Objects[subjectType].Permissions[targetPermission]; ok {
+						collect(subjectType, targetPermission)
+					}
+				}
+			} else {
+				relations = append(relations, name)
+			}
+		}
+		relations = append(relations, objDef.excludedRelations(permission)...)
+	}
+	collect(objectType, permission)
+	return relations
+}
+
+// sortedKeys returns the keys of a map in ascending order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
 }
 
 // IsValidObject checks that the object is non-empty and its type exists in metadata.
@@ -63,7 +694,7 @@ func (m Metadata) IsValidObject(obj Object) error {
 		return fmt.Errorf("id is required")
 	}
 	if _, ok := m.Objects[obj.Type]; !ok {
-		return fmt.Errorf("type is invalid: %q", obj.Type)
+		return fmt.Errorf("type is invalid: %q%s", obj.Type, didYouMean(obj.Type, sortedKeys(m.Objects)))
 	}
 	return nil
 }
@@ -74,13 +705,14 @@ func (m Metadata) IsValidObjectType(obj Object) error {
 		return fmt.Errorf("type is required")
 	}
 	if _, ok := m.Objects[obj.Type]; !ok {
-		return fmt.Errorf("type is invalid: %q", obj.Type)
+		return fmt.Errorf("type is invalid: %q%s", obj.Type, didYouMean(obj.Type, sortedKeys(m.Objects)))
 	}
 	return nil
 }
 
-// IsValidRelation checks that the relation exists on the resource type
-// and that the subject’s type is allowed by the relation definition.
+// IsValidRelation checks that the relation exists on the resource type,
+// that the subject’s type is allowed by the relation definition,
+// that both object IDs are well-formed, and that the caveat (if any) is valid.
 func (m Metadata) IsValidRelation(rel Relationship) error {
 	if err := m.IsValidObject(rel.Resource); err != nil {
 		return fmt.Errorf("resource %w", err)
@@ -88,14 +720,20 @@ func (m Metadata) IsValidRelation(rel Relationship) error {
 	if err := m.IsValidObject(rel.Subject); err != nil {
 		return fmt.Errorf("subject %w", err)
 	}
+	if err := m.IsValidObjectID(rel.Resource.ID); err != nil {
+		return fmt.Errorf("resource %w", err)
+	}
+	if err := m.IsValidObjectID(rel.Subject.ID); err != nil {
+		return fmt.Errorf("subject %w", err)
+	}
 	if rel.Relation == "" {
 		return fmt.Errorf("relation is required")
 	}
 
-	// Verify relation exists for the resource type
-	relDef, ok := m.Objects[rel.Resource.Type].Relations[rel.Relation]
+	// Verify relation exists for the resource type (possibly under an alias)
+	relDef, ok := m.Objects[rel.Resource.Type].Relations[m.CanonicalRelation(rel.Resource.Type, rel.Relation)]
 	if !ok {
-		return fmt.Errorf("relation is invalid: %s->%s->%s", rel.Resource.Type, rel.Relation, rel.Subject.Type)
+		return fmt.Errorf("%w%s", m.unknownRelationError(rel.Resource.Type, rel.Relation), m.swappedHint(rel))
 	}
 
 	// Check if subject type is allowed
@@ -107,9 +745,58 @@ func (m Metadata) IsValidRelation(rel Relationship) error {
 		}
 	}
 	if !allowed {
-		return fmt.Errorf("relation is invalid: %s->%s->%s", rel.Resource.Type, rel.Relation, rel.Subject.Type)
+		return fmt.Errorf("subject type %q not allowed for relation %q on type %q (allowed: [%s])%s",
+			rel.Subject.Type, rel.Relation, rel.Resource.Type, strings.Join(relDef.SubjectTypes, ", "), m.swappedHint(rel))
 	}
+	if relDef.RejectSelf && rel.Resource == rel.Subject {
+		return fmt.Errorf("relation is invalid: %s must not relate %s:%s to itself", rel.Relation, rel.Resource.Type, rel.Resource.ID)
+	}
+
+	if rel.Caveat != nil {
+		return m.IsValidCaveat(*rel.Caveat)
+	}
+
+	return nil
+}
 
+// withAliases returns the relations along with their aliases on every object type, and the relations the aliases
+// among them stand for, so that relationships written under any of these names are matched.
+func (m Metadata) withAliases(relations []string) []string {
+	names := map[string]bool{}
+	for _, relation := range relations {
+		names[relation] = true
+	}
+	for _, def := range m.Objects {
+		for alias, relation := range def.Aliases {
+			if names[alias] || names[relation] {
+				names[alias] = true
+				names[relation] = true
+			}
+		}
+	}
+	return sortedKeys(names)
+}
+
+// CanonicalRelation returns the relation a relation name stands for on an object type:
+// the aliased relation if the name is an alias, or else the name itself.
+func (m Metadata) CanonicalRelation(objectType, relation string) string {
+	if aliased, ok := m.Objects[objectType].Aliases[relation]; ok {
+		return aliased
+	}
+	return relation
+}
+
+// IsValidObjectID checks that the ID satisfies the schema's ID validation rules.
+func (m Metadata) IsValidObjectID(id string) error {
+	if strings.Contains(id, ":") {
+		return fmt.Errorf("id is invalid: %q must not contain ':'", id)
+	}
+	if m.IDValidation.MaxLength > 0 && len(id) > m.IDValidation.MaxLength {
+		return fmt.Errorf("id is invalid: length %d exceeds maximum of %d", len(id), m.IDValidation.MaxLength)
+	}
+	if m.IDValidation.pattern != nil && !m.IDValidation.pattern.MatchString(id) {
+		return fmt.Errorf("id is invalid: %q does not match pattern %s", id, m.IDValidation.Pattern)
+	}
 	return nil
 }
 
@@ -123,7 +810,255 @@ func (m Metadata) IsValidPermission(obj Object, permission string) error {
 		return fmt.Errorf("unknown object type: %q", obj.Type)
 	}
 	if _, ok := objDef.Permissions[permission]; !ok {
-		return fmt.Errorf("permission %q is invalid for resource type %q", permission, obj.Type)
+		return fmt.Errorf("permission %q is invalid for resource type %q%s", permission, obj.Type,
+			didYouMean(permission, sortedKeys(objDef.Permissions)))
+	}
+	return nil
+}
+
+// IsValidRelationName checks that the relation (or an alias of it) exists on the object type.
+func (m Metadata) IsValidRelationName(obj Object, relation string) error {
+	if err := m.IsValidObject(obj); err != nil {
+		return err
+	}
+	if _, ok := m.Objects[obj.Type].Relations[m.CanonicalRelation(obj.Type, relation)]; !ok {
+		return m.unknownRelationError(obj.Type, relation)
+	}
+	return nil
+}
+
+// IsKnownRelation checks that the relation (or an alias of it) exists on some object type,
+// as relations of any type may appear along a path.
+func (m Metadata) IsKnownRelation(relation string) error {
+	known := map[string]bool{}
+	for _, objDef := range m.Objects {
+		for name := range objDef.Relations {
+			known[name] = true
+		}
+		for alias := range objDef.Aliases {
+			known[alias] = true
+		}
+	}
+	if !known[relation] {
+		return fmt.Errorf("unknown relation %q%s", relation, didYouMean(relation, sortedKeys(known)))
 	}
 	return nil
 }
+
+// unknownRelationError reports a relation undefined on an object type, suggesting a close relation name,
+// or else naming the types declaring it: a relation only applies to resources of the type declaring it,
+// even if other types declare a relation of the same name.
+func (m Metadata) unknownRelationError(objectType, relation string) error {
+	relations := m.Objects[objectType].Relations
+	if hint := didYouMean(relation, sortedKeys(relations)); hint != "" {
+		return fmt.Errorf("unknown relation %q on type %q%s", relation, objectType, hint)
+	}
+	if declaring := m.typesDeclaring(relation); len(declaring) > 0 {
+		return fmt.Errorf("unknown relation %q on type %q (declared on: [%s])", relation, objectType, strings.Join(declaring, ", "))
+	}
+	return fmt.Errorf("unknown relation %q on type %q", relation, objectType)
+}
+
+// typesDeclaring returns the sorted object types declaring the relation, or an alias of that name.
+func (m Metadata) typesDeclaring(relation string) []string {
+	var types []string
+	for _, objectType := range sortedKeys(m.Objects) {
+		if _, ok := m.Objects[objectType].Relations[m.CanonicalRelation(objectType, relation)]; ok {
+			types = append(types, objectType)
+		}
+	}
+	return types
+}
+
+// swappedHint suggests swapping the resource and subject of a rejected relationship,
+// if the relation of the subject type accepts the resource type.
+func (m Metadata) swappedHint(rel Relationship) string {
+	relDef, ok := m.Objects[rel.Subject.Type].Relations[m.CanonicalRelation(rel.Subject.Type, rel.Relation)]
+	if ok && slices.Contains(relDef.SubjectTypes, rel.Resource.Type) {
+		swapped := Relationship{Resource: rel.Subject, Relation: rel.Relation, Subject: rel.Resource}
+		return fmt.Sprintf(" (resource and subject swapped? did you mean %s?)", swapped)
+	}
+	return ""
+}
+
+// RelevantRelations returns the relations a traversal must follow to evaluate permissions on resources of a type,
+// so that the other relations can be pruned during the traversal. It returns nil if no relation can be pruned.
+//
+// A relation is relevant if it is searched or excluded by a permission (or a permission it includes),
+// or if a path of the schema's type graph may contain both this relation and a searched or excluded one:
+// pruning it could then drop a deciding path.
+// Nothing is pruned for resource types with precedence rules, as any path may eliminate a deciding one.
+func (m Metadata) RelevantRelations(objectType string, permissions ...string) []string {
+	objDef := m.Objects[objectType]
+	if len(permissions) == 0 || len(objDef.PrecedenceRules) > 0 {
+		return nil
+	}
+	deciding := map[string]bool{}
+	for _, permission := range permissions {
+		if _, ok := objDef.Permissions[permission]; !ok {
+			return nil
+		}
+		for _, relation := range m.permissionRelations(objectType, permission) {
+			deciding[relation] = true
+		}
+	}
+
+	// Type graph edges: resource type -relation-> subject type
+	type edge struct{ from, relation, to string }
+	var edges []edge
+	for from, def := range m.Objects {
+		for relation, relDef := range def.Relations {
+			for _, to := range relDef.SubjectTypes {
+				edges = append(edges, edge{from, relation, to})
+			}
+		}
+	}
+
+	// reaches reports whether type b can be reached from type a (or is a)
+	reachable := map[string]map[string]bool{}
+	reaches := func(a, b string) bool {
+		if reachable[a] == nil {
+			seen := map[string]bool{a: true}
+			for queue := []string{a}; len(queue) > 0; queue = queue[1:] {
+				for _, e := range edges {
+					if e.from == queue[0] && !seen[e.to] {
+						seen[e.to] = true
+						queue = append(queue, e.to)
+					}
+				}
+			}
+			reachable[a] = seen
+		}
+		return reachable[a][b]
+	}
+
+	relevant := map[string]bool{}
+	pruned := false
+	for _, e := range edges {
+		if deciding[e.relation] {
+			relevant[e.relation] = true
+			continue
+		}
+		for _, d := range edges {
+			if deciding[d.relation] && (reaches(e.to, d.from) || reaches(d.to, e.from)) {
+				relevant[e.relation] = true
+				break
+			}
+		}
+	}
+	for _, e := range edges {
+		if !relevant[e.relation] {
+			pruned = true
+		}
+	}
+	if !pruned {
+		return nil
+	}
+	// Relationships written under an alias of a relevant relation are relevant too
+	for _, def := range m.Objects {
+		for alias, relation := range def.Aliases {
+			if relevant[relation] {
+				relevant[alias] = true
+			}
+		}
+	}
+	return sortedKeys(relevant)
+}
+
+// TraversableTypes returns the types of the objects a traversal in the given direction may go through to reach
+// objects of the target types (which are included), following the relations of the schema, or only the given ones
+// (or their aliases) if any. Objects of other types cannot lead to a target, so they can be pruned during the traversal.
+// It returns nil if no type can be pruned, or if a target type is unknown.
+func (m Metadata) TraversableTypes(targets []Object, forward bool, relations []string) []string {
+	if len(targets) == 0 {
+		return nil
+	}
+	followed := map[string]bool{}
+	for _, relation := range relations {
+		followed[relation] = true
+	}
+
+	// Type graph edges, in the direction of the traversal, indexed by the type they lead to
+	into := map[string][]string{}
+	for resourceType, def := range m.Objects {
+		for relation, relDef := range def.Relations {
+			if len(followed) > 0 && !followed[relation] && !followedAlias(def.Aliases, relation, followed) {
+				continue
+			}
+			for _, subjectType := range relDef.SubjectTypes {
+				if forward {
+					into[subjectType] = append(into[subjectType], resourceType)
+				} else {
+					into[resourceType] = append(into[resourceType], subjectType)
+				}
+			}
+		}
+	}
+
+	// Walk the edges back from the target types
+	traversable := map[string]bool{}
+	var queue []string
+	for _, target := range targets {
+		if _, ok := m.Objects[target.Type]; !ok {
+			return nil
+		}
+		if !traversable[target.Type] {
+			traversable[target.Type] = true
+			queue = append(queue, target.Type)
+		}
+	}
+	for ; len(queue) > 0; queue = queue[1:] {
+		for _, from := range into[queue[0]] {
+			if !traversable[from] {
+				traversable[from] = true
+				queue = append(queue, from)
+			}
+		}
+	}
+	if len(traversable) == len(m.Objects) {
+		return nil
+	}
+	return sortedKeys(traversable)
+}
+
+// followedAlias reports whether an alias of the relation is among the followed relations.
+func followedAlias(aliases map[string]string, relation string, followed map[string]bool) bool {
+	for alias, target := range aliases {
+		if target == relation && followed[alias] {
+			return true
+		}
+	}
+	return false
+}
+
+// didYouMean returns a " (did you mean ...?)" hint naming the first candidate close to name:
+// equal ignoring case, or at Levenshtein distance 1. It returns "" if there is none.
+func didYouMean(name string, candidates []string) string {
+	for _, candidate := range candidates {
+		if strings.EqualFold(name, candidate) || levenshtein(name, candidate) == 1 {
+			return fmt.Sprintf(" (did you mean %q?)", candidate)
+		}
+	}
+	return ""
+}
+
+// levenshtein returns the edit distance between a and b, in bytes.
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
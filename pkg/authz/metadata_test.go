@@ -0,0 +1,304 @@
+package authz_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/romrossi/authz-rebac/pkg/authz"
+)
+
+func TestIsValidRelationObjectIDs(t *testing.T) {
+	meta := authz.LoadMetadata()
+	tests := []struct {
+		name    string
+		rel     authz.Relationship
+		wantErr string
+	}{
+		{"valid", rel("project:p-1", "reader", "user:alice@example.com"), ""},
+		{"colon", rel("project:a:b", "reader", "user:alice"), "resource id is invalid: \"a:b\" must not contain ':'"},
+		{"space", rel("project:1", "reader", "user:alice smith"), "subject id is invalid: \"alice smith\" does not match pattern"},
+		{"over-length", rel("project:"+strings.Repeat("x", 257), "reader", "user:alice"), "resource id is invalid: length 257 exceeds maximum of 256"},
+		{"max length", rel("project:"+strings.Repeat("x", 256), "reader", "user:alice"), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := meta.IsValidRelation(tt.rel)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("IsValidRelation() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("IsValidRelation() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIDValidationConfigurable(t *testing.T) {
+	meta, err := authz.ParseMetadata([]byte(`
+schema_version: "1.0"
+id_validation:
+  max_length: 4
+  pattern: '^[0-9]+$'
+objects:
+  user:
+    relations: {}
+  doc:
+    relations:
+      owner:
+        subject_types: [user]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := meta.IsValidObjectID("1234"); err != nil {
+		t.Errorf("IsValidObjectID(1234) = %v, want nil", err)
+	}
+	if err := meta.IsValidObjectID("12345"); err == nil {
+		t.Error("IsValidObjectID(12345) = nil, want a length error")
+	}
+	if err := meta.IsValidObjectID("abc"); err == nil {
+		t.Error("IsValidObjectID(abc) = nil, want a pattern error")
+	}
+
+	if _, err := authz.ParseMetadata([]byte(`
+schema_version: "1.0"
+id_validation:
+  pattern: '['
+objects: {}
+`)); err == nil {
+		t.Error("ParseMetadata() accepted an invalid pattern")
+	}
+}
+
+func TestValidationSuggestsCloseNames(t *testing.T) {
+	meta := authz.LoadMetadata()
+	project := obj("project:1")
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"permission case", meta.IsValidPermission(project, "Edit"), `(did you mean "edit"?)`},
+		{"permission typo", meta.IsValidPermission(project, "edt"), `(did you mean "edit"?)`},
+		{"relation case", meta.IsValidRelation(rel("project:1", "Reader", "user:alice")), `(did you mean "reader"?)`},
+		{"relation typo", meta.IsValidRelation(rel("project:1", "owners", "user:alice")), `(did you mean "owner"?)`},
+		{"type typo", meta.IsValidObject(obj("projet:1")), `(did you mean "project"?)`},
+	}
+	for _, tt := range tests {
+		if tt.err == nil || !strings.HasSuffix(tt.err.Error(), tt.want) {
+			t.Errorf("%s: error = %v, want suffix %q", tt.name, tt.err, tt.want)
+		}
+	}
+
+	// Names are case-sensitive, and far names get no suggestion
+	for _, err := range []error{meta.IsValidPermission(project, "modify"), meta.IsValidRelation(rel("project:1", "admin", "user:alice"))} {
+		if err == nil || strings.Contains(err.Error(), "did you mean") {
+			t.Errorf("error = %v, want no suggestion", err)
+		}
+	}
+	if err := meta.IsValidPermission(project, "edit"); err != nil {
+		t.Errorf("IsValidPermission(edit) = %v, want nil", err)
+	}
+}
+
+func TestInvalidRelationErrors(t *testing.T) {
+	meta := authz.LoadMetadata()
+	tests := []struct {
+		name string
+		rel  authz.Relationship
+		want string
+	}{
+		{"unknown relation", rel("project:1", "admin", "user:alice"), `unknown relation "admin" on type "project"`},
+		{"subject type not allowed", rel("group:a", "member", "project:2"),
+			`subject type "project" not allowed for relation "member" on type "group" (allowed: [user, group])`},
+	}
+	for _, tt := range tests {
+		if err := meta.IsValidRelation(tt.rel); err == nil || err.Error() != tt.want {
+			t.Errorf("%s: error = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+// sharedRelationSchema declares a viewer relation on two types, with different subject types.
+const sharedRelationSchema = `
+schema_version: "1.0"
+objects:
+  user:
+    relations: {}
+  team:
+    relations:
+      member:
+        subject_types: [user]
+  folder:
+    relations:
+      viewer:
+        subject_types: [user, team]
+  doc:
+    relations:
+      viewer:
+        subject_types: [user]
+      editor:
+        subject_types: [user]
+`
+
+func TestSharedRelationErrors(t *testing.T) {
+	meta := loadSchema(t, sharedRelationSchema)
+	tests := []struct {
+		name string
+		rel  authz.Relationship
+		want string
+	}{
+		{"relation of other types", rel("team:a", "viewer", "user:alice"),
+			`unknown relation "viewer" on type "team" (declared on: [doc, folder])`},
+		{"relation of another type", rel("folder:a", "editor", "user:alice"),
+			`unknown relation "editor" on type "folder" (declared on: [doc])`},
+		{"subject type of the same relation on another type", rel("doc:1", "viewer", "team:a"),
+			`subject type "team" not allowed for relation "viewer" on type "doc" (allowed: [user])`},
+		{"resource and subject swapped", rel("user:alice", "member", "team:a"),
+			`unknown relation "member" on type "user" (declared on: [team]) (resource and subject swapped? did you mean team:a#member@user:alice?)`},
+		{"subject type declaring the relation", rel("doc:1", "viewer", "folder:a"),
+			`subject type "folder" not allowed for relation "viewer" on type "doc" (allowed: [user])`},
+		{"resource and subject swapped, relation on both", rel("team:a", "viewer", "folder:a"),
+			`unknown relation "viewer" on type "team" (declared on: [doc, folder]) (resource and subject swapped? did you mean folder:a#viewer@team:a?)`},
+	}
+	for _, tt := range tests {
+		if err := meta.IsValidRelation(tt.rel); err == nil || err.Error() != tt.want {
+			t.Errorf("%s: error = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+
+	// Each type accepts its own relation
+	for _, valid := range []authz.Relationship{rel("folder:a", "viewer", "team:a"), rel("doc:1", "viewer", "user:alice")} {
+		if err := meta.IsValidRelation(valid); err != nil {
+			t.Errorf("IsValidRelation(%s) = %v, want nil", valid, err)
+		}
+	}
+}
+
+func TestSelfReferentialRelations(t *testing.T) {
+	meta := loadSchema(t, `
+schema_version: "1.0"
+objects:
+  user:
+    relations: {}
+  folder:
+    relations:
+      parent:
+        subject_types: [folder]
+        reject_self: true
+      related:
+        subject_types: [folder]
+`)
+	if err := meta.IsValidRelation(rel("folder:1", "parent", "folder:1")); err == nil ||
+		err.Error() != "relation is invalid: parent must not relate folder:1 to itself" {
+		t.Errorf("IsValidRelation() of a self-loop = %v, want rejected", err)
+	}
+	for _, r := range []authz.Relationship{
+		rel("folder:1", "parent", "folder:2"),
+		rel("folder:1", "related", "folder:1"), // without reject_self
+	} {
+		if err := meta.IsValidRelation(r); err != nil {
+			t.Errorf("IsValidRelation(%s) = %v, want nil", r, err)
+		}
+	}
+
+	// A project is not its own parent in the default schema
+	if err := authz.LoadMetadata().IsValidRelation(rel("project:1", "parent", "project:1")); err == nil {
+		t.Error("IsValidRelation() of a project as its own parent = nil, want rejected")
+	}
+}
+
+func TestHierarchicalRelationRejectsResourceType(t *testing.T) {
+	_, err := authz.ParseMetadata([]byte(`
+schema_version: "1.0"
+objects:
+  org:
+    relations: {}
+  folder:
+    relations:
+      parent:
+        subject_types: [org, folder]
+        hierarchical: true
+`))
+	if err == nil || !strings.Contains(err.Error(), "a hierarchical relation must not have its resource type as subject type") {
+		t.Errorf("ParseMetadata() = %v, want the folder subject type rejected", err)
+	}
+}
+
+// writeSchemaFiles writes schema files into a new temporary directory, and returns the directory.
+func writeSchemaFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("write %s failed: %v", name, err)
+		}
+	}
+	return dir
+}
+
+func TestLoadMetadataDir(t *testing.T) {
+	dir := writeSchemaFiles(t, map[string]string{
+		"identity.yaml": `
+schema_version: "2.0"
+objects:
+  user:
+    relations: {}
+  group:
+    relations:
+      member:
+        subject_types: [user, group]
+`,
+		"docs.yml": `
+schema_version: "2.0"
+objects:
+  doc:
+    relations:
+      viewer:
+        subject_types: [user, group]
+    permissions:
+      view:
+        any_of: [viewer]
+`,
+		"README.md": "not a schema",
+	})
+	meta, err := authz.LoadMetadataDir(dir)
+	if err != nil {
+		t.Fatalf("LoadMetadataDir() failed: %v", err)
+	}
+	if meta.SchemaVersion != "2.0" || len(meta.Objects) != 3 {
+		t.Errorf("schema version %q with %d object types, want 2.0 with 3", meta.SchemaVersion, len(meta.Objects))
+	}
+	// Relations may refer to types of other files
+	if err := meta.IsValidRelation(rel("doc:1", "viewer", "group:a")); err != nil {
+		t.Errorf("IsValidRelation() = %v, want nil", err)
+	}
+}
+
+func TestLoadMetadataDirConflicts(t *testing.T) {
+	const users = `
+objects:
+  user:
+    relations: {}
+`
+	for _, tt := range []struct {
+		name  string
+		files map[string]string
+		want  string
+	}{
+		{"duplicate type", map[string]string{"a.yaml": users, "b.yaml": users}, `object type "user" is defined in both a.yaml and b.yaml`},
+		{"conflicting version", map[string]string{"a.yaml": `schema_version: "1.0"` + users, "b.yaml": `schema_version: "2.0"`},
+			`schema_version "2.0" in b.yaml conflicts with "1.0" in a.yaml`},
+		{"no schema file", map[string]string{"schema.json": "{}"}, "no schema file"},
+	} {
+		_, err := authz.LoadMetadataDir(writeSchemaFiles(t, tt.files))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
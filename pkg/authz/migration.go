@@ -0,0 +1,124 @@
+package authz
+
+import (
+	"context"
+
+	"github.com/romrossi/authz-rebac/pkg/db"
+)
+
+// migrationPageSize is the number of relationships read, and migrated within one transaction, by MigrateRelationships.
+const migrationPageSize = 1000
+
+// tupleAction is what MigrateRelationships does with a relationship.
+type tupleAction int
+
+const (
+	tupleKeep    tupleAction = iota // valid in the service schema
+	tupleRename                     // its relation is an alias in the service schema
+	tupleDelete                     // its relation or a type was removed from the previous schema
+	tupleUnknown                    // invalid in both schemas
+)
+
+// MigrateRelationships pages through all unexpired relationships by keyset,
+// and migrates the orphans of each page within its own transaction, so that no transaction grows with the graph.
+// Renamed relationships keep their expiry and caveat.
+func (s *serviceImpl) MigrateRelationships(ctx context.Context, previous Metadata, dryRun bool) (TupleMigration, error) {
+	migration := TupleMigration{
+		DryRun:  dryRun,
+		Renamed: map[string]int64{},
+		Deleted: map[string]int64{},
+		Unknown: map[string]int64{},
+	}
+
+	var after *Relationship
+	for {
+		page, err := s.authzRepo.ListAllRelationships(ctx, after, migrationPageSize)
+		if err != nil {
+			return migration, err
+		}
+
+		// Renamed relationships are deleted, then created under their relation
+		var created, deleted []Relationship
+		for _, rel := range page {
+			migration.Scanned++
+			action, key := s.tupleAction(previous, rel)
+			switch action {
+			case tupleRename:
+				renamed := rel
+				renamed.Relation = s.meta.CanonicalRelation(rel.Resource.Type, rel.Relation)
+				migration.Renamed[key+"->"+renamed.Relation]++
+				created = append(created, renamed)
+				deleted = append(deleted, rel)
+			case tupleDelete:
+				migration.Deleted[key]++
+				deleted = append(deleted, rel)
+			case tupleUnknown:
+				migration.Unknown[key]++
+			}
+		}
+
+		if !dryRun && len(deleted) > 0 {
+			err := db.WithTransaction(ctx, func(txCtx context.Context) error {
+				// Locked at once, in order, as the deleted and created relationships differ (see ApplyRelationships)
+				if err := s.authzRepo.LockRelationships(txCtx, append(append([]Relationship{}, deleted...), created...)); err != nil {
+					return err
+				}
+				if _, err := s.delete(txCtx, deleted); err != nil {
+					return err
+				}
+				if len(created) == 0 {
+					return nil
+				}
+				_, err := s.create(txCtx, created, ConflictUpsert)
+				return err
+			})
+			if err != nil {
+				return migration, err
+			}
+		}
+
+		if len(page) < migrationPageSize {
+			return migration, nil
+		}
+		after = &page[len(page)-1]
+	}
+}
+
+// tupleAction classifies a relationship against the service schema and the previous schema,
+// and returns the key it is counted under.
+func (s *serviceImpl) tupleAction(previous Metadata, rel Relationship) (tupleAction, string) {
+	key := rel.Resource.Type + "#" + rel.Relation
+	definedBefore := definesRelation(previous, rel.Resource.Type, rel.Relation)
+
+	if _, ok := s.meta.Objects[rel.Subject.Type]; !ok {
+		if _, ok := previous.Objects[rel.Subject.Type]; ok && definedBefore {
+			return tupleDelete, key + "@" + rel.Subject.Type
+		}
+		return tupleUnknown, key + "@" + rel.Subject.Type
+	}
+
+	objDef := s.meta.Objects[rel.Resource.Type]
+	if _, ok := objDef.Relations[rel.Relation]; ok {
+		return tupleKeep, key
+	}
+	if _, ok := objDef.Aliases[rel.Relation]; ok {
+		return tupleRename, key
+	}
+	if definedBefore {
+		return tupleDelete, key
+	}
+	return tupleUnknown, key
+}
+
+// definesRelation returns true if the schema defines the relation, or an alias of that name, on the object type.
+func definesRelation(meta Metadata, objectType, relation string) bool {
+	objDef, ok := meta.Objects[objectType]
+	if !ok {
+		return false
+	}
+	if _, ok := objDef.Relations[relation]; ok {
+		return true
+	}
+	_, ok = objDef.Aliases[relation]
+	return ok
+}
@@ -1,8 +1,42 @@
 package authz
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrPreconditionFailed is returned when a write precondition is not satisfied.
+	ErrPreconditionFailed = errors.New("precondition failed")
+
+	// ErrStaleRead is returned when a read cannot observe data at least as fresh as requested.
+	ErrStaleRead = errors.New("data is not yet as fresh as requested")
+
+	// ErrIdempotencyKeyReused is returned when an idempotency key already applied a different write request.
+	ErrIdempotencyKeyReused = errors.New("idempotency key already used for another request")
+
+	// ErrQueryTimeout is returned when a database operation exceeds its timeout.
+	ErrQueryTimeout = errors.New("database query timed out")
+
+	// ErrCircuitOpen is returned when database operations are suspended after repeated failures.
+	ErrCircuitOpen = errors.New("database unavailable")
+
+	// ErrRelationshipExists is returned when a write with the ConflictError policy creates an existing relationship.
+	ErrRelationshipExists = errors.New("relationship already exists")
+
+	// ErrCaveatContext is returned when a caveat cannot be evaluated with the context supplied on check.
+	ErrCaveatContext = errors.New("invalid caveat context")
+
+	// ErrInvalidTraversal is returned when a traversal request has no starting object to traverse from.
+	ErrInvalidTraversal = errors.New("invalid traversal request")
+
+	// ErrHistoryUnavailable is returned when a check as of a past time predates the relationship history.
+	ErrHistoryUnavailable = errors.New("relationship history unavailable")
 )
 
 // Object represents a unique resource or subject
@@ -11,33 +45,327 @@ type Object struct {
 	Type string `json:"type"`
 }
 
-// MarshalJSON serializes the object as a compact "type:id" string.
+// JSON forms of objects (see SetObjectFormat).
+const (
+	ObjectFormatCompact  = "compact"  // "type:id"
+	ObjectFormatExpanded = "expanded" // {"type": "type", "id": "id"}
+)
+
+// expandedObjects is true if objects are serialized in the expanded form.
+var expandedObjects atomic.Bool
+
+// SetObjectFormat sets the JSON form objects are serialized in: ObjectFormatCompact (the default), or
+// ObjectFormatExpanded for clients which do not parse "type:id" strings. Both forms are always deserialized.
+func SetObjectFormat(format string) error {
+	switch format {
+	case ObjectFormatCompact, ObjectFormatExpanded:
+		expandedObjects.Store(format == ObjectFormatExpanded)
+		return nil
+	default:
+		return fmt.Errorf("unknown object format %q (expected %s or %s)", format, ObjectFormatCompact, ObjectFormatExpanded)
+	}
+}
+
+// expandedObject is the expanded JSON form of an object.
+type expandedObject struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// MarshalJSON serializes the object as a compact "type:id" string, or in the expanded form (see SetObjectFormat).
 func (o Object) MarshalJSON() ([]byte, error) {
+	if expandedObjects.Load() {
+		return json.Marshal(expandedObject{Type: o.Type, ID: o.ID})
+	}
 	return json.Marshal(o.Type + ":" + o.ID)
 }
 
-// UnmarshalJSON deserializes a "type:id" string into an Object struct.
+// UnmarshalJSON deserializes a "type:id" string (see splitObject), or an expanded object, into an Object struct.
+// Either way, the type and ID are trimmed of surrounding spaces.
 func (o *Object) UnmarshalJSON(data []byte) error {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		var expanded expandedObject
+		if err := json.Unmarshal(trimmed, &expanded); err != nil {
+			return err
+		}
+		object := Object{Type: strings.TrimSpace(expanded.Type), ID: strings.TrimSpace(expanded.ID)}
+		if object.Type == "" {
+			return fmt.Errorf("invalid object format: %s has no type", trimmed)
+		}
+		*o = object
+		return nil
+	}
+
 	var s string
 	if err := json.Unmarshal(data, &s); err != nil {
 		return err
 	}
 
-	parts := strings.SplitN(s, ":", 2)
-	o.Type = parts[0]
-	if len(parts) > 1 {
-		o.ID = parts[1]
+	object, ok := splitObject(s)
+	if !ok {
+		return fmt.Errorf("invalid object format: %q is not \"type:id\"", s)
 	}
+	*o = object
 
 	return nil
 }
 
+// splitObject splits a "type:id" string into an object, and reports whether it has a colon.
+// Whitespace around the type and the ID is trimmed, as clients often paste it along with them.
+// Types and IDs are otherwise case-sensitive: "Doc:1" is not "doc:1" (validation suggests the type of the schema).
+func splitObject(s string) (Object, bool) {
+	objectType, id, ok := strings.Cut(s, ":")
+	return Object{Type: strings.TrimSpace(objectType), ID: strings.TrimSpace(id)}, ok
+}
+
 // Relationship represents a relationship entry,
 // associating a subject with a relation on a resource object.
 type Relationship struct {
-	Resource Object `json:"resource"`
-	Subject  Object `json:"subject"`
-	Relation string `json:"relation"`
+	Resource  Object              `json:"resource"`
+	Subject   Object              `json:"subject"`
+	Relation  string              `json:"relation"`
+	ExpiresAt *time.Time          `json:"expires_at,omitempty"` // nil if the relationship never expires
+	Caveat    *RelationshipCaveat `json:"caveat,omitempty"`     // nil if the relationship is unconditional
+
+	// Hop and Terminal locate the relationship in a path, when path metadata is requested: Hop is its index from
+	// the resource (0 for the first edge), and Terminal is true on the last edge, which reaches the subject.
+	Hop      *int `json:"hop,omitempty"`
+	Terminal bool `json:"terminal,omitempty"`
+}
+
+// RelationshipCaveat makes a relationship conditional: it only applies when the named caveat (see CaveatDefinition)
+// evaluates to true, with the parameters set in Context, or else supplied on check.
+type RelationshipCaveat struct {
+	Name    string                 `json:"name"`
+	Context map[string]interface{} `json:"context,omitempty"`
+}
+
+// String formats the relationship as "resource#relation@subject" (expiry and caveat excluded).
+func (r Relationship) String() string {
+	return r.Resource.Type + ":" + r.Resource.ID + "#" + r.Relation + "@" + r.Subject.Type + ":" + r.Subject.ID
+}
+
+// InvalidRelationshipError is the body of a write rejected by the schema, when schema hints are requested:
+// along with the error, it lists the relations of the resource type and the subject types each allows
+// (none if the resource type itself is unknown).
+type InvalidRelationshipError struct {
+	Error        string              `json:"error"`
+	Relationship Relationship        `json:"relationship"`
+	Relations    map[string][]string `json:"relations,omitempty"`
+}
+
+// RelationshipChange is an entry of the relationship change feed.
+type RelationshipChange struct {
+	ID           int64        `json:"id"`     // cursor, increasing with each change
+	Action       string       `json:"action"` // "create" or "delete"
+	Relationship Relationship `json:"relationship"`
+	ChangedAt    time.Time    `json:"changed_at"`
+}
+
+// AuditEntry records a relationship mutation and the actor who performed it.
+type AuditEntry struct {
+	ID           int64        `json:"id"`
+	Actor        string       `json:"actor"`
+	Action       string       `json:"action"` // "create" or "delete"
+	Relationship Relationship `json:"relationship"`
+	CreatedAt    time.Time    `json:"created_at"`
+}
+
+// AuditFilter selects the audit entries matching all the fields it sets. Entries are listed oldest first,
+// after the entry AfterID (keyset pagination: the ID of the last entry of the previous page), at most Limit of them.
+type AuditFilter struct {
+	Resource Object    // "type" or "type:id" of the resource (empty = any)
+	Subject  Object    // "type" or "type:id" of the subject (empty = any)
+	Actor    string    // empty = any
+	Action   string    // "create", "delete" or empty for any
+	Since    time.Time // entries created at or after (zero = no lower bound)
+	Until    time.Time // entries created before (zero = no upper bound)
+	AfterID  int64
+	Limit    int // 0 = no limit
+}
+
+// WriteRequest groups the relationships to delete and create in a single atomic write.
+type WriteRequest struct {
+	Delete       []Relationship     `json:"delete"`
+	Create       []Relationship     `json:"create"`
+	Precondition *WritePrecondition `json:"precondition,omitempty"`
+
+	// OnConflict tells how creations of existing relationships are handled (ConflictIgnore if empty).
+	OnConflict ConflictPolicy `json:"on_conflict,omitempty"`
+
+	// DryRun validates and simulates the write, then rolls it back (see WriteResult.Summary).
+	DryRun bool `json:"-"`
+
+	// BestEffort writes each relationship in its own transaction, instead of all or nothing:
+	// a relationship failing to be written does not prevent the others (see WriteResult.Items).
+	BestEffort bool `json:"-"`
+}
+
+// UnmarshalJSON deserializes a write request, relationship by relationship,
+// so that errors locate the malformed field (e.g. "create[2].resource: invalid object format ...").
+func (w *WriteRequest) UnmarshalJSON(data []byte) error {
+	var body struct {
+		Delete       []json.RawMessage `json:"delete"`
+		Create       []json.RawMessage `json:"create"`
+		Precondition *struct {
+			MustExist    []json.RawMessage `json:"must_exist"`
+			MustNotExist []json.RawMessage `json:"must_not_exist"`
+		} `json:"precondition"`
+		OnConflict ConflictPolicy `json:"on_conflict"`
+	}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return err
+	}
+	switch body.OnConflict {
+	case "", ConflictUpsert, ConflictIgnore, ConflictError:
+		w.OnConflict = body.OnConflict
+	default:
+		return fmt.Errorf("on_conflict: unknown policy %q (expected %s, %s or %s)", body.OnConflict, ConflictIgnore, ConflictError, ConflictUpsert)
+	}
+
+	var err error
+	if w.Delete, err = unmarshalRelationships("delete", body.Delete); err != nil {
+		return err
+	}
+	if w.Create, err = unmarshalRelationships("create", body.Create); err != nil {
+		return err
+	}
+	if body.Precondition != nil {
+		w.Precondition = &WritePrecondition{}
+		if w.Precondition.MustExist, err = unmarshalRelationships("precondition.must_exist", body.Precondition.MustExist); err != nil {
+			return err
+		}
+		if w.Precondition.MustNotExist, err = unmarshalRelationships("precondition.must_not_exist", body.Precondition.MustNotExist); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unmarshalRelationships deserializes the relationships of a list, field by field,
+// and prefixes errors with the list name, index and field (e.g. "create[2].subject").
+func unmarshalRelationships(list string, raws []json.RawMessage) ([]Relationship, error) {
+	var relationships []Relationship
+	for i, raw := range raws {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &fields); err != nil || fields == nil {
+			return nil, fmt.Errorf("%s[%d]: relationship must be a JSON object", list, i)
+		}
+
+		var rel Relationship
+		for _, field := range []struct {
+			name   string
+			target interface{}
+		}{
+			{"resource", &rel.Resource},
+			{"subject", &rel.Subject},
+			{"relation", &rel.Relation},
+			{"expires_at", &rel.ExpiresAt},
+			{"caveat", &rel.Caveat},
+		} {
+			value, ok := fields[field.name]
+			if !ok {
+				continue
+			}
+			if err := json.Unmarshal(value, field.target); err != nil {
+				return nil, fmt.Errorf("%s[%d].%s: %w", list, i, field.name, err)
+			}
+		}
+		rel.Relation = strings.TrimSpace(rel.Relation)
+		relationships = append(relationships, rel)
+	}
+	return relationships, nil
+}
+
+// ConflictPolicy tells how a write handles the creation of a relationship that already exists (and is not expired).
+type ConflictPolicy string
+
+const (
+	// ConflictUpsert replaces the expiry and caveat of the existing relationship.
+	ConflictUpsert ConflictPolicy = "upsert"
+	// ConflictIgnore leaves the existing relationship unchanged (the default).
+	ConflictIgnore ConflictPolicy = "ignore"
+	// ConflictError fails the whole write with ErrRelationshipExists.
+	ConflictError ConflictPolicy = "error"
+)
+
+// WritePrecondition lists relationships that must (or must not) exist for a write to be applied.
+// Writes of the same relationships are serialized, conditional or not, so that only one of them applies
+// when each creates the relationships the others require not to exist.
+type WritePrecondition struct {
+	MustExist    []Relationship `json:"must_exist"`
+	MustNotExist []Relationship `json:"must_not_exist"`
+}
+
+// WriteResult is the outcome of an applied write request.
+type WriteResult struct {
+	// ConsistencyToken identifies the write transaction. Reads given this token
+	// (at_least_as_fresh) are guaranteed to observe the write.
+	ConsistencyToken string `json:"consistency_token"`
+
+	// Created is the number of relationships inserted, or whose expiry changed.
+	// Deleted is the number of relationships removed.
+	Created int64 `json:"created"`
+	Deleted int64 `json:"deleted"`
+
+	// Replayed is true if the write was already applied under the same idempotency key:
+	// the other fields are then those of the original write.
+	Replayed bool `json:"-"`
+
+	// Summary details the intended changes of a dry run (nil otherwise).
+	Summary *WriteSummary `json:"summary,omitempty"`
+
+	// Items details the outcome of each deletion, then each creation, of a best-effort write (nil otherwise).
+	Items []WriteItemResult `json:"items,omitempty"`
+}
+
+// Outcomes of the relationships of a best-effort write.
+const (
+	WriteSucceeded = "succeeded" // the relationship was deleted, or inserted (or its expiry or caveat changed)
+	WriteSkipped   = "skipped"   // nothing to do: the deleted relationship does not exist, or the created one exists
+	WriteFailed    = "failed"    // the relationship is invalid, or writing it failed
+)
+
+// WriteItemResult is the outcome of writing one relationship of a best-effort write.
+type WriteItemResult struct {
+	Operation    string       `json:"operation"` // "delete" or "create"
+	Relationship Relationship `json:"relationship"`
+	Status       string       `json:"status"`           // WriteSucceeded, WriteSkipped or WriteFailed
+	Reason       string       `json:"reason,omitempty"` // why the relationship was skipped or failed
+}
+
+// WriteSummary details the changes a write request would make.
+type WriteSummary struct {
+	Created       []Relationship `json:"created"`        // creations that would insert a row
+	AlreadyExists []Relationship `json:"already_exists"` // creations skipped as the relationship exists
+	Deleted       []Relationship `json:"deleted"`        // deletions matching an existing row
+	NotFound      []Relationship `json:"not_found"`      // deletions matching no row
+}
+
+// Relationships returns all relationships referenced by the write request, including preconditions.
+func (w WriteRequest) Relationships() []Relationship {
+	rels := make([]Relationship, 0, len(w.Delete)+len(w.Create))
+	rels = append(rels, w.Delete...)
+	rels = append(rels, w.Create...)
+	if w.Precondition != nil {
+		rels = append(rels, w.Precondition.MustExist...)
+		rels = append(rels, w.Precondition.MustNotExist...)
+	}
+	return rels
+}
+
+// IdempotencyKey identifies a write applied at most once: the key given by an actor (keys of different actors
+// never collide), along with the hash of the write request, to tell a replay from another request reusing the key.
+type IdempotencyKey struct {
+	Actor       string
+	Key         string
+	RequestHash string
+}
+
+// IdempotentWrite is a write already applied under an idempotency key.
+type IdempotentWrite struct {
+	RequestHash string // hash of the write request
+	Result      []byte // WriteResult JSON
 }
 
 // TraversalRequest defines parameters for traversing relationship paths in the graph.
@@ -53,6 +381,68 @@ type TraversalRequest struct {
 	// StopOn is the stopping object for the traversal.
 	// May be "type" (stop on all of that type) or "type:id".
 	StopOn Object
+
+	// StopOnAny optionally lists several stopping objects, each like StopOn:
+	// the traversal stops on any of them. If set, StopOn is ignored.
+	StopOnAny []Object
+
+	// AtLeastAsFresh is an optional consistency token (see WriteResult).
+	// If set, the traversal observes all writes up to and including the token's transaction.
+	AtLeastAsFresh string
+
+	// AsOf optionally evaluates the traversal on the relationships valid at this past time, as recorded
+	// by the history of relationship changes, instead of the current ones (zero = now).
+	AsOf time.Time
+
+	// KeepEliminated retains the paths discarded by precedence rules
+	// in the response instead of dropping them.
+	KeepEliminated bool
+
+	// SkipPrecedence returns every discovered path, without applying the deny overrides and precedence rules
+	// of the resource type, which then eliminate nothing (e.g. to debug a model, or to show the whole graph).
+	// Checks ignore it, as permissions and relations are always evaluated on effective paths.
+	SkipPrecedence bool
+
+	// MaxResults caps the number of resource-subject pairs returned (0 = default limit).
+	MaxResults int
+
+	// Relations optionally restricts the traversal to relationships with one of these relations,
+	// pruning the other edges during the traversal (empty = all relations).
+	Relations []string
+
+	// Types optionally restricts the traversal to objects of these types, pruning the edges leading to objects
+	// of other types during the traversal (empty = all types). See Metadata.TraversableTypes.
+	Types []string
+
+	// ExcludeRelations optionally lists relations whose relationships are not followed, as if they did not exist
+	// (e.g. for what-if analysis), along with the relationships written under their aliases.
+	ExcludeRelations []string
+
+	// CaveatContext supplies the caveat parameters not set by caveated relationships (see RelationshipCaveat).
+	// Paths through a relationship whose caveat evaluates to false are dropped before precedence rules apply.
+	CaveatContext map[string]interface{}
+}
+
+// Validate checks that the traversal starts on an object, with both a type and an ID: traversals follow the
+// relationships of a single starting object, and there is no type-wide mode. To reach all objects of a type,
+// start on the other end of the traversal and stop on the type instead (see StopOn).
+// An empty start ID would otherwise match no relationship, and silently traverse nothing.
+func (r TraversalRequest) Validate() error {
+	if r.StartOn.Type == "" {
+		return fmt.Errorf("%w: missing type of the starting object", ErrInvalidTraversal)
+	}
+	if r.StartOn.ID == "" {
+		return fmt.Errorf("%w: missing ID of the starting object of type '%s'", ErrInvalidTraversal, r.StartOn.Type)
+	}
+	return nil
+}
+
+// StopTargets returns the stopping objects of the traversal: StopOnAny, or else StopOn.
+func (r TraversalRequest) StopTargets() []Object {
+	if len(r.StopOnAny) > 0 {
+		return r.StopOnAny
+	}
+	return []Object{r.StopOn}
 }
 
 // TraversalResponseItem contains all discovered paths for a specific resource-subject pair.
@@ -65,6 +455,33 @@ type TraversalResponseItem struct {
 
 	// Subject is the subject object reached at the end of paths.
 	Subject Object `json:"subject"`
+
+	// EliminatedPaths holds the paths discarded by precedence rules (only if KeepEliminated is set).
+	EliminatedPaths []EliminatedPath `json:"eliminated_paths,omitempty"`
+}
+
+// EliminatedPath is a path discarded by precedence rules, along with the rule that discarded it.
+type EliminatedPath struct {
+	Path []Relationship `json:"path"`
+	Rule PrecedenceRule `json:"rule"`
+}
+
+// CheckOptions controls what a permission check returns beyond the allowed/denied decision.
+type CheckOptions struct {
+	// ShowMatchingPaths includes the paths satisfying each permission.
+	ShowMatchingPaths bool
+
+	// MaxPaths caps the matching paths shown per permission (no cap if 0).
+	MaxPaths int
+
+	// Explain attaches the reasoning behind each permission evaluation.
+	Explain bool
+
+	// ShowReason attaches a deny reason code to each denied permission (see DenyReasonNoPath).
+	ShowReason bool
+
+	// Permissions restricts the evaluation to these permissions (all permissions if empty).
+	Permissions []string
 }
 
 // PermissionCheckItem represents the evaluation of permissions for a resource-subject pair.
@@ -74,8 +491,167 @@ type PermissionCheckItem struct {
 	PermissionEvals map[string]PermissionEval `json:"permissions"` // key: permission name
 }
 
+// ResourceAccess lists the permissions granted to a subject on a resource.
+type ResourceAccess struct {
+	Resource    Object   `json:"resource"`
+	Permissions []string `json:"permissions"` // sorted
+}
+
+// PermissionCount is the number of resources (or subjects) on which a permission is granted.
+type PermissionCount struct {
+	Count int `json:"count"`
+}
+
+// RelationshipCount is the number of relationships of a resource.
+type RelationshipCount struct {
+	Count int64 `json:"count"`
+}
+
 // PermissionEval represents the result of evaluating a single permission.
 type PermissionEval struct {
-	Allowed       bool             `json:"allowed"`                  // true if permission is granted
-	MatchingPaths [][]Relationship `json:"matching_paths,omitempty"` // paths satisfying the permission
+	Allowed        bool                   `json:"allowed"`                   // true if permission is granted
+	MatchingPaths  [][]Relationship       `json:"matching_paths,omitempty"`  // paths satisfying the permission
+	PathsTruncated bool                   `json:"paths_truncated,omitempty"` // true if more paths than CheckOptions.MaxPaths satisfy it
+	Reason         string                 `json:"reason,omitempty"`          // why the permission is denied (if requested)
+	Explanation    *PermissionExplanation `json:"explanation,omitempty"`     // reasoning behind the result (explain mode only)
+
+	// MatchingPathsCompact replaces MatchingPaths when the compact path format is requested.
+	MatchingPathsCompact *CompactPaths `json:"matching_paths_compact,omitempty"`
+
+	// Permissions details the evaluation of each permission, when several are checked at once:
+	// Allowed is then true if any of them is allowed.
+	Permissions map[string]PermissionEval `json:"permissions,omitempty"`
+}
+
+// RelationCheck is the result of checking whether a relation connects a resource to a subject.
+type RelationCheck struct {
+	Related       bool             `json:"related"`                  // true if an effective path contains the relation
+	MatchingPaths [][]Relationship `json:"matching_paths,omitempty"` // effective paths containing the relation
+
+	// MatchingPathsCompact replaces MatchingPaths when the compact path format is requested.
+	MatchingPathsCompact *CompactPaths `json:"matching_paths_compact,omitempty"`
+}
+
+// CompactPaths encodes relationship paths without repeating their objects: each object is listed once in Nodes,
+// and each relationship of a path is an edge between the indexes of its resource and subject in Nodes.
+// It is smaller than the paths it encodes, and maps directly to a graph. Relationships decodes it back.
+type CompactPaths struct {
+	Nodes []Object        `json:"nodes"` // objects along the paths, in order of appearance
+	Paths [][]CompactEdge `json:"paths"`
+}
+
+// CompactEdge is a relationship of a CompactPaths path (paths never carry expiries).
+type CompactEdge struct {
+	Resource int                 `json:"resource"` // index of the resource in Nodes
+	Relation string              `json:"relation"`
+	Subject  int                 `json:"subject"` // index of the subject in Nodes
+	Caveat   *RelationshipCaveat `json:"caveat,omitempty"`
+}
+
+// NewCompactPaths encodes paths in the compact format, or returns nil if there are none.
+func NewCompactPaths(paths [][]Relationship) *CompactPaths {
+	if len(paths) == 0 {
+		return nil
+	}
+	compact := &CompactPaths{Paths: make([][]CompactEdge, len(paths))}
+	index := map[Object]int{}
+	node := func(o Object) int {
+		i, ok := index[o]
+		if !ok {
+			i = len(compact.Nodes)
+			index[o] = i
+			compact.Nodes = append(compact.Nodes, o)
+		}
+		return i
+	}
+	for i, path := range paths {
+		compact.Paths[i] = make([]CompactEdge, len(path))
+		for j, rel := range path {
+			compact.Paths[i][j] = CompactEdge{Resource: node(rel.Resource), Relation: rel.Relation, Subject: node(rel.Subject), Caveat: rel.Caveat}
+		}
+	}
+	return compact
+}
+
+// Relationships decodes the compact paths, failing if an edge refers to a node out of range.
+func (c CompactPaths) Relationships() ([][]Relationship, error) {
+	paths := make([][]Relationship, len(c.Paths))
+	for i, edges := range c.Paths {
+		paths[i] = make([]Relationship, len(edges))
+		for j, edge := range edges {
+			if edge.Resource < 0 || edge.Resource >= len(c.Nodes) || edge.Subject < 0 || edge.Subject >= len(c.Nodes) {
+				return nil, fmt.Errorf("paths[%d][%d]: node index out of range (%d nodes)", i, j, len(c.Nodes))
+			}
+			paths[i][j] = Relationship{Resource: c.Nodes[edge.Resource], Relation: edge.Relation, Subject: c.Nodes[edge.Subject], Caveat: edge.Caveat}
+		}
+	}
+	return paths, nil
+}
+
+// Deny reason codes of a PermissionEval.
+const (
+	DenyReasonNoPath             = "no_path"              // no path connects the resource to the subject
+	DenyReasonExcluded           = "excluded"             // a path contains a relation excluded by the permission
+	DenyReasonNoMatchingRelation = "no_matching_relation" // no path grants the permission
+)
+
+// PermissionExplanation details how a permission evaluation was reached.
+type PermissionExplanation struct {
+	SearchedRelations []string         `json:"searched_relations"`           // relations or permissions granting the permission (AnyOf)
+	RequiredRelations []string         `json:"required_relations,omitempty"` // relations or permissions all required by the permission (AllOf)
+	MissingRequired   string           `json:"missing_required,omitempty"`   // first required relation or permission not granted
+	ExpressionHolds   *bool            `json:"expression_holds,omitempty"`   // whether the expression of the permission holds (Expr)
+	ExcludedBy        string           `json:"excluded_by,omitempty"`        // relation that denied the permission (Except)
+	ExcludingPaths    [][]Relationship `json:"excluding_paths,omitempty"`    // paths containing the excluding relation
+	MatchedPaths      [][]Relationship `json:"matched_paths,omitempty"`      // paths containing a searched or required relation
+	EliminatedPaths   []EliminatedPath `json:"eliminated_paths,omitempty"`   // paths discarded by precedence rules
+}
+
+// SubjectsCheckRequest is the body of a check of a permission on a resource for several subjects at once.
+type SubjectsCheckRequest struct {
+	Resource Object   `json:"resource"`
+	Subjects []Object `json:"subjects"`
+}
+
+// SubjectCheck is the evaluation of the permission for one of the subjects of a SubjectsCheckRequest.
+type SubjectCheck struct {
+	Subject Object `json:"subject"`
+	Allowed bool   `json:"allowed"`
+}
+
+// WarmRequest is the body of a request priming the effective paths of resources.
+type WarmRequest struct {
+	Resources []Object `json:"resources"`
+}
+
+// WarmResult reports how many cache entries a WarmRequest primed.
+type WarmResult struct {
+	Warmed       int  `json:"warmed"`        // number of cache entries primed
+	CacheEnabled bool `json:"cache_enabled"` // false if no path cache is enabled: nothing is then warmed
+}
+
+// TupleMigration reports the relationships left orphaned by a schema change, and how they were migrated.
+// Counts are keyed by "resource_type#relation", suffixed with "@subject_type" if the subject type was removed.
+type TupleMigration struct {
+	DryRun  bool             `json:"dry_run"` // true if the orphans were only counted
+	Scanned int64            `json:"scanned"` // number of unexpired relationships read
+	Renamed map[string]int64 `json:"renamed"` // orphans rewritten to the relation their name is now an alias of
+	Deleted map[string]int64 `json:"deleted"` // orphans whose relation, resource type or subject type was removed
+	Unknown map[string]int64 `json:"unknown"` // orphans defined by neither schema, left untouched
+}
+
+// RelationshipDiagnosis reports the relationships the schema rejects (e.g. whose relation is no longer defined
+// on their resource type, or no longer allows their subject type), and whether they were deleted.
+// Counts are keyed by "resource_type#relation@subject_type".
+type RelationshipDiagnosis struct {
+	Fixed   bool                  `json:"fixed"`   // true if the invalid relationships were deleted
+	Scanned int64                 `json:"scanned"` // number of unexpired relationships read
+	Counts  map[string]int64      `json:"counts"`  // number of invalid relationships by key
+	Invalid []InvalidRelationship `json:"invalid"` // invalid relationships, in key order
+}
+
+// InvalidRelationship is a stored relationship the schema rejects, with the reason.
+type InvalidRelationship struct {
+	Relationship Relationship `json:"relationship"`
+	Error        string       `json:"error"`
 }
@@ -0,0 +1,326 @@
+package authz_test
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/romrossi/authz-rebac/pkg/authz"
+)
+
+// pruningSchema has no precedence rules, so that the traversal of a permission may prune the relations it ignores.
+const pruningSchema = `
+schema_version: "1.0"
+objects:
+  user:
+    relations: {}
+  team:
+    relations:
+      member:
+        subject_types: [user]
+  doc:
+    relations:
+      viewer:
+        subject_types: [user, team]
+      editor:
+        subject_types: [user]
+      auditor:
+        subject_types: [user, team]
+    permissions:
+      view:
+        any_of: [viewer, editor]
+      edit:
+        any_of: [editor]
+      audit:
+        any_of: [auditor]
+`
+
+func TestRelevantRelations(t *testing.T) {
+	meta := loadSchema(t, pruningSchema)
+	tests := []struct {
+		permissions []string
+		want        string
+	}{
+		{[]string{"view"}, "editor,member,viewer"},
+		{[]string{"edit"}, "editor"},
+		{[]string{"audit"}, "auditor,member"},
+		{[]string{"view", "audit"}, ""}, // nothing to prune
+		{[]string{"unknown"}, ""},
+		{nil, ""},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(meta.RelevantRelations("doc", tt.permissions...), ","); got != tt.want {
+			t.Errorf("RelevantRelations(doc, %v) = %q, want %q", tt.permissions, got, tt.want)
+		}
+	}
+
+	// Any path may eliminate a deciding one under precedence rules
+	if got := authz.LoadMetadata().RelevantRelations("project", "read"); got != nil {
+		t.Errorf("RelevantRelations(project, read) = %v, want nil with precedence rules", got)
+	}
+}
+
+func TestTraversableTypes(t *testing.T) {
+	meta := authz.LoadMetadata()
+	tests := []struct {
+		targets   []authz.Object
+		forward   bool
+		relations []string
+		want      string
+	}{
+		{[]authz.Object{{Type: "group"}}, true, nil, "group,project"},
+		{[]authz.Object{obj("group:eng")}, false, nil, "group,user"},
+		{[]authz.Object{{Type: "application"}, {Type: "group"}}, false, nil, "application,group,user"},
+		{[]authz.Object{{Type: "user"}}, true, []string{"parent", "administrator"}, "application,project,user"},
+		{[]authz.Object{{Type: "user"}}, true, nil, ""}, // every type leads to users
+		{[]authz.Object{{Type: "team"}}, true, nil, ""},
+		{nil, true, nil, ""},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(meta.TraversableTypes(tt.targets, tt.forward, tt.relations), ","); got != tt.want {
+			t.Errorf("TraversableTypes(%v, %v, %v) = %q, want %q", tt.targets, tt.forward, tt.relations, got, tt.want)
+		}
+	}
+}
+
+// TestPrunedTraversalMatchesUnfiltered checks that evaluating one permission, which prunes the relations it ignores,
+// decides as evaluating all permissions, which follows every relation.
+func TestPrunedTraversalMatchesUnfiltered(t *testing.T) {
+	svc, repo := newService(t, loadSchema(t, pruningSchema))
+	seedRelations(t, repo,
+		rel("doc:1", "viewer", "team:a"),
+		rel("team:a", "member", "user:alice"),
+		rel("doc:1", "auditor", "team:b"),
+		rel("team:b", "member", "user:bob"),
+		rel("team:b", "member", "user:alice"),
+		rel("doc:1", "editor", "user:carol"),
+		rel("doc:2", "auditor", "user:alice"),
+		rel("doc:2", "viewer", "user:dan"),
+	)
+	ctx := context.Background()
+
+	// allowed returns the decisions of a traversal, by resource, subject and permission
+	allowed := func(request authz.TraversalRequest, permissions []string) map[string]bool {
+		items, _, err := svc.CheckPermissions(ctx, request, authz.CheckOptions{Permissions: permissions})
+		if err != nil {
+			t.Fatalf("CheckPermissions() failed: %v", err)
+		}
+		decisions := map[string]bool{}
+		for _, item := range items {
+			for permission, eval := range item.PermissionEvals {
+				if eval.Allowed {
+					decisions[item.Resource.ID+" "+item.Subject.ID+" "+permission] = true
+				}
+			}
+		}
+		return decisions
+	}
+
+	requests := []authz.TraversalRequest{
+		{StartOn: obj("doc:1"), Forward: true, StopOn: authz.Object{Type: "user"}},
+		{StartOn: obj("doc:2"), Forward: true, StopOn: authz.Object{Type: "user"}},
+		{StartOn: obj("user:alice"), StopOn: authz.Object{Type: "doc"}},
+		{StartOn: obj("user:bob"), StopOn: authz.Object{Type: "doc"}},
+	}
+	for _, request := range requests {
+		all := allowed(request, nil)
+		for _, permission := range []string{"view", "edit", "audit"} {
+			pruned := allowed(request, []string{permission})
+			for key := range pruned {
+				if !all[key] {
+					t.Errorf("%s allowed on %v with pruning only", key, request.StartOn)
+				}
+			}
+			for key := range all {
+				if strings.HasSuffix(key, " "+permission) && !pruned[key] {
+					t.Errorf("%s denied on %v with pruning", key, request.StartOn)
+				}
+			}
+		}
+	}
+}
+
+// exprPruningSchema has permissions requiring several entries (all_of), or defined by expressions, including
+// a negation alone, which must not grant the permission to subjects without paths.
+const exprPruningSchema = `
+schema_version: "1.0"
+objects:
+  user:
+    relations: {}
+  team:
+    relations:
+      member:
+        subject_types: [user]
+  doc:
+    relations:
+      viewer:
+        subject_types: [user, team]
+      editor:
+        subject_types: [user]
+      suspended:
+        subject_types: [user]
+      trained:
+        subject_types: [user]
+    permissions:
+      open:
+        expr: {not: suspended}
+      review:
+        expr: {or: [editor, {and: [viewer, {not: suspended}]}]}
+      deploy:
+        all_of: [editor, trained]
+      publish:
+        any_of: [viewer, editor]
+        all_of: [trained]
+        expr: {not: suspended}
+`
+
+// TestIsPermittedMatchesCheckPermissions checks that the boolean check of a single permission decides as evaluating
+// all permissions, with and without precedence rules.
+func TestIsPermittedMatchesCheckPermissions(t *testing.T) {
+	tests := []struct {
+		name      string
+		meta      authz.Metadata
+		seed      []authz.Relationship
+		resources []string
+		subjects  []string
+	}{
+		{
+			name: "without precedence rules",
+			meta: loadSchema(t, pruningSchema),
+			seed: []authz.Relationship{
+				rel("doc:1", "viewer", "team:a"),
+				rel("team:a", "member", "user:alice"),
+				rel("doc:1", "auditor", "team:b"),
+				rel("team:b", "member", "user:bob"),
+				rel("doc:1", "editor", "user:carol"),
+				rel("doc:2", "auditor", "user:alice"),
+			},
+			resources: []string{"doc:1", "doc:2"},
+			subjects:  []string{"user:alice", "user:bob", "user:carol", "user:dan"},
+		},
+		{
+			name: "with precedence rules",
+			meta: authz.LoadMetadata(),
+			seed: []authz.Relationship{
+				rel("project:1", "reader", "group:eng"),
+				rel("group:eng", "member", "user:alice"),
+				rel("project:1", "owner", "user:alice"),
+				rel("project:1", "forbidden", "user:bob"),
+				rel("project:1", "owner", "user:bob"),
+				rel("project:2", "parent", "project:1"),
+				rel("project:2", "contributor", "user:carol"),
+			},
+			resources: []string{"project:1", "project:2"},
+			subjects:  []string{"user:alice", "user:bob", "user:carol", "user:dan"},
+		},
+		{
+			name: "with all_of and expressions",
+			meta: loadSchema(t, exprPruningSchema),
+			seed: []authz.Relationship{
+				rel("doc:1", "viewer", "team:a"),
+				rel("team:a", "member", "user:alice"),
+				rel("doc:1", "suspended", "user:bob"),
+				rel("doc:1", "viewer", "user:bob"),
+				rel("doc:1", "editor", "user:carol"),
+				rel("doc:1", "suspended", "user:carol"),
+				rel("doc:1", "editor", "user:erin"),
+				rel("doc:1", "trained", "user:erin"),
+				rel("doc:1", "trained", "user:carol"),
+				rel("doc:2", "viewer", "user:alice"),
+				rel("doc:2", "trained", "user:alice"),
+			},
+			resources: []string{"doc:1", "doc:2"},
+			subjects:  []string{"user:alice", "user:bob", "user:carol", "user:dan", "user:erin"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, repo := newService(t, tt.meta)
+			seedRelations(t, repo, tt.seed...)
+			ctx := context.Background()
+
+			for _, resource := range tt.resources {
+				for _, subject := range tt.subjects {
+					request := authz.TraversalRequest{StartOn: obj(resource), Forward: true, StopOn: obj(subject)}
+					items, _, err := svc.CheckPermissions(ctx, request, authz.CheckOptions{})
+					if err != nil {
+						t.Fatalf("CheckPermissions() failed: %v", err)
+					}
+					for permission := range tt.meta.Objects[obj(resource).Type].Permissions {
+						want := len(items) > 0 && items[0].PermissionEvals[permission].Allowed
+						got, err := svc.IsPermitted(ctx, request, permission)
+						if err != nil {
+							t.Fatalf("IsPermitted() failed: %v", err)
+						}
+						if got != want {
+							t.Errorf("IsPermitted(%s, %s, %s) = %v, want %v", resource, subject, permission, got, want)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkPrunedTraversal checks a permission on a document with many irrelevant relationships,
+// with (one permission) and without (all permissions) pruning.
+func BenchmarkPrunedTraversal(b *testing.B) {
+	svc, repo := newService(b, loadSchema(b, pruningSchema))
+	relationships := []authz.Relationship{rel("doc:1", "viewer", "user:alice")}
+	for i := 0; i < 100; i++ {
+		team := "team:" + strconv.Itoa(i)
+		relationships = append(relationships, rel("doc:1", "auditor", team))
+		for j := 0; j < 20; j++ {
+			relationships = append(relationships, rel(team, "member", "user:"+strconv.Itoa(i*20+j)))
+		}
+	}
+	seedRelations(b, repo, relationships...)
+	request := authz.TraversalRequest{StartOn: obj("doc:1"), Forward: true, StopOn: authz.Object{Type: "user"}}
+
+	for _, bench := range []struct {
+		name        string
+		permissions []string
+	}{
+		{"pruned", []string{"view"}},
+		{"unfiltered", nil},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, _, err := svc.CheckPermissions(context.Background(), request, authz.CheckOptions{Permissions: bench.permissions}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkIsPermitted checks a permission granted to one of many subjects of a document, as a boolean
+// and as a full evaluation.
+func BenchmarkIsPermitted(b *testing.B) {
+	svc, repo := newService(b, loadSchema(b, pruningSchema))
+	var relationships []authz.Relationship
+	for i := 0; i < 100; i++ {
+		team := "team:" + strconv.Itoa(i)
+		relationships = append(relationships, rel("doc:1", "viewer", team), rel(team, "member", "user:alice"))
+	}
+	seedRelations(b, repo, relationships...)
+	request := authz.TraversalRequest{StartOn: obj("doc:1"), Forward: true, StopOn: obj("user:alice")}
+	ctx := context.Background()
+
+	b.Run("boolean", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if allowed, err := svc.IsPermitted(ctx, request, "view"); err != nil || !allowed {
+				b.Fatal(allowed, err)
+			}
+		}
+	})
+	b.Run("evaluation", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			items, _, err := svc.CheckPermissions(ctx, request, authz.CheckOptions{Permissions: []string{"view"}})
+			if err != nil || !items[0].PermissionEvals["view"].Allowed {
+				b.Fatal(items, err)
+			}
+		}
+	})
+}
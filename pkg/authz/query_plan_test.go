@@ -0,0 +1,81 @@
+package authz_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/romrossi/authz-rebac/pkg/authz"
+	"github.com/romrossi/authz-rebac/pkg/db"
+)
+
+// queryPlan returns the SQLite query plan of a query, one step per line.
+func queryPlan(t *testing.T, query string, values []interface{}) string {
+	t.Helper()
+	rows, err := db.DB.QueryContext(context.Background(), "EXPLAIN QUERY PLAN "+query, values...)
+	if err != nil {
+		t.Fatalf("EXPLAIN QUERY PLAN failed: %v", err)
+	}
+	defer rows.Close()
+
+	var plan []string
+	for rows.Next() {
+		var id, parent, unused int
+		var detail string
+		if err := rows.Scan(&id, &parent, &unused, &detail); err != nil {
+			t.Fatal(err)
+		}
+		plan = append(plan, detail)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return strings.Join(plan, "\n")
+}
+
+// TestTraversalQueryPlanUsesIndexes checks that both the start node and the recursive step of a traversal
+// search the relationship table with the index of their direction, instead of scanning it.
+func TestTraversalQueryPlanUsesIndexes(t *testing.T) {
+	newService(t, authz.LoadMetadata())
+
+	tests := []struct {
+		name    string
+		request authz.TraversalRequest
+		index   string
+	}{
+		{"forward", authz.TraversalRequest{StartOn: obj("project:1"), Forward: true, StopOn: obj("user:alice")}, "idx_relationship_resource"},
+		{"backward", authz.TraversalRequest{StartOn: obj("user:alice"), StopOn: authz.Object{Type: "project"}}, "idx_relationship_subject"},
+	}
+	for _, tt := range tests {
+		query, values := authz.SQLiteListPathsQuery(tt.request)
+		plan := queryPlan(t, query, values)
+		if n := strings.Count(plan, "SEARCH r USING INDEX "+tt.index+" ("); n != 2 {
+			t.Errorf("%s: %d searches using %s, want 2 (start node and recursive step):\n%s", tt.name, n, tt.index, plan)
+		}
+	}
+}
+
+// TestAuditQueryPlanUsesIndexes checks that each filter of the audit log searches it with its index.
+func TestAuditQueryPlanUsesIndexes(t *testing.T) {
+	newService(t, authz.LoadMetadata())
+
+	since := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		filter authz.AuditFilter
+		index  string
+	}{
+		{"resource", authz.AuditFilter{Resource: obj("project:1")}, "idx_audit_log_resource"},
+		{"subject", authz.AuditFilter{Subject: obj("user:alice")}, "idx_audit_log_subject"},
+		{"actor", authz.AuditFilter{Actor: "api-key:admin"}, "idx_audit_log_actor"},
+		{"time range", authz.AuditFilter{Since: since, Until: since.Add(time.Hour)}, "idx_audit_log_created_at"},
+	}
+	for _, tt := range tests {
+		query, values := authz.SQLiteListAuditEntriesQuery(tt.filter)
+		plan := queryPlan(t, query, values)
+		if !strings.Contains(plan, "USING INDEX "+tt.index+" (") {
+			t.Errorf("%s: no search using %s:\n%s", tt.name, tt.index, plan)
+		}
+	}
+}
@@ -2,19 +2,39 @@ package authz
 
 import (
 	"context"
-	"encoding/json"
+	"database/sql"
 	"fmt"
+	"hash/crc32"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/lib/pq"
 	"github.com/romrossi/authz-rebac/pkg/db"
 )
 
 // AuthzRepository defines the interface for authorization-related database operations.
 type AuthzRepository interface {
-	InsertBulk(ctx context.Context, relationship []Relationship) error
-	DeleteBulk(ctx context.Context, relationship []Relationship) error
-	ListRelationships(ctx context.Context, object Object) ([]Relationship, error)
+	InsertBulk(ctx context.Context, relationship []Relationship, policy ConflictPolicy) ([]Relationship, error)
+	DeleteBulk(ctx context.Context, relationship []Relationship) ([]Relationship, error)
+	ListRelationships(ctx context.Context, object Object, subjectTypes []string) ([]Relationship, error)
+	ListSubjects(ctx context.Context, resource Object, relations []string) ([]Object, error)
+	CountRelationships(ctx context.Context, resource Object, relations []string) (int64, error)
+	ObjectExists(ctx context.Context, object Object) (bool, error)
+	FindRelationships(ctx context.Context, relationships []Relationship) ([]Relationship, error)
+	LockRelationships(ctx context.Context, relationships []Relationship) error
+	ListAllRelationships(ctx context.Context, after *Relationship, limit int) ([]Relationship, error)
 	ListPaths(ctx context.Context, request TraversalRequest) ([]TraversalResponseItem, error)
+	ClaimIdempotencyKey(ctx context.Context, key IdempotencyKey, ttl time.Duration) (*IdempotentWrite, error)
+	SaveIdempotentResult(ctx context.Context, key IdempotencyKey, result []byte) error
+	ConsistencyToken(ctx context.Context) (string, error)
+	IsConsistencyTokenVisible(ctx context.Context, token string) (bool, error)
+	ListChanges(ctx context.Context, since int64, limit int) ([]RelationshipChange, error)
+	LatestChangeID(ctx context.Context) (int64, error)
+	HistoryStart(ctx context.Context) (time.Time, error)
+	InsertAuditEntries(ctx context.Context, actor, action string, relationships []Relationship) error
+	ListAuditEntries(ctx context.Context, filter AuditFilter) ([]AuditEntry, error)
+	DeleteExpired(ctx context.Context, before time.Time) (int64, error)
 }
 
 // pgRepository is a PostgreSQL implementation of the authz repository.
@@ -26,66 +46,145 @@ func NewPGRepository() AuthzRepository {
 }
 
 // ListRelationships reads relationships of a resource and recursively its parents in one query.
-func (r *pgRepository) ListRelationships(ctx context.Context, object Object) ([]Relationship, error) {
+// If subjectTypes is not empty, only relationships to subjects of these types are returned.
+func (r *pgRepository) ListRelationships(ctx context.Context, object Object, subjectTypes []string) ([]Relationship, error) {
 	query := `
         WITH RECURSIVE ancestor AS (
-            SELECT resource_type, resource_id, subject_type, subject_id, relation
+            SELECT resource_type, resource_id, subject_type, subject_id, relation, expires_at, caveat_name, caveat_context
             FROM relationship
             WHERE resource_type = $1
 			  AND resource_id = $2
+			  AND (expires_at IS NULL OR expires_at > now())
 
             UNION
 
-            SELECT r.resource_type, r.resource_id, r.subject_type, r.subject_id, r.relation
+            SELECT r.resource_type, r.resource_id, r.subject_type, r.subject_id, r.relation, r.expires_at, r.caveat_name, r.caveat_context
             FROM relationship r
             JOIN ancestor a 
 			  ON r.resource_type = a.subject_type
 			 AND r.resource_id = a.subject_id
             WHERE a.relation = 'parent'
+			  AND (r.expires_at IS NULL OR r.expires_at > now())
         )
-        SELECT resource_type, resource_id, subject_type, subject_id, relation
+        SELECT resource_type, resource_id, subject_type, subject_id, relation, expires_at, caveat_name, caveat_context
 		FROM ancestor
 		WHERE relation != 'parent'
+		  AND (coalesce(cardinality($3::text[]), 0) = 0 OR subject_type = ANY($3))
     `
 
 	// Execute query
-	rows, err := db.GetStatement(ctx).QueryContext(ctx, query, object.Type, object.ID)
+	rows, err := db.GetStatement(ctx).QueryContext(ctx, query, object.Type, object.ID, pq.Array(subjectTypes))
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var rels []Relationship
-	for rows.Next() {
-		var rel Relationship
-		if err := rows.Scan(&rel.Resource.ID, &rel.Resource.Type, &rel.Subject.Type, &rel.Subject.ID, &rel.Relation); err != nil {
-			return nil, err
-		}
-		rels = append(rels, rel)
+	return scanRelationships(rows)
+}
+
+// ListSubjects reads the subjects directly related to a resource by any of the relations (no traversal),
+// ordered by type and ID.
+func (r *pgRepository) ListSubjects(ctx context.Context, resource Object, relations []string) ([]Object, error) {
+	query := `
+        SELECT DISTINCT subject_type, subject_id
+        FROM relationship
+        WHERE resource_type = $1
+          AND resource_id = $2
+          AND relation = ANY($3)
+          AND (expires_at IS NULL OR expires_at > now())
+        ORDER BY subject_type, subject_id
+    `
+	rows, err := db.GetStatement(ctx).QueryContext(ctx, query, resource.Type, resource.ID, pq.Array(relations))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanObjects(rows)
+}
+
+// CountRelationships counts the unexpired relationships of a resource (no traversal),
+// of any of the relations, or of any relation if relations is empty.
+func (r *pgRepository) CountRelationships(ctx context.Context, resource Object, relations []string) (int64, error) {
+	query := `
+        SELECT COUNT(*)
+        FROM relationship
+        WHERE resource_type = $1
+          AND resource_id = $2
+          AND (coalesce(cardinality($3::text[]), 0) = 0 OR relation = ANY($3))
+          AND (expires_at IS NULL OR expires_at > now())
+    `
+	var count int64
+	if err := db.GetStatement(ctx).QueryRowContext(ctx, query, resource.Type, resource.ID, pq.Array(relations)).Scan(&count); err != nil {
+		return 0, fmt.Errorf("count relationships failed: %w", err)
+	}
+	return count, nil
+}
+
+// ObjectExists reports whether the object is the resource or the subject of an unexpired relationship.
+func (r *pgRepository) ObjectExists(ctx context.Context, object Object) (bool, error) {
+	query := `
+        SELECT EXISTS (
+            SELECT 1 FROM relationship
+            WHERE resource_type = $1 AND resource_id = $2
+              AND (expires_at IS NULL OR expires_at > now())
+        ) OR EXISTS (
+            SELECT 1 FROM relationship
+            WHERE subject_type = $1 AND subject_id = $2
+              AND (expires_at IS NULL OR expires_at > now())
+        )
+    `
+	var exists bool
+	if err := db.GetStatement(ctx).QueryRowContext(ctx, query, object.Type, object.ID).Scan(&exists); err != nil {
+		return false, fmt.Errorf("check object existence failed: %w", err)
 	}
-	return rels, rows.Err()
+	return exists, nil
 }
 
-// InsertBulk inserts multiple relationships into the database in one query.
-func (r *pgRepository) InsertBulk(ctx context.Context, relationships []Relationship) error {
+// Postgres accepts at most 65535 bind parameters per statement;
+// bulk operations are split into chunks that stay below that limit.
+const (
+	relationshipColumns = 5
+	maxBulkRows         = 65535 / relationshipColumns
+	insertColumns       = relationshipColumns + 3 // with expires_at, caveat_name and caveat_context
+	maxInsertRows       = 65535 / insertColumns
+)
+
+// InsertBulk inserts multiple relationships into the database, without duplicates (see uniqueRelationships),
+// in as many queries as required by the parameter limit, within a single transaction.
+// Existing relationships are handled according to the conflict policy (ConflictIgnore if empty),
+// and expired ones are always replaced.
+// It returns the relationships inserted, replaced, or (with ConflictUpsert) whose expiry or caveat changed.
+func (r *pgRepository) InsertBulk(ctx context.Context, relationships []Relationship, policy ConflictPolicy) ([]Relationship, error) {
+	return inChunks(ctx, uniqueRelationships(relationships), maxInsertRows, func(ctx context.Context, chunk []Relationship) ([]Relationship, error) {
+		return r.insertChunk(ctx, chunk, policy)
+	})
+}
+
+// insertChunk inserts multiple relationships into the database in one query.
+func (r *pgRepository) insertChunk(ctx context.Context, relationships []Relationship, policy ConflictPolicy) ([]Relationship, error) {
 	if len(relationships) == 0 {
-		return nil // nothing to insert
+		return nil, nil // nothing to insert
 	}
 
 	// Build query dynamically
 	query := `
-        INSERT INTO relationship (resource_id, resource_type, subject_id, subject_type, relation)
+        INSERT INTO relationship (resource_id, resource_type, subject_id, subject_type, relation, expires_at, caveat_name, caveat_context)
         VALUES 
     `
 
-	// placeholders for each row: ($1, $2, $3, $4, $5), ($6, $7, $8, $9, $10), ...
-	values := make([]interface{}, 0, len(relationships)*5)
+	// placeholders for each row: ($1, $2, $3, $4, $5, $6, $7, $8), ($9, ...), ...
 	placeholders := make([]string, 0, len(relationships))
+	values := make([]interface{}, 0, len(relationships)*insertColumns)
 
 	for i, rel := range relationships {
-		n := i*5 + 1
+		caveatName, caveatContext, err := caveatValues(rel)
+		if err != nil {
+			return nil, err
+		}
+		n := i*insertColumns + 1
 		placeholders = append(placeholders,
-			fmt.Sprintf("($%d, $%d, $%d, $%d, $%d)", n, n+1, n+2, n+3, n+4),
+			fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d::timestamptz, $%d, $%d::jsonb)", n, n+1, n+2, n+3, n+4, n+5, n+6, n+7),
 		)
 		values = append(values,
 			rel.Resource.ID,
@@ -93,23 +192,75 @@ func (r *pgRepository) InsertBulk(ctx context.Context, relationships []Relations
 			rel.Subject.ID,
 			rel.Subject.Type,
 			rel.Relation,
+			rel.ExpiresAt,
+			caveatName,
+			caveatContext,
 		)
 	}
 
+	// Creating an existing relationship replaces its expiry and caveat with ConflictUpsert,
+	// and otherwise only if it expired. The conflicting row is locked, so that concurrent writes do not interleave.
 	query += strings.Join(placeholders, ",")
-	query += " ON CONFLICT DO NOTHING"
+	query += `
+        ON CONFLICT (resource_id, resource_type, subject_id, subject_type, relation)
+        DO UPDATE SET expires_at = EXCLUDED.expires_at, caveat_name = EXCLUDED.caveat_name, caveat_context = EXCLUDED.caveat_context
+    `
+	if policy == ConflictUpsert {
+		query += `
+        WHERE (relationship.expires_at, relationship.caveat_name, relationship.caveat_context)
+              IS DISTINCT FROM (EXCLUDED.expires_at, EXCLUDED.caveat_name, EXCLUDED.caveat_context)
+    `
+	} else {
+		query += `
+        WHERE relationship.expires_at <= now()
+    `
+	}
 
-	_, err := db.GetStatement(ctx).ExecContext(ctx, query, values...)
+	// Only the rows inserted or updated are returned: with ConflictError, the others conflicted with an existing one
+	query += `
+        RETURNING resource_type, resource_id, subject_type, subject_id, relation, expires_at, caveat_name, caveat_context
+    `
+	rows, err := db.GetStatement(ctx).QueryContext(ctx, query, values...)
+	if err != nil {
+		return nil, fmt.Errorf("bulk insert relationships failed: %w", err)
+	}
+	defer rows.Close()
+	inserted, err := scanRelationships(rows)
 	if err != nil {
-		return fmt.Errorf("bulk insert relationships failed: %w", err)
+		return nil, fmt.Errorf("bulk insert relationships failed: %w", err)
+	}
+	if policy == ConflictError {
+		return inserted, conflictError(relationships, inserted)
+	}
+	return inserted, nil
+}
+
+// conflictError returns ErrRelationshipExists for the first of the relationships that is not among
+// the inserted ones, as it conflicted with an existing relationship (see ConflictError), or nil if there is none.
+func conflictError(relationships, inserted []Relationship) error {
+	written := make(map[string]bool, len(inserted))
+	for _, rel := range inserted {
+		written[rel.String()] = true
+	}
+	for _, rel := range relationships {
+		if !written[rel.String()] {
+			return fmt.Errorf("%w: %s", ErrRelationshipExists, rel)
+		}
 	}
 	return nil
 }
 
-// DeleteBulk removes multiple relationships from the database in one query.
-func (r *pgRepository) DeleteBulk(ctx context.Context, relationships []Relationship) error {
+// DeleteBulk removes multiple relationships from the database,
+// in as many queries as required by the parameter limit, within a single transaction.
+// It returns the relationships deleted.
+func (r *pgRepository) DeleteBulk(ctx context.Context, relationships []Relationship) ([]Relationship, error) {
+	return inChunks(ctx, relationships, maxBulkRows, r.deleteChunk)
+}
+
+// deleteChunk removes multiple relationships from the database in one query.
+func (r *pgRepository) deleteChunk(ctx context.Context, relationships []Relationship) ([]Relationship, error) {
 	if len(relationships) == 0 {
-		return nil // nothing to delete
+		return nil, nil // nothing to delete
 	}
 
 	query := `
@@ -117,13 +268,99 @@ func (r *pgRepository) DeleteBulk(ctx context.Context, relationships []Relations
         WHERE (resource_id, resource_type, subject_id, subject_type, relation) IN (
     `
 
+	placeholders, values := relationshipValues(relationships, pgBindVar)
+
+	query += strings.Join(placeholders, ",") + ")"
+	query += " RETURNING resource_type, resource_id, subject_type, subject_id, relation, expires_at, caveat_name, caveat_context"
+
+	rows, err := db.GetStatement(ctx).QueryContext(ctx, query, values...)
+	if err != nil {
+		return nil, fmt.Errorf("bulk delete relationships failed: %w", err)
+	}
+	defer rows.Close()
+	deleted, err := scanRelationships(rows)
+	if err != nil {
+		return nil, fmt.Errorf("bulk delete relationships failed: %w", err)
+	}
+	return deleted, nil
+}
+
+// FindRelationships returns which of the given relationships exist (and are not expired) in the database.
+// Found rows are locked (FOR SHARE) until the end of the current transaction.
+func (r *pgRepository) FindRelationships(ctx context.Context, relationships []Relationship) ([]Relationship, error) {
+	var found []Relationship
+	for _, chunk := range chunkRelationships(relationships, maxBulkRows) {
+		query := `
+            SELECT resource_type, resource_id, subject_type, subject_id, relation, expires_at, caveat_name, caveat_context
+            FROM relationship
+            WHERE (resource_id, resource_type, subject_id, subject_type, relation) IN (
+        `
+
+		placeholders, values := relationshipValues(chunk, pgBindVar)
+
+		query += strings.Join(placeholders, ",") + ")"
+		query += " AND (expires_at IS NULL OR expires_at > now()) FOR SHARE"
+
+		rows, err := db.GetStatement(ctx).QueryContext(ctx, query, values...)
+		if err != nil {
+			return nil, fmt.Errorf("find relationships failed: %w", err)
+		}
+		rels, err := scanRelationships(rows)
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, rels...)
+	}
+	return found, nil
+}
+
+// relationshipLockSlots is the number of rows of the relationship_lock table.
+// Bounding it bounds the locks a bulk write holds, whatever its number of relationships.
+const relationshipLockSlots = 4096
+
+// lockSlots returns the relationship_lock rows the relationship keys hash to, in order; colliding keys share a row.
+func lockSlots(relationships []Relationship) []int64 {
+	seen := make(map[int64]bool, len(relationships))
+	slots := make([]int64, 0, len(relationships))
+	for _, rel := range relationships {
+		slot := int64(crc32.ChecksumIEEE([]byte(rel.String())) % relationshipLockSlots)
+		if !seen[slot] {
+			seen[slot] = true
+			slots = append(slots, slot)
+		}
+	}
+	sort.Slice(slots, func(i, j int) bool { return slots[i] < slots[j] })
+	return slots
+}
+
+// LockRelationships locks the relationship_lock rows the relationship keys hash to, so that the writes
+// of the same relationships (see WritePrecondition) are serialized until the transaction of ctx ends.
+// The upsert locks each row exclusively, whether it exists or not, in order so that concurrent writes do not deadlock.
+func (r *pgRepository) LockRelationships(ctx context.Context, relationships []Relationship) error {
+	if len(relationships) == 0 {
+		return nil
+	}
+	_, err := db.GetStatement(ctx).ExecContext(ctx, `
+        INSERT INTO relationship_lock (slot) SELECT unnest($1::int[])
+        ON CONFLICT (slot) DO UPDATE SET slot = EXCLUDED.slot
+    `, pq.Array(lockSlots(relationships)))
+	if err != nil {
+		return fmt.Errorf("lock relationships failed: %w", err)
+	}
+	return nil
+}
+
+// relationshipValues builds one placeholder tuple per relationship (key columns only),
+// along with the flattened values bound to them. bindVar formats the n-th bind variable (from 1).
+func relationshipValues(relationships []Relationship, bindVar func(n int) string) ([]string, []interface{}) {
 	placeholders := make([]string, 0, len(relationships))
-	values := make([]interface{}, 0, len(relationships)*5)
+	values := make([]interface{}, 0, len(relationships)*relationshipColumns)
 
 	for i, rel := range relationships {
-		n := i*5 + 1
+		n := i*relationshipColumns + 1
 		placeholders = append(placeholders,
-			fmt.Sprintf("($%d, $%d, $%d, $%d, $%d)", n, n+1, n+2, n+3, n+4),
+			fmt.Sprintf("(%s, %s, %s, %s, %s)", bindVar(n), bindVar(n+1), bindVar(n+2), bindVar(n+3), bindVar(n+4)),
 		)
 		values = append(values,
 			rel.Resource.ID,
@@ -133,21 +370,168 @@ func (r *pgRepository) DeleteBulk(ctx context.Context, relationships []Relations
 			rel.Relation,
 		)
 	}
+	return placeholders, values
+}
 
-	query += strings.Join(placeholders, ",") + ")"
+// uniqueRelationships returns relationships without duplicates: of the relationships sharing a key,
+// the last one wins (its expiry is the one written), as if they were inserted one after the other.
+// Postgres rejects an upsert affecting the same row twice.
+func uniqueRelationships(relationships []Relationship) []Relationship {
+	type key struct {
+		resource, subject Object
+		relation          string
+	}
+	last := make(map[key]int, len(relationships))
+	for i, rel := range relationships {
+		last[key{rel.Resource, rel.Subject, rel.Relation}] = i
+	}
+	unique := make([]Relationship, 0, len(last))
+	for i, rel := range relationships {
+		if last[key{rel.Resource, rel.Subject, rel.Relation}] == i {
+			unique = append(unique, rel)
+		}
+	}
+	return unique
+}
+
+// sortRelationships returns a copy of relationships sorted in unique key order (the order of the
+// key columns: resource_id, resource_type, subject_id, subject_type, relation). Concurrent batches
+// writing overlapping rows then lock them in the same order, instead of deadlocking.
+func sortRelationships(relationships []Relationship) []Relationship {
+	sorted := append([]Relationship(nil), relationships...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		for _, c := range [...][2]string{
+			{a.Resource.ID, b.Resource.ID},
+			{a.Resource.Type, b.Resource.Type},
+			{a.Subject.ID, b.Subject.ID},
+			{a.Subject.Type, b.Subject.Type},
+			{a.Relation, b.Relation},
+		} {
+			if c[0] != c[1] {
+				return c[0] < c[1]
+			}
+		}
+		return false
+	})
+	return sorted
+}
+
+// pgBindVar formats a Postgres bind variable: $1, $2, ...
+func pgBindVar(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+// qmarkBindVar formats a MySQL or SQLite bind variable, which is always "?".
+func qmarkBindVar(int) string {
+	return "?"
+}
+
+// stopOnCondition builds the condition matching the end of traversal paths (r.next_type, r.next_id)
+// with any of the stopping objects, whose bind variables are numbered from first. It returns the condition and its values.
+func stopOnCondition(targets []Object, bindVar func(n int) string, first int) (string, []interface{}) {
+	conditions := make([]string, 0, len(targets))
+	values := make([]interface{}, 0, 2*len(targets))
+	n := first
+	for _, target := range targets {
+		if target.ID == "" {
+			conditions = append(conditions, fmt.Sprintf("r.next_type = %s", bindVar(n)))
+			values = append(values, target.Type)
+			n++
+			continue
+		}
+		conditions = append(conditions, fmt.Sprintf("(r.next_type = %s AND r.next_id = %s)", bindVar(n), bindVar(n+1)))
+		values = append(values, target.Type, target.ID)
+		n += 2
+	}
+	return "(" + strings.Join(conditions, " OR ") + ")", values
+}
+
+// chunkRelationships splits relationships into consecutive chunks of at most size elements.
+func chunkRelationships(relationships []Relationship, size int) [][]Relationship {
+	var chunks [][]Relationship
+	for len(relationships) > size {
+		chunks = append(chunks, relationships[:size])
+		relationships = relationships[size:]
+	}
+	if len(relationships) > 0 {
+		chunks = append(chunks, relationships)
+	}
+	return chunks
+}
 
-	_, err := db.GetStatement(ctx).ExecContext(ctx, query, values...)
+// inChunks applies write to consecutive chunks of at most size relationships, and returns all the relationships
+// it affected. All chunks are written within a single transaction (the current one, if any),
+// so that a batch split across several statements is applied entirely or not at all.
+// Relationships are written in unique key order, whatever the order given (see sortRelationships).
+func inChunks(ctx context.Context, relationships []Relationship, size int,
+	write func(ctx context.Context, chunk []Relationship) ([]Relationship, error)) ([]Relationship, error) {
+	var affected []Relationship
+	err := db.WithTransaction(ctx, func(txCtx context.Context) error {
+		for _, chunk := range chunkRelationships(sortRelationships(relationships), size) {
+			rels, err := write(txCtx, chunk)
+			if err != nil {
+				return err
+			}
+			affected = append(affected, rels...)
+		}
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("bulk delete relationships failed: %w", err)
+		return nil, err
 	}
-	return nil
+	return affected, nil
+}
+
+// ListAllRelationships reads at most limit unexpired relationships, in unique key order,
+// starting after the given relationship (or from the first one if after is nil).
+func (r *pgRepository) ListAllRelationships(ctx context.Context, after *Relationship, limit int) ([]Relationship, error) {
+	query := `
+        SELECT resource_type, resource_id, subject_type, subject_id, relation, expires_at, caveat_name, caveat_context
+        FROM relationship
+        WHERE (expires_at IS NULL OR expires_at > now())
+    `
+	var values []interface{}
+	if after != nil {
+		query += ` AND (resource_id, resource_type, subject_id, subject_type, relation) > ($1, $2, $3, $4, $5)`
+		values = append(values, after.Resource.ID, after.Resource.Type, after.Subject.ID, after.Subject.Type, after.Relation)
+	}
+	values = append(values, limit)
+	query += fmt.Sprintf(` ORDER BY resource_id, resource_type, subject_id, subject_type, relation LIMIT $%d`, len(values))
+
+	rows, err := db.GetStatement(ctx).QueryContext(ctx, query, values...)
+	if err != nil {
+		return nil, fmt.Errorf("list all relationships failed: %w", err)
+	}
+	defer rows.Close()
+
+	return scanRelationships(rows)
 }
 
+// pgHistoryCTE defines the relationships valid at the time bound to $%[1]d (see TraversalRequest.AsOf):
+// the ones whose latest change until then is a creation, not expired at that time. Their expiry is read
+// as NULL, so that the traversal does not check it against the current time.
+const pgHistoryCTE = `
+		history AS (
+			SELECT resource_type, resource_id, subject_type, subject_id, relation,
+				NULL::timestamptz AS expires_at, caveat_name, caveat_context
+			FROM (
+				SELECT c.*, ROW_NUMBER() OVER (
+					PARTITION BY resource_type, resource_id, subject_type, subject_id, relation ORDER BY id DESC
+				) AS rn
+				FROM relationship_change c
+				WHERE c.changed_at <= $%[1]d
+			) latest
+			WHERE latest.rn = 1 AND latest.action = 'create'
+			  AND (latest.expires_at IS NULL OR latest.expires_at > $%[1]d)
+		),`
+
 // ListPaths performs a recursive traversal and returns relationship paths.
+// Each recursion step relies on the (type, id) indexes of the relationship table (see the db migrations).
 func (r *pgRepository) ListPaths(ctx context.Context, tRequest TraversalRequest) ([]TraversalResponseItem, error) {
 	// SQL request template
 	const sqlTemplate = `
-		WITH RECURSIVE rel_tree AS (
+		WITH RECURSIVE %[4]s rel_tree AS (
 			-- Start node
 			SELECT
 				r.%[1]s_type AS start_type,
@@ -158,11 +542,16 @@ func (r *pgRepository) ListPaths(ctx context.Context, tRequest TraversalRequest)
 					json_build_object(
 						'resource', r.resource_type || ':' || r.resource_id,
 						'subject',  r.subject_type || ':' || r.subject_id,
-						'relation', r.relation
+						'relation', r.relation,
+						'caveat',   CASE WHEN r.caveat_name IS NULL THEN NULL ELSE json_build_object('name', r.caveat_name, 'context', r.caveat_context) END
 					)
 				)::jsonb AS path
-			FROM relationship r
+			FROM %[5]s r
 			WHERE r.%[1]s_type = $1 AND r.%[1]s_id = $2
+			  AND (r.expires_at IS NULL OR r.expires_at > now())
+			  AND (coalesce(cardinality($3::text[]), 0) = 0 OR r.relation = ANY($3))
+			  AND (coalesce(cardinality($4::text[]), 0) = 0 OR r.%[2]s_type = ANY($4))
+			  AND (coalesce(cardinality($5::text[]), 0) = 0 OR NOT r.relation = ANY($5))
 
 			UNION ALL
 
@@ -175,12 +564,19 @@ func (r *pgRepository) ListPaths(ctx context.Context, tRequest TraversalRequest)
 				t.path || json_build_object(
 					'resource', r.resource_type || ':' || r.resource_id,
 					'subject',  r.subject_type || ':' || r.subject_id,
-					'relation', r.relation
+					'relation', r.relation,
+					'caveat',   CASE WHEN r.caveat_name IS NULL THEN NULL ELSE json_build_object('name', r.caveat_name, 'context', r.caveat_context) END
 				)::jsonb
-			FROM relationship r
+			FROM %[5]s r
 			JOIN rel_tree t
 			  ON r.%[1]s_id = t.next_id
 			 AND r.%[1]s_type = t.next_type
+			WHERE (r.expires_at IS NULL OR r.expires_at > now())
+			  AND (coalesce(cardinality($3::text[]), 0) = 0 OR r.relation = ANY($3))
+			  AND (coalesce(cardinality($4::text[]), 0) = 0 OR r.%[2]s_type = ANY($4))
+			  AND (coalesce(cardinality($5::text[]), 0) = 0 OR NOT r.relation = ANY($5))
+			  -- A self-referential relationship is only followed from the start node: again, it would loop endlessly
+			  AND NOT (r.resource_type = r.subject_type AND r.resource_id = r.subject_id)
 		)
 		SELECT
 			start_type,
@@ -189,59 +585,247 @@ func (r *pgRepository) ListPaths(ctx context.Context, tRequest TraversalRequest)
 			next_id,
 			json_agg(path)
 		FROM rel_tree r
-		WHERE r.next_type = $3
-		  AND ($4 = '' OR r.next_id = $4)
+		WHERE %[3]s
 		GROUP BY start_type, start_id, next_type, next_id
+		ORDER BY start_type, start_id, next_type, next_id -- the same results are kept when truncated
 	`
 
-	// Direction-dependent placeholders
+	// Direction-dependent placeholders, stopping condition, and relationships traversed: current or historical
+	stopCondition, stopValues := stopOnCondition(tRequest.StopTargets(), pgBindVar, 6)
+	source, history := "relationship", ""
+	if !tRequest.AsOf.IsZero() {
+		source, history = "history", fmt.Sprintf(pgHistoryCTE, 6+len(stopValues))
+	}
 	var query string
 	if tRequest.Forward {
-		query = fmt.Sprintf(sqlTemplate, "resource", "subject")
+		query = fmt.Sprintf(sqlTemplate, "resource", "subject", stopCondition, history, source)
 	} else {
-		query = fmt.Sprintf(sqlTemplate, "subject", "resource")
+		query = fmt.Sprintf(sqlTemplate, "subject", "resource", stopCondition, history, source)
+	}
+	if tRequest.MaxResults > 0 {
+		query += fmt.Sprintf(" LIMIT %d", tRequest.MaxResults)
 	}
 
 	// Execute query
-	rows, err := db.GetStatement(ctx).QueryContext(
-		ctx, query,
-		tRequest.StartOn.Type, tRequest.StartOn.ID,
-		tRequest.StopOn.Type, tRequest.StopOn.ID,
-	)
+	values := append([]interface{}{tRequest.StartOn.Type, tRequest.StartOn.ID, pq.Array(tRequest.Relations), pq.Array(tRequest.Types), pq.Array(tRequest.ExcludeRelations)}, stopValues...)
+	if !tRequest.AsOf.IsZero() {
+		values = append(values, tRequest.AsOf)
+	}
+	rows, err := db.GetStatement(ctx).QueryContext(ctx, query, values...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	// Build response
-	var response []TraversalResponseItem
-	for rows.Next() {
-		var start, stop Object
-		var rawPaths []byte
-		if err := rows.Scan(&start.Type, &start.ID, &stop.Type, &stop.ID, &rawPaths); err != nil {
-			return nil, fmt.Errorf("scan traversal row failed: %w", err)
-		}
+	return scanTraversalItems(rows, tRequest.Forward)
+}
 
-		var paths [][]Relationship
-		if err := json.Unmarshal(rawPaths, &paths); err != nil {
-			return nil, err
+// ClaimIdempotencyKey records an idempotency key, and returns nil if it was claimed,
+// or else the write already applied under it less than ttl ago. Expired keys are claimed again.
+// When called within a transaction, concurrent claims of the same key wait for it to complete.
+func (r *pgRepository) ClaimIdempotencyKey(ctx context.Context, key IdempotencyKey, ttl time.Duration) (*IdempotentWrite, error) {
+	// Forget the key if it expired
+	_, err := db.GetStatement(ctx).ExecContext(ctx, `
+        DELETE FROM idempotency_key
+        WHERE actor = $1 AND key = $2
+          AND created_at < now() - make_interval(secs => $3)
+    `, key.Actor, key.Key, ttl.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("expire idempotency key failed: %w", err)
+	}
+
+	// Claim the key
+	res, err := db.GetStatement(ctx).ExecContext(ctx, `
+        INSERT INTO idempotency_key (actor, key, request_hash)
+        VALUES ($1, $2, $3)
+        ON CONFLICT DO NOTHING
+    `, key.Actor, key.Key, key.RequestHash)
+	if err != nil {
+		return nil, fmt.Errorf("claim idempotency key failed: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("claim idempotency key failed: %w", err)
+	}
+	if n == 1 {
+		return nil, nil
+	}
+
+	// Read the write applied under the key
+	var applied IdempotentWrite
+	var result sql.NullString
+	err = db.GetStatement(ctx).QueryRowContext(ctx, `
+        SELECT request_hash, result FROM idempotency_key WHERE actor = $1 AND key = $2
+    `, key.Actor, key.Key).Scan(&applied.RequestHash, &result)
+	if err != nil {
+		return nil, fmt.Errorf("read idempotency key failed: %w", err)
+	}
+	applied.Result = []byte(result.String)
+	return &applied, nil
+}
+
+// SaveIdempotentResult records the result of the write applied under a claimed idempotency key.
+func (r *pgRepository) SaveIdempotentResult(ctx context.Context, key IdempotencyKey, result []byte) error {
+	_, err := db.GetStatement(ctx).ExecContext(ctx, `
+        UPDATE idempotency_key SET result = $3 WHERE actor = $1 AND key = $2
+    `, key.Actor, key.Key, string(result))
+	if err != nil {
+		return fmt.Errorf("save idempotent result failed: %w", err)
+	}
+	return nil
+}
+
+// ConsistencyToken returns the ID of the current transaction, used as a consistency token.
+// Must be called within a transaction.
+func (r *pgRepository) ConsistencyToken(ctx context.Context) (string, error) {
+	var token string
+	if err := db.GetStatement(ctx).QueryRowContext(ctx, `SELECT pg_current_xact_id()::text`).Scan(&token); err != nil {
+		return "", fmt.Errorf("get consistency token failed: %w", err)
+	}
+	return token, nil
+}
+
+// IsConsistencyTokenVisible reports whether the transaction identified by the token
+// is committed and visible to the current snapshot.
+func (r *pgRepository) IsConsistencyTokenVisible(ctx context.Context, token string) (bool, error) {
+	var visible bool
+	query := `
+        SELECT pg_visible_in_snapshot($1::xid8, pg_current_snapshot())
+           AND pg_xact_status($1::xid8) = 'committed'
+    `
+	if err := db.GetStatement(ctx).QueryRowContext(ctx, query, token).Scan(&visible); err != nil {
+		return false, fmt.Errorf("check consistency token failed: %w", err)
+	}
+	return visible, nil
+}
+
+// ListChanges reads at most limit relationship changes with an ID greater than since, in ID order.
+func (r *pgRepository) ListChanges(ctx context.Context, since int64, limit int) ([]RelationshipChange, error) {
+	query := `
+        SELECT id, action, resource_type, resource_id, subject_type, subject_id, relation, changed_at
+        FROM relationship_change
+        WHERE id > $1
+        ORDER BY id
+        LIMIT $2
+    `
+
+	rows, err := db.GetStatement(ctx).QueryContext(ctx, query, since, limit)
+	if err != nil {
+		return nil, fmt.Errorf("list changes failed: %w", err)
+	}
+	defer rows.Close()
+
+	return scanChanges(rows)
+}
+
+// LatestChangeID returns the ID of the latest relationship change, or 0 if there is none.
+func (r *pgRepository) LatestChangeID(ctx context.Context) (int64, error) {
+	var id int64
+	if err := db.GetStatement(ctx).QueryRowContext(ctx, `SELECT COALESCE(MAX(id), 0) FROM relationship_change`).Scan(&id); err != nil {
+		return 0, fmt.Errorf("get latest change failed: %w", err)
+	}
+	return id, nil
+}
+
+// HistoryStart returns the time the relationship history starts (see TraversalRequest.AsOf).
+func (r *pgRepository) HistoryStart(ctx context.Context) (time.Time, error) {
+	var start time.Time
+	if err := db.GetStatement(ctx).QueryRowContext(ctx, `SELECT started_at FROM relationship_history ORDER BY started_at LIMIT 1`).Scan(&start); err != nil {
+		return time.Time{}, fmt.Errorf("get history start failed: %w", err)
+	}
+	return start, nil
+}
+
+// InsertAuditEntries records one audit entry per relationship, in as many queries as required by the parameter limit.
+func (r *pgRepository) InsertAuditEntries(ctx context.Context, actor, action string, relationships []Relationship) error {
+	// actor and action are bound once, as the first two parameters of each query
+	for _, chunk := range chunkRelationships(relationships, maxBulkRows-1) {
+		placeholders, values := relationshipValues(chunk, pgBindVar)
+		for i := range placeholders {
+			n := i*relationshipColumns + 3
+			placeholders[i] = fmt.Sprintf("($1, $2, $%d, $%d, $%d, $%d, $%d)", n, n+1, n+2, n+3, n+4)
 		}
 
-		var resource, subject Object
-		if tRequest.Forward {
-			resource = start
-			subject = stop
-		} else {
-			resource = stop
-			subject = start
+		query := `
+            INSERT INTO audit_log (actor, action, resource_id, resource_type, subject_id, subject_type, relation)
+            VALUES 
+        ` + strings.Join(placeholders, ",")
+
+		_, err := db.GetStatement(ctx).ExecContext(ctx, query, append([]interface{}{actor, action}, values...)...)
+		if err != nil {
+			return fmt.Errorf("insert audit entries failed: %w", err)
 		}
+	}
+	return nil
+}
+
+// ListAuditEntries reads the audit entries selected by the filter, oldest first.
+func (r *pgRepository) ListAuditEntries(ctx context.Context, filter AuditFilter) ([]AuditEntry, error) {
+	conditions, values := auditConditions(filter, pgBindVar, func(t time.Time) interface{} { return t })
+	query := `
+        SELECT id, actor, action, resource_type, resource_id, subject_type, subject_id, relation, created_at
+        FROM audit_log
+        WHERE ` + conditions + `
+        ORDER BY id
+    `
+	if filter.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", filter.Limit)
+	}
 
-		response = append(response, TraversalResponseItem{
-			Resource: resource,
-			Subject:  subject,
-			Paths:    paths,
-		})
+	rows, err := db.GetStatement(ctx).QueryContext(ctx, query, values...)
+	if err != nil {
+		return nil, fmt.Errorf("list audit entries failed: %w", err)
 	}
+	defer rows.Close()
+
+	return scanAuditEntries(rows)
+}
 
-	return response, rows.Err()
+// auditConditions returns the WHERE conditions selecting the audit entries of a filter, with their values,
+// each condition using an index: idx_audit_log_resource, idx_audit_log_subject, idx_audit_log_actor,
+// idx_audit_log_created_at, or the primary key. Times are bound as encoded by timeValue.
+func auditConditions(filter AuditFilter, bindVar func(n int) string, timeValue func(time.Time) interface{}) (string, []interface{}) {
+	conditions := []string{"id > " + bindVar(1)}
+	values := []interface{}{filter.AfterID}
+	add := func(column string, value interface{}) {
+		values = append(values, value)
+		conditions = append(conditions, column+bindVar(len(values)))
+	}
+	if filter.Resource.Type != "" {
+		add("resource_type = ", filter.Resource.Type)
+	}
+	if filter.Resource.ID != "" {
+		add("resource_id = ", filter.Resource.ID)
+	}
+	if filter.Subject.Type != "" {
+		add("subject_type = ", filter.Subject.Type)
+	}
+	if filter.Subject.ID != "" {
+		add("subject_id = ", filter.Subject.ID)
+	}
+	if filter.Actor != "" {
+		add("actor = ", filter.Actor)
+	}
+	if filter.Action != "" {
+		add("action = ", filter.Action)
+	}
+	if !filter.Since.IsZero() {
+		add("created_at >= ", timeValue(filter.Since))
+	}
+	if !filter.Until.IsZero() {
+		add("created_at < ", timeValue(filter.Until))
+	}
+	return strings.Join(conditions, " AND "), values
+}
+
+// DeleteExpired removes relationships which expired before the given time, and returns how many were removed.
+func (r *pgRepository) DeleteExpired(ctx context.Context, before time.Time) (int64, error) {
+	res, err := db.GetStatement(ctx).ExecContext(ctx, `
+        DELETE FROM relationship
+        WHERE expires_at < $1
+    `, before)
+	if err != nil {
+		return 0, fmt.Errorf("delete expired relationships failed: %w", err)
+	}
+	return res.RowsAffected()
 }
@@ -0,0 +1,244 @@
+package authz
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Circuit breaker states.
+const (
+	breakerClosed   = iota // operations run, consecutive failures are counted
+	breakerOpen            // operations fail fast until the cooldown elapses
+	breakerHalfOpen        // one probe operation runs, deciding whether to close or reopen
+)
+
+// breakerRepository decorates an AuthzRepository with a circuit breaker: after threshold consecutive failures,
+// operations fail fast with ErrCircuitOpen for the cooldown, instead of piling up on an unavailable database.
+// Then a single probe operation is let through: its success closes the circuit, its failure reopens it.
+type breakerRepository struct {
+	repo      AuthzRepository
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    int
+	failures int
+	openedAt time.Time
+}
+
+// NewBreakerRepository wraps repo with a circuit breaker opening after threshold consecutive failed operations,
+// for the given cooldown.
+func NewBreakerRepository(repo AuthzRepository, threshold int, cooldown time.Duration) AuthzRepository {
+	return &breakerRepository{repo: repo, threshold: threshold, cooldown: cooldown}
+}
+
+// guard runs op if the circuit allows it, and records its outcome.
+func (r *breakerRepository) guard(ctx context.Context, name string, op func(ctx context.Context) error) error {
+	if err := r.allow(); err != nil {
+		return fmt.Errorf("%w: %s not attempted: %v", ErrCircuitOpen, name, err)
+	}
+	err := op(ctx)
+	r.record(name, isDatabaseFailure(ctx, err))
+	return err
+}
+
+// allow reports an error if an operation may not run now, and moves an open circuit to half-open
+// once its cooldown has elapsed.
+func (r *breakerRepository) allow() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch r.state {
+	case breakerOpen:
+		if wait := r.cooldown - time.Since(r.openedAt); wait > 0 {
+			return fmt.Errorf("retry in %v", wait.Round(time.Millisecond))
+		}
+		r.state = breakerHalfOpen
+		return nil
+	case breakerHalfOpen:
+		return errors.New("probing the database")
+	}
+	return nil
+}
+
+// record updates the circuit with the outcome of an operation.
+func (r *breakerRepository) record(name string, failed bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !failed {
+		if r.state == breakerHalfOpen {
+			log.Printf("[INFO] breakerRepository: %s succeeded, circuit closed", name)
+		}
+		r.state, r.failures = breakerClosed, 0
+		return
+	}
+	r.failures++
+	if r.state == breakerHalfOpen || r.failures >= r.threshold {
+		if r.state != breakerOpen {
+			log.Printf("[WARN] breakerRepository: %s failed (%d consecutive failures), circuit open for %v", name, r.failures, r.cooldown)
+		}
+		r.state, r.openedAt = breakerOpen, time.Now()
+	}
+}
+
+// isDatabaseFailure reports whether err tells of an unhealthy database: operations cancelled by their caller,
+// and operations the backend does not support, do not count.
+func isDatabaseFailure(ctx context.Context, err error) bool {
+	return err != nil && ctx.Err() == nil && !errors.Is(err, errors.ErrUnsupported)
+}
+func (r *breakerRepository) InsertBulk(ctx context.Context, relationships []Relationship, policy ConflictPolicy) (rels []Relationship, err error) {
+	err = r.guard(ctx, "InsertBulk", func(ctx context.Context) error {
+		rels, err = r.repo.InsertBulk(ctx, relationships, policy)
+		return err
+	})
+	return rels, err
+}
+
+func (r *breakerRepository) DeleteBulk(ctx context.Context, relationships []Relationship) (rels []Relationship, err error) {
+	err = r.guard(ctx, "DeleteBulk", func(ctx context.Context) error {
+		rels, err = r.repo.DeleteBulk(ctx, relationships)
+		return err
+	})
+	return rels, err
+}
+
+func (r *breakerRepository) ListRelationships(ctx context.Context, object Object, subjectTypes []string) (rels []Relationship, err error) {
+	err = r.guard(ctx, "ListRelationships", func(ctx context.Context) error {
+		rels, err = r.repo.ListRelationships(ctx, object, subjectTypes)
+		return err
+	})
+	return rels, err
+}
+
+func (r *breakerRepository) CountRelationships(ctx context.Context, resource Object, relations []string) (n int64, err error) {
+	err = r.guard(ctx, "CountRelationships", func(ctx context.Context) error {
+		n, err = r.repo.CountRelationships(ctx, resource, relations)
+		return err
+	})
+	return n, err
+}
+
+func (r *breakerRepository) ObjectExists(ctx context.Context, object Object) (exists bool, err error) {
+	err = r.guard(ctx, "ObjectExists", func(ctx context.Context) error {
+		exists, err = r.repo.ObjectExists(ctx, object)
+		return err
+	})
+	return exists, err
+}
+
+func (r *breakerRepository) ListSubjects(ctx context.Context, resource Object, relations []string) (subjects []Object, err error) {
+	err = r.guard(ctx, "ListSubjects", func(ctx context.Context) error {
+		subjects, err = r.repo.ListSubjects(ctx, resource, relations)
+		return err
+	})
+	return subjects, err
+}
+
+func (r *breakerRepository) FindRelationships(ctx context.Context, relationships []Relationship) (rels []Relationship, err error) {
+	err = r.guard(ctx, "FindRelationships", func(ctx context.Context) error {
+		rels, err = r.repo.FindRelationships(ctx, relationships)
+		return err
+	})
+	return rels, err
+}
+
+func (r *breakerRepository) LockRelationships(ctx context.Context, relationships []Relationship) error {
+	return r.guard(ctx, "LockRelationships", func(ctx context.Context) error {
+		return r.repo.LockRelationships(ctx, relationships)
+	})
+}
+
+func (r *breakerRepository) ListAllRelationships(ctx context.Context, after *Relationship, limit int) (rels []Relationship, err error) {
+	err = r.guard(ctx, "ListAllRelationships", func(ctx context.Context) error {
+		rels, err = r.repo.ListAllRelationships(ctx, after, limit)
+		return err
+	})
+	return rels, err
+}
+
+func (r *breakerRepository) ListPaths(ctx context.Context, request TraversalRequest) (items []TraversalResponseItem, err error) {
+	err = r.guard(ctx, "ListPaths", func(ctx context.Context) error {
+		items, err = r.repo.ListPaths(ctx, request)
+		return err
+	})
+	return items, err
+}
+
+func (r *breakerRepository) ClaimIdempotencyKey(ctx context.Context, key IdempotencyKey, ttl time.Duration) (applied *IdempotentWrite, err error) {
+	err = r.guard(ctx, "ClaimIdempotencyKey", func(ctx context.Context) error {
+		applied, err = r.repo.ClaimIdempotencyKey(ctx, key, ttl)
+		return err
+	})
+	return applied, err
+}
+
+func (r *breakerRepository) SaveIdempotentResult(ctx context.Context, key IdempotencyKey, result []byte) error {
+	return r.guard(ctx, "SaveIdempotentResult", func(ctx context.Context) error {
+		return r.repo.SaveIdempotentResult(ctx, key, result)
+	})
+}
+
+func (r *breakerRepository) ConsistencyToken(ctx context.Context) (token string, err error) {
+	err = r.guard(ctx, "ConsistencyToken", func(ctx context.Context) error {
+		token, err = r.repo.ConsistencyToken(ctx)
+		return err
+	})
+	return token, err
+}
+
+func (r *breakerRepository) IsConsistencyTokenVisible(ctx context.Context, token string) (visible bool, err error) {
+	err = r.guard(ctx, "IsConsistencyTokenVisible", func(ctx context.Context) error {
+		visible, err = r.repo.IsConsistencyTokenVisible(ctx, token)
+		return err
+	})
+	return visible, err
+}
+
+func (r *breakerRepository) ListChanges(ctx context.Context, since int64, limit int) (changes []RelationshipChange, err error) {
+	err = r.guard(ctx, "ListChanges", func(ctx context.Context) error {
+		changes, err = r.repo.ListChanges(ctx, since, limit)
+		return err
+	})
+	return changes, err
+}
+
+func (r *breakerRepository) LatestChangeID(ctx context.Context) (id int64, err error) {
+	err = r.guard(ctx, "LatestChangeID", func(ctx context.Context) error {
+		id, err = r.repo.LatestChangeID(ctx)
+		return err
+	})
+	return id, err
+}
+
+func (r *breakerRepository) HistoryStart(ctx context.Context) (start time.Time, err error) {
+	err = r.guard(ctx, "HistoryStart", func(ctx context.Context) error {
+		start, err = r.repo.HistoryStart(ctx)
+		return err
+	})
+	return start, err
+}
+
+func (r *breakerRepository) InsertAuditEntries(ctx context.Context, actor, action string, relationships []Relationship) error {
+	return r.guard(ctx, "InsertAuditEntries", func(ctx context.Context) error {
+		return r.repo.InsertAuditEntries(ctx, actor, action, relationships)
+	})
+}
+
+func (r *breakerRepository) ListAuditEntries(ctx context.Context, filter AuditFilter) (entries []AuditEntry, err error) {
+	err = r.guard(ctx, "ListAuditEntries", func(ctx context.Context) error {
+		entries, err = r.repo.ListAuditEntries(ctx, filter)
+		return err
+	})
+	return entries, err
+}
+
+func (r *breakerRepository) DeleteExpired(ctx context.Context, before time.Time) (n int64, err error) {
+	err = r.guard(ctx, "DeleteExpired", func(ctx context.Context) error {
+		n, err = r.repo.DeleteExpired(ctx, before)
+		return err
+	})
+	return n, err
+}
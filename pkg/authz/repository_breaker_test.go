@@ -0,0 +1,101 @@
+package authz_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/romrossi/authz-rebac/pkg/authz"
+)
+
+// flakyRepository fails its traversals while down is set, like an unreachable database, and counts them.
+type flakyRepository struct {
+	authz.AuthzRepository
+	down  *atomic.Bool
+	calls *atomic.Int32
+}
+
+func (r flakyRepository) ListPaths(ctx context.Context, request authz.TraversalRequest) ([]authz.TraversalResponseItem, error) {
+	r.calls.Add(1)
+	if r.down.Load() {
+		return nil, errors.New("connection refused")
+	}
+	return r.AuthzRepository.ListPaths(ctx, request)
+}
+
+func TestBreakerRepository(t *testing.T) {
+	_, repo := newService(t, authz.LoadMetadata())
+	flaky := flakyRepository{repo, new(atomic.Bool), new(atomic.Int32)}
+	breakerRepo := authz.NewBreakerRepository(flaky, 3, 50*time.Millisecond)
+	request := authz.TraversalRequest{StartOn: obj("project:1"), Forward: true, StopOn: obj("user:alice")}
+	ctx := context.Background()
+
+	// Failures below the threshold reach the database
+	flaky.down.Store(true)
+	for i := 0; i < 3; i++ {
+		if _, err := breakerRepo.ListPaths(ctx, request); err == nil || errors.Is(err, authz.ErrCircuitOpen) {
+			t.Fatalf("ListPaths() #%d error = %v, want the database error", i+1, err)
+		}
+	}
+
+	// Then the circuit is open: operations fail fast, without reaching the database
+	if _, err := breakerRepo.ListPaths(ctx, request); !errors.Is(err, authz.ErrCircuitOpen) {
+		t.Errorf("ListPaths() error = %v, want ErrCircuitOpen", err)
+	}
+	if _, err := breakerRepo.InsertBulk(ctx, []authz.Relationship{rel("project:1", "reader", "user:alice")}, authz.ConflictIgnore); !errors.Is(err, authz.ErrCircuitOpen) {
+		t.Errorf("InsertBulk() error = %v, want ErrCircuitOpen", err)
+	}
+	if calls := flaky.calls.Load(); calls != 3 {
+		t.Errorf("database reached %d times, want 3", calls)
+	}
+
+	// After the cooldown, a failed probe reopens the circuit at once
+	time.Sleep(60 * time.Millisecond)
+	if _, err := breakerRepo.ListPaths(ctx, request); err == nil || errors.Is(err, authz.ErrCircuitOpen) {
+		t.Errorf("probe error = %v, want the database error", err)
+	}
+	if _, err := breakerRepo.ListPaths(ctx, request); !errors.Is(err, authz.ErrCircuitOpen) {
+		t.Errorf("ListPaths() after a failed probe error = %v, want ErrCircuitOpen", err)
+	}
+
+	// A successful probe closes it
+	flaky.down.Store(false)
+	time.Sleep(60 * time.Millisecond)
+	for i := 0; i < 5; i++ {
+		if _, err := breakerRepo.ListPaths(ctx, request); err != nil {
+			t.Errorf("ListPaths() #%d after recovery error = %v, want nil", i+1, err)
+		}
+	}
+
+	// Operations cancelled by their caller are not failures
+	flaky.down.Store(true)
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	for i := 0; i < 5; i++ {
+		breakerRepo.ListPaths(cancelled, request)
+	}
+	flaky.down.Store(false)
+	if _, err := breakerRepo.ListPaths(ctx, request); err != nil {
+		t.Errorf("ListPaths() after cancelled operations error = %v, want nil", err)
+	}
+}
+
+func TestCircuitOpenStatus(t *testing.T) {
+	meta := authz.LoadMetadata()
+	_, repo := newService(t, meta)
+	flaky := flakyRepository{repo, new(atomic.Bool), new(atomic.Int32)}
+	flaky.down.Store(true)
+	svc := authz.NewService(authz.NewBreakerRepository(flaky, 1, time.Minute), meta)
+	h := routes(authz.NewAuthzHandler(svc, meta, 0))
+
+	url := v1Prefix + "/permissions/read?resource=project:1&subject=user:alice"
+	if rec := serve(h, "GET", url, ""); rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d (body: %s), want 500 for the database error", rec.Code, rec.Body)
+	}
+	if rec := serve(h, "GET", url, ""); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d (body: %s), want 503 once the circuit is open", rec.Code, rec.Body)
+	}
+}
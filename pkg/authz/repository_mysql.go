@@ -0,0 +1,686 @@
+package authz
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/romrossi/authz-rebac/pkg/db"
+)
+
+// errConsistencyTokensUnsupported returns the error of the consistency token operations of a backend without them.
+func errConsistencyTokensUnsupported(backend string) error {
+	return fmt.Errorf("consistency tokens are not supported by the %s backend: %w", backend, errors.ErrUnsupported)
+}
+
+// mysqlRepository is a MySQL 8 implementation of the authz repository.
+// Its queries mirror those of pgRepository, in the MySQL dialect.
+type mysqlRepository struct{}
+
+// NewMySQLRepository creates a new mysqlRepository instance.
+func NewMySQLRepository() AuthzRepository {
+	return &mysqlRepository{}
+}
+
+// ListRelationships reads relationships of a resource and recursively its parents in one query.
+// If subjectTypes is not empty, only relationships to subjects of these types are returned.
+func (r *mysqlRepository) ListRelationships(ctx context.Context, object Object, subjectTypes []string) ([]Relationship, error) {
+	query := `
+        WITH RECURSIVE ancestor AS (
+            SELECT resource_type, resource_id, subject_type, subject_id, relation, expires_at, caveat_name, caveat_context
+            FROM relationship
+            WHERE resource_type = ?
+              AND resource_id = ?
+              AND (expires_at IS NULL OR expires_at > NOW())
+
+            UNION
+
+            SELECT r.resource_type, r.resource_id, r.subject_type, r.subject_id, r.relation, r.expires_at, r.caveat_name, r.caveat_context
+            FROM relationship r
+            JOIN ancestor a
+              ON r.resource_type = a.subject_type
+             AND r.resource_id = a.subject_id
+            WHERE a.relation = 'parent'
+              AND (r.expires_at IS NULL OR r.expires_at > NOW())
+        )
+        SELECT resource_type, resource_id, subject_type, subject_id, relation, expires_at, caveat_name, caveat_context
+        FROM ancestor
+        WHERE relation != 'parent'
+    `
+	values := []interface{}{object.Type, object.ID}
+
+	// Bind subject types one by one (no array type in MySQL)
+	if len(subjectTypes) > 0 {
+		query += " AND subject_type IN (?" + strings.Repeat(", ?", len(subjectTypes)-1) + ")"
+		for _, t := range subjectTypes {
+			values = append(values, t)
+		}
+	}
+
+	rows, err := db.GetStatement(ctx).QueryContext(ctx, query, values...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanRelationships(rows)
+}
+
+// ListSubjects reads the subjects directly related to a resource by any of the relations (no traversal),
+// ordered by type and ID.
+func (r *mysqlRepository) ListSubjects(ctx context.Context, resource Object, relations []string) ([]Object, error) {
+	if len(relations) == 0 {
+		return nil, nil
+	}
+	// Bind relations one by one (no array type in MySQL)
+	query := `
+        SELECT DISTINCT subject_type, subject_id
+        FROM relationship
+        WHERE resource_type = ?
+          AND resource_id = ?
+          AND relation IN (?` + strings.Repeat(", ?", len(relations)-1) + `)
+          AND (expires_at IS NULL OR expires_at > NOW())
+        ORDER BY subject_type, subject_id
+    `
+	values := []interface{}{resource.Type, resource.ID}
+	for _, relation := range relations {
+		values = append(values, relation)
+	}
+
+	rows, err := db.GetStatement(ctx).QueryContext(ctx, query, values...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanObjects(rows)
+}
+
+// CountRelationships counts the unexpired relationships of a resource (no traversal),
+// of any of the relations, or of any relation if relations is empty.
+func (r *mysqlRepository) CountRelationships(ctx context.Context, resource Object, relations []string) (int64, error) {
+	query := `
+        SELECT COUNT(*)
+        FROM relationship
+        WHERE resource_type = ?
+          AND resource_id = ?
+          AND (expires_at IS NULL OR expires_at > NOW())
+    `
+	values := []interface{}{resource.Type, resource.ID}
+	if len(relations) > 0 {
+		query += ` AND relation IN (?` + strings.Repeat(", ?", len(relations)-1) + `)`
+		for _, relation := range relations {
+			values = append(values, relation)
+		}
+	}
+
+	var count int64
+	if err := db.GetStatement(ctx).QueryRowContext(ctx, query, values...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("count relationships failed: %w", err)
+	}
+	return count, nil
+}
+
+// ObjectExists reports whether the object is the resource or the subject of an unexpired relationship.
+func (r *mysqlRepository) ObjectExists(ctx context.Context, object Object) (bool, error) {
+	query := `
+        SELECT EXISTS (
+            SELECT 1 FROM relationship
+            WHERE resource_type = ? AND resource_id = ?
+              AND (expires_at IS NULL OR expires_at > NOW())
+        ) OR EXISTS (
+            SELECT 1 FROM relationship
+            WHERE subject_type = ? AND subject_id = ?
+              AND (expires_at IS NULL OR expires_at > NOW())
+        )
+    `
+	var exists bool
+	if err := db.GetStatement(ctx).QueryRowContext(ctx, query, object.Type, object.ID, object.Type, object.ID).Scan(&exists); err != nil {
+		return false, fmt.Errorf("check object existence failed: %w", err)
+	}
+	return exists, nil
+}
+
+// InsertBulk inserts multiple relationships into the database, without duplicates (see uniqueRelationships),
+// in as many queries as required by the parameter limit, within a single transaction.
+// Existing relationships are handled according to the conflict policy (ConflictIgnore if empty),
+// and expired ones are always replaced.
+// It returns the relationships inserted, replaced, or (with ConflictUpsert) whose expiry or caveat changed.
+func (r *mysqlRepository) InsertBulk(ctx context.Context, relationships []Relationship, policy ConflictPolicy) ([]Relationship, error) {
+	return inChunks(ctx, uniqueRelationships(relationships), maxInsertRows, func(ctx context.Context, chunk []Relationship) ([]Relationship, error) {
+		return r.insertChunk(ctx, chunk, policy)
+	})
+}
+
+// mysqlDuplicateEntry is the number of the MySQL error of a unique key violation.
+const mysqlDuplicateEntry = 1062
+
+// insertChunk inserts multiple relationships into the database.
+// MySQL has no RETURNING clause: the existing rows left unchanged are read first, locked until the end
+// of the transaction, and all the other relationships are the ones written.
+func (r *mysqlRepository) insertChunk(ctx context.Context, chunk []Relationship, policy ConflictPolicy) ([]Relationship, error) {
+	placeholders := make([]string, 0, len(chunk))
+	values := make([]interface{}, 0, len(chunk)*insertColumns)
+	for _, rel := range chunk {
+		caveatName, caveatContext, err := caveatValues(rel)
+		if err != nil {
+			return nil, err
+		}
+		placeholders = append(placeholders, "(?, ?, ?, ?, ?, ?, ?, CAST(? AS JSON))")
+		values = append(values,
+			rel.Resource.ID,
+			rel.Resource.Type,
+			rel.Subject.ID,
+			rel.Subject.Type,
+			rel.Relation,
+			rel.ExpiresAt,
+			caveatName,
+			caveatContext,
+		)
+	}
+
+	if policy == ConflictError {
+		return r.insertNewChunk(ctx, chunk, placeholders, values)
+	}
+
+	// Creating an existing relationship replaces its expiry and caveat with ConflictUpsert,
+	// and otherwise only if it expired
+	kept, replaced := `
+              WHERE r.expires_at IS NULL OR r.expires_at > NOW(6)
+        `, `
+              WHERE r.expires_at <= NOW(6)
+        `
+	if policy == ConflictUpsert {
+		kept, replaced = `
+              WHERE r.expires_at <=> new.expires_at
+                AND r.caveat_name <=> new.caveat_name
+                AND r.caveat_context <=> new.caveat_context
+        `, `
+              WHERE NOT (r.expires_at <=> new.expires_at)
+                 OR NOT (r.caveat_name <=> new.caveat_name)
+                 OR NOT (r.caveat_context <=> new.caveat_context)
+        `
+	}
+	join := `
+            JOIN (VALUES ` + strings.Join(rowPlaceholders(placeholders), ",") + `)
+              AS new (resource_id, resource_type, subject_id, subject_type, relation, expires_at, caveat_name, caveat_context)
+              ON r.resource_id = new.resource_id
+             AND r.resource_type = new.resource_type
+             AND r.subject_id = new.subject_id
+             AND r.subject_type = new.subject_type
+             AND r.relation = new.relation
+        `
+	keptQuery := `
+            SELECT r.resource_type, r.resource_id, r.subject_type, r.subject_id, r.relation, r.expires_at, r.caveat_name, r.caveat_context
+            FROM relationship r
+        ` + join + kept + " FOR UPDATE"
+	rows, err := db.GetStatement(ctx).QueryContext(ctx, keptQuery, values...)
+	if err != nil {
+		return nil, fmt.Errorf("bulk insert relationships failed: %w", err)
+	}
+	unchanged, err := scanRelationships(rows)
+	rows.Close()
+	if err != nil {
+		return nil, fmt.Errorf("bulk insert relationships failed: %w", err)
+	}
+
+	updateQuery := `
+            UPDATE relationship r
+        ` + join + `
+            SET r.expires_at = new.expires_at, r.caveat_name = new.caveat_name, r.caveat_context = new.caveat_context
+        ` + replaced
+	if _, err := db.GetStatement(ctx).ExecContext(ctx, updateQuery, values...); err != nil {
+		return nil, fmt.Errorf("bulk insert relationships failed: %w", err)
+	}
+
+	// The remaining existing relationships are left unchanged (the update of a column to itself affects no row)
+	insertQuery := `
+            INSERT INTO relationship (resource_id, resource_type, subject_id, subject_type, relation, expires_at, caveat_name, caveat_context)
+            VALUES ` + strings.Join(placeholders, ",") + ` AS new
+            ON DUPLICATE KEY UPDATE relation = relation
+        `
+	if _, err := db.GetStatement(ctx).ExecContext(ctx, insertQuery, values...); err != nil {
+		return nil, fmt.Errorf("bulk insert relationships failed: %w", err)
+	}
+	return withoutRelationships(chunk, unchanged), nil
+}
+
+// withoutRelationships returns the relationships which are not among the excluded ones.
+func withoutRelationships(relationships, excluded []Relationship) []Relationship {
+	skipped := make(map[string]bool, len(excluded))
+	for _, rel := range excluded {
+		skipped[rel.String()] = true
+	}
+	var rels []Relationship
+	for _, rel := range relationships {
+		if !skipped[rel.String()] {
+			rels = append(rels, rel)
+		}
+	}
+	return rels
+}
+
+// insertNewChunk inserts relationships that must not exist (see ConflictError): expired ones are deleted first,
+// then a plain insert fails with ErrRelationshipExists on the unique key of any other, even if inserted concurrently.
+func (r *mysqlRepository) insertNewChunk(ctx context.Context, chunk []Relationship, placeholders []string, values []interface{}) ([]Relationship, error) {
+	keyPlaceholders, keyValues := relationshipValues(chunk, qmarkBindVar)
+	deleteQuery := `
+            DELETE FROM relationship
+            WHERE (resource_id, resource_type, subject_id, subject_type, relation) IN (
+        ` + strings.Join(keyPlaceholders, ",") + `)
+              AND expires_at <= NOW(6)
+        `
+	if _, err := db.GetStatement(ctx).ExecContext(ctx, deleteQuery, keyValues...); err != nil {
+		return nil, fmt.Errorf("bulk insert relationships failed: %w", err)
+	}
+
+	insertQuery := `
+            INSERT INTO relationship (resource_id, resource_type, subject_id, subject_type, relation, expires_at, caveat_name, caveat_context)
+            VALUES ` + strings.Join(placeholders, ",")
+	_, err := db.GetStatement(ctx).ExecContext(ctx, insertQuery, values...)
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlDuplicateEntry {
+		// Only the statement is rolled back: the existing relationships can still be read to name one
+		existing, findErr := r.FindRelationships(ctx, chunk)
+		if findErr != nil || len(existing) == 0 {
+			return nil, fmt.Errorf("%w: %v", ErrRelationshipExists, err)
+		}
+		return nil, fmt.Errorf("%w: %s", ErrRelationshipExists, existing[0])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("bulk insert relationships failed: %w", err)
+	}
+	return chunk, nil
+}
+
+// rowPlaceholders prefixes each row placeholder with ROW, as required by MySQL table value constructors.
+func rowPlaceholders(placeholders []string) []string {
+	rows := make([]string, len(placeholders))
+	for i, p := range placeholders {
+		rows[i] = "ROW" + p
+	}
+	return rows
+}
+
+// DeleteBulk removes multiple relationships from the database,
+// in as many queries as required by the parameter limit, within a single transaction.
+// It returns the relationships deleted.
+func (r *mysqlRepository) DeleteBulk(ctx context.Context, relationships []Relationship) ([]Relationship, error) {
+	return inChunks(ctx, relationships, maxBulkRows, r.deleteChunk)
+}
+
+// deleteChunk removes multiple relationships from the database.
+// MySQL has no RETURNING clause: the rows deleted are read first, locked until the end of the transaction.
+func (r *mysqlRepository) deleteChunk(ctx context.Context, chunk []Relationship) ([]Relationship, error) {
+	placeholders, values := relationshipValues(chunk, qmarkBindVar)
+	where := `
+            WHERE (resource_id, resource_type, subject_id, subject_type, relation) IN (
+        ` + strings.Join(placeholders, ",") + ")"
+
+	rows, err := db.GetStatement(ctx).QueryContext(ctx, `
+            SELECT resource_type, resource_id, subject_type, subject_id, relation, expires_at, caveat_name, caveat_context
+            FROM relationship
+        `+where+" FOR UPDATE", values...)
+	if err != nil {
+		return nil, fmt.Errorf("bulk delete relationships failed: %w", err)
+	}
+	deleted, err := scanRelationships(rows)
+	rows.Close()
+	if err != nil {
+		return nil, fmt.Errorf("bulk delete relationships failed: %w", err)
+	}
+
+	if _, err := db.GetStatement(ctx).ExecContext(ctx, "DELETE FROM relationship"+where, values...); err != nil {
+		return nil, fmt.Errorf("bulk delete relationships failed: %w", err)
+	}
+	return deleted, nil
+}
+
+// FindRelationships returns which of the given relationships exist (and are not expired) in the database.
+// Found rows are locked (FOR SHARE) until the end of the current transaction.
+func (r *mysqlRepository) FindRelationships(ctx context.Context, relationships []Relationship) ([]Relationship, error) {
+	var found []Relationship
+	for _, chunk := range chunkRelationships(relationships, maxBulkRows) {
+		placeholders, values := relationshipValues(chunk, qmarkBindVar)
+		query := `
+            SELECT resource_type, resource_id, subject_type, subject_id, relation, expires_at, caveat_name, caveat_context
+            FROM relationship
+            WHERE (resource_id, resource_type, subject_id, subject_type, relation) IN (
+        ` + strings.Join(placeholders, ",") + `)
+              AND (expires_at IS NULL OR expires_at > NOW())
+            FOR SHARE
+        `
+
+		rows, err := db.GetStatement(ctx).QueryContext(ctx, query, values...)
+		if err != nil {
+			return nil, fmt.Errorf("find relationships failed: %w", err)
+		}
+		rels, err := scanRelationships(rows)
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, rels...)
+	}
+	return found, nil
+}
+
+// LockRelationships locks the relationship_lock rows the relationship keys hash to, so that the writes
+// of the same relationships (see WritePrecondition) are serialized until the transaction of ctx ends.
+// The upsert locks each row exclusively, whether it exists or not, in order so that concurrent writes do not deadlock.
+func (r *mysqlRepository) LockRelationships(ctx context.Context, relationships []Relationship) error {
+	if len(relationships) == 0 {
+		return nil
+	}
+	slots := lockSlots(relationships)
+	values := make([]interface{}, len(slots))
+	for i, slot := range slots {
+		values[i] = slot
+	}
+
+	query := "INSERT INTO relationship_lock (slot) VALUES " + strings.TrimSuffix(strings.Repeat("(?),", len(values)), ",") +
+		" ON DUPLICATE KEY UPDATE slot = slot"
+	if _, err := db.GetStatement(ctx).ExecContext(ctx, query, values...); err != nil {
+		return fmt.Errorf("lock relationships failed: %w", err)
+	}
+	return nil
+}
+
+// ListAllRelationships reads at most limit unexpired relationships, in unique key order,
+// starting after the given relationship (or from the first one if after is nil).
+func (r *mysqlRepository) ListAllRelationships(ctx context.Context, after *Relationship, limit int) ([]Relationship, error) {
+	query := `
+        SELECT resource_type, resource_id, subject_type, subject_id, relation, expires_at, caveat_name, caveat_context
+        FROM relationship
+        WHERE (expires_at IS NULL OR expires_at > NOW())
+    `
+	var values []interface{}
+	if after != nil {
+		query += ` AND (resource_id, resource_type, subject_id, subject_type, relation) > (?, ?, ?, ?, ?)`
+		values = append(values, after.Resource.ID, after.Resource.Type, after.Subject.ID, after.Subject.Type, after.Relation)
+	}
+	query += ` ORDER BY resource_id, resource_type, subject_id, subject_type, relation LIMIT ?`
+	values = append(values, limit)
+
+	rows, err := db.GetStatement(ctx).QueryContext(ctx, query, values...)
+	if err != nil {
+		return nil, fmt.Errorf("list all relationships failed: %w", err)
+	}
+	defer rows.Close()
+
+	return scanRelationships(rows)
+}
+
+// ListPaths performs a recursive traversal and returns relationship paths.
+func (r *mysqlRepository) ListPaths(ctx context.Context, tRequest TraversalRequest) ([]TraversalResponseItem, error) {
+	// SQL request template
+	const sqlTemplate = `
+        WITH RECURSIVE %[5]s rel_tree (start_type, start_id, next_type, next_id, path) AS (
+            -- Start node
+            SELECT
+                r.%[1]s_type,
+                r.%[1]s_id,
+                r.%[2]s_type,
+                r.%[2]s_id,
+                JSON_ARRAY(
+                    JSON_OBJECT(
+                        'resource', CONCAT(r.resource_type, ':', r.resource_id),
+                        'subject',  CONCAT(r.subject_type, ':', r.subject_id),
+                        'relation', r.relation,
+                        'caveat',   IF(r.caveat_name IS NULL, NULL, JSON_OBJECT('name', r.caveat_name, 'context', r.caveat_context))
+                    )
+                )
+            FROM %[6]s r
+            WHERE r.%[1]s_type = ? AND r.%[1]s_id = ?
+              AND (r.expires_at IS NULL OR r.expires_at > NOW())%[4]s
+
+            UNION ALL
+
+            -- Recursive step
+            SELECT
+                t.start_type,
+                t.start_id,
+                r.%[2]s_type,
+                r.%[2]s_id,
+                JSON_ARRAY_APPEND(t.path, '$', JSON_OBJECT(
+                    'resource', CONCAT(r.resource_type, ':', r.resource_id),
+                    'subject',  CONCAT(r.subject_type, ':', r.subject_id),
+                    'relation', r.relation,
+                    'caveat',   IF(r.caveat_name IS NULL, NULL, JSON_OBJECT('name', r.caveat_name, 'context', r.caveat_context))
+                ))
+            FROM %[6]s r
+            JOIN rel_tree t
+              ON r.%[1]s_id = t.next_id
+             AND r.%[1]s_type = t.next_type
+            WHERE (r.expires_at IS NULL OR r.expires_at > NOW())%[4]s
+              -- A self-referential relationship is only followed from the start node: again, it would loop endlessly
+              AND NOT (r.resource_type = r.subject_type AND r.resource_id = r.subject_id)
+        )
+        SELECT
+            start_type,
+            start_id,
+            next_type,
+            next_id,
+            JSON_ARRAYAGG(path)
+        FROM rel_tree r
+        WHERE %[3]s
+        GROUP BY start_type, start_id, next_type, next_id
+        ORDER BY start_type, start_id, next_type, next_id -- the same results are kept when truncated
+    `
+
+	// Direction-dependent placeholders, stopping condition, and relations, excluded relations and types filters
+	from, next := "subject", "resource"
+	if tRequest.Forward {
+		from, next = "resource", "subject"
+	}
+	stopCondition, stopValues := stopOnCondition(tRequest.StopTargets(), qmarkBindVar, 1)
+	pruneCondition, pruneValues := "", []interface{}{}
+	if len(tRequest.Relations) > 0 {
+		pruneCondition += " AND r.relation IN (?" + strings.Repeat(", ?", len(tRequest.Relations)-1) + ")"
+		for _, relation := range tRequest.Relations {
+			pruneValues = append(pruneValues, relation)
+		}
+	}
+	if len(tRequest.ExcludeRelations) > 0 {
+		pruneCondition += " AND r.relation NOT IN (?" + strings.Repeat(", ?", len(tRequest.ExcludeRelations)-1) + ")"
+		for _, relation := range tRequest.ExcludeRelations {
+			pruneValues = append(pruneValues, relation)
+		}
+	}
+	if len(tRequest.Types) > 0 {
+		pruneCondition += " AND r." + next + "_type IN (?" + strings.Repeat(", ?", len(tRequest.Types)-1) + ")"
+		for _, objectType := range tRequest.Types {
+			pruneValues = append(pruneValues, objectType)
+		}
+	}
+	source, history, historyValues := "relationship", "", []interface{}{}
+	if !tRequest.AsOf.IsZero() {
+		source, history, historyValues = "history", mysqlHistoryCTE, []interface{}{tRequest.AsOf.UTC(), tRequest.AsOf.UTC()}
+	}
+	query := fmt.Sprintf(sqlTemplate, from, next, stopCondition, pruneCondition, history, source)
+	if tRequest.MaxResults > 0 {
+		query += fmt.Sprintf(" LIMIT %d", tRequest.MaxResults)
+	}
+
+	// Execute query
+	// Bind variables in order: history, start node, recursive step, stopping condition
+	values := append(historyValues, tRequest.StartOn.Type, tRequest.StartOn.ID)
+	values = append(values, pruneValues...)
+	values = append(values, pruneValues...)
+	values = append(values, stopValues...)
+	rows, err := db.GetStatement(ctx).QueryContext(ctx, query, values...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanTraversalItems(rows, tRequest.Forward)
+}
+
+// ClaimIdempotencyKey records an idempotency key, and returns nil if it was claimed,
+// or else the write already applied under it less than ttl ago. Expired keys are claimed again.
+func (r *mysqlRepository) ClaimIdempotencyKey(ctx context.Context, key IdempotencyKey, ttl time.Duration) (*IdempotentWrite, error) {
+	// Forget the key if it expired
+	_, err := db.GetStatement(ctx).ExecContext(ctx, "DELETE FROM idempotency_key WHERE actor = ? AND `key` = ? AND created_at < NOW(6) - INTERVAL ? MICROSECOND",
+		key.Actor, key.Key, ttl.Microseconds())
+	if err != nil {
+		return nil, fmt.Errorf("expire idempotency key failed: %w", err)
+	}
+
+	// Claim the key
+	res, err := db.GetStatement(ctx).ExecContext(ctx, "INSERT IGNORE INTO idempotency_key (actor, `key`, request_hash) VALUES (?, ?, ?)",
+		key.Actor, key.Key, key.RequestHash)
+	if err != nil {
+		return nil, fmt.Errorf("claim idempotency key failed: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("claim idempotency key failed: %w", err)
+	}
+	if n == 1 {
+		return nil, nil
+	}
+
+	// Read the write applied under the key
+	var applied IdempotentWrite
+	var result sql.NullString
+	err = db.GetStatement(ctx).QueryRowContext(ctx, "SELECT request_hash, result FROM idempotency_key WHERE actor = ? AND `key` = ?",
+		key.Actor, key.Key).Scan(&applied.RequestHash, &result)
+	if err != nil {
+		return nil, fmt.Errorf("read idempotency key failed: %w", err)
+	}
+	applied.Result = []byte(result.String)
+	return &applied, nil
+}
+
+// SaveIdempotentResult records the result of the write applied under a claimed idempotency key.
+func (r *mysqlRepository) SaveIdempotentResult(ctx context.Context, key IdempotencyKey, result []byte) error {
+	_, err := db.GetStatement(ctx).ExecContext(ctx, "UPDATE idempotency_key SET result = ? WHERE actor = ? AND `key` = ?",
+		string(result), key.Actor, key.Key)
+	if err != nil {
+		return fmt.Errorf("save idempotent result failed: %w", err)
+	}
+	return nil
+}
+
+// ConsistencyToken is not supported by MySQL: it returns an empty token, which reads ignore.
+func (r *mysqlRepository) ConsistencyToken(ctx context.Context) (string, error) {
+	return "", nil
+}
+
+// IsConsistencyTokenVisible is not supported by MySQL.
+func (r *mysqlRepository) IsConsistencyTokenVisible(ctx context.Context, token string) (bool, error) {
+	return false, errConsistencyTokensUnsupported("mysql")
+}
+
+// ListChanges reads at most limit relationship changes with an ID greater than since, in ID order.
+func (r *mysqlRepository) ListChanges(ctx context.Context, since int64, limit int) ([]RelationshipChange, error) {
+	query := `
+        SELECT id, action, resource_type, resource_id, subject_type, subject_id, relation, changed_at
+        FROM relationship_change
+        WHERE id > ?
+        ORDER BY id
+        LIMIT ?
+    `
+
+	rows, err := db.GetStatement(ctx).QueryContext(ctx, query, since, limit)
+	if err != nil {
+		return nil, fmt.Errorf("list changes failed: %w", err)
+	}
+	defer rows.Close()
+
+	return scanChanges(rows)
+}
+
+// LatestChangeID returns the ID of the latest relationship change, or 0 if there is none.
+func (r *mysqlRepository) LatestChangeID(ctx context.Context) (int64, error) {
+	var id int64
+	if err := db.GetStatement(ctx).QueryRowContext(ctx, `SELECT COALESCE(MAX(id), 0) FROM relationship_change`).Scan(&id); err != nil {
+		return 0, fmt.Errorf("get latest change failed: %w", err)
+	}
+	return id, nil
+}
+
+// HistoryStart returns the time the relationship history starts (see TraversalRequest.AsOf).
+func (r *mysqlRepository) HistoryStart(ctx context.Context) (time.Time, error) {
+	var start time.Time
+	if err := db.GetStatement(ctx).QueryRowContext(ctx, `SELECT started_at FROM relationship_history ORDER BY started_at LIMIT 1`).Scan(&start); err != nil {
+		return time.Time{}, fmt.Errorf("get history start failed: %w", err)
+	}
+	return start, nil
+}
+
+// InsertAuditEntries records one audit entry per relationship, in as many queries as required by the parameter limit.
+func (r *mysqlRepository) InsertAuditEntries(ctx context.Context, actor, action string, relationships []Relationship) error {
+	const columns = relationshipColumns + 2 // with actor and action
+	for _, chunk := range chunkRelationships(relationships, 65535/columns) {
+		placeholders := make([]string, 0, len(chunk))
+		values := make([]interface{}, 0, len(chunk)*columns)
+		for _, rel := range chunk {
+			placeholders = append(placeholders, "(?, ?, ?, ?, ?, ?, ?)")
+			values = append(values, actor, action, rel.Resource.ID, rel.Resource.Type, rel.Subject.ID, rel.Subject.Type, rel.Relation)
+		}
+
+		query := `
+            INSERT INTO audit_log (actor, action, resource_id, resource_type, subject_id, subject_type, relation)
+            VALUES ` + strings.Join(placeholders, ",")
+
+		if _, err := db.GetStatement(ctx).ExecContext(ctx, query, values...); err != nil {
+			return fmt.Errorf("insert audit entries failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// ListAuditEntries reads the audit entries selected by the filter, oldest first.
+func (r *mysqlRepository) ListAuditEntries(ctx context.Context, filter AuditFilter) ([]AuditEntry, error) {
+	conditions, values := auditConditions(filter, qmarkBindVar, func(t time.Time) interface{} { return t })
+	query := `
+        SELECT id, actor, action, resource_type, resource_id, subject_type, subject_id, relation, created_at
+        FROM audit_log
+        WHERE ` + conditions + `
+        ORDER BY id
+    `
+	if filter.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", filter.Limit)
+	}
+
+	rows, err := db.GetStatement(ctx).QueryContext(ctx, query, values...)
+	if err != nil {
+		return nil, fmt.Errorf("list audit entries failed: %w", err)
+	}
+	defer rows.Close()
+
+	return scanAuditEntries(rows)
+}
+
+// DeleteExpired removes relationships which expired before the given time, and returns how many were removed.
+func (r *mysqlRepository) DeleteExpired(ctx context.Context, before time.Time) (int64, error) {
+	res, err := db.GetStatement(ctx).ExecContext(ctx, `DELETE FROM relationship WHERE expires_at < ?`, before)
+	if err != nil {
+		return 0, fmt.Errorf("delete expired relationships failed: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// mysqlHistoryCTE defines the relationships valid at the time bound to its two variables (see TraversalRequest.AsOf):
+// the ones whose latest change until then is a creation, not expired at that time. Their expiry is read
+// as NULL, so that the traversal does not check it against the current time.
+const mysqlHistoryCTE = `
+        history AS (
+            SELECT resource_type, resource_id, subject_type, subject_id, relation,
+                NULL AS expires_at, caveat_name, caveat_context
+            FROM (
+                SELECT c.*, ROW_NUMBER() OVER (
+                    PARTITION BY resource_type, resource_id, subject_type, subject_id, relation ORDER BY id DESC
+                ) AS rn
+                FROM relationship_change c
+                WHERE c.changed_at <= ?
+            ) latest
+            WHERE latest.rn = 1 AND latest.action = 'create'
+              AND (latest.expires_at IS NULL OR latest.expires_at > ?)
+        ),`
@@ -0,0 +1,607 @@
+package authz
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/romrossi/authz-rebac/pkg/db"
+)
+
+// SQLite accepts at most 32766 bind variables per statement.
+const (
+	sqliteMaxBulkRows   = 32766 / relationshipColumns
+	sqliteMaxInsertRows = 32766 / insertColumns
+)
+
+// sqliteRepository is a SQLite implementation of the authz repository, meant for local development and tests.
+// Its queries mirror those of pgRepository, in the SQLite dialect. Timestamps are compared with julianday(),
+// and always bound in UTC.
+type sqliteRepository struct{}
+
+// NewSQLiteRepository creates a new sqliteRepository instance.
+func NewSQLiteRepository() AuthzRepository {
+	return &sqliteRepository{}
+}
+
+// ListRelationships reads relationships of a resource and recursively its parents in one query.
+// If subjectTypes is not empty, only relationships to subjects of these types are returned.
+func (r *sqliteRepository) ListRelationships(ctx context.Context, object Object, subjectTypes []string) ([]Relationship, error) {
+	query := `
+        WITH RECURSIVE ancestor AS (
+            SELECT resource_type, resource_id, subject_type, subject_id, relation, expires_at, caveat_name, caveat_context
+            FROM relationship
+            WHERE resource_type = ?
+              AND resource_id = ?
+              AND (expires_at IS NULL OR julianday(expires_at) > julianday('now'))
+
+            UNION
+
+            SELECT r.resource_type, r.resource_id, r.subject_type, r.subject_id, r.relation, r.expires_at, r.caveat_name, r.caveat_context
+            FROM relationship r
+            JOIN ancestor a
+              ON r.resource_type = a.subject_type
+             AND r.resource_id = a.subject_id
+            WHERE a.relation = 'parent'
+              AND (r.expires_at IS NULL OR julianday(r.expires_at) > julianday('now'))
+        )
+        SELECT resource_type, resource_id, subject_type, subject_id, relation, expires_at, caveat_name, caveat_context
+        FROM ancestor
+        WHERE relation != 'parent'
+    `
+	values := []interface{}{object.Type, object.ID}
+
+	// Bind subject types one by one (no array type in SQLite)
+	if len(subjectTypes) > 0 {
+		query += " AND subject_type IN (?" + strings.Repeat(", ?", len(subjectTypes)-1) + ")"
+		for _, t := range subjectTypes {
+			values = append(values, t)
+		}
+	}
+
+	rows, err := db.GetStatement(ctx).QueryContext(ctx, query, values...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanRelationships(rows)
+}
+
+// ListSubjects reads the subjects directly related to a resource by any of the relations (no traversal),
+// ordered by type and ID.
+func (r *sqliteRepository) ListSubjects(ctx context.Context, resource Object, relations []string) ([]Object, error) {
+	if len(relations) == 0 {
+		return nil, nil
+	}
+	// Bind relations one by one (no array type in SQLite)
+	query := `
+        SELECT DISTINCT subject_type, subject_id
+        FROM relationship
+        WHERE resource_type = ?
+          AND resource_id = ?
+          AND relation IN (?` + strings.Repeat(", ?", len(relations)-1) + `)
+          AND (expires_at IS NULL OR julianday(expires_at) > julianday('now'))
+        ORDER BY subject_type, subject_id
+    `
+	values := []interface{}{resource.Type, resource.ID}
+	for _, relation := range relations {
+		values = append(values, relation)
+	}
+
+	rows, err := db.GetStatement(ctx).QueryContext(ctx, query, values...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanObjects(rows)
+}
+
+// CountRelationships counts the unexpired relationships of a resource (no traversal),
+// of any of the relations, or of any relation if relations is empty.
+func (r *sqliteRepository) CountRelationships(ctx context.Context, resource Object, relations []string) (int64, error) {
+	query := `
+        SELECT COUNT(*)
+        FROM relationship
+        WHERE resource_type = ?
+          AND resource_id = ?
+          AND (expires_at IS NULL OR julianday(expires_at) > julianday('now'))
+    `
+	values := []interface{}{resource.Type, resource.ID}
+	if len(relations) > 0 {
+		query += ` AND relation IN (?` + strings.Repeat(", ?", len(relations)-1) + `)`
+		for _, relation := range relations {
+			values = append(values, relation)
+		}
+	}
+
+	var count int64
+	if err := db.GetStatement(ctx).QueryRowContext(ctx, query, values...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("count relationships failed: %w", err)
+	}
+	return count, nil
+}
+
+// ObjectExists reports whether the object is the resource or the subject of an unexpired relationship.
+func (r *sqliteRepository) ObjectExists(ctx context.Context, object Object) (bool, error) {
+	query := `
+        SELECT EXISTS (
+            SELECT 1 FROM relationship
+            WHERE resource_type = ? AND resource_id = ?
+              AND (expires_at IS NULL OR julianday(expires_at) > julianday('now'))
+        ) OR EXISTS (
+            SELECT 1 FROM relationship
+            WHERE subject_type = ? AND subject_id = ?
+              AND (expires_at IS NULL OR julianday(expires_at) > julianday('now'))
+        )
+    `
+	var exists bool
+	if err := db.GetStatement(ctx).QueryRowContext(ctx, query, object.Type, object.ID, object.Type, object.ID).Scan(&exists); err != nil {
+		return false, fmt.Errorf("check object existence failed: %w", err)
+	}
+	return exists, nil
+}
+
+// InsertBulk inserts multiple relationships into the database, without duplicates (see uniqueRelationships),
+// in as many queries as required by the bind variable limit, within a single transaction.
+// Existing relationships are handled according to the conflict policy (ConflictIgnore if empty),
+// and expired ones are always replaced.
+// It returns the relationships inserted, replaced, or (with ConflictUpsert) whose expiry or caveat changed.
+func (r *sqliteRepository) InsertBulk(ctx context.Context, relationships []Relationship, policy ConflictPolicy) ([]Relationship, error) {
+	return inChunks(ctx, uniqueRelationships(relationships), sqliteMaxInsertRows, func(ctx context.Context, chunk []Relationship) ([]Relationship, error) {
+		return r.insertChunk(ctx, chunk, policy)
+	})
+}
+
+// insertChunk inserts multiple relationships into the database.
+func (r *sqliteRepository) insertChunk(ctx context.Context, chunk []Relationship, policy ConflictPolicy) ([]Relationship, error) {
+	placeholders := make([]string, 0, len(chunk))
+	values := make([]interface{}, 0, len(chunk)*insertColumns)
+	for _, rel := range chunk {
+		var expiresAt interface{}
+		if rel.ExpiresAt != nil {
+			expiresAt = rel.ExpiresAt.UTC()
+		}
+		caveatName, caveatContext, err := caveatValues(rel)
+		if err != nil {
+			return nil, err
+		}
+		placeholders = append(placeholders, "(?, ?, ?, ?, ?, ?, ?, ?)")
+		values = append(values,
+			rel.Resource.ID,
+			rel.Resource.Type,
+			rel.Subject.ID,
+			rel.Subject.Type,
+			rel.Relation,
+			expiresAt,
+			caveatName,
+			caveatContext,
+		)
+	}
+
+	// Creating an existing relationship replaces its expiry and caveat with ConflictUpsert,
+	// and otherwise only if it expired
+	query := `
+            INSERT INTO relationship (resource_id, resource_type, subject_id, subject_type, relation, expires_at, caveat_name, caveat_context)
+            VALUES ` + strings.Join(placeholders, ",") + `
+            ON CONFLICT (resource_id, resource_type, subject_id, subject_type, relation)
+            DO UPDATE SET expires_at = excluded.expires_at, caveat_name = excluded.caveat_name, caveat_context = excluded.caveat_context
+        `
+	if policy == ConflictUpsert {
+		query += `
+            WHERE expires_at IS NOT excluded.expires_at
+               OR caveat_name IS NOT excluded.caveat_name
+               OR caveat_context IS NOT excluded.caveat_context
+        `
+	} else {
+		query += `
+            WHERE julianday(expires_at) <= julianday('now')
+        `
+	}
+
+	// Only the rows inserted or updated are returned: with ConflictError, the others conflicted with an existing one
+	query += `
+            RETURNING resource_type, resource_id, subject_type, subject_id, relation, expires_at, caveat_name, caveat_context
+        `
+	rows, err := db.GetStatement(ctx).QueryContext(ctx, query, values...)
+	if err != nil {
+		return nil, fmt.Errorf("bulk insert relationships failed: %w", err)
+	}
+	defer rows.Close()
+	inserted, err := scanRelationships(rows)
+	if err != nil {
+		return nil, fmt.Errorf("bulk insert relationships failed: %w", err)
+	}
+	if policy == ConflictError {
+		return inserted, conflictError(chunk, inserted)
+	}
+	return inserted, nil
+}
+
+// DeleteBulk removes multiple relationships from the database,
+// in as many queries as required by the bind variable limit, within a single transaction.
+// It returns the relationships deleted.
+func (r *sqliteRepository) DeleteBulk(ctx context.Context, relationships []Relationship) ([]Relationship, error) {
+	return inChunks(ctx, relationships, sqliteMaxBulkRows, r.deleteChunk)
+}
+
+// deleteChunk removes multiple relationships from the database in one query.
+func (r *sqliteRepository) deleteChunk(ctx context.Context, chunk []Relationship) ([]Relationship, error) {
+	placeholders, values := relationshipValues(chunk, qmarkBindVar)
+	query := `
+            DELETE FROM relationship
+            WHERE (resource_id, resource_type, subject_id, subject_type, relation) IN (
+                VALUES ` + strings.Join(placeholders, ",") + `
+            )
+            RETURNING resource_type, resource_id, subject_type, subject_id, relation, expires_at, caveat_name, caveat_context
+        `
+
+	rows, err := db.GetStatement(ctx).QueryContext(ctx, query, values...)
+	if err != nil {
+		return nil, fmt.Errorf("bulk delete relationships failed: %w", err)
+	}
+	defer rows.Close()
+	deleted, err := scanRelationships(rows)
+	if err != nil {
+		return nil, fmt.Errorf("bulk delete relationships failed: %w", err)
+	}
+	return deleted, nil
+}
+
+// FindRelationships returns which of the given relationships exist (and are not expired) in the database.
+// No lock is needed: SQLite serializes transactions writing to the database.
+func (r *sqliteRepository) FindRelationships(ctx context.Context, relationships []Relationship) ([]Relationship, error) {
+	var found []Relationship
+	for _, chunk := range chunkRelationships(relationships, sqliteMaxBulkRows) {
+		placeholders, values := relationshipValues(chunk, qmarkBindVar)
+		query := `
+            SELECT resource_type, resource_id, subject_type, subject_id, relation, expires_at, caveat_name, caveat_context
+            FROM relationship
+            WHERE (resource_id, resource_type, subject_id, subject_type, relation) IN (
+                VALUES ` + strings.Join(placeholders, ",") + `
+            )
+              AND (expires_at IS NULL OR julianday(expires_at) > julianday('now'))
+        `
+
+		rows, err := db.GetStatement(ctx).QueryContext(ctx, query, values...)
+		if err != nil {
+			return nil, fmt.Errorf("find relationships failed: %w", err)
+		}
+		rels, err := scanRelationships(rows)
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, rels...)
+	}
+	return found, nil
+}
+
+// LockRelationships does nothing: the single connection to the database serializes the transactions
+// (see db.Connect), so that the writes of the same relationships (see WritePrecondition) already are.
+func (r *sqliteRepository) LockRelationships(ctx context.Context, relationships []Relationship) error {
+	return nil
+}
+
+// ListAllRelationships reads at most limit unexpired relationships, in unique key order,
+// starting after the given relationship (or from the first one if after is nil).
+func (r *sqliteRepository) ListAllRelationships(ctx context.Context, after *Relationship, limit int) ([]Relationship, error) {
+	query := `
+        SELECT resource_type, resource_id, subject_type, subject_id, relation, expires_at, caveat_name, caveat_context
+        FROM relationship
+        WHERE (expires_at IS NULL OR julianday(expires_at) > julianday('now'))
+    `
+	var values []interface{}
+	if after != nil {
+		query += ` AND (resource_id, resource_type, subject_id, subject_type, relation) > (?, ?, ?, ?, ?)`
+		values = append(values, after.Resource.ID, after.Resource.Type, after.Subject.ID, after.Subject.Type, after.Relation)
+	}
+	query += ` ORDER BY resource_id, resource_type, subject_id, subject_type, relation LIMIT ?`
+	values = append(values, limit)
+
+	rows, err := db.GetStatement(ctx).QueryContext(ctx, query, values...)
+	if err != nil {
+		return nil, fmt.Errorf("list all relationships failed: %w", err)
+	}
+	defer rows.Close()
+
+	return scanRelationships(rows)
+}
+
+// ListPaths performs a recursive traversal and returns relationship paths.
+func (r *sqliteRepository) ListPaths(ctx context.Context, tRequest TraversalRequest) ([]TraversalResponseItem, error) {
+	query, values := sqliteListPathsQuery(tRequest)
+	rows, err := db.GetStatement(ctx).QueryContext(ctx, query, values...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanTraversalItems(rows, tRequest.Forward)
+}
+
+// sqliteListPathsQuery builds the recursive traversal query of ListPaths, along with its bind values.
+func sqliteListPathsQuery(tRequest TraversalRequest) (string, []interface{}) {
+	// SQL request template
+	// Paths are stored as JSON text: json() restores them as JSON values when aggregated.
+	const sqlTemplate = `
+        WITH RECURSIVE %[5]s rel_tree (start_type, start_id, next_type, next_id, path) AS (
+            -- Start node
+            SELECT
+                r.%[1]s_type,
+                r.%[1]s_id,
+                r.%[2]s_type,
+                r.%[2]s_id,
+                json_array(
+                    json_object(
+                        'resource', r.resource_type || ':' || r.resource_id,
+                        'subject',  r.subject_type || ':' || r.subject_id,
+                        'relation', r.relation,
+                        'caveat',   CASE WHEN r.caveat_name IS NULL THEN NULL ELSE json_object('name', r.caveat_name, 'context', json(r.caveat_context)) END
+                    )
+                )
+            FROM %[6]s r
+            WHERE r.%[1]s_type = ? AND r.%[1]s_id = ?
+              AND (r.expires_at IS NULL OR julianday(r.expires_at) > julianday('now'))%[4]s
+
+            UNION ALL
+
+            -- Recursive step
+            SELECT
+                t.start_type,
+                t.start_id,
+                r.%[2]s_type,
+                r.%[2]s_id,
+                json_insert(t.path, '$[#]', json_object(
+                    'resource', r.resource_type || ':' || r.resource_id,
+                    'subject',  r.subject_type || ':' || r.subject_id,
+                    'relation', r.relation,
+                    'caveat',   CASE WHEN r.caveat_name IS NULL THEN NULL ELSE json_object('name', r.caveat_name, 'context', json(r.caveat_context)) END
+                ))
+            FROM %[6]s r
+            JOIN rel_tree t
+              ON r.%[1]s_id = t.next_id
+             AND r.%[1]s_type = t.next_type
+            WHERE (r.expires_at IS NULL OR julianday(r.expires_at) > julianday('now'))%[4]s
+              -- A self-referential relationship is only followed from the start node: again, it would loop endlessly
+              AND NOT (r.resource_type = r.subject_type AND r.resource_id = r.subject_id)
+        )
+        SELECT
+            start_type,
+            start_id,
+            next_type,
+            next_id,
+            json_group_array(json(path))
+        FROM rel_tree r
+        WHERE %[3]s
+        GROUP BY start_type, start_id, next_type, next_id
+        ORDER BY start_type, start_id, next_type, next_id -- the same results are kept when truncated
+    `
+
+	// Direction-dependent placeholders, stopping condition, and relations, excluded relations and types filters
+	from, next := "subject", "resource"
+	if tRequest.Forward {
+		from, next = "resource", "subject"
+	}
+	stopCondition, stopValues := stopOnCondition(tRequest.StopTargets(), qmarkBindVar, 1)
+	pruneCondition, pruneValues := "", []interface{}{}
+	if len(tRequest.Relations) > 0 {
+		pruneCondition += " AND r.relation IN (?" + strings.Repeat(", ?", len(tRequest.Relations)-1) + ")"
+		for _, relation := range tRequest.Relations {
+			pruneValues = append(pruneValues, relation)
+		}
+	}
+	if len(tRequest.ExcludeRelations) > 0 {
+		pruneCondition += " AND r.relation NOT IN (?" + strings.Repeat(", ?", len(tRequest.ExcludeRelations)-1) + ")"
+		for _, relation := range tRequest.ExcludeRelations {
+			pruneValues = append(pruneValues, relation)
+		}
+	}
+	if len(tRequest.Types) > 0 {
+		pruneCondition += " AND r." + next + "_type IN (?" + strings.Repeat(", ?", len(tRequest.Types)-1) + ")"
+		for _, objectType := range tRequest.Types {
+			pruneValues = append(pruneValues, objectType)
+		}
+	}
+	source, history, historyValues := "relationship", "", []interface{}{}
+	if !tRequest.AsOf.IsZero() {
+		source, history, historyValues = "history", sqliteHistoryCTE, []interface{}{tRequest.AsOf.UTC(), tRequest.AsOf.UTC()}
+	}
+	query := fmt.Sprintf(sqlTemplate, from, next, stopCondition, pruneCondition, history, source)
+	if tRequest.MaxResults > 0 {
+		query += fmt.Sprintf(" LIMIT %d", tRequest.MaxResults)
+	}
+
+	// Bind variables in order: history, start node, recursive step, stopping condition
+	values := append(historyValues, tRequest.StartOn.Type, tRequest.StartOn.ID)
+	values = append(values, pruneValues...)
+	values = append(values, pruneValues...)
+	values = append(values, stopValues...)
+	return query, values
+}
+
+// ClaimIdempotencyKey records an idempotency key, and returns nil if it was claimed,
+// or else the write already applied under it less than ttl ago. Expired keys are claimed again.
+func (r *sqliteRepository) ClaimIdempotencyKey(ctx context.Context, key IdempotencyKey, ttl time.Duration) (*IdempotentWrite, error) {
+	// Forget the key if it expired
+	_, err := db.GetStatement(ctx).ExecContext(ctx, `
+        DELETE FROM idempotency_key
+        WHERE actor = ? AND "key" = ?
+          AND julianday(created_at) < julianday(?)
+    `, key.Actor, key.Key, time.Now().Add(-ttl).UTC())
+	if err != nil {
+		return nil, fmt.Errorf("expire idempotency key failed: %w", err)
+	}
+
+	// Claim the key
+	res, err := db.GetStatement(ctx).ExecContext(ctx, `
+        INSERT INTO idempotency_key (actor, "key", request_hash)
+        VALUES (?, ?, ?)
+        ON CONFLICT DO NOTHING
+    `, key.Actor, key.Key, key.RequestHash)
+	if err != nil {
+		return nil, fmt.Errorf("claim idempotency key failed: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("claim idempotency key failed: %w", err)
+	}
+	if n == 1 {
+		return nil, nil
+	}
+
+	// Read the write applied under the key
+	var applied IdempotentWrite
+	var result sql.NullString
+	err = db.GetStatement(ctx).QueryRowContext(ctx, `
+        SELECT request_hash, result FROM idempotency_key WHERE actor = ? AND "key" = ?
+    `, key.Actor, key.Key).Scan(&applied.RequestHash, &result)
+	if err != nil {
+		return nil, fmt.Errorf("read idempotency key failed: %w", err)
+	}
+	applied.Result = []byte(result.String)
+	return &applied, nil
+}
+
+// SaveIdempotentResult records the result of the write applied under a claimed idempotency key.
+func (r *sqliteRepository) SaveIdempotentResult(ctx context.Context, key IdempotencyKey, result []byte) error {
+	_, err := db.GetStatement(ctx).ExecContext(ctx, `
+        UPDATE idempotency_key SET result = ? WHERE actor = ? AND "key" = ?
+    `, string(result), key.Actor, key.Key)
+	if err != nil {
+		return fmt.Errorf("save idempotent result failed: %w", err)
+	}
+	return nil
+}
+
+// ConsistencyToken is not supported by SQLite: it returns an empty token, which reads ignore.
+func (r *sqliteRepository) ConsistencyToken(ctx context.Context) (string, error) {
+	return "", nil
+}
+
+// IsConsistencyTokenVisible is not supported by SQLite.
+func (r *sqliteRepository) IsConsistencyTokenVisible(ctx context.Context, token string) (bool, error) {
+	return false, errConsistencyTokensUnsupported("sqlite")
+}
+
+// ListChanges reads at most limit relationship changes with an ID greater than since, in ID order.
+func (r *sqliteRepository) ListChanges(ctx context.Context, since int64, limit int) ([]RelationshipChange, error) {
+	query := `
+        SELECT id, action, resource_type, resource_id, subject_type, subject_id, relation, changed_at
+        FROM relationship_change
+        WHERE id > ?
+        ORDER BY id
+        LIMIT ?
+    `
+
+	rows, err := db.GetStatement(ctx).QueryContext(ctx, query, since, limit)
+	if err != nil {
+		return nil, fmt.Errorf("list changes failed: %w", err)
+	}
+	defer rows.Close()
+
+	return scanChanges(rows)
+}
+
+// LatestChangeID returns the ID of the latest relationship change, or 0 if there is none.
+func (r *sqliteRepository) LatestChangeID(ctx context.Context) (int64, error) {
+	var id int64
+	if err := db.GetStatement(ctx).QueryRowContext(ctx, `SELECT COALESCE(MAX(id), 0) FROM relationship_change`).Scan(&id); err != nil {
+		return 0, fmt.Errorf("get latest change failed: %w", err)
+	}
+	return id, nil
+}
+
+// HistoryStart returns the time the relationship history starts (see TraversalRequest.AsOf).
+func (r *sqliteRepository) HistoryStart(ctx context.Context) (time.Time, error) {
+	var start time.Time
+	if err := db.GetStatement(ctx).QueryRowContext(ctx, `SELECT started_at FROM relationship_history ORDER BY started_at LIMIT 1`).Scan(&start); err != nil {
+		return time.Time{}, fmt.Errorf("get history start failed: %w", err)
+	}
+	return start, nil
+}
+
+// InsertAuditEntries records one audit entry per relationship, in as many queries as required by the bind variable limit.
+func (r *sqliteRepository) InsertAuditEntries(ctx context.Context, actor, action string, relationships []Relationship) error {
+	const columns = relationshipColumns + 2 // with actor and action
+	for _, chunk := range chunkRelationships(relationships, 32766/columns) {
+		placeholders := make([]string, 0, len(chunk))
+		values := make([]interface{}, 0, len(chunk)*columns)
+		for _, rel := range chunk {
+			placeholders = append(placeholders, "(?, ?, ?, ?, ?, ?, ?)")
+			values = append(values, actor, action, rel.Resource.ID, rel.Resource.Type, rel.Subject.ID, rel.Subject.Type, rel.Relation)
+		}
+
+		query := `
+            INSERT INTO audit_log (actor, action, resource_id, resource_type, subject_id, subject_type, relation)
+            VALUES ` + strings.Join(placeholders, ",")
+
+		if _, err := db.GetStatement(ctx).ExecContext(ctx, query, values...); err != nil {
+			return fmt.Errorf("insert audit entries failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// ListAuditEntries reads the audit entries selected by the filter, oldest first.
+func (r *sqliteRepository) ListAuditEntries(ctx context.Context, filter AuditFilter) ([]AuditEntry, error) {
+	query, values := sqliteListAuditEntriesQuery(filter)
+	rows, err := db.GetStatement(ctx).QueryContext(ctx, query, values...)
+	if err != nil {
+		return nil, fmt.Errorf("list audit entries failed: %w", err)
+	}
+	defer rows.Close()
+
+	return scanAuditEntries(rows)
+}
+
+// sqliteListAuditEntriesQuery builds the query listing audit entries, and its bind values.
+// Times are bound in the text encoding of CURRENT_TIMESTAMP, so that created_at is compared as is, with its index.
+func sqliteListAuditEntriesQuery(filter AuditFilter) (string, []interface{}) {
+	conditions, values := auditConditions(filter, qmarkBindVar, func(t time.Time) interface{} {
+		return t.UTC().Format("2006-01-02 15:04:05")
+	})
+	query := `
+        SELECT id, actor, action, resource_type, resource_id, subject_type, subject_id, relation, created_at
+        FROM audit_log
+        WHERE ` + conditions + `
+        ORDER BY id
+    `
+	if filter.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", filter.Limit)
+	}
+	return query, values
+}
+
+// DeleteExpired removes relationships which expired before the given time, and returns how many were removed.
+func (r *sqliteRepository) DeleteExpired(ctx context.Context, before time.Time) (int64, error) {
+	res, err := db.GetStatement(ctx).ExecContext(ctx, `
+        DELETE FROM relationship
+        WHERE julianday(expires_at) < julianday(?)
+    `, before.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("delete expired relationships failed: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// sqliteHistoryCTE defines the relationships valid at the time bound to its two variables (see TraversalRequest.AsOf):
+// the ones whose latest change until then is a creation, not expired at that time. Their expiry is read
+// as NULL, so that the traversal does not check it against the current time.
+const sqliteHistoryCTE = `
+        history AS (
+            SELECT resource_type, resource_id, subject_type, subject_id, relation,
+                NULL AS expires_at, caveat_name, caveat_context
+            FROM (
+                SELECT c.*, ROW_NUMBER() OVER (
+                    PARTITION BY resource_type, resource_id, subject_type, subject_id, relation ORDER BY id DESC
+                ) AS rn
+                FROM relationship_change c
+                WHERE julianday(c.changed_at) <= julianday(?)
+            ) latest
+            WHERE latest.rn = 1 AND latest.action = 'create'
+              AND (latest.expires_at IS NULL OR julianday(latest.expires_at) > julianday(?))
+        ),`
@@ -0,0 +1,129 @@
+package authz_test
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/romrossi/authz-rebac/pkg/authz"
+)
+
+// readers returns n relationships granting reader on distinct projects to distinct users.
+func readers(n int) []authz.Relationship {
+	relationships := make([]authz.Relationship, n)
+	for i := range relationships {
+		id := strconv.Itoa(i)
+		relationships[i] = rel("project:"+id, "reader", "user:"+id)
+	}
+	return relationships
+}
+
+func TestBulkOperationsChunked(t *testing.T) {
+	_, repo := newService(t, authz.LoadMetadata())
+	ctx := context.Background()
+
+	// More rows than fit in two statements, for both inserts and deletions (inserts bind more columns per row)
+	rows := 2*authz.SQLiteMaxBulkRows + 1
+	relationships := readers(rows)
+	written, err := repo.InsertBulk(ctx, relationships, authz.ConflictIgnore)
+	if err != nil {
+		t.Fatalf("InsertBulk() failed: %v", err)
+	}
+	if len(written) != rows {
+		t.Errorf("InsertBulk() = %d relationships, want %d", len(written), rows)
+	}
+	if found, err := repo.FindRelationships(ctx, relationships); err != nil || len(found) != rows {
+		t.Errorf("FindRelationships() = %d relationships, %v, want %d", len(found), err, rows)
+	}
+
+	deleted, err := repo.DeleteBulk(ctx, relationships)
+	if err != nil {
+		t.Fatalf("DeleteBulk() failed: %v", err)
+	}
+	if len(deleted) != rows {
+		t.Errorf("DeleteBulk() = %d relationships, want %d", len(deleted), rows)
+	}
+}
+
+func TestInsertBulkRepeatedRelationship(t *testing.T) {
+	_, repo := newService(t, authz.LoadMetadata())
+	ctx := context.Background()
+	first, last := time.Now().Add(time.Hour).UTC().Truncate(time.Millisecond), time.Now().Add(2*time.Hour).UTC().Truncate(time.Millisecond)
+
+	relationships := []authz.Relationship{
+		rel("project:1", "reader", "user:alice"),
+		rel("project:1", "reader", "user:bob"),
+		rel("project:1", "reader", "user:alice"),
+	}
+	relationships[0].ExpiresAt = &first
+	relationships[2].ExpiresAt = &last
+	written, err := repo.InsertBulk(ctx, relationships, authz.ConflictIgnore)
+	if err != nil {
+		t.Fatalf("InsertBulk() failed: %v", err)
+	}
+	if len(written) != 2 {
+		t.Errorf("InsertBulk() = %v, want 2 relationships", written)
+	}
+
+	found, err := repo.FindRelationships(ctx, relationships[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].ExpiresAt == nil || !found[0].ExpiresAt.Equal(last) {
+		t.Errorf("found = %+v, want the expiry of the last occurrence (%s)", found, last)
+	}
+}
+
+func TestBulkWritesSorted(t *testing.T) {
+	newService(t, authz.LoadMetadata()) // chunks are written within a transaction
+	want := []authz.Relationship{
+		rel("project:1", "owner", "user:alice"),
+		rel("project:1", "reader", "user:alice"),
+		rel("project:1", "owner", "user:bob"),
+		rel("project:1", "reader", "user:bob"),
+		rel("project:1", "reader", "group:eng"),
+		rel("project:2", "reader", "user:alice"),
+		rel("group:eng", "member", "user:carol"),
+	}
+
+	// Whatever the input order, rows are written in key order (resource ID and type, subject ID and type, relation)
+	for _, order := range [][]int{{0, 1, 2, 3, 4, 5, 6}, {6, 5, 4, 3, 2, 1, 0}, {3, 0, 6, 2, 5, 1, 4}} {
+		input := make([]authz.Relationship, len(order))
+		for i, j := range order {
+			input[i] = want[j]
+		}
+		var written []authz.Relationship
+		_, err := authz.InChunks(context.Background(), input, 3, func(_ context.Context, chunk []authz.Relationship) ([]authz.Relationship, error) {
+			written = append(written, chunk...)
+			return chunk, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(written, want) {
+			t.Errorf("input order %v: written %v, want %v", order, written, want)
+		}
+		if input[0] != want[order[0]] {
+			t.Errorf("input order %v: input modified", order)
+		}
+	}
+}
+
+func TestDeleteExpired(t *testing.T) {
+	_, repo := newService(t, authz.LoadMetadata())
+	ctx := context.Background()
+	longAgo, recently := time.Now().Add(-time.Hour), time.Now().Add(-time.Second)
+	expired, grace := rel("project:1", "reader", "user:alice"), rel("project:1", "reader", "user:bob")
+	expired.ExpiresAt, grace.ExpiresAt = &longAgo, &recently
+	seedRelations(t, repo, expired, grace, rel("project:1", "reader", "user:carol"))
+
+	n, err := repo.DeleteExpired(ctx, time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("DeleteExpired() = %d, want 1 (the relationship expired before the grace period)", n)
+	}
+}
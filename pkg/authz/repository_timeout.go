@@ -0,0 +1,187 @@
+package authz
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// timeoutRepository decorates an AuthzRepository, bounding the duration of each of its operations.
+type timeoutRepository struct {
+	repo    AuthzRepository
+	timeout time.Duration
+}
+
+// NewTimeoutRepository wraps repo so that each operation is cancelled after the given timeout,
+// and then fails with ErrQueryTimeout.
+func NewTimeoutRepository(repo AuthzRepository, timeout time.Duration) AuthzRepository {
+	return &timeoutRepository{repo: repo, timeout: timeout}
+}
+
+// withTimeout runs op with a context bounded by the timeout, and reports a deadline hit as ErrQueryTimeout.
+// Drivers do not all wrap context errors (lib/pq reports a cancelled statement), hence the context check.
+func (r *timeoutRepository) withTimeout(ctx context.Context, name string, op func(ctx context.Context) error) error {
+	opCtx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	err := op(opCtx)
+	if err != nil && errors.Is(opCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+		return fmt.Errorf("%w: %s exceeded %v: %v", ErrQueryTimeout, name, r.timeout, err)
+	}
+	return err
+}
+
+func (r *timeoutRepository) InsertBulk(ctx context.Context, relationships []Relationship, policy ConflictPolicy) (rels []Relationship, err error) {
+	err = r.withTimeout(ctx, "InsertBulk", func(ctx context.Context) error {
+		rels, err = r.repo.InsertBulk(ctx, relationships, policy)
+		return err
+	})
+	return rels, err
+}
+
+func (r *timeoutRepository) DeleteBulk(ctx context.Context, relationships []Relationship) (rels []Relationship, err error) {
+	err = r.withTimeout(ctx, "DeleteBulk", func(ctx context.Context) error {
+		rels, err = r.repo.DeleteBulk(ctx, relationships)
+		return err
+	})
+	return rels, err
+}
+
+func (r *timeoutRepository) ListRelationships(ctx context.Context, object Object, subjectTypes []string) (rels []Relationship, err error) {
+	err = r.withTimeout(ctx, "ListRelationships", func(ctx context.Context) error {
+		rels, err = r.repo.ListRelationships(ctx, object, subjectTypes)
+		return err
+	})
+	return rels, err
+}
+
+func (r *timeoutRepository) CountRelationships(ctx context.Context, resource Object, relations []string) (n int64, err error) {
+	err = r.withTimeout(ctx, "CountRelationships", func(ctx context.Context) error {
+		n, err = r.repo.CountRelationships(ctx, resource, relations)
+		return err
+	})
+	return n, err
+}
+
+func (r *timeoutRepository) ObjectExists(ctx context.Context, object Object) (exists bool, err error) {
+	err = r.withTimeout(ctx, "ObjectExists", func(ctx context.Context) error {
+		exists, err = r.repo.ObjectExists(ctx, object)
+		return err
+	})
+	return exists, err
+}
+
+func (r *timeoutRepository) ListSubjects(ctx context.Context, resource Object, relations []string) (subjects []Object, err error) {
+	err = r.withTimeout(ctx, "ListSubjects", func(ctx context.Context) error {
+		subjects, err = r.repo.ListSubjects(ctx, resource, relations)
+		return err
+	})
+	return subjects, err
+}
+
+func (r *timeoutRepository) FindRelationships(ctx context.Context, relationships []Relationship) (rels []Relationship, err error) {
+	err = r.withTimeout(ctx, "FindRelationships", func(ctx context.Context) error {
+		rels, err = r.repo.FindRelationships(ctx, relationships)
+		return err
+	})
+	return rels, err
+}
+
+func (r *timeoutRepository) LockRelationships(ctx context.Context, relationships []Relationship) error {
+	return r.withTimeout(ctx, "LockRelationships", func(ctx context.Context) error {
+		return r.repo.LockRelationships(ctx, relationships)
+	})
+}
+
+func (r *timeoutRepository) ListAllRelationships(ctx context.Context, after *Relationship, limit int) (rels []Relationship, err error) {
+	err = r.withTimeout(ctx, "ListAllRelationships", func(ctx context.Context) error {
+		rels, err = r.repo.ListAllRelationships(ctx, after, limit)
+		return err
+	})
+	return rels, err
+}
+
+func (r *timeoutRepository) ListPaths(ctx context.Context, request TraversalRequest) (items []TraversalResponseItem, err error) {
+	err = r.withTimeout(ctx, "ListPaths", func(ctx context.Context) error {
+		items, err = r.repo.ListPaths(ctx, request)
+		return err
+	})
+	return items, err
+}
+
+func (r *timeoutRepository) ClaimIdempotencyKey(ctx context.Context, key IdempotencyKey, ttl time.Duration) (applied *IdempotentWrite, err error) {
+	err = r.withTimeout(ctx, "ClaimIdempotencyKey", func(ctx context.Context) error {
+		applied, err = r.repo.ClaimIdempotencyKey(ctx, key, ttl)
+		return err
+	})
+	return applied, err
+}
+
+func (r *timeoutRepository) SaveIdempotentResult(ctx context.Context, key IdempotencyKey, result []byte) error {
+	return r.withTimeout(ctx, "SaveIdempotentResult", func(ctx context.Context) error {
+		return r.repo.SaveIdempotentResult(ctx, key, result)
+	})
+}
+
+func (r *timeoutRepository) ConsistencyToken(ctx context.Context) (token string, err error) {
+	err = r.withTimeout(ctx, "ConsistencyToken", func(ctx context.Context) error {
+		token, err = r.repo.ConsistencyToken(ctx)
+		return err
+	})
+	return token, err
+}
+
+func (r *timeoutRepository) IsConsistencyTokenVisible(ctx context.Context, token string) (visible bool, err error) {
+	err = r.withTimeout(ctx, "IsConsistencyTokenVisible", func(ctx context.Context) error {
+		visible, err = r.repo.IsConsistencyTokenVisible(ctx, token)
+		return err
+	})
+	return visible, err
+}
+
+func (r *timeoutRepository) ListChanges(ctx context.Context, since int64, limit int) (changes []RelationshipChange, err error) {
+	err = r.withTimeout(ctx, "ListChanges", func(ctx context.Context) error {
+		changes, err = r.repo.ListChanges(ctx, since, limit)
+		return err
+	})
+	return changes, err
+}
+
+func (r *timeoutRepository) LatestChangeID(ctx context.Context) (id int64, err error) {
+	err = r.withTimeout(ctx, "LatestChangeID", func(ctx context.Context) error {
+		id, err = r.repo.LatestChangeID(ctx)
+		return err
+	})
+	return id, err
+}
+
+func (r *timeoutRepository) HistoryStart(ctx context.Context) (start time.Time, err error) {
+	err = r.withTimeout(ctx, "HistoryStart", func(ctx context.Context) error {
+		start, err = r.repo.HistoryStart(ctx)
+		return err
+	})
+	return start, err
+}
+
+func (r *timeoutRepository) InsertAuditEntries(ctx context.Context, actor, action string, relationships []Relationship) error {
+	return r.withTimeout(ctx, "InsertAuditEntries", func(ctx context.Context) error {
+		return r.repo.InsertAuditEntries(ctx, actor, action, relationships)
+	})
+}
+
+func (r *timeoutRepository) ListAuditEntries(ctx context.Context, filter AuditFilter) (entries []AuditEntry, err error) {
+	err = r.withTimeout(ctx, "ListAuditEntries", func(ctx context.Context) error {
+		entries, err = r.repo.ListAuditEntries(ctx, filter)
+		return err
+	})
+	return entries, err
+}
+
+func (r *timeoutRepository) DeleteExpired(ctx context.Context, before time.Time) (n int64, err error) {
+	err = r.withTimeout(ctx, "DeleteExpired", func(ctx context.Context) error {
+		n, err = r.repo.DeleteExpired(ctx, before)
+		return err
+	})
+	return n, err
+}
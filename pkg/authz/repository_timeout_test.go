@@ -0,0 +1,60 @@
+package authz_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/romrossi/authz-rebac/pkg/authz"
+)
+
+// slowRepository blocks its traversals until their context is done, like a pathological recursive query.
+type slowRepository struct {
+	authz.AuthzRepository
+}
+
+func (r slowRepository) ListPaths(ctx context.Context, request authz.TraversalRequest) ([]authz.TraversalResponseItem, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestTimeoutRepository(t *testing.T) {
+	_, repo := newService(t, authz.LoadMetadata())
+	timeoutRepo := authz.NewTimeoutRepository(slowRepository{repo}, 20*time.Millisecond)
+	request := authz.TraversalRequest{StartOn: obj("project:1"), Forward: true, StopOn: obj("user:alice")}
+
+	start := time.Now()
+	_, err := timeoutRepo.ListPaths(context.Background(), request)
+	if !errors.Is(err, authz.ErrQueryTimeout) {
+		t.Errorf("ListPaths() error = %v, want ErrQueryTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("ListPaths() returned after %v, want at the deadline", elapsed)
+	}
+
+	// A request cancelled by the caller is not a timeout
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := timeoutRepo.ListPaths(ctx, request); !errors.Is(err, context.Canceled) || errors.Is(err, authz.ErrQueryTimeout) {
+		t.Errorf("ListPaths() error = %v, want context.Canceled", err)
+	}
+
+	// Operations within the deadline are unaffected
+	if _, err := timeoutRepo.InsertBulk(context.Background(), []authz.Relationship{rel("project:1", "reader", "user:alice")}, authz.ConflictIgnore); err != nil {
+		t.Errorf("InsertBulk() error = %v, want nil", err)
+	}
+}
+
+func TestQueryTimeoutStatus(t *testing.T) {
+	meta := authz.LoadMetadata()
+	_, repo := newService(t, meta)
+	svc := authz.NewService(authz.NewTimeoutRepository(slowRepository{repo}, 20*time.Millisecond), meta)
+	h := routes(authz.NewAuthzHandler(svc, meta, 0))
+
+	rec := serve(h, "GET", v1Prefix+"/permissions/read?resource=project:1&subject=user:alice", "")
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("status = %d (body: %s), want 504", rec.Code, rec.Body)
+	}
+}
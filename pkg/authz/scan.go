@@ -0,0 +1,166 @@
+package authz
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Scanning helpers shared by the SQL repositories.
+// Each expects the columns in the order documented on it.
+
+// scanRelationships scans rows of (resource_type, resource_id, subject_type, subject_id, relation, expires_at,
+// caveat_name, caveat_context).
+func scanRelationships(rows *sql.Rows) ([]Relationship, error) {
+	var rels []Relationship
+	for rows.Next() {
+		var rel Relationship
+		var expiresAt nullTime
+		var caveatName, caveatContext sql.NullString
+		if err := rows.Scan(&rel.Resource.Type, &rel.Resource.ID, &rel.Subject.Type, &rel.Subject.ID, &rel.Relation, &expiresAt,
+			&caveatName, &caveatContext); err != nil {
+			return nil, err
+		}
+		if expiresAt.Valid {
+			rel.ExpiresAt = &expiresAt.Time
+		}
+		if caveatName.Valid {
+			rel.Caveat = &RelationshipCaveat{Name: caveatName.String}
+			if caveatContext.Valid {
+				if err := json.Unmarshal([]byte(caveatContext.String), &rel.Caveat.Context); err != nil {
+					return nil, fmt.Errorf("invalid caveat context of %s: %w", rel, err)
+				}
+			}
+		}
+		rels = append(rels, rel)
+	}
+	return rels, rows.Err()
+}
+
+// scanObjects scans rows of (type, id) into objects.
+func scanObjects(rows *sql.Rows) ([]Object, error) {
+	var objects []Object
+	for rows.Next() {
+		var obj Object
+		if err := rows.Scan(&obj.Type, &obj.ID); err != nil {
+			return nil, err
+		}
+		objects = append(objects, obj)
+	}
+	return objects, rows.Err()
+}
+
+// caveatValues returns the values of the caveat_name and caveat_context columns of a relationship:
+// NULL for an unconditional relationship, and the context as a JSON object.
+func caveatValues(rel Relationship) (name, context interface{}, err error) {
+	if rel.Caveat == nil {
+		return nil, nil, nil
+	}
+	if rel.Caveat.Context == nil {
+		return rel.Caveat.Name, nil, nil
+	}
+	data, err := json.Marshal(rel.Caveat.Context)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid caveat context of %s: %w", rel, err)
+	}
+	return rel.Caveat.Name, string(data), nil
+}
+
+// scanTraversalItems scans rows of (start_type, start_id, next_type, next_id, paths as a JSON array).
+func scanTraversalItems(rows *sql.Rows, forward bool) ([]TraversalResponseItem, error) {
+	var response []TraversalResponseItem
+	for rows.Next() {
+		var start, stop Object
+		var rawPaths []byte
+		if err := rows.Scan(&start.Type, &start.ID, &stop.Type, &stop.ID, &rawPaths); err != nil {
+			return nil, fmt.Errorf("scan traversal row failed: %w", err)
+		}
+
+		var paths [][]Relationship
+		if err := json.Unmarshal(rawPaths, &paths); err != nil {
+			return nil, err
+		}
+
+		var resource, subject Object
+		if forward {
+			resource = start
+			subject = stop
+		} else {
+			resource = stop
+			subject = start
+		}
+
+		response = append(response, TraversalResponseItem{
+			Resource: resource,
+			Subject:  subject,
+			Paths:    paths,
+		})
+	}
+
+	return response, rows.Err()
+}
+
+// scanChanges scans rows of (id, action, resource_type, resource_id, subject_type, subject_id, relation, changed_at).
+func scanChanges(rows *sql.Rows) ([]RelationshipChange, error) {
+	var changes []RelationshipChange
+	for rows.Next() {
+		var c RelationshipChange
+		rel := &c.Relationship
+		if err := rows.Scan(&c.ID, &c.Action, &rel.Resource.Type, &rel.Resource.ID, &rel.Subject.Type, &rel.Subject.ID, &rel.Relation, &c.ChangedAt); err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
+
+// scanAuditEntries scans rows of (id, actor, action, resource_type, resource_id, subject_type, subject_id, relation, created_at).
+func scanAuditEntries(rows *sql.Rows) ([]AuditEntry, error) {
+	var entries []AuditEntry
+	for rows.Next() {
+		var e AuditEntry
+		rel := &e.Relationship
+		if err := rows.Scan(&e.ID, &e.Actor, &e.Action, &rel.Resource.Type, &rel.Resource.ID, &rel.Subject.Type, &rel.Subject.ID, &rel.Relation, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// nullTime scans a nullable timestamp. Besides time values, it accepts the text encoding
+// of SQLite, whose driver only returns times for columns declared as timestamps.
+type nullTime struct {
+	Time  time.Time
+	Valid bool
+}
+
+// sqliteTimeFormats are the text encodings of timestamps in SQLite: written by the driver, or by CURRENT_TIMESTAMP.
+var sqliteTimeFormats = []string{
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05",
+}
+
+// Scan implements sql.Scanner.
+func (t *nullTime) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		t.Time, t.Valid = time.Time{}, false
+		return nil
+	case time.Time:
+		t.Time, t.Valid = v, true
+		return nil
+	case []byte:
+		return t.Scan(string(v))
+	case string:
+		for _, format := range sqliteTimeFormats {
+			if parsed, err := time.Parse(format, v); err == nil {
+				t.Time, t.Valid = parsed, true
+				return nil
+			}
+		}
+		return fmt.Errorf("invalid timestamp: %q", v)
+	}
+	return fmt.Errorf("cannot scan %T into a timestamp", value)
+}
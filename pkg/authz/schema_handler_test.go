@@ -0,0 +1,157 @@
+package authz_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/romrossi/authz-rebac/pkg/authz"
+)
+
+func TestListObjectTypes(t *testing.T) {
+	h, _, _ := newTestServer(t, authz.LoadMetadata())
+	var types []string
+	decode(t, serve(h, "GET", v1Prefix+"/schema/objects", ""), http.StatusOK, &types)
+	if got := strings.Join(types, ","); got != "application,group,project,user" {
+		t.Errorf("types = %s, want application,group,project,user", got)
+	}
+}
+
+func TestGetObjectDefinition(t *testing.T) {
+	h, _, _ := newTestServer(t, authz.LoadMetadata())
+
+	var def authz.ObjectDefinition
+	decode(t, serve(h, "GET", v1Prefix+"/schema/objects/project", ""), http.StatusOK, &def)
+	if strings.Join(def.Relations["reader"].SubjectTypes, ",") != "user,group" {
+		t.Errorf("reader subject types = %v, want user,group", def.Relations["reader"].SubjectTypes)
+	}
+	if edit := def.Permissions["edit"]; strings.Join(edit.AnyOf, ",") != "administrator,owner" {
+		t.Errorf("edit = %+v, want any of administrator,owner", edit)
+	}
+	if def.PrecedenceRules != nil {
+		t.Errorf("precedence rules = %v, want none without include_rules", def.PrecedenceRules)
+	}
+
+	def = authz.ObjectDefinition{}
+	decode(t, serve(h, "GET", v1Prefix+"/schema/objects/project?include_rules=true", ""), http.StatusOK, &def)
+	if len(def.PrecedenceRules) != 3 {
+		t.Errorf("precedence rules = %v, want 3 with include_rules", def.PrecedenceRules)
+	}
+
+	if rec := serve(h, "GET", v1Prefix+"/schema/objects/folder", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404 for an unknown type", rec.Code)
+	}
+	if rec := serve(h, "GET", v1Prefix+"/schema/objects/project?include_rules=maybe", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400 for an invalid include_rules", rec.Code)
+	}
+}
+
+func TestGetRelationDefinition(t *testing.T) {
+	h, _, _ := newTestServer(t, loadSchema(t, `
+schema_version: "1.0"
+objects:
+  user:
+    relations: {}
+  group:
+    relations:
+      member:
+        subject_types: [user]
+  folder:
+    relations: {}
+  doc:
+    relations:
+      parent:
+        subject_types: [folder, doc]
+      viewer:
+        subject_types: [user, group]
+    aliases:
+      reader: viewer
+    permissions:
+      view:
+        any_of: [viewer]
+`))
+
+	tests := []struct {
+		relation string
+		want     string
+	}{
+		{"parent", "folder,doc"},
+		{"viewer", "user,group"},
+		{"reader", "user,group"}, // alias of viewer
+	}
+	for _, tt := range tests {
+		var def authz.RelationDefinition
+		decode(t, serve(h, "GET", v1Prefix+"/schema/objects/doc/relations/"+tt.relation, ""), http.StatusOK, &def)
+		if got := strings.Join(def.SubjectTypes, ","); got != tt.want {
+			t.Errorf("%s subject types = %s, want %s", tt.relation, got, tt.want)
+		}
+	}
+
+	for _, path := range []string{"/schema/objects/page/relations/parent", "/schema/objects/doc/relations/owner", "/schema/objects/doc/relations/view"} {
+		if rec := serve(h, "GET", v1Prefix+path, ""); rec.Code != http.StatusNotFound {
+			t.Errorf("GET %s: status = %d, want 404", path, rec.Code)
+		}
+	}
+}
+
+func TestValidateSchema(t *testing.T) {
+	h, _, _ := newTestServer(t, authz.LoadMetadata())
+
+	var validation authz.SchemaValidation
+	decode(t, serve(h, "POST", v1Prefix+"/schema/validate", string(authz.Schema)), http.StatusOK, &validation)
+	if !validation.Valid || validation.SchemaVersion != authz.LoadMetadata().SchemaVersion || len(validation.Errors) != 0 {
+		t.Errorf("validation = %+v, want the embedded schema valid", validation)
+	}
+
+	tests := []struct {
+		name   string
+		schema string
+		errors []string
+	}{
+		{
+			name: "several errors",
+			schema: `
+objects:
+  doc:
+    relations:
+      viewer:
+        subject_types: [user, team]
+    permissions:
+      view:
+        any_of: [viewer, editor]
+  user:
+    relations: {}
+`,
+			errors: []string{
+				"schema_version: schema version is required",
+				`objects.doc.relations.viewer.subject_types.1: undefined object type "team"`,
+				`objects.doc.permissions.view.any_of.1: undefined relation or permission "editor"`,
+			},
+		},
+		{
+			name:   "invalid YAML",
+			schema: "objects: [",
+		},
+	}
+	for _, tt := range tests {
+		validation = authz.SchemaValidation{}
+		decode(t, serve(h, "POST", v1Prefix+"/schema/validate", tt.schema), http.StatusUnprocessableEntity, &validation)
+		if validation.Valid || len(validation.Errors) == 0 {
+			t.Errorf("%s: validation = %+v, want errors", tt.name, validation)
+			continue
+		}
+		if tt.errors == nil {
+			if len(validation.Errors) != 1 || validation.Errors[0].Path != nil {
+				t.Errorf("%s: errors = %v, want a single error without path", tt.name, validation.Errors)
+			}
+			continue
+		}
+		var got []string
+		for _, err := range validation.Errors {
+			got = append(got, err.Error())
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.errors, "\n") {
+			t.Errorf("%s: errors =\n%s\nwant\n%s", tt.name, strings.Join(got, "\n"), strings.Join(tt.errors, "\n"))
+		}
+	}
+}
@@ -2,30 +2,113 @@ package authz
 
 import (
 	"context"
+	"crypto/sha256"
 	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
 
+	"github.com/romrossi/authz-rebac/pkg/actor"
 	"github.com/romrossi/authz-rebac/pkg/db"
 )
 
 // AuthzService defines the business logic for authorization operations.
 type AuthzService interface {
 	// CheckPermissions evaluates permissions for a given traversal request.
-	CheckPermissions(ctx context.Context, request TraversalRequest, showMatchingPaths bool) ([]PermissionCheckItem, error)
+	// truncated is true if the traversal discovered more resource-subject pairs than request.MaxResults.
+	CheckPermissions(ctx context.Context, request TraversalRequest, opts CheckOptions) (items []PermissionCheckItem, truncated bool, err error)
 
-	// CreateRelationship inserts multiple relationships.
-	CreateRelationships(ctx context.Context, relationships []Relationship) error
+	// IsPermitted reports whether a permission is granted on the resource of a forward traversal request to its subject,
+	// as CheckPermissions would, but without building evaluations nor matching paths.
+	IsPermitted(ctx context.Context, request TraversalRequest, permission string) (bool, error)
 
-	// DeleteRelationship removes multiple relationships.
-	DeleteRelationships(ctx context.Context, relationships []Relationship) error
+	// CheckRelation checks whether an effective path of a traversal request contains the relation (or one of its
+	// aliases), without evaluating permissions. Matching paths are only returned with showMatchingPaths.
+	CheckRelation(ctx context.Context, request TraversalRequest, relation string, showMatchingPaths bool) (RelationCheck, error)
 
-	// ListRelationships retrieves all relationships of a resource.
-	ListRelationships(ctx context.Context, object Object) ([]Relationship, error)
+	// CountPermitted counts the resource-subject pairs discovered by a traversal request on which a permission is granted.
+	// truncated is true if the traversal discovered more pairs than request.MaxResults: the count is then a lower bound.
+	CountPermitted(ctx context.Context, request TraversalRequest, permission string) (count int, truncated bool, err error)
+
+	// CreateRelationship inserts multiple relationships, and returns how many were inserted or replaced an expired one.
+	CreateRelationships(ctx context.Context, relationships []Relationship) (int64, error)
+
+	// DeleteRelationship removes multiple relationships, and returns how many were removed.
+	DeleteRelationships(ctx context.Context, relationships []Relationship) (int64, error)
+
+	// ApplyRelationships deletes then creates relationships atomically, if the request preconditions hold.
+	// If an idempotency key is given and the actor already applied the same request under it, nothing is done
+	// and the original result is returned, marked replayed; if it applied another request, ErrIdempotencyKeyReused
+	// is returned.
+	// A best-effort request (without preconditions nor idempotency key) is instead applied relationship by relationship,
+	// each validated against the schema, and the result details the outcome of each.
+	ApplyRelationships(ctx context.Context, idempotencyKey string, request WriteRequest) (WriteResult, error)
+
+	// ListRelationships retrieves all relationships of a resource, optionally restricted to some subject types.
+	ListRelationships(ctx context.Context, object Object, subjectTypes []string) ([]Relationship, error)
+
+	// ListSubjects retrieves the subjects directly related to a resource by a relation (or one of its aliases).
+	ListSubjects(ctx context.Context, resource Object, relation string) ([]Object, error)
+
+	// CountRelationships counts the relationships of a resource (no traversal) of a relation (or one of its aliases),
+	// or of any relation if relation is empty.
+	CountRelationships(ctx context.Context, resource Object, relation string) (int64, error)
+
+	// ObjectExists reports whether the object is the resource or the subject of a relationship: objects have no
+	// other record, so one without relationships cannot be told apart from a nonexistent one (e.g. a mistyped ID).
+	ObjectExists(ctx context.Context, object Object) (bool, error)
+
+	// ExportRelationships calls fn with every unexpired relationship, read from a consistent snapshot.
+	// It stops at the first error returned by fn.
+	ExportRelationships(ctx context.Context, fn func(Relationship) error) error
+
+	// MigrateRelationships migrates the relationships orphaned by the change from the previous schema to the service schema:
+	// those using a former relation name, kept as an alias, are rewritten to the relation, and those whose relation,
+	// resource type or subject type was removed are deleted. With dryRun, the orphans are only counted.
+	MigrateRelationships(ctx context.Context, previous Metadata, dryRun bool) (TupleMigration, error)
+
+	// DiagnoseRelationships reports the stored relationships the service schema rejects. With fix, they are deleted.
+	DiagnoseRelationships(ctx context.Context, fix bool) (RelationshipDiagnosis, error)
+
+	// ListAuditEntries retrieves the audit entries selected by a filter, oldest first.
+	// truncated is true if more entries follow the filter.Limit returned (or defaultAuditLimit, if unset).
+	ListAuditEntries(ctx context.Context, filter AuditFilter) (entries []AuditEntry, truncated bool, err error)
+
+	// WatchChanges streams relationship changes with an ID greater than since
+	// (or only future changes if since is negative), until ctx is done.
+	WatchChanges(ctx context.Context, since int64) (<-chan RelationshipChange, error)
 
 	// ListEffectivePaths returns all effective paths discovered during traversal,
 	// reduced according to precedence rules.
-	ListEffectivePaths(ctx context.Context, request TraversalRequest) ([]TraversalResponseItem, error)
+	// truncated is true if the traversal discovered more resource-subject pairs than request.MaxResults.
+	ListEffectivePaths(ctx context.Context, request TraversalRequest) (items []TraversalResponseItem, truncated bool, err error)
 }
 
+// anonymousActor is recorded in the audit log when the request carries no actor.
+const anonymousActor = "anonymous"
+
+// changeBatchSize is the maximum number of changes read at once by WatchChanges.
+const changeBatchSize = 500
+
+// defaultMaxResults is the number of resource-subject pairs a traversal returns when the request sets no limit.
+const defaultMaxResults = 10000
+
+// defaultAuditLimit is the number of audit entries listed when the filter sets no limit.
+const defaultAuditLimit = 1000
+
+// exportPageSize is the number of relationships read at once by ExportRelationships.
+const exportPageSize = 1000
+
+// errDryRun aborts the transaction of a dry run, so that it is rolled back.
+var errDryRun = errors.New("dry run")
+
+// idempotencyKeyTTL is how long an applied idempotency key short-circuits replays.
+const idempotencyKeyTTL = 24 * time.Hour
+
 // serviceImpl implements AuthzService.
 type serviceImpl struct {
 	authzRepo AuthzRepository
@@ -37,23 +120,459 @@ func NewService(authzRepo AuthzRepository, meta Metadata) AuthzService {
 	return &serviceImpl{authzRepo: authzRepo, meta: meta}
 }
 
-// CreateRelationship inserts relationships into the repository within a transaction.
-func (s *serviceImpl) CreateRelationships(ctx context.Context, relationships []Relationship) error {
-	return db.WithTransaction(ctx, func(txCtx context.Context) error {
-		return s.authzRepo.InsertBulk(txCtx, relationships)
+// CreateRelationship inserts relationships into the repository within a transaction, and audits them.
+// Existing relationships are left unchanged, unless they expired (see ConflictIgnore).
+func (s *serviceImpl) CreateRelationships(ctx context.Context, relationships []Relationship) (int64, error) {
+	var created int64
+	err := db.WithTransaction(ctx, func(txCtx context.Context) (err error) {
+		created, err = s.create(txCtx, relationships, ConflictIgnore)
+		return err
 	})
+	return created, err
 }
 
-// DeleteRelationship removes a relationships from the repository within a transaction.
-func (s *serviceImpl) DeleteRelationships(ctx context.Context, relationships []Relationship) error {
-	return db.WithTransaction(ctx, func(txCtx context.Context) error {
-		return s.authzRepo.DeleteBulk(txCtx, relationships)
+// DeleteRelationship removes a relationships from the repository within a transaction, and audits them.
+func (s *serviceImpl) DeleteRelationships(ctx context.Context, relationships []Relationship) (int64, error) {
+	var deleted int64
+	err := db.WithTransaction(ctx, func(txCtx context.Context) (err error) {
+		deleted, err = s.delete(txCtx, relationships)
+		return err
 	})
+	return deleted, err
+}
+
+// create inserts relationships and records those it wrote in the audit log, on behalf of the context actor.
+// Existing relationships are handled according to the conflict policy, enforced by the insert itself
+// (see AuthzRepository.InsertBulk): with ConflictError, the first existing one fails with ErrRelationshipExists.
+// The relationships are locked first, like those of any write (see checkPrecondition).
+// It returns the number of relationships inserted or whose expiry changed.
+func (s *serviceImpl) create(ctx context.Context, relationships []Relationship, policy ConflictPolicy) (int64, error) {
+	if err := s.authzRepo.LockRelationships(ctx, relationships); err != nil {
+		return 0, err
+	}
+	created, err := s.authzRepo.InsertBulk(ctx, relationships, policy)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(created)), s.authzRepo.InsertAuditEntries(ctx, actorFromContext(ctx), "create", created)
+}
+
+// delete removes relationships and records those which existed in the audit log, on behalf of the context actor.
+// The relationships are locked first, like those of any write (see checkPrecondition).
+// It returns the number of relationships removed.
+func (s *serviceImpl) delete(ctx context.Context, relationships []Relationship) (int64, error) {
+	if err := s.authzRepo.LockRelationships(ctx, relationships); err != nil {
+		return 0, err
+	}
+	deleted, err := s.authzRepo.DeleteBulk(ctx, relationships)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(deleted)), s.authzRepo.InsertAuditEntries(ctx, actorFromContext(ctx), "delete", deleted)
+}
+
+// actorFromContext returns the actor carried by the context (see router.Actor), or anonymousActor.
+func actorFromContext(ctx context.Context) string {
+	if name := actor.FromContext(ctx); name != "" {
+		return name
+	}
+	return anonymousActor
+}
+
+// ListAuditEntries retrieves the audit entries selected by a filter from the repository.
+// One more entry than the limit is read to detect truncation.
+func (s *serviceImpl) ListAuditEntries(ctx context.Context, filter AuditFilter) ([]AuditEntry, bool, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultAuditLimit
+	}
+	filter.Limit = limit + 1
+	entries, err := s.authzRepo.ListAuditEntries(ctx, filter)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(entries) > limit {
+		return entries[:limit], true, nil
+	}
+	return entries, false, nil
+}
+
+// ApplyRelationships deletes then creates relationships within a single transaction.
+// The idempotency key (if any) is claimed in the same transaction, so it is recorded only if the writes are applied,
+// with their result. Keys are scoped to the context actor.
+// Preconditions are checked in the same transaction too; if any fails, ErrPreconditionFailed is returned.
+// A dry run ignores the idempotency key, and is rolled back after computing its summary.
+func (s *serviceImpl) ApplyRelationships(
+	ctx context.Context,
+	idempotencyKey string,
+	request WriteRequest,
+) (WriteResult, error) {
+
+	if request.BestEffort {
+		return s.applyEach(ctx, request)
+	}
+
+	var result WriteResult
+	err := db.WithTransaction(ctx, func(txCtx context.Context) error {
+		if request.DryRun {
+			summary, err := s.dryRun(txCtx, request)
+			if err != nil {
+				return err
+			}
+			result.Summary = summary
+			result.Created, result.Deleted = int64(len(summary.Created)), int64(len(summary.Deleted))
+			return errDryRun
+		}
+
+		token, err := s.authzRepo.ConsistencyToken(txCtx)
+		if err != nil {
+			return err
+		}
+		result.ConsistencyToken = token
+
+		key := IdempotencyKey{Actor: actorFromContext(ctx), Key: idempotencyKey, RequestHash: requestHash(request)}
+		if idempotencyKey != "" {
+			applied, err := s.authzRepo.ClaimIdempotencyKey(txCtx, key, idempotencyKeyTTL)
+			if err != nil {
+				return err
+			}
+			if applied != nil {
+				if applied.RequestHash != key.RequestHash {
+					return fmt.Errorf("%w: %s", ErrIdempotencyKeyReused, idempotencyKey)
+				}
+				result = WriteResult{Replayed: true}
+				if err := json.Unmarshal(applied.Result, &result); err != nil {
+					return fmt.Errorf("decode idempotent result failed: %w", err)
+				}
+				return nil
+			}
+		}
+		// All relationships are locked at once, in order: locking the deleted ones, then the created ones, could deadlock
+		if err := s.authzRepo.LockRelationships(txCtx, request.Relationships()); err != nil {
+			return err
+		}
+		if err := s.checkPrecondition(txCtx, request.Precondition); err != nil {
+			return err
+		}
+		if result.Deleted, err = s.delete(txCtx, request.Delete); err != nil {
+			return err
+		}
+		if result.Created, err = s.create(txCtx, request.Create, request.OnConflict); err != nil {
+			return err
+		}
+		if idempotencyKey != "" {
+			data, err := json.Marshal(result)
+			if err != nil {
+				return err
+			}
+			return s.authzRepo.SaveIdempotentResult(txCtx, key, data)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errDryRun) {
+		return WriteResult{}, err
+	}
+	return result, nil
+}
+
+// applyEach deletes then creates the relationships of a best-effort write request one by one, each validated
+// against the schema and written in its own transaction, so that a relationship failing does not roll the others back.
+// The consistency token is that of the last relationship written. Only a canceled context stops the write.
+func (s *serviceImpl) applyEach(ctx context.Context, request WriteRequest) (WriteResult, error) {
+	var result WriteResult
+	now := time.Now()
+	apply := func(operation string, rel Relationship) error {
+		item := WriteItemResult{Operation: operation, Relationship: rel, Status: WriteSucceeded}
+		err := s.meta.IsValidRelation(rel)
+		if err == nil && operation == "create" && rel.ExpiresAt != nil && !rel.ExpiresAt.After(now) {
+			err = fmt.Errorf("expires_at must be in the future: %s", rel)
+		}
+		var n int64
+		var token string
+		if err == nil {
+			err = db.WithTransaction(ctx, func(txCtx context.Context) (err error) {
+				if operation == "delete" {
+					n, err = s.delete(txCtx, []Relationship{rel})
+				} else {
+					n, err = s.create(txCtx, []Relationship{rel}, request.OnConflict)
+				}
+				if err != nil || n == 0 {
+					return err
+				}
+				token, err = s.authzRepo.ConsistencyToken(txCtx)
+				return err
+			})
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+		}
+
+		switch {
+		case err != nil:
+			item.Status, item.Reason = WriteFailed, err.Error()
+		case n == 0 && operation == "delete":
+			item.Status, item.Reason = WriteSkipped, "relationship does not exist"
+		case n == 0:
+			item.Status, item.Reason = WriteSkipped, "relationship already exists"
+		case operation == "delete":
+			result.Deleted += n
+			result.ConsistencyToken = token
+		default:
+			result.Created += n
+			result.ConsistencyToken = token
+		}
+		result.Items = append(result.Items, item)
+		return nil
+	}
+
+	for _, rel := range request.Delete {
+		if err := apply("delete", rel); err != nil {
+			return WriteResult{}, err
+		}
+	}
+	for _, rel := range request.Create {
+		if err := apply("create", rel); err != nil {
+			return WriteResult{}, err
+		}
+	}
+	return result, nil
+}
+
+// requestHash returns the hex SHA-256 of a canonical form of a write request, which identifies its content
+// whatever the formatting of the body it was decoded from, and whatever the object format (see SetObjectFormat):
+// relationships are keyed by their String form, and their expiry normalized to UTC.
+func requestHash(request WriteRequest) string {
+	type canonicalRelationship struct {
+		Key       string              `json:"key"`
+		ExpiresAt *time.Time          `json:"expires_at,omitempty"`
+		Caveat    *RelationshipCaveat `json:"caveat,omitempty"`
+	}
+	canonical := func(relationships []Relationship) []canonicalRelationship {
+		list := make([]canonicalRelationship, len(relationships))
+		for i, rel := range relationships {
+			list[i] = canonicalRelationship{Key: rel.String(), Caveat: rel.Caveat}
+			if rel.ExpiresAt != nil {
+				expiresAt := rel.ExpiresAt.UTC()
+				list[i].ExpiresAt = &expiresAt
+			}
+		}
+		return list
+	}
+	type canonicalPrecondition struct {
+		MustExist    []canonicalRelationship `json:"must_exist"`
+		MustNotExist []canonicalRelationship `json:"must_not_exist"`
+	}
+	body := struct {
+		Delete       []canonicalRelationship `json:"delete"`
+		Create       []canonicalRelationship `json:"create"`
+		Precondition *canonicalPrecondition  `json:"precondition,omitempty"`
+		OnConflict   ConflictPolicy          `json:"on_conflict,omitempty"`
+	}{Delete: canonical(request.Delete), Create: canonical(request.Create), OnConflict: request.OnConflict}
+	if request.Precondition != nil {
+		body.Precondition = &canonicalPrecondition{
+			MustExist:    canonical(request.Precondition.MustExist),
+			MustNotExist: canonical(request.Precondition.MustNotExist),
+		}
+	}
+	data, _ := json.Marshal(body)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// dryRun checks preconditions, summarizes the changes of a write request, then applies them
+// so that any database error surfaces. The caller must roll the transaction back.
+func (s *serviceImpl) dryRun(ctx context.Context, request WriteRequest) (*WriteSummary, error) {
+	if err := s.authzRepo.LockRelationships(ctx, request.Relationships()); err != nil {
+		return nil, err
+	}
+	if err := s.checkPrecondition(ctx, request.Precondition); err != nil {
+		return nil, err
+	}
+
+	found, err := s.authzRepo.FindRelationships(ctx, append(append([]Relationship{}, request.Delete...), request.Create...))
+	if err != nil {
+		return nil, err
+	}
+	exists := make(map[string]bool, len(found))
+	for _, rel := range found {
+		exists[rel.String()] = true
+	}
+
+	// Deletions are applied first, then creations
+	summary := &WriteSummary{}
+	for _, rel := range request.Delete {
+		if exists[rel.String()] {
+			summary.Deleted = append(summary.Deleted, rel)
+			exists[rel.String()] = false
+		} else {
+			summary.NotFound = append(summary.NotFound, rel)
+		}
+	}
+	for _, rel := range request.Create {
+		if exists[rel.String()] {
+			summary.AlreadyExists = append(summary.AlreadyExists, rel)
+		} else {
+			summary.Created = append(summary.Created, rel)
+			exists[rel.String()] = true
+		}
+	}
+
+	if _, err := s.delete(ctx, request.Delete); err != nil {
+		return nil, err
+	}
+	if _, err := s.create(ctx, request.Create, request.OnConflict); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// checkPrecondition verifies that all required relationships exist and all forbidden ones do not.
+// The caller locks the relationships first, until the end of the transaction: under READ COMMITTED, two writes
+// checking that a relationship does not exist could otherwise both see it missing, then both create it.
+// Every write (see create and delete) locks the relationships it writes as well, so that a write without
+// a precondition cannot change them between the check and the end of a conditional write.
+func (s *serviceImpl) checkPrecondition(ctx context.Context, precondition *WritePrecondition) error {
+	if precondition == nil {
+		return nil
+	}
+
+	candidates := append(append([]Relationship{}, precondition.MustExist...), precondition.MustNotExist...)
+	if len(candidates) == 0 {
+		return nil
+	}
+	found, err := s.authzRepo.FindRelationships(ctx, candidates)
+	if err != nil {
+		return err
+	}
+	exists := make(map[string]bool, len(found))
+	for _, rel := range found {
+		exists[rel.String()] = true
+	}
+
+	for _, rel := range precondition.MustExist {
+		if !exists[rel.String()] {
+			return fmt.Errorf("%w: relationship %s does not exist", ErrPreconditionFailed, rel)
+		}
+	}
+	for _, rel := range precondition.MustNotExist {
+		if exists[rel.String()] {
+			return fmt.Errorf("%w: relationship %s exists", ErrPreconditionFailed, rel)
+		}
+	}
+	return nil
 }
 
 // ListRelationships retrieves all relationships from the repository of a resource from the repository.
-func (s *serviceImpl) ListRelationships(ctx context.Context, object Object) ([]Relationship, error) {
-	return s.authzRepo.ListRelationships(ctx, object)
+func (s *serviceImpl) ListRelationships(ctx context.Context, object Object, subjectTypes []string) ([]Relationship, error) {
+	return s.authzRepo.ListRelationships(ctx, object, subjectTypes)
+}
+
+// ListSubjects retrieves the subjects directly related to a resource by a relation,
+// including relationships written under an alias of the relation.
+func (s *serviceImpl) ListSubjects(ctx context.Context, resource Object, relation string) ([]Object, error) {
+	return s.authzRepo.ListSubjects(ctx, resource, s.relationNames(resource.Type, relation))
+}
+
+// CountRelationships counts the relationships of a resource, including those written under an alias of the relation.
+func (s *serviceImpl) CountRelationships(ctx context.Context, resource Object, relation string) (int64, error) {
+	var relations []string
+	if relation != "" {
+		relations = s.relationNames(resource.Type, relation)
+	}
+	return s.authzRepo.CountRelationships(ctx, resource, relations)
+}
+
+// ObjectExists reports whether the object is the resource or the subject of a relationship.
+func (s *serviceImpl) ObjectExists(ctx context.Context, object Object) (bool, error) {
+	return s.authzRepo.ObjectExists(ctx, object)
+}
+
+// relationNames returns the names relationships of a relation of an object type may be stored under:
+// the relation, then its aliases.
+func (s *serviceImpl) relationNames(objectType, relation string) []string {
+	relation = s.meta.CanonicalRelation(objectType, relation)
+	relations := []string{relation}
+	aliases := s.meta.Objects[objectType].Aliases
+	for _, alias := range sortedKeys(aliases) {
+		if aliases[alias] == relation {
+			relations = append(relations, alias)
+		}
+	}
+	return relations
+}
+
+// ExportRelationships pages through all relationships by keyset, within a read-only snapshot transaction.
+func (s *serviceImpl) ExportRelationships(ctx context.Context, fn func(Relationship) error) error {
+	return db.WithSnapshot(ctx, func(txCtx context.Context) error {
+		var after *Relationship
+		for {
+			page, err := s.authzRepo.ListAllRelationships(txCtx, after, exportPageSize)
+			if err != nil {
+				return err
+			}
+			for _, rel := range page {
+				if err := fn(rel); err != nil {
+					return err
+				}
+			}
+			if len(page) < exportPageSize {
+				return nil
+			}
+			after = &page[len(page)-1]
+		}
+	})
+}
+
+// WatchChanges subscribes to change notifications, then reads all changes after the cursor,
+// and again each time it is notified. The returned channel is closed when ctx is done or reading fails.
+func (s *serviceImpl) WatchChanges(ctx context.Context, since int64) (<-chan RelationshipChange, error) {
+	// Subscribe before reading, so that no change committed in between is missed
+	notifications, err := db.Subscribe(ctx, "relationship_change")
+	if err != nil {
+		return nil, err
+	}
+
+	if since < 0 {
+		if since, err = s.authzRepo.LatestChangeID(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	changes := make(chan RelationshipChange)
+	go func() {
+		defer close(changes)
+		for {
+			// Read all pending changes
+			for {
+				batch, err := s.authzRepo.ListChanges(ctx, since, changeBatchSize)
+				if err != nil {
+					if ctx.Err() == nil {
+						log.Printf("[ERROR] serviceImpl.WatchChanges: r.ListChanges failed: %v", err)
+					}
+					return
+				}
+				for _, change := range batch {
+					select {
+					case changes <- change:
+						since = change.ID
+					case <-ctx.Done():
+						return
+					}
+				}
+				if len(batch) < changeBatchSize {
+					break
+				}
+			}
+
+			// Wait for new changes
+			select {
+			case <-notifications:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return changes, nil
 }
 
 // CheckPermissions evaluates permissions for each resource-subject pair
@@ -61,154 +580,831 @@ func (s *serviceImpl) ListRelationships(ctx context.Context, object Object) ([]R
 func (s *serviceImpl) CheckPermissions(
 	ctx context.Context,
 	request TraversalRequest,
-	showMatchingPaths bool,
-) ([]PermissionCheckItem, error) {
+	opts CheckOptions,
+) ([]PermissionCheckItem, bool, error) {
+
+	// Explaining requires the paths eliminated by precedence rules
+	if opts.Explain {
+		request.KeepEliminated = true
+	}
+	request.SkipPrecedence = false // permissions are evaluated on effective paths
+
+	// Evaluating selected permissions only requires following their relevant relations
+	// (pairs only connected through pruned relations are then omitted: the permissions are denied to them)
+	if len(opts.Permissions) > 0 && len(request.Relations) == 0 {
+		resourceType := request.StartOn.Type
+		if !request.Forward {
+			resourceType = request.StopOn.Type
+		}
+		if request.Forward || len(request.StopOnAny) == 0 {
+			request.Relations = s.meta.RelevantRelations(resourceType, opts.Permissions...)
+		}
+	}
 
 	// Step 1: Resolve effective paths according to precedence rules
-	tResponse, err := s.ListEffectivePaths(ctx, request)
+	tResponse, truncated, err := s.ListEffectivePaths(ctx, request)
 	if err != nil {
-		return nil, err
+		return nil, false, err
+	}
+
+	// Permissions granted by default are granted to subjects without paths too: add the pairs of the resource
+	// with the subjects named by the request that no path reaches
+	if request.Forward && request.StartOn.ID != "" && s.meta.Objects[request.StartOn.Type].hasDefaultAllow() {
+		reached := map[Object]bool{}
+		for _, item := range tResponse {
+			reached[item.Subject] = true
+		}
+		for _, target := range request.StopTargets() {
+			if target.ID != "" && !reached[target] {
+				reached[target] = true
+				tResponse = append(tResponse, TraversalResponseItem{Resource: request.StartOn, Subject: target})
+			}
+		}
 	}
 
 	// Step 2: Evaluate all permissions for each resource-subject pair
+	// (stopping as soon as the caller gives up, as there may be many pairs)
 	results := make([]PermissionCheckItem, 0, len(tResponse))
 	for _, item := range tResponse {
+		if err := ctx.Err(); err != nil {
+			return nil, false, err
+		}
 		results = append(results, PermissionCheckItem{
 			Resource:        item.Resource,
 			Subject:         item.Subject,
-			PermissionEvals: s.evaluateAllPermissions(item.Resource, item.Paths, showMatchingPaths),
+			PermissionEvals: s.evaluateAllPermissions(item, opts),
 		})
 	}
-	return results, nil
+	return results, truncated, nil
 }
 
-// evaluateAllPermissions evaluates all permissions defined for a resource type.
-func (s *serviceImpl) evaluateAllPermissions(
-	resource Object,
-	paths [][]Relationship,
+// IsPermitted evaluates a single permission on the pair of a forward traversal request. Paths only need reducing
+// if the resource type has precedence rules or deny overrides, which may eliminate granting paths: otherwise,
+// they are evaluated as traversed, and CheckPermissions does the rest. Only the reduction is skipped:
+// every path relevant to the permission is still loaded and evaluated, rather than stopping at the first path
+// granting it, as a path containing an excluded relation, a missing AllOf entry or a negated entry of the
+// expression may still deny it.
+func (s *serviceImpl) IsPermitted(ctx context.Context, request TraversalRequest, permission string) (bool, error) {
+	if err := request.Validate(); err != nil {
+		return false, err
+	}
+	objDef := s.meta.Objects[request.StartOn.Type]
+	if len(objDef.PrecedenceRules) > 0 || len(objDef.DenyOverrides) > 0 {
+		items, _, err := s.CheckPermissions(ctx, request, CheckOptions{Permissions: []string{permission}})
+		if err != nil || len(items) == 0 {
+			return false, err
+		}
+		return items[0].PermissionEvals[permission].Allowed, nil
+	}
+
+	if len(request.Relations) == 0 {
+		request.Relations = s.meta.RelevantRelations(request.StartOn.Type, permission)
+	}
+	if len(request.Types) == 0 {
+		request.Types = s.meta.TraversableTypes(request.StopTargets(), request.Forward, request.Relations)
+	}
+	// Excluded relations are skipped whatever the name they are written under
+	if len(request.ExcludeRelations) > 0 {
+		request.ExcludeRelations = s.meta.withAliases(request.ExcludeRelations)
+	}
+	if err := s.checkHistory(ctx, request.AsOf); err != nil {
+		return false, err
+	}
+	var items []TraversalResponseItem
+	err := s.withFreshness(ctx, request.AtLeastAsFresh, func(ctx context.Context) error {
+		var err error
+		items, err = s.authzRepo.ListPaths(ctx, request)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+
+	var paths [][]Relationship
+	for _, item := range items {
+		for _, path := range item.Paths {
+			for i := range path {
+				path[i].Relation = s.meta.CanonicalRelation(path[i].Resource.Type, path[i].Relation)
+			}
+		}
+		satisfied, err := s.satisfiedPaths(item.Paths, request.CaveatContext)
+		if err != nil {
+			return false, err
+		}
+		paths = append(paths, satisfied...)
+	}
+	return s.evaluatePermission(request.StartOn, permission, paths, false, 0).Allowed, nil
+}
+
+// CheckRelation searches the relation in the effective paths between the resource and the subject of the request.
+// Paths name relations written under an alias by the relation they stand for (see ListEffectivePaths).
+func (s *serviceImpl) CheckRelation(
+	ctx context.Context,
+	request TraversalRequest,
+	relation string,
 	showMatchingPaths bool,
+) (RelationCheck, error) {
+
+	request.SkipPrecedence = false // relations are searched in effective paths
+	items, _, err := s.ListEffectivePaths(ctx, request)
+	if err != nil {
+		return RelationCheck{}, err
+	}
+	var check RelationCheck
+	for _, item := range items {
+		for _, path := range item.Paths {
+			if !s.pathContainsRelation(path, relation) {
+				continue
+			}
+			check.Related = true
+			if !showMatchingPaths {
+				return check, nil
+			}
+			check.MatchingPaths = append(check.MatchingPaths, path)
+		}
+	}
+	return check, nil
+}
+
+// pathContainsRelation reports whether the path includes the relation, named by its alias on the relationship's
+// resource type or not.
+func (s *serviceImpl) pathContainsRelation(path []Relationship, relation string) bool {
+	for _, r := range path {
+		if r.Relation == s.meta.CanonicalRelation(r.Resource.Type, relation) {
+			return true
+		}
+	}
+	return false
+}
+
+// CountPermitted counts the resource-subject pairs on which the permission is granted,
+// evaluating only this permission (which restricts the traversal to its relevant relations).
+func (s *serviceImpl) CountPermitted(ctx context.Context, request TraversalRequest, permission string) (int, bool, error) {
+	items, truncated, err := s.CheckPermissions(ctx, request, CheckOptions{Permissions: []string{permission}})
+	if err != nil {
+		return 0, false, err
+	}
+	count := 0
+	for _, item := range items {
+		if item.PermissionEvals[permission].Allowed {
+			count++
+		}
+	}
+	return count, truncated, nil
+}
+
+// evaluateAllPermissions evaluates all permissions defined for the resource type of a traversal item,
+// or only the permissions selected by the options (those undefined on the type are skipped).
+func (s *serviceImpl) evaluateAllPermissions(
+	item TraversalResponseItem,
+	opts CheckOptions,
 ) map[string]PermissionEval {
 
-	perms := s.meta.Objects[resource.Type].Permissions
-	evals := make(map[string]PermissionEval, len(perms))
+	perms := s.meta.Objects[item.Resource.Type].Permissions
+	names := sortedKeys(perms)
+	if len(opts.Permissions) > 0 {
+		names = nil
+		for _, name := range opts.Permissions {
+			if _, ok := perms[name]; ok {
+				names = append(names, name)
+			}
+		}
+	}
+	evals := make(map[string]PermissionEval, len(names))
 
-	for name, def := range perms {
-		evals[name] = s.evaluatePermission(def, paths, showMatchingPaths)
+	for _, name := range names {
+		eval := s.evaluatePermission(item.Resource, name, item.Paths, opts.ShowMatchingPaths, opts.MaxPaths)
+		if opts.Explain {
+			eval.Explanation = s.explainPermission(item.Resource, name, item.Paths, item.EliminatedPaths)
+		}
+		if opts.ShowReason && !eval.Allowed {
+			eval.Reason = s.denyReason(item.Resource, name, item.Paths)
+		}
+		evals[name] = eval
 	}
 	return evals
 }
 
-// evaluatePermission checks whether a single permission is allowed,
-// based on the given traversal paths and permission definition.
+// evaluatePermission checks whether a single permission is allowed on a resource,
+// based on the given traversal paths (from the resource to a subject) and the permission definitions.
 //
 // Rules:
-//  1. If any path contains an excluded relation (Except), deny immediately.
-//  2. If any path contains an allowed relation (AnyOf), grant permission.
-//     - If showMatchingPaths is true, collect all matching paths.
-//     - Otherwise, return after the first match.
+//  1. If any path contains an excluded relation (Except, or a deny override of the type), deny immediately.
+//  2. Deny unless every AllOf entry is granted, and the expression holds (see matchExpr):
+//     the paths matching them are then matching paths too.
+//  3. A permission whose default decision is "allow" is then granted. So is a permission without AnyOf entries,
+//     if an AllOf entry or a non-negated entry of its expression is granted: a permission is never granted
+//     only by what the subject lacks (e.g. {not: suspended}), as it would then be granted to any subject
+//     without paths, which checks do not list.
+//     Otherwise, AnyOf entries are evaluated in order, and the first one granted grants the permission.
+//     Entries of AnyOf and AllOf are granted the same way:
+//     - an entry naming another permission of the resource type is granted if that permission is,
+//     evaluated recursively with its own rules (a permission within a cycle is denied);
+//     - an entry "relation->permission" is granted if the permission is, on an object the resource
+//     is related to by the relation (see arrowMatches);
+//     - otherwise, the entry is a relation, granted if any path contains it.
+//     If showMatchingPaths is true, all entries are evaluated to collect all matching paths,
+//     up to maxPaths if positive (the evaluation is then marked truncated).
+//     Otherwise, return after the first match.
 func (s *serviceImpl) evaluatePermission(
-	permission PermissionDefinition,
+	resource Object,
+	permission string,
 	paths [][]Relationship,
 	showMatchingPaths bool,
+	maxPaths int,
 ) PermissionEval {
 
-	eval := PermissionEval{Allowed: false}
+	permissions := s.meta.Objects[resource.Type].Permissions
+	visiting := map[string]bool{}
+	var evaluate func(permission string) PermissionEval
 
-	// Rule 1: deny if any excluded relation is found
-	for _, except := range permission.Except {
+	// match reports whether an AnyOf or AllOf entry is granted, with the paths matching it
+	// (the first one only for a relation, unless showMatchingPaths is true) and whether they are truncated.
+	match := func(entry string) (bool, [][]Relationship, bool) {
+		if _, ok := permissions[entry]; ok {
+			nested := evaluate(entry)
+			return nested.Allowed, nested.MatchingPaths, nested.PathsTruncated
+		}
+		if relation, targetPermission, ok := parseArrow(entry); ok {
+			matching := s.arrowMatches(resource, relation, targetPermission, paths)
+			return len(matching) > 0, matching, false
+		}
+		var matching [][]Relationship
 		for _, path := range paths {
-			if pathContains(path, except) {
+			if pathContains(path, entry) {
+				matching = append(matching, path)
+				if !showMatchingPaths {
+					break // one path is enough to grant the permission
+				}
+			}
+		}
+		return len(matching) > 0, matching, false
+	}
+
+	evaluate = func(permission string) PermissionEval {
+		eval := PermissionEval{Allowed: false}
+		def, ok := permissions[permission]
+		if !ok || visiting[permission] {
+			return eval
+		}
+		visiting[permission] = true
+		defer delete(visiting, permission)
+
+		// Rule 1: deny if any excluded relation is found
+		for _, except := range s.meta.Objects[resource.Type].excludedRelations(permission) {
+			for _, path := range paths {
+				if pathContains(path, except) {
+					return eval
+				}
+			}
+		}
+
+		// Rule 2: deny unless every required permission or relation is found
+		var required [][]Relationship
+		for _, allOf := range def.AllOf {
+			granted, matching, truncated := match(allOf)
+			if !granted {
+				return PermissionEval{Allowed: false}
+			}
+			required = append(required, matching...)
+			eval.PathsTruncated = eval.PathsTruncated || truncated
+		}
+		grantedByRequirements := len(def.AllOf) > 0
+		if def.Expr != nil {
+			exprMatch := matchExpr(*def.Expr, match, showMatchingPaths)
+			if !exprMatch.holds {
+				return PermissionEval{Allowed: false}
+			}
+			grantedByRequirements = grantedByRequirements || exprMatch.positive
+			required = append(required, exprMatch.paths...)
+			eval.PathsTruncated = eval.PathsTruncated || exprMatch.truncated
+		}
+
+		// Rule 3: allow by default if the permission is public, or if it only has requirements (AllOf, Expr)
+		// granting it positively, or else if any required permission or relation is found
+		if def.DefaultDecision == DecisionAllow || (len(def.AnyOf) == 0 && grantedByRequirements) {
+			eval.Allowed = true
+			if !showMatchingPaths {
 				return eval
 			}
 		}
+		for _, anyOf := range def.AnyOf {
+			granted, matching, truncated := match(anyOf)
+			if !granted {
+				continue
+			}
+
+			eval.Allowed = true
+			eval.PathsTruncated = eval.PathsTruncated || truncated
+			if !showMatchingPaths {
+				return eval // return early if paths are not needed
+			}
+			eval.MatchingPaths = uniquePaths(append(eval.MatchingPaths, matching...)) // a path may match several entries
+			if maxPaths > 0 && len(eval.MatchingPaths) > maxPaths {
+				break // remaining entries could only add paths
+			}
+		}
+		if !eval.Allowed {
+			return PermissionEval{Allowed: false}
+		}
+		if len(required) > 0 {
+			eval.MatchingPaths = uniquePaths(append(required, eval.MatchingPaths...))
+		}
+		if maxPaths > 0 && len(eval.MatchingPaths) > maxPaths {
+			eval.MatchingPaths = eval.MatchingPaths[:maxPaths]
+			eval.PathsTruncated = true
+		}
+		return eval
 	}
+	return evaluate(permission)
+}
 
-	// Rule 2: allow if any required relation is found
-	for _, anyOf := range permission.AnyOf {
+// exprMatch is the result of matching a permission expression (see matchExpr).
+type exprMatch struct {
+	holds     bool             // the expression holds
+	positive  bool             // an entry holding it is granted, not only negated: see evaluatePermission
+	paths     [][]Relationship // paths matching its granted entries (none under a not)
+	truncated bool             // the paths of an entry are truncated
+}
+
+// matchExpr matches an expression, given match, which evaluates its entries (see evaluatePermission).
+// Unless showMatchingPaths is true, an or node returns after its first operand holding positively.
+func matchExpr(expr PermissionExpr, match func(entry string) (bool, [][]Relationship, bool), showMatchingPaths bool) exprMatch {
+	switch {
+	case expr.Ref != "":
+		granted, paths, truncated := match(expr.Ref)
+		return exprMatch{holds: granted, positive: granted, paths: paths, truncated: truncated}
+	case expr.Not != nil:
+		return exprMatch{holds: !matchExpr(*expr.Not, match, showMatchingPaths).holds}
+	case expr.And != nil:
+		result := exprMatch{holds: true}
+		for _, operand := range expr.And {
+			operandMatch := matchExpr(operand, match, showMatchingPaths)
+			if !operandMatch.holds {
+				return exprMatch{}
+			}
+			result.positive = result.positive || operandMatch.positive
+			result.paths = append(result.paths, operandMatch.paths...)
+			result.truncated = result.truncated || operandMatch.truncated
+		}
+		return result
+	}
+	var result exprMatch
+	for _, operand := range expr.Or {
+		operandMatch := matchExpr(operand, match, showMatchingPaths)
+		if !operandMatch.holds {
+			continue
+		}
+		result.holds = true
+		result.positive = result.positive || operandMatch.positive
+		result.paths = append(result.paths, operandMatch.paths...)
+		result.truncated = result.truncated || operandMatch.truncated
+		if !showMatchingPaths && result.positive {
+			break
+		}
+	}
+	return result
+}
+
+// denyReason returns the reason code of a permission denied on a resource with the given traversal paths:
+// no path, a path containing a relation the permission excludes, or else no path granting it.
+func (s *serviceImpl) denyReason(resource Object, permission string, paths [][]Relationship) string {
+	if len(paths) == 0 {
+		return DenyReasonNoPath
+	}
+	for _, except := range s.meta.Objects[resource.Type].excludedRelations(permission) {
 		for _, path := range paths {
-			if pathContains(path, anyOf) {
-				eval.Allowed = true
-				if showMatchingPaths {
-					eval.MatchingPaths = append(eval.MatchingPaths, path)
-				} else {
-					return eval // return early if paths are not needed
+			if pathContains(path, except) {
+				return DenyReasonExcluded
+			}
+		}
+	}
+	return DenyReasonNoMatchingRelation
+}
+
+// arrowMatches returns the paths granting "relation->permission" on a resource:
+// paths whose step from the resource follows the relation to a target object,
+// and whose rest (from the target to the subject) grants the permission on the target.
+// Rests are shorter than their paths, which bounds the recursion through hierarchies (e.g. parent->view).
+// Paths may be ordered from the resource (forward traversals) or from the subject (backward traversals).
+func (s *serviceImpl) arrowMatches(resource Object, relation, permission string, paths [][]Relationship) [][]Relationship {
+	// Group the rests of paths by target, remembering the path of each rest
+	var targets []Object
+	rests := map[Object][][]Relationship{}
+	fullPaths := map[Object]map[string][]Relationship{}
+	for _, path := range paths {
+		target, rest, ok := splitPath(path, resource, relation)
+		if !ok || len(rest) == 0 {
+			continue
+		}
+		if _, ok := rests[target]; !ok {
+			targets = append(targets, target)
+			fullPaths[target] = map[string][]Relationship{}
+		}
+		rests[target] = append(rests[target], rest)
+		fullPaths[target][pathKey(rest)] = path
+	}
+
+	var matching [][]Relationship
+	for _, target := range targets {
+		if _, ok := s.meta.Objects[target.Type].Permissions[permission]; !ok {
+			continue // the permission is not defined on every type the relation leads to
+		}
+		eval := s.evaluatePermission(target, permission, rests[target], true, 0)
+		if eval.Allowed && len(eval.MatchingPaths) == 0 {
+			eval.MatchingPaths = rests[target] // granted by default: every path to the target grants it
+		}
+		for _, rest := range eval.MatchingPaths {
+			matching = append(matching, fullPaths[target][pathKey(rest)])
+		}
+	}
+	return matching
+}
+
+// splitPath splits a path at its step from the resource, if that step follows the given relation:
+// it returns the object reached by the step, and the rest of the path, from that object to the subject.
+func splitPath(path []Relationship, resource Object, relation string) (Object, []Relationship, bool) {
+	if len(path) == 0 {
+		return Object{}, nil, false
+	}
+	if first := path[0]; first.Resource == resource && first.Relation == relation {
+		return first.Subject, path[1:], true
+	}
+	if last := path[len(path)-1]; last.Resource == resource && last.Relation == relation {
+		return last.Subject, path[:len(path)-1], true
+	}
+	return Object{}, nil, false
+}
+
+// explainPermission describes how a permission is evaluated on the given traversal paths:
+// the relations and permissions searched, the exclusion that denied it (if any), the matching paths,
+// and the paths previously eliminated by precedence rules.
+// It mirrors the rules of evaluatePermission but never returns early:
+// the paths matched by a searched permission are its matching paths.
+func (s *serviceImpl) explainPermission(
+	resource Object,
+	permission string,
+	paths [][]Relationship,
+	eliminated []EliminatedPath,
+) *PermissionExplanation {
+
+	permissions := s.meta.Objects[resource.Type].Permissions
+	def := permissions[permission]
+	explanation := &PermissionExplanation{
+		SearchedRelations: def.AnyOf,
+		RequiredRelations: def.AllOf,
+		EliminatedPaths:   eliminated,
+	}
+
+	// Rule 1: the first excluded relation found denies the permission
+	for _, except := range s.meta.Objects[resource.Type].excludedRelations(permission) {
+		for _, path := range paths {
+			if pathContains(path, except) {
+				explanation.ExcludingPaths = append(explanation.ExcludingPaths, path)
+			}
+		}
+		if len(explanation.ExcludingPaths) > 0 {
+			explanation.ExcludedBy = except
+			break
+		}
+	}
+
+	// Rules 2 and 3: collect every path containing a searched or required relation, or matching a searched or
+	// required permission, and name the first required entry not granted
+	matchEntry := func(entry string) (bool, [][]Relationship, bool) {
+		var matching [][]Relationship
+		if _, ok := permissions[entry]; ok {
+			if entry == permission {
+				return false, nil, false
+			}
+			nested := s.evaluatePermission(resource, entry, paths, true, 0)
+			return nested.Allowed, nested.MatchingPaths, false
+		}
+		if relation, targetPermission, ok := parseArrow(entry); ok {
+			matching = s.arrowMatches(resource, relation, targetPermission, paths)
+		} else {
+			for _, path := range paths {
+				if pathContains(path, entry) {
+					matching = append(matching, path)
 				}
 			}
 		}
+		return len(matching) > 0, matching, false
+	}
+	matched := map[string]bool{}
+	for i, entry := range def.entries() {
+		granted, matching, _ := matchEntry(entry)
+		if !granted && i >= len(def.AnyOf) && explanation.MissingRequired == "" {
+			explanation.MissingRequired = entry
+		}
+		for _, path := range matching {
+			matched[pathKey(path)] = true
+		}
+	}
+	if def.Expr != nil {
+		exprMatch := matchExpr(*def.Expr, matchEntry, true)
+		explanation.ExpressionHolds = &exprMatch.holds
+		for _, path := range exprMatch.paths {
+			matched[pathKey(path)] = true
+		}
+	}
+	for _, path := range paths {
+		if matched[pathKey(path)] {
+			explanation.MatchedPaths = append(explanation.MatchedPaths, path)
+		}
 	}
 
-	return eval
+	return explanation
+}
+
+// checkHistory checks that the relationship history covers the time of a check as of a past time (if any):
+// relationships are only recorded from its start on, so that an earlier check could not be answered.
+func (s *serviceImpl) checkHistory(ctx context.Context, asOf time.Time) error {
+	if asOf.IsZero() {
+		return nil
+	}
+	start, err := s.authzRepo.HistoryStart(ctx)
+	if err != nil {
+		return err
+	}
+	if asOf.Before(start) {
+		return fmt.Errorf("%w: as of %s, before the start of the history (%s)",
+			ErrHistoryUnavailable, asOf.UTC().Format(time.RFC3339Nano), start.UTC().Format(time.RFC3339Nano))
+	}
+	return nil
 }
 
 // ListEffectivePaths reduces all traversal paths by applying precedence rules (see schema.yaml)
-// If multiple paths are equally effective, all are kept.
-func (s *serviceImpl) ListEffectivePaths(ctx context.Context, request TraversalRequest) ([]TraversalResponseItem, error) {
-	// Get all paths
-	tResponse, err := s.authzRepo.ListPaths(ctx, request)
+// At most request.MaxResults (or defaultMaxResults) items are returned: one more is read to detect truncation.
+// If multiple paths are equally effective, all are kept; with request.SkipPrecedence, every path is.
+// A request without a starting object ID fails with ErrInvalidTraversal (see TraversalRequest.Validate).
+func (s *serviceImpl) ListEffectivePaths(ctx context.Context, request TraversalRequest) ([]TraversalResponseItem, bool, error) {
+	if err := request.Validate(); err != nil {
+		return nil, false, err
+	}
+
+	maxResults := request.MaxResults
+	if maxResults <= 0 {
+		maxResults = defaultMaxResults
+	}
+	request.MaxResults = maxResults + 1
+
+	// Prune the objects which cannot lead to a stopping object
+	if len(request.Types) == 0 {
+		request.Types = s.meta.TraversableTypes(request.StopTargets(), request.Forward, request.Relations)
+	}
+	// Excluded relations are skipped whatever the name they are written under
+	if len(request.ExcludeRelations) > 0 {
+		request.ExcludeRelations = s.meta.withAliases(request.ExcludeRelations)
+	}
+
+	// Get all paths, from data at least as fresh as requested (or as of the requested time)
+	if err := s.checkHistory(ctx, request.AsOf); err != nil {
+		return nil, false, err
+	}
+	var tResponse []TraversalResponseItem
+	err := s.withFreshness(ctx, request.AtLeastAsFresh, func(ctx context.Context) error {
+		var err error
+		tResponse, err = s.authzRepo.ListPaths(ctx, request)
+		return err
+	})
 	if err != nil {
-		return nil, err
+		return nil, false, err
+	}
+	truncated := len(tResponse) > maxResults
+	if truncated {
+		tResponse = tResponse[:maxResults]
 	}
 
-	// Get precedence rules from metadata
-	precedenceRules := s.meta.Objects[request.StartOn.Type].PrecedenceRules
+	// drop paths whose caveats are not satisfied, and pairs left without paths;
+	// relations written under an alias are renamed to the relation they stand for
+	items := tResponse[:0]
+	for _, item := range tResponse {
+		if err := ctx.Err(); err != nil {
+			return nil, false, err
+		}
+		for _, path := range item.Paths {
+			for i := range path {
+				path[i].Relation = s.meta.CanonicalRelation(path[i].Resource.Type, path[i].Relation)
+			}
+		}
+		paths, err := s.satisfiedPaths(item.Paths, request.CaveatContext)
+		if err != nil {
+			return nil, false, err
+		}
+		if len(paths) > 0 {
+			item.Paths = paths
+			items = append(items, item)
+		}
+	}
+	tResponse = items
 
-	// apply precedence rules to keep only effective paths
+	// apply precedence rules of the resource type to keep only effective paths, unless skipped
+	// (the resource is the start object only when traversing forward)
 	for i := range tResponse {
-		tResponse[i].Paths = effectivePaths(tResponse[i].Paths, precedenceRules)
+		if request.SkipPrecedence {
+			tResponse[i].Paths = uniquePaths(tResponse[i].Paths)
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, false, err
+		}
+		objDef := s.meta.Objects[tResponse[i].Resource.Type]
+		paths, denied := denyingPaths(uniquePaths(tResponse[i].Paths), objDef.DenyOverrides)
+		paths, eliminated := effectivePaths(paths, objDef.PrecedenceRules, objDef.PrecedenceMode)
+		eliminated = append(denied, eliminated...)
+		tResponse[i].Paths = paths
+		if request.KeepEliminated {
+			tResponse[i].EliminatedPaths = eliminated
+		}
 	}
-	return tResponse, nil
+	return tResponse, truncated, nil
+}
+
+// satisfiedPaths returns the paths whose caveated relationships all have a caveat evaluating to true
+// with the given context. It returns an error wrapping ErrCaveatContext if a caveat cannot be evaluated.
+func (s *serviceImpl) satisfiedPaths(paths [][]Relationship, context map[string]interface{}) ([][]Relationship, error) {
+	satisfied := paths[:0:0]
+	for _, path := range paths {
+		ok := true
+		for _, rel := range path {
+			if rel.Caveat == nil {
+				continue
+			}
+			allowed, err := s.meta.EvaluateCaveat(*rel.Caveat, context)
+			if err != nil {
+				return nil, fmt.Errorf("%w (relationship %s)", err, rel)
+			}
+			if !allowed {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			satisfied = append(satisfied, path)
+		}
+	}
+	return satisfied, nil
+}
+
+// withFreshness runs fn, ensuring it reads data at least as fresh as the consistency token (if any).
+// The token is checked within a transaction, whose later statements see at least the same data.
+// If the token's transaction is not visible, ErrStaleRead is returned.
+func (s *serviceImpl) withFreshness(ctx context.Context, token string, fn func(ctx context.Context) error) error {
+	if token == "" {
+		return fn(ctx)
+	}
+	return db.WithTransaction(ctx, func(txCtx context.Context) error {
+		visible, err := s.authzRepo.IsConsistencyTokenVisible(txCtx, token)
+		if err != nil {
+			return err
+		}
+		if !visible {
+			return fmt.Errorf("%w: token %s", ErrStaleRead, token)
+		}
+		return fn(txCtx)
+	})
+}
+
+// uniquePaths removes the duplicates of paths made of the same relationships in the same order,
+// which the traversal may return when a node is reached several times. The first occurrence is kept.
+func uniquePaths(paths [][]Relationship) [][]Relationship {
+	seen := make(map[string]struct{}, len(paths))
+	unique := paths[:0:0]
+	for _, p := range paths {
+		key := pathKey(p)
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		unique = append(unique, p)
+	}
+	return unique
+}
+
+// pathKey returns the canonical key of a path: its ordered relationships (expiry excluded).
+func pathKey(path []Relationship) string {
+	var key strings.Builder
+	for _, r := range path {
+		key.WriteString(r.String())
+		key.WriteByte(0)
+	}
+	return key.String()
+}
+
+// denyingPaths returns the paths containing a deny override relation if there is any, eliminating the others
+// by a "deny_overrides" rule naming the relation of the first denying path; or else all paths.
+func denyingPaths(paths [][]Relationship, denyOverrides []string) ([][]Relationship, []EliminatedPath) {
+	var denying, others [][]Relationship
+	rule := PrecedenceRule{Rule: ruleDenyOverrides}
+	for _, path := range paths {
+		relation := firstContained(path, denyOverrides)
+		if relation == "" {
+			others = append(others, path)
+			continue
+		}
+		if len(denying) == 0 {
+			rule.Relation = relation
+		}
+		denying = append(denying, path)
+	}
+	if len(denying) == 0 {
+		return paths, nil
+	}
+	eliminated := make([]EliminatedPath, 0, len(others))
+	for _, path := range others {
+		eliminated = append(eliminated, EliminatedPath{Path: path, Rule: rule})
+	}
+	return denying, eliminated
 }
 
 // effectivePaths filters paths down to only the most effective ones
-// according to the precedence rules defined in compare.
-func effectivePaths(paths [][]Relationship, rules []PrecedenceRule) [][]Relationship {
+// according to the precedence rules, combined as defined in compare (or compareWeighted in weighted mode).
+// It also returns the discarded paths, each with the rule that discarded it.
+func effectivePaths(paths [][]Relationship, rules []PrecedenceRule, mode string) ([][]Relationship, []EliminatedPath) {
 	if len(paths) == 0 {
-		return nil
+		return nil, nil
+	}
+	compareFn := compare
+	if mode == precedenceWeighted {
+		compareFn = compareWeighted
 	}
 
 	effective := [][]Relationship{paths[0]}
+	var eliminated []EliminatedPath
 	for _, p := range paths[1:] {
-		switch cmp := compare(p, effective[0], rules); {
+		switch cmp, rule := compareFn(p, effective[0], rules); {
 		case cmp < 0:
-			effective = [][]Relationship{p} // found a better path -> reset
+			// found a better path -> reset
+			for _, e := range effective {
+				eliminated = append(eliminated, EliminatedPath{Path: e, Rule: rule})
+			}
+			effective = [][]Relationship{p}
 		case cmp == 0:
 			effective = append(effective, p) // equally effective -> keep
+		default:
+			eliminated = append(eliminated, EliminatedPath{Path: p, Rule: rule})
 		}
 	}
-	return effective
+	return effective, eliminated
 }
 
 // compare returns:
 //   - negative if a is more effective than b
 //   - zero if equally effective
 //   - positive if a is less effective than b
-func compare(a, b []Relationship, rules []PrecedenceRule) int {
+//
+// along with the rule that differentiated a and b (zero value if equally effective).
+func compare(a, b []Relationship, rules []PrecedenceRule) (int, PrecedenceRule) {
 	for _, rule := range rules {
-		switch rule.Rule {
-		case "path_with":
-			aHas, bHas := pathContains(a, rule.Relation), pathContains(b, rule.Relation)
-			if aHas != bHas {
-				if aHas {
-					return -1
-				}
-				return 1
-			}
-		case "path_without":
-			aHas, bHas := pathContains(a, rule.Relation), pathContains(b, rule.Relation)
-			if aHas != bHas {
-				if !aHas {
-					return -1
-				}
-				return 1
-			}
-		case "path_with_fewer":
-			aCount, bCount := pathCount(a, rule.Relation), pathCount(b, rule.Relation)
-			if aCount != bCount {
-				return aCount - bCount
-			}
+		if diff := penalty(a, rule) - penalty(b, rule); diff != 0 {
+			return diff, rule
+		}
+	}
+	return 0, PrecedenceRule{} // equally effective if all rules exhausted
+}
+
+// compareWeighted compares paths like compare, by their total penalty over all rules, each weighted.
+// The differentiating rule is the one contributing most to the difference.
+func compareWeighted(a, b []Relationship, rules []PrecedenceRule) (int, PrecedenceRule) {
+	diffs := make([]int, len(rules))
+	total := 0
+	for i, rule := range rules {
+		weight := rule.Weight
+		if weight == 0 {
+			weight = 1
 		}
+		diffs[i] = weight * (penalty(a, rule) - penalty(b, rule))
+		total += diffs[i]
 	}
-	return 0 // equally effective if all rules exhausted
+	if total == 0 {
+		return 0, PrecedenceRule{}
+	}
+	decisive, largest := PrecedenceRule{}, 0
+	for i, rule := range rules {
+		if contribution := diffs[i] * total; contribution > largest {
+			decisive, largest = rule, contribution
+		}
+	}
+	return total, decisive
+}
+
+// penalty returns how much a rule disfavors a path: 1 if it lacks a relation required by "path_with"
+// or contains one avoided by "path_without", the number of occurrences for "path_with_fewer", and else 0.
+func penalty(path []Relationship, rule PrecedenceRule) int {
+	switch rule.Rule {
+	case rulePathWith:
+		if !pathContains(path, rule.Relation) {
+			return 1
+		}
+	case rulePathWithout:
+		if pathContains(path, rule.Relation) {
+			return 1
+		}
+	case rulePathWithFewer:
+		return pathCount(path, rule.Relation)
+	}
+	return 0
 }
 
 // pathContains reports whether the path includes a relation with the given label.
@@ -221,6 +1417,16 @@ func pathContains(path []Relationship, relation string) bool {
 	return false
 }
 
+// firstContained returns the first of the relations the path includes, or "" if it includes none.
+func firstContained(path []Relationship, relations []string) string {
+	for _, relation := range relations {
+		if pathContains(path, relation) {
+			return relation
+		}
+	}
+	return ""
+}
+
 // pathCount returns the number of times a relation appears in the path.
 func pathCount(path []Relationship, relation string) int {
 	count := 0
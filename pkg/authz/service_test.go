@@ -0,0 +1,1145 @@
+package authz_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/romrossi/authz-rebac/pkg/actor"
+	"github.com/romrossi/authz-rebac/pkg/authz"
+)
+
+// check evaluates permissions of a subject on a resource, failing the test if the evaluation fails.
+func check(t *testing.T, svc authz.AuthzService, resource, subject string, opts authz.CheckOptions) map[string]authz.PermissionEval {
+	t.Helper()
+	request := authz.TraversalRequest{StartOn: obj(resource), Forward: true, StopOn: obj(subject)}
+	items, _, err := svc.CheckPermissions(context.Background(), request, opts)
+	if err != nil {
+		t.Fatalf("check %s on %s failed: %v", subject, resource, err)
+	}
+	if len(items) != 1 {
+		t.Fatalf("check %s on %s returned %d items, want 1", subject, resource, len(items))
+	}
+	return items[0].PermissionEvals
+}
+
+func TestExplainIdentifiesExcludingRelation(t *testing.T) {
+	svc, repo := newService(t, authz.LoadMetadata())
+	seedRelations(t, repo,
+		rel("project:1", "reader", "group:eng"),
+		rel("group:eng", "member", "user:alice"),
+		rel("project:1", "forbidden", "user:alice"),
+	)
+
+	eval := check(t, svc, "project:1", "user:alice", authz.CheckOptions{Explain: true, Permissions: []string{"read"}})["read"]
+	if eval.Allowed {
+		t.Fatal("read allowed, want denied")
+	}
+	explanation := eval.Explanation
+	if explanation == nil {
+		t.Fatal("no explanation")
+	}
+	if explanation.ExcludedBy != "forbidden" {
+		t.Errorf("excluded_by = %q, want forbidden", explanation.ExcludedBy)
+	}
+	if len(explanation.ExcludingPaths) != 1 || explanation.ExcludingPaths[0][0].Relation != "forbidden" {
+		t.Errorf("excluding_paths = %v, want the forbidden relationship", explanation.ExcludingPaths)
+	}
+	if len(explanation.SearchedRelations) != 5 || explanation.SearchedRelations[0] != "administrator" {
+		t.Errorf("searched_relations = %v, want the any_of of read", explanation.SearchedRelations)
+	}
+	// The member path is eliminated by the direct forbidden relationship (path_without member)
+	if len(explanation.EliminatedPaths) != 1 || explanation.EliminatedPaths[0].Rule.Relation != "member" {
+		t.Errorf("eliminated_paths = %v, want the path through group:eng", explanation.EliminatedPaths)
+	}
+}
+
+func TestExplainAllowed(t *testing.T) {
+	svc, repo := newService(t, authz.LoadMetadata())
+	seedRelations(t, repo, rel("project:1", "owner", "user:alice"))
+
+	evals := check(t, svc, "project:1", "user:alice", authz.CheckOptions{Explain: true})
+	eval := evals["edit"]
+	if !eval.Allowed || eval.Explanation == nil {
+		t.Fatalf("edit = %+v, want allowed with an explanation", eval)
+	}
+	if eval.Explanation.ExcludedBy != "" || len(eval.Explanation.MatchedPaths) != 1 {
+		t.Errorf("explanation = %+v, want one matched path and no exclusion", eval.Explanation)
+	}
+	if evals["read"].Explanation == nil {
+		t.Error("read has no explanation, want one per permission")
+	}
+}
+
+// seedGroupReaderForbidden grants read on project:1 to alice through group:eng,
+// and forbids it directly: precedence rules eliminate the path through the group.
+func seedGroupReaderForbidden(t *testing.T, repo authz.AuthzRepository) {
+	t.Helper()
+	seedRelations(t, repo,
+		rel("project:1", "reader", "group:eng"),
+		rel("group:eng", "member", "user:alice"),
+		rel("project:1", "forbidden", "user:alice"),
+	)
+}
+
+func TestListEffectivePathsKeepEliminated(t *testing.T) {
+	svc, repo := newService(t, authz.LoadMetadata())
+	seedGroupReaderForbidden(t, repo)
+
+	request := authz.TraversalRequest{StartOn: obj("project:1"), Forward: true, StopOn: obj("user:alice")}
+	items, _, err := svc.ListEffectivePaths(context.Background(), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || len(items[0].Paths) != 1 || items[0].EliminatedPaths != nil {
+		t.Fatalf("items = %+v, want one effective path and no eliminated paths", items)
+	}
+
+	request.KeepEliminated = true
+	items, _, err = svc.ListEffectivePaths(context.Background(), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || len(items[0].EliminatedPaths) != 1 {
+		t.Fatalf("items = %+v, want one eliminated path", items)
+	}
+	eliminated := items[0].EliminatedPaths[0]
+	if len(eliminated.Path) != 2 || eliminated.Path[0].Relation != "reader" || eliminated.Path[1].Relation != "member" {
+		t.Errorf("eliminated path = %v, want reader then member", eliminated.Path)
+	}
+	if eliminated.Rule.Rule != "path_without" || eliminated.Rule.Relation != "member" {
+		t.Errorf("eliminating rule = %+v, want path_without member", eliminated.Rule)
+	}
+}
+
+func TestListEffectivePathsBackwardAppliesResourceRules(t *testing.T) {
+	svc, repo := newService(t, authz.LoadMetadata())
+	seedGroupReaderForbidden(t, repo)
+
+	// Starting on the subject, whose type has no precedence rules, must reduce the paths as from the resource
+	request := authz.TraversalRequest{StartOn: obj("user:alice"), Forward: false, StopOn: authz.Object{Type: "project"}}
+	items, _, err := svc.ListEffectivePaths(context.Background(), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Resource != obj("project:1") {
+		t.Fatalf("items = %+v, want project:1", items)
+	}
+	if len(items[0].Paths) != 1 || items[0].Paths[0][0].Relation != "forbidden" {
+		t.Errorf("paths = %v, want the forbidden relationship only", items[0].Paths)
+	}
+
+	checks, _, err := svc.CheckPermissions(context.Background(), request, authz.CheckOptions{Permissions: []string{"read"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checks) != 1 || checks[0].PermissionEvals["read"].Allowed {
+		t.Errorf("checks = %+v, want read denied on project:1", checks)
+	}
+}
+
+func TestApplyRelationshipsIdempotencyKey(t *testing.T) {
+	svc, repo := newService(t, authz.LoadMetadata())
+	ctx := context.Background()
+	seedRelations(t, repo, rel("project:1", "reader", "user:bob"))
+	request := authz.WriteRequest{
+		Delete: []authz.Relationship{rel("project:1", "reader", "user:bob")},
+		Create: []authz.Relationship{rel("project:1", "reader", "user:alice")},
+	}
+
+	result, err := svc.ApplyRelationships(ctx, "key-1", request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Replayed || result.Created != 1 || result.Deleted != 1 {
+		t.Fatalf("result = %+v, want 1 created and 1 deleted", result)
+	}
+
+	// Bob is granted again in between: a replay must neither delete him again nor report anything else
+	seedRelations(t, repo, rel("project:1", "reader", "user:bob"))
+	replay, err := svc.ApplyRelationships(ctx, "key-1", request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !replay.Replayed || replay.Created != 1 || replay.Deleted != 1 {
+		t.Errorf("replay = %+v, want the original result, replayed", replay)
+	}
+	assertAllowed(t, svc, "project:1", "user:bob", "read")
+
+	// The request is identified whatever the object format, e.g. when it changes across a restart
+	if err := authz.SetObjectFormat(authz.ObjectFormatExpanded); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { authz.SetObjectFormat(authz.ObjectFormatCompact) })
+	if replay, err := svc.ApplyRelationships(ctx, "key-1", request); err != nil || !replay.Replayed {
+		t.Errorf("replay with expanded objects = %+v, %v, want the original result, replayed", replay, err)
+	}
+	authz.SetObjectFormat(authz.ObjectFormatCompact)
+
+	// The key is reused for another request
+	other := authz.WriteRequest{Create: []authz.Relationship{rel("project:1", "owner", "user:alice")}}
+	if _, err := svc.ApplyRelationships(ctx, "key-1", other); !errors.Is(err, authz.ErrIdempotencyKeyReused) {
+		t.Errorf("ApplyRelationships() with a reused key = %v, want ErrIdempotencyKeyReused", err)
+	}
+	assertDenied(t, svc, "project:1", "user:alice", "edit")
+
+	// Keys are scoped to the actor
+	actorCtx := actor.NewContext(ctx, "api-key:other")
+	result, err = svc.ApplyRelationships(actorCtx, "key-1", other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Replayed || result.Created != 1 {
+		t.Errorf("result = %+v, want the write applied for another actor", result)
+	}
+}
+
+func TestApplyRelationshipsPrecondition(t *testing.T) {
+	svc, repo := newService(t, authz.LoadMetadata())
+	ctx := context.Background()
+	seedRelations(t, repo, rel("project:1", "owner", "user:alice"))
+	grantBob := []authz.Relationship{rel("project:1", "reader", "user:bob")}
+
+	tests := []struct {
+		name         string
+		precondition authz.WritePrecondition
+		wantErr      bool
+	}{
+		{"must_exist violated", authz.WritePrecondition{MustExist: []authz.Relationship{rel("project:1", "owner", "user:carol")}}, true},
+		{"must_not_exist violated", authz.WritePrecondition{MustNotExist: []authz.Relationship{rel("project:1", "owner", "user:alice")}}, true},
+		{"satisfied", authz.WritePrecondition{
+			MustExist:    []authz.Relationship{rel("project:1", "owner", "user:alice")},
+			MustNotExist: []authz.Relationship{rel("project:1", "forbidden", "user:bob")},
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := svc.ApplyRelationships(ctx, "", authz.WriteRequest{Create: grantBob, Precondition: &tt.precondition})
+			if tt.wantErr {
+				if !errors.Is(err, authz.ErrPreconditionFailed) {
+					t.Fatalf("ApplyRelationships() = %v, want ErrPreconditionFailed", err)
+				}
+				assertDenied(t, svc, "project:1", "user:bob", "read")
+				return
+			}
+			if err != nil || result.Created != 1 {
+				t.Fatalf("ApplyRelationships() = %+v, %v, want 1 created", result, err)
+			}
+			assertAllowed(t, svc, "project:1", "user:bob", "read")
+		})
+	}
+}
+
+func TestCheckPermissionsSinglePermission(t *testing.T) {
+	svc, repo := newService(t, authz.LoadMetadata())
+	seedRelations(t, repo, rel("project:1", "owner", "user:alice"))
+
+	evals := check(t, svc, "project:1", "user:alice", authz.CheckOptions{Permissions: []string{"edit"}})
+	if len(evals) != 1 || !evals["edit"].Allowed {
+		t.Errorf("evals = %+v, want edit only, allowed", evals)
+	}
+
+	evals = check(t, svc, "project:1", "user:alice", authz.CheckOptions{})
+	if len(evals) != len(authz.LoadMetadata().Objects["project"].Permissions) {
+		t.Errorf("evals = %+v, want every permission without a filter", evals)
+	}
+}
+
+func TestWatchChanges(t *testing.T) {
+	svc, _ := newService(t, authz.LoadMetadata())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes, err := svc.WatchChanges(ctx, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.CreateRelationships(ctx, []authz.Relationship{rel("project:1", "reader", "user:alice")}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case change := <-changes:
+		if change.Action != "create" || change.Relationship.String() != rel("project:1", "reader", "user:alice").String() {
+			t.Errorf("change = %+v, want the creation of the relationship", change)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no change received")
+	}
+
+	// The feed is closed once the watcher is gone
+	cancel()
+	select {
+	case _, ok := <-changes:
+		if ok {
+			t.Error("change received after cancellation")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("feed not closed after cancellation")
+	}
+}
+
+func TestAuditLogRecordsMutations(t *testing.T) {
+	svc, _ := newService(t, authz.LoadMetadata())
+	ctx := actor.NewContext(context.Background(), "api-key:admin")
+
+	request := authz.WriteRequest{Create: []authz.Relationship{rel("project:1", "reader", "user:alice")}}
+	if _, err := svc.ApplyRelationships(ctx, "", request); err != nil {
+		t.Fatal(err)
+	}
+	request = authz.WriteRequest{Delete: []authz.Relationship{rel("project:1", "reader", "user:alice")}}
+	if _, err := svc.ApplyRelationships(context.Background(), "", request); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, _, err := svc.ListAuditEntries(context.Background(), authz.AuditFilter{Resource: obj("project:1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("entries = %+v, want a creation and a deletion", entries)
+	}
+	if entries[0].Action != "create" || entries[0].Actor != "api-key:admin" {
+		t.Errorf("entries[0] = %+v, want a creation by api-key:admin", entries[0])
+	}
+	if entries[1].Action != "delete" || entries[1].Actor != "anonymous" {
+		t.Errorf("entries[1] = %+v, want an anonymous deletion", entries[1])
+	}
+	if entries[1].Relationship.String() != rel("project:1", "reader", "user:alice").String() {
+		t.Errorf("entries[1].Relationship = %s, want the deleted relationship", entries[1].Relationship)
+	}
+
+	// A dry run records nothing
+	request = authz.WriteRequest{Create: []authz.Relationship{rel("project:1", "owner", "user:bob")}, DryRun: true}
+	if _, err := svc.ApplyRelationships(ctx, "", request); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _, err := svc.ListAuditEntries(context.Background(), authz.AuditFilter{Resource: obj("project:1")}); err != nil || len(entries) != 2 {
+		t.Errorf("entries after a dry run = %d, %v, want 2", len(entries), err)
+	}
+}
+
+func TestAuditLogSkipsNoOpWrites(t *testing.T) {
+	svc, _ := newService(t, authz.LoadMetadata())
+	ctx := context.Background()
+	alice, bob := rel("project:1", "reader", "user:alice"), rel("project:1", "reader", "user:bob")
+
+	if _, err := svc.CreateRelationships(ctx, []authz.Relationship{alice}); err != nil {
+		t.Fatal(err)
+	}
+	// Only bob is created, and nothing is deleted
+	if _, err := svc.CreateRelationships(ctx, []authz.Relationship{alice, bob}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := svc.DeleteRelationships(ctx, []authz.Relationship{rel("project:1", "reader", "user:carol")}); err != nil || n != 0 {
+		t.Fatalf("DeleteRelationships() of a missing relationship = %d, %v, want 0", n, err)
+	}
+
+	entries, _, err := svc.ListAuditEntries(ctx, authz.AuditFilter{Resource: obj("project:1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Relationship.String() != alice.String() || entries[1].Relationship.String() != bob.String() {
+		t.Errorf("entries = %+v, want the creations of alice then bob only", entries)
+	}
+}
+
+func TestExpiredRelationshipIgnored(t *testing.T) {
+	svc, repo := newService(t, authz.LoadMetadata())
+	past, future := time.Now().Add(-time.Minute), time.Now().Add(time.Hour)
+	expired, live := rel("project:1", "owner", "user:alice"), rel("project:1", "owner", "user:bob")
+	expired.ExpiresAt, live.ExpiresAt = &past, &future
+	seedRelations(t, repo, expired, live)
+
+	assertDenied(t, svc, "project:1", "user:alice", "edit")
+	assertAllowed(t, svc, "project:1", "user:bob", "edit")
+}
+
+func TestCreateRelationshipsKeepsExistingExpiry(t *testing.T) {
+	svc, repo := newService(t, authz.LoadMetadata())
+	ctx := context.Background()
+	past, future := time.Now().Add(-time.Minute), time.Now().Add(time.Hour)
+	expired := rel("project:1", "owner", "user:bob")
+	expired.ExpiresAt = &past
+	seedRelations(t, repo, rel("project:1", "owner", "user:alice"), expired)
+
+	// Re-posting a permanent grant with an expiry leaves it permanent, whereas an expired one is replaced
+	alice, bob := rel("project:1", "owner", "user:alice"), rel("project:1", "owner", "user:bob")
+	alice.ExpiresAt, bob.ExpiresAt = &future, &future
+	if n, err := svc.CreateRelationships(ctx, []authz.Relationship{alice, bob}); err != nil || n != 1 {
+		t.Fatalf("CreateRelationships() = %d, %v, want 1 replaced", n, err)
+	}
+	found, err := repo.FindRelationships(ctx, []authz.Relationship{alice, bob})
+	if err != nil || len(found) != 2 {
+		t.Fatalf("FindRelationships() = %+v, %v, want 2 relationships", found, err)
+	}
+	for _, r := range found {
+		if permanent := r.ExpiresAt == nil; permanent != (r.Subject.ID == "alice") {
+			t.Errorf("%s expires at %v, want only alice permanent", r, r.ExpiresAt)
+		}
+	}
+}
+
+func TestListEffectivePathsMaxResults(t *testing.T) {
+	svc, repo := newService(t, authz.LoadMetadata())
+	seedRelations(t, repo,
+		rel("project:1", "reader", "user:alice"),
+		rel("project:2", "reader", "user:alice"),
+		rel("project:3", "reader", "user:alice"),
+	)
+	request := authz.TraversalRequest{StartOn: obj("user:alice"), StopOn: authz.Object{Type: "project"}}
+
+	for _, tt := range []struct {
+		maxResults, items int
+		truncated         bool
+	}{
+		{maxResults: 2, items: 2, truncated: true},
+		{maxResults: 3, items: 3, truncated: false},
+		{maxResults: 0, items: 3, truncated: false}, // default limit
+	} {
+		request.MaxResults = tt.maxResults
+		items, truncated, err := svc.ListEffectivePaths(context.Background(), request)
+		if err != nil {
+			t.Fatalf("ListEffectivePaths() failed: %v", err)
+		}
+		if len(items) != tt.items || truncated != tt.truncated {
+			t.Errorf("max results %d: %d items, truncated %v, want %d items, truncated %v",
+				tt.maxResults, len(items), truncated, tt.items, tt.truncated)
+		}
+	}
+}
+
+func TestDiamondPathsAppearOnce(t *testing.T) {
+	svc, repo := newService(t, authz.LoadMetadata())
+	seedRelations(t, repo,
+		rel("project:1", "reader", "group:a"),
+		rel("project:1", "reader", "group:b"),
+		rel("group:a", "member", "group:c"),
+		rel("group:b", "member", "group:c"),
+		rel("group:c", "member", "user:alice"),
+	)
+	ctx := context.Background()
+	wantPaths := func(name string, paths [][]authz.Relationship) {
+		t.Helper()
+		seen := map[string]bool{}
+		for _, path := range paths {
+			var key []string
+			for _, r := range path {
+				key = append(key, r.String())
+			}
+			if seen[strings.Join(key, " ")] {
+				t.Errorf("%s: path %v appears more than once", name, key)
+			}
+			seen[strings.Join(key, " ")] = true
+		}
+		if len(seen) != 2 {
+			t.Errorf("%s: %d distinct paths, want 2 (through group:a and group:b)", name, len(seen))
+		}
+	}
+
+	for _, request := range []authz.TraversalRequest{
+		{StartOn: obj("project:1"), Forward: true, StopOn: obj("user:alice")},
+		{StartOn: obj("user:alice"), StopOn: authz.Object{Type: "project"}},
+	} {
+		items, _, err := svc.ListEffectivePaths(ctx, request)
+		if err != nil || len(items) != 1 {
+			t.Fatalf("ListEffectivePaths() = %d items, %v, want 1", len(items), err)
+		}
+		wantPaths("ListEffectivePaths", items[0].Paths)
+	}
+
+	evals := check(t, svc, "project:1", "user:alice", authz.CheckOptions{ShowMatchingPaths: true, Permissions: []string{"read"}})
+	wantPaths("matching paths", evals["read"].MatchingPaths)
+
+	// A path matching several entries of a permission is one matching path
+	svc, repo = newService(t, loadSchema(t, `
+schema_version: "1.0"
+objects:
+  user:
+    relations: {}
+  group:
+    relations:
+      member:
+        subject_types: [user, group]
+  doc:
+    relations:
+      viewer:
+        subject_types: [user, group]
+    permissions:
+      view:
+        any_of: [viewer]
+      read:
+        any_of: [viewer, view]
+`))
+	seedRelations(t, repo,
+		rel("doc:1", "viewer", "group:a"),
+		rel("doc:1", "viewer", "group:b"),
+		rel("group:a", "member", "group:c"),
+		rel("group:b", "member", "group:c"),
+		rel("group:c", "member", "user:alice"),
+	)
+	request := authz.TraversalRequest{StartOn: obj("doc:1"), Forward: true, StopOn: obj("user:alice")}
+	items, _, err := svc.CheckPermissions(ctx, request, authz.CheckOptions{ShowMatchingPaths: true, Permissions: []string{"read"}})
+	if err != nil || len(items) != 1 {
+		t.Fatalf("CheckPermissions() = %d items, %v, want 1", len(items), err)
+	}
+	wantPaths("matching paths of several entries", items[0].PermissionEvals["read"].MatchingPaths)
+}
+
+// usersetSchema defines permissions including other permissions of the same type.
+const usersetSchema = `
+schema_version: "1.0"
+objects:
+  user:
+    relations: {}
+  doc:
+    relations:
+      owner:
+        subject_types: [user]
+      editor:
+        subject_types: [user]
+      viewer:
+        subject_types: [user]
+      banned:
+        subject_types: [user]
+    permissions:
+      edit:
+        any_of: [owner, editor]
+      view:
+        any_of: [viewer, edit]
+        except: [banned]
+      comment:
+        any_of: [view]
+`
+
+func TestPermissionIncludesPermission(t *testing.T) {
+	svc, repo := newService(t, loadSchema(t, usersetSchema))
+	seedRelations(t, repo,
+		rel("doc:1", "owner", "user:alice"),
+		rel("doc:1", "editor", "user:bob"),
+		rel("doc:1", "editor", "user:eve"),
+		rel("doc:1", "banned", "user:eve"),
+		rel("doc:1", "viewer", "user:carol"),
+	)
+
+	for _, tt := range []struct {
+		subject             string
+		edit, view, comment bool
+	}{
+		{"user:alice", true, true, true},  // owner -> edit -> view -> comment
+		{"user:bob", true, true, true},    // editor -> edit -> view -> comment
+		{"user:eve", true, false, false},  // banned from view, and so from comment
+		{"user:carol", false, true, true}, // viewer
+	} {
+		evals := check(t, svc, "doc:1", tt.subject, authz.CheckOptions{})
+		if evals["edit"].Allowed != tt.edit || evals["view"].Allowed != tt.view || evals["comment"].Allowed != tt.comment {
+			t.Errorf("%s: edit %v, view %v, comment %v, want %v, %v, %v", tt.subject,
+				evals["edit"].Allowed, evals["view"].Allowed, evals["comment"].Allowed, tt.edit, tt.view, tt.comment)
+		}
+	}
+}
+
+func TestPermissionCycleRejected(t *testing.T) {
+	schema := strings.Replace(usersetSchema, "any_of: [owner, editor]", "any_of: [owner, comment]", 1)
+	_, err := authz.ParseMetadata([]byte(schema))
+	if err == nil || !strings.Contains(err.Error(), "permission cycle: ") {
+		t.Errorf("ParseMetadata() = %v, want a permission cycle error", err)
+	}
+}
+
+func TestPermissionThroughParent(t *testing.T) {
+	svc, repo := newService(t, loadSchema(t, `
+schema_version: "1.0"
+objects:
+  user:
+    relations: {}
+  folder:
+    relations:
+      parent:
+        subject_types: [folder]
+      viewer:
+        subject_types: [user]
+      editor:
+        subject_types: [user]
+      banned:
+        subject_types: [user]
+    permissions:
+      view:
+        any_of: [viewer, parent->view]
+        except: [banned]
+  doc:
+    relations:
+      parent:
+        subject_types: [folder]
+      reader:
+        subject_types: [user]
+    permissions:
+      view:
+        any_of: [reader, parent->view]
+`))
+	seedRelations(t, repo,
+		rel("doc:1", "parent", "folder:child"),
+		rel("folder:child", "parent", "folder:root"),
+		rel("folder:root", "viewer", "user:alice"),
+		rel("folder:child", "viewer", "user:bob"),
+		rel("folder:root", "viewer", "user:eve"),
+		rel("folder:child", "banned", "user:eve"),
+		rel("folder:root", "editor", "user:dan"),
+		rel("doc:1", "reader", "user:carol"),
+	)
+
+	assertAllowed(t, svc, "doc:1", "user:alice", "view") // doc -> child -> root
+	assertAllowed(t, svc, "doc:1", "user:bob", "view")   // doc -> child
+	assertAllowed(t, svc, "doc:1", "user:carol", "view") // reader of the doc itself
+	assertAllowed(t, svc, "folder:child", "user:alice", "view")
+	assertDenied(t, svc, "folder:root", "user:bob", "view") // permissions are not inherited upward
+	assertDenied(t, svc, "folder:child", "user:eve", "view")
+	assertDenied(t, svc, "doc:1", "user:eve", "view") // banned from the child folder
+	assertDenied(t, svc, "doc:1", "user:dan", "view") // a relation other than view on the folder
+}
+
+// aliasSchema renames the owner relation of documents to admin, keeping owner as an alias.
+const aliasSchema = `
+schema_version: "1.0"
+objects:
+  user:
+    relations: {}
+  doc:
+    relations:
+      admin:
+        subject_types: [user]
+      viewer:
+        subject_types: [user]
+    aliases:
+      owner: admin
+    permissions:
+      edit:
+        any_of: [admin]
+      view:
+        any_of: [viewer, edit]
+`
+
+func TestRelationAlias(t *testing.T) {
+	meta := loadSchema(t, aliasSchema)
+	if err := meta.IsValidRelation(rel("doc:1", "owner", "user:alice")); err != nil {
+		t.Errorf("IsValidRelation() under the alias = %v, want nil", err)
+	}
+
+	svc, repo := newService(t, meta)
+	seedRelations(t, repo,
+		rel("doc:1", "owner", "user:alice"),
+		rel("doc:1", "admin", "user:bob"),
+		rel("doc:1", "viewer", "user:carol"),
+	)
+	// alice wrote under the former name, also when the traversal is pruned to the relations of edit
+	for _, opts := range []authz.CheckOptions{{}, {Permissions: []string{"edit"}}} {
+		for _, subject := range []string{"user:alice", "user:bob"} {
+			if evals := check(t, svc, "doc:1", subject, opts); !evals["edit"].Allowed {
+				t.Errorf("%s with permissions %v: edit denied, want allowed", subject, opts.Permissions)
+			}
+		}
+	}
+	if evals := check(t, svc, "doc:1", "user:carol", authz.CheckOptions{}); evals["edit"].Allowed || !evals["view"].Allowed {
+		t.Errorf("user:carol: edit %v, view %v, want denied and allowed", evals["edit"].Allowed, evals["view"].Allowed)
+	}
+
+	evals := check(t, svc, "doc:1", "user:alice", authz.CheckOptions{Permissions: []string{"view"}, ShowMatchingPaths: true})
+	if paths := evals["view"].MatchingPaths; len(paths) != 1 || paths[0][0].Relation != "admin" {
+		t.Errorf("matching paths = %+v, want a single path through admin", paths)
+	}
+
+	for _, tt := range []struct{ from, to, wantErr string }{
+		{"viewer", "admin", `alias "viewer" is already a relation`},
+		{"owner", "manager", `undefined relation "manager"`},
+	} {
+		schema := strings.Replace(aliasSchema, "owner: admin", tt.from+": "+tt.to, 1)
+		if _, err := authz.ParseMetadata([]byte(schema)); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("alias %s: %s: ParseMetadata() = %v, want %q", tt.from, tt.to, err, tt.wantErr)
+		}
+	}
+}
+
+func TestSelfLoopTraversal(t *testing.T) {
+	// Self-referential relationships are only rejected on write (see RejectSelf): those a schema allows are followed
+	svc, repo := newService(t, loadSchema(t, `
+schema_version: "1.0"
+objects:
+  user:
+    relations:
+      self:
+        subject_types: [user]
+    permissions:
+      manage:
+        any_of: [self]
+  group:
+    relations:
+      member:
+        subject_types: [user, group]
+    permissions:
+      view:
+        any_of: [member]
+`))
+	seedRelations(t, repo,
+		rel("user:1", "self", "user:1"),
+		rel("group:a", "member", "group:a"),
+		rel("group:a", "member", "user:1"),
+	)
+	assertAllowed(t, svc, "user:1", "user:1", "manage")
+	assertDenied(t, svc, "user:1", "user:2", "manage")
+
+	// Traversals going on from a self-loop terminate
+	assertAllowed(t, svc, "group:a", "user:1", "view")
+	assertAllowed(t, svc, "group:a", "group:a", "view")
+}
+
+// cancelingRepository cancels the context of a traversal once its paths are read,
+// as a client disconnecting while they are evaluated would.
+type cancelingRepository struct {
+	authz.AuthzRepository
+	cancel context.CancelFunc
+}
+
+func (r *cancelingRepository) ListPaths(ctx context.Context, request authz.TraversalRequest) ([]authz.TraversalResponseItem, error) {
+	items, err := r.AuthzRepository.ListPaths(ctx, request)
+	r.cancel()
+	return items, err
+}
+
+func TestCheckPermissionsStopsWhenCanceled(t *testing.T) {
+	meta := authz.LoadMetadata()
+	_, sqliteRepo := newService(t, meta)
+	seedRelations(t, sqliteRepo,
+		rel("project:1", "reader", "user:alice"),
+		rel("project:2", "reader", "user:alice"),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	svc := authz.NewService(&cancelingRepository{AuthzRepository: sqliteRepo, cancel: cancel}, meta)
+
+	request := authz.TraversalRequest{StartOn: obj("user:alice"), StopOn: authz.Object{Type: "project"}}
+	items, _, err := svc.CheckPermissions(ctx, request, authz.CheckOptions{})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("CheckPermissions() = %d items, error %v, want %v", len(items), err, context.Canceled)
+	}
+}
+
+// weightedSchema ranks document paths by two rules, one disfavoring group membership and the other parent folders;
+// the precedence mode is substituted for MODE.
+const weightedSchema = `
+schema_version: "1.0"
+objects:
+  user:
+    relations: {}
+  group:
+    relations:
+      member:
+        subject_types: [user]
+  folder:
+    relations:
+      viewer:
+        subject_types: [user]
+  doc:
+    relations:
+      parent:
+        subject_types: [folder]
+      viewer:
+        subject_types: [user, group]
+    precedence_mode: MODE
+    precedence_rules:
+      - rule: path_without
+        relation: member
+        weight: 1
+      - rule: path_with_fewer
+        relation: parent
+        weight: 3
+`
+
+func TestWeightedPrecedence(t *testing.T) {
+	for _, tt := range []struct {
+		mode, want, rule string
+	}{
+		// the first rule decides: the path without membership wins
+		{mode: "lexicographic", want: "parent", rule: "member"},
+		// the parent penalty (3) outweighs the membership penalty (1)
+		{mode: "weighted", want: "viewer", rule: "parent"},
+	} {
+		svc, repo := newService(t, loadSchema(t, strings.Replace(weightedSchema, "MODE", tt.mode, 1)))
+		seedRelations(t, repo,
+			rel("doc:1", "parent", "folder:1"),
+			rel("folder:1", "viewer", "user:alice"),
+			rel("doc:1", "viewer", "group:a"),
+			rel("group:a", "member", "user:alice"),
+		)
+		request := authz.TraversalRequest{StartOn: obj("doc:1"), Forward: true, StopOn: obj("user:alice"), KeepEliminated: true}
+		items, _, err := svc.ListEffectivePaths(context.Background(), request)
+		if err != nil || len(items) != 1 {
+			t.Fatalf("%s: ListEffectivePaths() = %d items, %v, want 1", tt.mode, len(items), err)
+		}
+		item := items[0]
+		if len(item.Paths) != 1 || item.Paths[0][0].Relation != tt.want {
+			t.Errorf("%s: effective paths = %v, want the path starting with %s", tt.mode, item.Paths, tt.want)
+		}
+		if len(item.EliminatedPaths) != 1 || item.EliminatedPaths[0].Rule.Relation != tt.rule {
+			t.Errorf("%s: eliminated paths = %+v, want one eliminated by the %s rule", tt.mode, item.EliminatedPaths, tt.rule)
+		}
+	}
+
+	if _, err := authz.ParseMetadata([]byte(strings.Replace(weightedSchema, "MODE", "additive", 1))); err == nil ||
+		!strings.Contains(err.Error(), `unknown precedence mode "additive"`) {
+		t.Errorf("ParseMetadata() of an unknown mode = %v, want rejected", err)
+	}
+}
+
+func TestDenyOverrides(t *testing.T) {
+	svc, repo := newService(t, loadSchema(t, `
+schema_version: "1.0"
+objects:
+  user:
+    relations: {}
+  group:
+    relations:
+      member:
+        subject_types: [user]
+  doc:
+    relations:
+      viewer:
+        subject_types: [user, group]
+      blocked:
+        subject_types: [user, group]
+    deny_overrides: [blocked]
+    precedence_rules:
+      - rule: path_without
+        relation: member
+    permissions:
+      view:
+        any_of: [viewer]
+`))
+	seedRelations(t, repo,
+		rel("doc:1", "viewer", "user:alice"),
+		rel("doc:1", "blocked", "group:a"),
+		rel("group:a", "member", "user:alice"),
+		rel("doc:2", "viewer", "user:alice"),
+	)
+
+	// The blocking path is the only effective one, although precedence rules favor the direct path
+	request := authz.TraversalRequest{StartOn: obj("doc:1"), Forward: true, StopOn: obj("user:alice"), KeepEliminated: true}
+	items, _, err := svc.ListEffectivePaths(context.Background(), request)
+	if err != nil || len(items) != 1 {
+		t.Fatalf("ListEffectivePaths() = %d items, %v, want 1", len(items), err)
+	}
+	if paths := items[0].Paths; len(paths) != 1 || paths[0][0].Relation != "blocked" {
+		t.Errorf("effective paths = %v, want the blocking path only", paths)
+	}
+	if eliminated := items[0].EliminatedPaths; len(eliminated) != 1 ||
+		eliminated[0].Rule != (authz.PrecedenceRule{Rule: "deny_overrides", Relation: "blocked"}) {
+		t.Errorf("eliminated paths = %+v, want the viewer path eliminated by deny_overrides", eliminated)
+	}
+
+	evals := check(t, svc, "doc:1", "user:alice", authz.CheckOptions{Explain: true, ShowReason: true})
+	if view := evals["view"]; view.Allowed || view.Reason != authz.DenyReasonExcluded || view.Explanation.ExcludedBy != "blocked" {
+		t.Errorf("view = %+v, want denied, excluded by blocked", view)
+	}
+	assertAllowed(t, svc, "doc:2", "user:alice", "view")
+}
+
+const defaultDecisionSchema = `
+schema_version: "1.0"
+objects:
+  user:
+    relations: {}
+  doc:
+    relations:
+      editor:
+        subject_types: [user]
+      blocked:
+        subject_types: [user]
+    permissions:
+      view:
+        any_of: [editor]
+        except: [blocked]
+        default_decision: allow
+      edit:
+        any_of: [editor]
+        except: [blocked]
+        default_decision: deny
+`
+
+func TestDefaultDecision(t *testing.T) {
+	svc, repo := newService(t, loadSchema(t, defaultDecisionSchema))
+	seedRelations(t, repo,
+		rel("doc:1", "editor", "user:alice"),
+		rel("doc:1", "blocked", "user:bob"),
+	)
+
+	// Granted by default to subjects without any path, unless an excluded relation is found
+	assertAllowed(t, svc, "doc:1", "user:carol", "view")
+	assertDenied(t, svc, "doc:1", "user:carol", "edit")
+	assertDenied(t, svc, "doc:1", "user:bob", "view")
+	assertAllowed(t, svc, "doc:1", "user:alice", "edit")
+
+	evals := check(t, svc, "doc:1", "user:alice", authz.CheckOptions{ShowMatchingPaths: true})
+	if view := evals["view"]; !view.Allowed || len(view.MatchingPaths) != 1 || view.MatchingPaths[0][0].Relation != "editor" {
+		t.Errorf("view = %+v, want allowed, matching the editor path", view)
+	}
+	evals = check(t, svc, "doc:1", "user:bob", authz.CheckOptions{ShowReason: true})
+	if view := evals["view"]; view.Allowed || view.Reason != authz.DenyReasonExcluded {
+		t.Errorf("view = %+v, want denied, excluded", view)
+	}
+
+	for _, permission := range []string{"view", "edit"} {
+		request := authz.TraversalRequest{StartOn: obj("doc:1"), Forward: true, StopOn: obj("user:carol")}
+		allowed, err := svc.IsPermitted(context.Background(), request, permission)
+		if err != nil || allowed != (permission == "view") {
+			t.Errorf("IsPermitted(%s) = %v, %v, want %v", permission, allowed, err, permission == "view")
+		}
+	}
+}
+
+func TestDefaultDecisionValidation(t *testing.T) {
+	schema := strings.Replace(defaultDecisionSchema, "default_decision: deny", "default_decision: grant", 1)
+	if _, err := authz.ParseMetadata([]byte(schema)); err == nil || !strings.Contains(err.Error(), `unsupported default decision "grant"`) {
+		t.Errorf("ParseMetadata() = %v, want an unsupported default decision error", err)
+	}
+
+	// Only a permission granted by default may omit any_of
+	schema = strings.Replace(defaultDecisionSchema, "any_of: [editor]\n        except: [blocked]\n        default_decision: allow", "default_decision: allow", 1)
+	if _, err := authz.ParseMetadata([]byte(schema)); err != nil {
+		t.Errorf("ParseMetadata() without any_of = %v, want nil", err)
+	}
+	schema = strings.Replace(schema, "default_decision: deny", "", 1)
+	schema = strings.Replace(schema, "any_of: [editor]", "", 1)
+	if _, err := authz.ParseMetadata([]byte(schema)); err == nil || !strings.Contains(err.Error(), "at least one relation is required") {
+		t.Errorf("ParseMetadata() = %v, want any_of required", err)
+	}
+}
+
+func TestTraversalWithoutStartObject(t *testing.T) {
+	svc, repo := newService(t, authz.LoadMetadata())
+	seedRelations(t, repo, rel("project:1", "reader", "user:alice"))
+	ctx := context.Background()
+
+	for name, request := range map[string]authz.TraversalRequest{
+		"empty start ID":   {StartOn: authz.Object{Type: "project"}, Forward: true, StopOn: obj("user:alice")},
+		"empty start type": {StartOn: authz.Object{ID: "1"}, Forward: true, StopOn: obj("user:alice")},
+	} {
+		if _, _, err := svc.ListEffectivePaths(ctx, request); !errors.Is(err, authz.ErrInvalidTraversal) {
+			t.Errorf("%s: ListEffectivePaths error = %v, want ErrInvalidTraversal", name, err)
+		}
+		if _, _, err := svc.CheckPermissions(ctx, request, authz.CheckOptions{}); !errors.Is(err, authz.ErrInvalidTraversal) {
+			t.Errorf("%s: CheckPermissions error = %v, want ErrInvalidTraversal", name, err)
+		}
+		if _, err := svc.IsPermitted(ctx, request, "read"); !errors.Is(err, authz.ErrInvalidTraversal) {
+			t.Errorf("%s: IsPermitted error = %v, want ErrInvalidTraversal", name, err)
+		}
+	}
+
+	// The start object may be either end of the relationships, with the other end given by type
+	request := authz.TraversalRequest{StartOn: obj("user:alice"), Forward: false, StopOn: authz.Object{Type: "project"}}
+	items, _, err := svc.ListEffectivePaths(ctx, request)
+	if err != nil || len(items) != 1 {
+		t.Errorf("ListEffectivePaths from the subject = %d items (err: %v), want 1", len(items), err)
+	}
+}
+
+const allOfSchema = `
+schema_version: "1.0"
+objects:
+  user:
+    relations: {}
+  group:
+    relations:
+      member:
+        subject_types: [user]
+  project:
+    relations:
+      member:
+        subject_types: [user, group]
+      trained:
+        subject_types: [user]
+      owner:
+        subject_types: [user]
+      blocked:
+        subject_types: [user]
+    permissions:
+      deploy:
+        all_of: [member, trained]
+        except: [blocked]
+      release:
+        any_of: [owner]
+        all_of: [trained]
+`
+
+func TestAllOf(t *testing.T) {
+	svc, repo := newService(t, loadSchema(t, allOfSchema))
+	seedRelations(t, repo,
+		rel("project:1", "member", "user:alice"),
+		rel("project:1", "trained", "user:alice"),
+		rel("project:1", "member", "user:bob"),
+		rel("project:1", "member", "group:eng"),
+		rel("group:eng", "member", "user:carol"),
+		rel("project:1", "trained", "user:carol"),
+		rel("project:1", "member", "user:dave"),
+		rel("project:1", "trained", "user:dave"),
+		rel("project:1", "blocked", "user:dave"),
+		rel("project:1", "owner", "user:erin"),
+		rel("project:1", "trained", "user:erin"),
+		rel("project:1", "owner", "user:frank"),
+	)
+
+	for _, tt := range []struct {
+		subject, permission string
+		allowed             bool
+	}{
+		{"user:alice", "deploy", true},   // every required relation
+		{"user:bob", "deploy", false},    // member only: partially satisfied
+		{"user:carol", "deploy", true},   // member through a group
+		{"user:dave", "deploy", false},   // excluded, whatever the required relations
+		{"user:erin", "release", true},   // an AnyOf entry and every AllOf entry
+		{"user:alice", "release", false}, // every AllOf entry but no AnyOf entry
+		{"user:frank", "release", false}, // an AnyOf entry but not every AllOf entry
+	} {
+		if tt.allowed {
+			assertAllowed(t, svc, "project:1", tt.subject, tt.permission)
+		} else {
+			assertDenied(t, svc, "project:1", tt.subject, tt.permission)
+		}
+		request := authz.TraversalRequest{StartOn: obj("project:1"), Forward: true, StopOn: obj(tt.subject)}
+		if allowed, err := svc.IsPermitted(context.Background(), request, tt.permission); err != nil || allowed != tt.allowed {
+			t.Errorf("IsPermitted(%s, %s) = %v, %v, want %v", tt.subject, tt.permission, allowed, err, tt.allowed)
+		}
+	}
+
+	// The paths matching every required relation grant the permission
+	deploy := check(t, svc, "project:1", "user:alice", authz.CheckOptions{ShowMatchingPaths: true, Permissions: []string{"deploy"}})["deploy"]
+	if len(deploy.MatchingPaths) != 2 || deploy.MatchingPaths[0][0].Relation != "member" || deploy.MatchingPaths[1][0].Relation != "trained" {
+		t.Errorf("deploy = %+v, want the member and trained paths", deploy)
+	}
+
+	// A partially satisfied requirement names the relation missing
+	deploy = check(t, svc, "project:1", "user:bob", authz.CheckOptions{Explain: true, Permissions: []string{"deploy"}})["deploy"]
+	if explanation := deploy.Explanation; explanation.MissingRequired != "trained" || len(explanation.MatchedPaths) != 1 {
+		t.Errorf("explanation = %+v, want trained missing, with the member path matched", explanation)
+	}
+}
+
+func TestAllOfValidation(t *testing.T) {
+	for _, tt := range []struct {
+		name, old, new, want string
+	}{
+		{"undefined entry", "all_of: [member, trained]", "all_of: [member, certified]", `permissions.deploy.all_of.1: undefined relation or permission "certified"`},
+		{"granted by default", "all_of: [trained]", "all_of: [trained]\n        default_decision: allow", `permissions.release.all_of: not allowed with default decision "allow"`},
+		{"cycle", "all_of: [trained]\n", "all_of: [trained]\n      ship:\n        all_of: [ship]\n", "permission cycle: ship -> ship"},
+	} {
+		_, err := authz.ParseMetadata([]byte(strings.Replace(allOfSchema, tt.old, tt.new, 1)))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: ParseMetadata() = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+const exprSchema = `
+schema_version: "1.0"
+objects:
+  user:
+    relations: {}
+  project:
+    relations:
+      owner:
+        subject_types: [user]
+      editor:
+        subject_types: [user]
+      suspended:
+        subject_types: [user]
+      trained:
+        subject_types: [user]
+    permissions:
+      edit:
+        expr:
+          or:
+            - owner
+            - and: [editor, {not: suspended}]
+      deploy:
+        any_of: [edit]
+        expr: {or: [owner, {and: [trained, {not: {or: [suspended]}}]}]}
+`
+
+func TestPermissionExpr(t *testing.T) {
+	svc, repo := newService(t, loadSchema(t, exprSchema))
+	seedRelations(t, repo,
+		rel("project:1", "owner", "user:alice"),
+		rel("project:1", "editor", "user:bob"),
+		rel("project:1", "editor", "user:carol"),
+		rel("project:1", "suspended", "user:carol"),
+		rel("project:1", "owner", "user:dave"),
+		rel("project:1", "suspended", "user:dave"),
+		rel("project:1", "suspended", "user:erin"),
+		rel("project:1", "editor", "user:frank"),
+		rel("project:1", "trained", "user:frank"),
+	)
+
+	for _, tt := range []struct {
+		subject, permission string
+		allowed             bool
+	}{
+		{"user:alice", "edit", true},   // first operand of the or
+		{"user:bob", "edit", true},     // editor, and not suspended
+		{"user:carol", "edit", false},  // editor, but suspended
+		{"user:dave", "edit", true},    // owner, whether suspended or not
+		{"user:erin", "edit", false},   // suspended only: a not alone does not grant the or
+		{"user:alice", "deploy", true}, // AnyOf entry, and expression holding through owner
+		{"user:bob", "deploy", false},  // AnyOf entry, but expression not holding
+		{"user:frank", "deploy", true}, // AnyOf entry, and expression holding through the nested and
+	} {
+		if tt.allowed {
+			assertAllowed(t, svc, "project:1", tt.subject, tt.permission)
+		} else {
+			assertDenied(t, svc, "project:1", tt.subject, tt.permission)
+		}
+	}
+
+	// Paths matching entries under a not are not matching paths
+	edit := check(t, svc, "project:1", "user:bob", authz.CheckOptions{ShowMatchingPaths: true, Permissions: []string{"edit"}})["edit"]
+	if len(edit.MatchingPaths) != 1 || edit.MatchingPaths[0][0].Relation != "editor" {
+		t.Errorf("edit = %+v, want the editor path", edit)
+	}
+	edit = check(t, svc, "project:1", "user:carol", authz.CheckOptions{Explain: true, Permissions: []string{"edit"}})["edit"]
+	if explanation := edit.Explanation; explanation.ExpressionHolds == nil || *explanation.ExpressionHolds {
+		t.Errorf("explanation = %+v, want the expression not holding", explanation)
+	}
+
+	// Expressions are encoded in JSON as written in the schema, entries as plain strings
+	def := loadSchema(t, exprSchema).Objects["project"].Permissions["edit"]
+	data, err := json.Marshal(def.Expr)
+	if want := `{"or":["owner",{"and":["editor",{"not":"suspended"}]}]}`; err != nil || string(data) != want {
+		t.Errorf("json.Marshal(expr) = %s, %v, want %s", data, err, want)
+	}
+	var decoded authz.PermissionExpr
+	if err := json.Unmarshal(data, &decoded); err != nil || !reflect.DeepEqual(&decoded, def.Expr) {
+		t.Errorf("json.Unmarshal(%s) = %+v, %v, want %+v", data, decoded, err, def.Expr)
+	}
+}
+
+func TestPermissionExprValidation(t *testing.T) {
+	for _, tt := range []struct {
+		name, old, new, want string
+	}{
+		{"undefined nested entry", "{not: suspended}", "{not: blocked}", `permissions.edit.expr.or.1.and.1.not: undefined relation or permission "blocked"`},
+		{"several operators", "and: [editor, {not: suspended}]", "{and: [editor], or: [owner]}", "permissions.edit.expr.or.1: exactly one of"},
+		{"no operand", "[owner, {and: [trained, {not: {or: [suspended]}}]}]", "[owner, {and: []}]", "permissions.deploy.expr.or.1.and: at least one operand is required"},
+		{"cycle", "- owner\n", "- owner\n            - deploy\n", "permission cycle: edit -> deploy -> edit"},
+		{"granted by default", "any_of: [edit]", "default_decision: allow", `permissions.deploy.expr: not allowed with default decision "allow"`},
+	} {
+		schema := strings.Replace(exprSchema, tt.old, tt.new, 1)
+		if schema == exprSchema {
+			t.Fatalf("%s: %q not found in the schema", tt.name, tt.old)
+		}
+		_, err := authz.ParseMetadata([]byte(schema))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: ParseMetadata() = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package authz
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// RunExpirySweeper periodically deletes relationships which expired more than grace ago,
+// until ctx is done. Expired relationships are ignored by reads in the meantime.
+func RunExpirySweeper(ctx context.Context, authzRepo AuthzRepository, interval, grace time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			n, err := authzRepo.DeleteExpired(ctx, time.Now().Add(-grace))
+			if err != nil {
+				log.Printf("[ERROR] RunExpirySweeper: r.DeleteExpired failed: %v", err)
+				continue
+			}
+			if n > 0 {
+				log.Printf("[INFO] RunExpirySweeper: deleted %d expired relationships", n)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,84 @@
+// Package authztest helps testing authorization models: it runs the authz service over an in-memory SQLite
+// database, seeds relationships into it and asserts permissions, failing the test on unexpected outcomes.
+// The database is the process-wide one of package db, so tests using it must not run in parallel.
+package authztest
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/romrossi/authz-rebac/pkg/authz"
+	"github.com/romrossi/authz-rebac/pkg/db"
+)
+
+// LoadSchema parses and validates a YAML schema, failing the test if it is invalid.
+func LoadSchema(t testing.TB, schema string) authz.Metadata {
+	t.Helper()
+	meta, err := authz.ParseMetadata([]byte(schema))
+	if err != nil {
+		t.Fatalf("invalid schema: %v", err)
+	}
+	return meta
+}
+
+// NewService connects a new in-memory SQLite database, closed when the test ends,
+// and returns a service over it with the schema, along with its repository.
+func NewService(t testing.TB, meta authz.Metadata) (authz.AuthzService, authz.AuthzRepository) {
+	t.Helper()
+	db.Connect(db.DriverSQLite, "", "", ":memory:", "", "")
+	conn := db.DB
+	t.Cleanup(func() { conn.Close() })
+
+	repo := authz.NewSQLiteRepository()
+	return authz.NewService(repo, meta), repo
+}
+
+// SeedRelations inserts relationships into the repository, without validating them against the schema.
+func SeedRelations(t testing.TB, repo authz.AuthzRepository, relationships ...authz.Relationship) {
+	t.Helper()
+	if _, err := repo.InsertBulk(context.Background(), relationships, authz.ConflictIgnore); err != nil {
+		t.Fatalf("seed relationships failed: %v", err)
+	}
+}
+
+// Rel builds a relationship from "type:id" objects.
+func Rel(resource, relation, subject string) authz.Relationship {
+	return authz.Relationship{Resource: Obj(resource), Relation: relation, Subject: Obj(subject)}
+}
+
+// Obj builds an object from "type:id".
+func Obj(raw string) authz.Object {
+	objectType, id, _ := strings.Cut(raw, ":")
+	return authz.Object{Type: objectType, ID: id}
+}
+
+// AssertAllowed fails the test unless the permission of the subject ("type:id") on the resource ("type:id")
+// is granted.
+func AssertAllowed(t testing.TB, svc authz.AuthzService, resource, subject, permission string) {
+	t.Helper()
+	if !Permitted(t, svc, resource, subject, permission) {
+		t.Errorf("%s is denied %s on %s, want allowed", subject, permission, resource)
+	}
+}
+
+// AssertDenied fails the test if the permission of the subject ("type:id") on the resource ("type:id")
+// is granted.
+func AssertDenied(t testing.TB, svc authz.AuthzService, resource, subject, permission string) {
+	t.Helper()
+	if Permitted(t, svc, resource, subject, permission) {
+		t.Errorf("%s is allowed %s on %s, want denied", subject, permission, resource)
+	}
+}
+
+// Permitted evaluates a permission of a subject on a resource, denied if no path connects them.
+// The test fails at once if the check does, e.g. for a permission missing from the schema.
+func Permitted(t testing.TB, svc authz.AuthzService, resource, subject, permission string) bool {
+	t.Helper()
+	request := authz.TraversalRequest{StartOn: Obj(resource), Forward: true, StopOn: Obj(subject)}
+	items, _, err := svc.CheckPermissions(context.Background(), request, authz.CheckOptions{Permissions: []string{permission}})
+	if err != nil {
+		t.Fatalf("check %s %s %s failed: %v", resource, permission, subject, err)
+	}
+	return len(items) == 1 && items[0].PermissionEvals[permission].Allowed
+}
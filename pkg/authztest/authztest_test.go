@@ -0,0 +1,108 @@
+package authztest_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/romrossi/authz-rebac/pkg/authz"
+	"github.com/romrossi/authz-rebac/pkg/authztest"
+)
+
+// docSchema is a small model: editors can view and edit a document, viewers can only view it,
+// and folder viewers inherit viewing on the documents of the folder.
+const docSchema = `
+schema_version: "1.0"
+objects:
+  user:
+    relations: {}
+  folder:
+    relations:
+      viewer:
+        subject_types: [user]
+    permissions:
+      view:
+        any_of: [viewer]
+  doc:
+    relations:
+      parent:
+        subject_types: [folder]
+      editor:
+        subject_types: [user]
+      viewer:
+        subject_types: [user]
+    permissions:
+      view:
+        any_of: [editor, viewer, parent->view]
+      edit:
+        any_of: [editor]
+`
+
+func TestDocModel(t *testing.T) {
+	svc, repo := authztest.NewService(t, authztest.LoadSchema(t, docSchema))
+	authztest.SeedRelations(t, repo,
+		authztest.Rel("doc:1", "editor", "user:alice"),
+		authztest.Rel("doc:1", "viewer", "user:bob"),
+		authztest.Rel("doc:1", "parent", "folder:a"),
+		authztest.Rel("folder:a", "viewer", "user:carol"),
+	)
+
+	tests := []struct {
+		subject    string
+		permission string
+		allowed    bool
+	}{
+		{"user:alice", "view", true},
+		{"user:alice", "edit", true},
+		{"user:bob", "view", true},
+		{"user:bob", "edit", false},
+		{"user:carol", "view", true},
+		{"user:carol", "edit", false},
+		{"user:dave", "view", false},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %s", tt.subject, tt.permission), func(t *testing.T) {
+			if tt.allowed {
+				authztest.AssertAllowed(t, svc, "doc:1", tt.subject, tt.permission)
+			} else {
+				authztest.AssertDenied(t, svc, "doc:1", tt.subject, tt.permission)
+			}
+		})
+	}
+}
+
+func TestEmbeddedSchema(t *testing.T) {
+	svc, repo := authztest.NewService(t, authz.LoadMetadata())
+	authztest.SeedRelations(t, repo,
+		authztest.Rel("project:1", "reader", "group:devs"),
+		authztest.Rel("group:devs", "member", "user:alice"),
+	)
+
+	authztest.AssertAllowed(t, svc, "project:1", "user:alice", "read")
+	authztest.AssertDenied(t, svc, "project:1", "user:alice", "edit")
+}
+
+// recorder is a testing.TB recording failures instead of reporting them.
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestAssertionsFail(t *testing.T) {
+	svc, repo := authztest.NewService(t, authztest.LoadSchema(t, docSchema))
+	authztest.SeedRelations(t, repo, authztest.Rel("doc:1", "viewer", "user:bob"))
+
+	rec := &recorder{TB: t}
+	authztest.AssertAllowed(rec, svc, "doc:1", "user:bob", "edit")
+	authztest.AssertDenied(rec, svc, "doc:1", "user:bob", "view")
+	want := []string{
+		"user:bob is denied edit on doc:1, want allowed",
+		"user:bob is allowed view on doc:1, want denied",
+	}
+	if fmt.Sprint(rec.errors) != fmt.Sprint(want) {
+		t.Errorf("errors = %q, want %q", rec.errors, want)
+	}
+}
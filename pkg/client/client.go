@@ -0,0 +1,168 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/romrossi/authz-rebac/pkg/authz"
+)
+
+// Client is a typed client of the authz HTTP API.
+type Client interface {
+	// Check reports whether the subject has the permission on the resource.
+	Check(ctx context.Context, resource, subject authz.Object, permission string) (bool, error)
+
+	// BatchCheck evaluates all permissions between resources and subjects matching the filters.
+	// Filters may omit the ID ("type"), but at least one of them must be specific ("type:id").
+	BatchCheck(ctx context.Context, resourceFilter, subjectFilter authz.Object) ([]authz.PermissionCheckItem, error)
+
+	// Write deletes then creates relationships atomically.
+	Write(ctx context.Context, creates, deletes []authz.Relationship) (authz.WriteResult, error)
+
+	// ListRelations lists the relationships of a resource and its parents.
+	ListRelations(ctx context.Context, resource authz.Object) ([]authz.Relationship, error)
+}
+
+// Error is returned when the server responds with a non-2xx status.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("authz: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// httpClient implements Client over HTTP.
+type httpClient struct {
+	baseURL    string
+	apiKey     string
+	timeout    time.Duration
+	httpClient *http.Client
+}
+
+// NewClient creates a client of the server at baseURL (e.g. "http://localhost:8080").
+// The API key is sent as a bearer token if not empty. If timeout is positive,
+// each call is bounded by it, in addition to the deadline of its context.
+func NewClient(baseURL, apiKey string, timeout time.Duration) Client {
+	return &httpClient{
+		baseURL:    strings.TrimRight(baseURL, "/") + "/api/v1",
+		apiKey:     apiKey,
+		timeout:    timeout,
+		httpClient: http.DefaultClient,
+	}
+}
+
+// Check calls GET /permissions/{permission}.
+func (c *httpClient) Check(ctx context.Context, resource, subject authz.Object, permission string) (bool, error) {
+	query := url.Values{}
+	query.Set("resource", objectParam(resource))
+	query.Set("subject", objectParam(subject))
+
+	var eval authz.PermissionEval
+	if err := c.do(ctx, http.MethodGet, "/permissions/"+url.PathEscape(permission), query, nil, &eval); err != nil {
+		return false, err
+	}
+	return eval.Allowed, nil
+}
+
+// BatchCheck calls GET /permissions.
+func (c *httpClient) BatchCheck(ctx context.Context, resourceFilter, subjectFilter authz.Object) ([]authz.PermissionCheckItem, error) {
+	query := url.Values{}
+	query.Set("resource_filter", objectParam(resourceFilter))
+	query.Set("subject_filter", objectParam(subjectFilter))
+
+	var items []authz.PermissionCheckItem
+	if err := c.do(ctx, http.MethodGet, "/permissions", query, nil, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// Write calls POST /relations.
+func (c *httpClient) Write(ctx context.Context, creates, deletes []authz.Relationship) (authz.WriteResult, error) {
+	request := authz.WriteRequest{Create: creates, Delete: deletes}
+
+	var result authz.WriteResult
+	if err := c.do(ctx, http.MethodPost, "/relations", nil, request, &result); err != nil {
+		return authz.WriteResult{}, err
+	}
+	return result, nil
+}
+
+// ListRelations calls GET /resources/{resource}/relations.
+func (c *httpClient) ListRelations(ctx context.Context, resource authz.Object) ([]authz.Relationship, error) {
+	var rels []authz.Relationship
+	if err := c.do(ctx, http.MethodGet, "/resources/"+url.PathEscape(objectParam(resource))+"/relations", nil, nil, &rels); err != nil {
+		return nil, err
+	}
+	return rels, nil
+}
+
+// do sends a request with an optional JSON body, and decodes the JSON response into out.
+func (c *httpClient) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	// Build request
+	target := c.baseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("authz: encode request failed: %w", err)
+		}
+		reader = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return fmt.Errorf("authz: build request failed: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	// Send request
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("authz: %s %s failed: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	// Decode response
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("authz: decode response failed: %w", err)
+	}
+	return nil
+}
+
+// objectParam formats an object as a "type:id" parameter, or "type" if it has no ID.
+func objectParam(o authz.Object) string {
+	if o.ID == "" {
+		return o.Type
+	}
+	return o.Type + ":" + o.ID
+}
@@ -0,0 +1,137 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/romrossi/authz-rebac/pkg/authz"
+	"github.com/romrossi/authz-rebac/pkg/db"
+	"github.com/romrossi/authz-rebac/pkg/router"
+)
+
+const apiKey = "test-key"
+
+// newTestServer serves the real handlers over a new in-memory SQLite database with the default schema,
+// behind API key authentication.
+func newTestServer(t *testing.T, wrap router.Middleware) *httptest.Server {
+	t.Helper()
+	meta := authz.LoadMetadata()
+	svc, _ := newService(t, meta)
+	h := authz.NewAuthzHandler(svc, meta, 0)
+
+	r := router.NewRouter()
+	r.AddGlobalMiddleware(router.APIKeyAuth(apiKey))
+	if wrap != nil {
+		r.AddGlobalMiddleware(wrap)
+	}
+	r.Handle("GET", "/api/v1/permissions/{permission}", h.CheckPermission())
+	r.Handle("GET", "/api/v1/permissions", h.CheckPermissions())
+	r.Handle("POST", "/api/v1/relations", h.ManageRelationships())
+	r.Handle("GET", "/api/v1/resources/{resource}/relations", h.ListResourceRelations())
+
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func obj(objectType, id string) authz.Object {
+	return authz.Object{Type: objectType, ID: id}
+}
+
+func TestClient(t *testing.T) {
+	server := newTestServer(t, nil)
+	c := NewClient(server.URL+"/", apiKey, time.Second)
+	ctx := context.Background()
+	project, alice, bob := obj("project", "1"), obj("user", "alice"), obj("user", "bob")
+
+	result, err := c.Write(ctx, []authz.Relationship{
+		{Resource: project, Relation: "owner", Subject: alice},
+		{Resource: project, Relation: "reader", Subject: bob},
+	}, nil)
+	if err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+	if result.Created != 2 {
+		t.Errorf("Write() created %d, want 2", result.Created)
+	}
+
+	if allowed, err := c.Check(ctx, project, alice, "edit"); err != nil || !allowed {
+		t.Errorf("Check(alice, edit) = %v, %v, want allowed", allowed, err)
+	}
+	if allowed, err := c.Check(ctx, project, bob, "edit"); err != nil || allowed {
+		t.Errorf("Check(bob, edit) = %v, %v, want denied", allowed, err)
+	}
+
+	items, err := c.BatchCheck(ctx, project, obj("user", ""))
+	if err != nil {
+		t.Fatalf("BatchCheck() failed: %v", err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("BatchCheck() = %+v, want alice and bob", items)
+	}
+	for _, item := range items {
+		if !item.PermissionEvals["read"].Allowed {
+			t.Errorf("item = %+v, want read allowed", item)
+		}
+	}
+
+	rels, err := c.ListRelations(ctx, project)
+	if err != nil || len(rels) != 2 {
+		t.Errorf("ListRelations() = %v, %v, want 2 relationships", rels, err)
+	}
+
+	result, err = c.Write(ctx, nil, []authz.Relationship{{Resource: project, Relation: "reader", Subject: bob}})
+	if err != nil || result.Deleted != 1 {
+		t.Errorf("Write() = %+v, %v, want 1 deleted", result, err)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	server := newTestServer(t, nil)
+	ctx := context.Background()
+
+	var apiErr *Error
+	_, err := NewClient(server.URL, "wrong-key", 0).Check(ctx, obj("project", "1"), obj("user", "alice"), "edit")
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Check() with a wrong key = %v, want a 401 Error", err)
+	}
+
+	_, err = NewClient(server.URL, apiKey, 0).Check(ctx, obj("project", "1"), obj("user", "alice"), "fly")
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("Check() of an unknown permission = %v, want a 400 Error", err)
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	slow := func(next router.HandlerFunc) router.HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request, params map[string]string) {
+			select {
+			case <-time.After(time.Second):
+			case <-req.Context().Done():
+			}
+			next(w, req, params)
+		}
+	}
+	server := newTestServer(t, slow)
+
+	_, err := NewClient(server.URL, apiKey, 50*time.Millisecond).Check(context.Background(), obj("project", "1"), obj("user", "alice"), "edit")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Check() = %v, want the deadline exceeded", err)
+	}
+}
+
+// newService connects a new in-memory SQLite database, closed when the test ends,
+// and returns a service over it with the schema, along with its repository.
+func newService(t *testing.T, meta authz.Metadata) (authz.AuthzService, authz.AuthzRepository) {
+	t.Helper()
+	db.Connect(db.DriverSQLite, "", "", ":memory:", "", "")
+	conn := db.DB
+	t.Cleanup(func() { conn.Close() })
+
+	repo := authz.NewSQLiteRepository()
+	return authz.NewService(repo, meta), repo
+}
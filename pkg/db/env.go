@@ -0,0 +1,24 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// EnvOrFile reads the file named by the envKey+"_FILE" environment variable (e.g. a mounted secret),
+// preferred over the envKey environment variable, and if neither is set, returns a default value.
+// A trailing newline is trimmed from the file content.
+func EnvOrFile(envKey, defaultVal string) (string, error) {
+	if path, exists := os.LookupEnv(envKey + "_FILE"); exists {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("cannot read %s_FILE: %w", envKey, err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+	if val, exists := os.LookupEnv(envKey); exists {
+		return val, nil
+	}
+	return defaultVal, nil
+}
@@ -0,0 +1,33 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEnvOrFile(t *testing.T) {
+	if got, err := EnvOrFile("AUTHZ_TEST_SECRET", "default"); got != "default" || err != nil {
+		t.Errorf("without variables: got %q, %v, want the default", got, err)
+	}
+
+	t.Setenv("AUTHZ_TEST_SECRET", "from-env")
+	if got, err := EnvOrFile("AUTHZ_TEST_SECRET", "default"); got != "from-env" || err != nil {
+		t.Errorf("with the variable: got %q, %v, want %q", got, err, "from-env")
+	}
+
+	// The file is preferred over the variable, without its trailing newline
+	path := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(path, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AUTHZ_TEST_SECRET_FILE", path)
+	if got, err := EnvOrFile("AUTHZ_TEST_SECRET", "default"); got != "from-file" || err != nil {
+		t.Errorf("with the file: got %q, %v, want %q", got, err, "from-file")
+	}
+
+	t.Setenv("AUTHZ_TEST_SECRET_FILE", filepath.Join(t.TempDir(), "missing"))
+	if _, err := EnvOrFile("AUTHZ_TEST_SECRET", "default"); err == nil {
+		t.Error("with a missing file: got no error")
+	}
+}
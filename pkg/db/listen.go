@@ -0,0 +1,115 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// pollInterval is the delay between signals to subscribers when notifications are not supported (MySQL).
+const pollInterval = time.Second
+
+// hub shares a single listener connection between all subscribers of the process.
+var hub = &listenerHub{subs: make(map[string]map[chan struct{}]struct{})}
+
+type listenerHub struct {
+	mu       sync.Mutex
+	listener *pq.Listener
+	subs     map[string]map[chan struct{}]struct{} // key: channel name
+}
+
+// Subscribe listens to a Postgres notification channel (LISTEN/NOTIFY) until ctx is done.
+// The returned channel receives a signal whenever notifications were received, or the listener
+// reconnected (and notifications may have been missed). Signals are coalesced: payloads are
+// not delivered, subscribers are expected to re-read the state they are interested in.
+// Drivers without notifications (MySQL) signal subscribers periodically instead.
+func Subscribe(ctx context.Context, channel string) (<-chan struct{}, error) {
+	if Driver != DriverPostgres {
+		return poll(ctx), nil
+	}
+
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	// Open the shared listener connection on first use
+	if hub.listener == nil {
+		hub.listener = pq.NewListener(connString, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+			if err != nil {
+				log.Printf("[WARN] db.Subscribe: listener event %d: %v", ev, err)
+			}
+		})
+		go hub.run(hub.listener)
+	}
+
+	// Listen to the channel on first subscription
+	if len(hub.subs[channel]) == 0 {
+		if err := hub.listener.Listen(channel); err != nil && err != pq.ErrChannelAlreadyOpen {
+			return nil, fmt.Errorf("listen %s failed: %w", channel, err)
+		}
+		hub.subs[channel] = make(map[chan struct{}]struct{})
+	}
+
+	sub := make(chan struct{}, 1)
+	hub.subs[channel][sub] = struct{}{}
+
+	// Unsubscribe when done
+	go func() {
+		<-ctx.Done()
+		hub.mu.Lock()
+		defer hub.mu.Unlock()
+		delete(hub.subs[channel], sub)
+		if len(hub.subs[channel]) == 0 {
+			delete(hub.subs, channel)
+			if err := hub.listener.Unlisten(channel); err != nil {
+				log.Printf("[WARN] db.Subscribe: unlisten %s failed: %v", channel, err)
+			}
+		}
+	}()
+
+	return sub, nil
+}
+
+// run dispatches notifications to subscribers.
+func (h *listenerHub) run(listener *pq.Listener) {
+	for n := range listener.NotificationChannel() {
+		h.mu.Lock()
+		for channel, subs := range h.subs {
+			// A nil notification means the connection was re-established: signal everyone
+			if n != nil && n.Channel != channel {
+				continue
+			}
+			for sub := range subs {
+				select {
+				case sub <- struct{}{}:
+				default: // a signal is already pending
+				}
+			}
+		}
+		h.mu.Unlock()
+	}
+}
+
+// poll signals the returned channel every pollInterval, until ctx is done.
+func poll(ctx context.Context) <-chan struct{} {
+	sub := make(chan struct{}, 1)
+	go func() {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				select {
+				case sub <- struct{}{}:
+				default: // a signal is already pending
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return sub
+}
@@ -0,0 +1,121 @@
+package db
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"sort"
+	"strings"
+)
+
+// migrations holds the SQL migrations of each driver, in migrations/<driver>/<version>.sql.
+// Versions are applied in lexical order: they are prefixed with a zero-padded sequence number.
+//
+//go:embed migrations
+var migrations embed.FS
+
+// Migrate applies the migrations of the connected driver which were not applied yet, each within its own transaction.
+// Applied versions are recorded in the schema_migrations table, so that running it again is a no-op.
+// With Postgres, the configured schema (DB_SCHEMA) is created if needed.
+func Migrate(ctx context.Context) error {
+	versions, err := migrationVersions(Driver)
+	if err != nil {
+		return err
+	}
+
+	if Driver == DriverPostgres {
+		// The search_path is the schema name validated on connection (see postgresConnString)
+		var schema string
+		if err := DB.QueryRowContext(ctx, "SELECT current_setting('search_path')").Scan(&schema); err != nil {
+			return fmt.Errorf("read search_path: %w", err)
+		}
+		if _, err := DB.ExecContext(ctx, "CREATE SCHEMA IF NOT EXISTS "+schema); err != nil {
+			return fmt.Errorf("create schema %s: %w", schema, err)
+		}
+	}
+	if _, err := DB.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version VARCHAR(255) NOT NULL PRIMARY KEY,
+		applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`); err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+
+	for _, version := range versions {
+		err := WithTransaction(ctx, func(ctx context.Context) error {
+			return applyMigration(ctx, version)
+		})
+		if err != nil {
+			return fmt.Errorf("migration %s: %w", version, err)
+		}
+	}
+	return nil
+}
+
+// applyMigration applies the given migration unless it was already applied, within the transaction of ctx.
+func applyMigration(ctx context.Context, version string) error {
+	stmt := GetStatement(ctx)
+	bindVar := "?"
+	if Driver == DriverPostgres {
+		bindVar = "$1"
+		// Serialize concurrent migrations (e.g. several instances started with --migrate)
+		if _, err := stmt.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext('schema_migrations'))"); err != nil {
+			return err
+		}
+	}
+
+	var applied int
+	if err := stmt.QueryRowContext(ctx, "SELECT COUNT(*) FROM schema_migrations WHERE version = "+bindVar, version).Scan(&applied); err != nil {
+		return err
+	}
+	if applied > 0 {
+		return nil
+	}
+
+	script, err := migrations.ReadFile("migrations/" + Driver + "/" + version + ".sql")
+	if err != nil {
+		return err
+	}
+	if _, err := stmt.ExecContext(ctx, string(script)); err != nil {
+		return err
+	}
+	if _, err := stmt.ExecContext(ctx, "INSERT INTO schema_migrations (version) VALUES ("+bindVar+")", version); err != nil {
+		return err
+	}
+	log.Printf("[INFO] db.Migrate: applied migration %s", version)
+	return nil
+}
+
+// migrationVersions lists the migration versions of the given driver, in order.
+func migrationVersions(driver string) ([]string, error) {
+	entries, err := fs.ReadDir(migrations, "migrations/"+driver)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for driver %q: %w", driver, err)
+	}
+	var versions []string
+	for _, entry := range entries {
+		if name := entry.Name(); !entry.IsDir() && strings.HasSuffix(name, ".sql") {
+			versions = append(versions, strings.TrimSuffix(name, ".sql"))
+		}
+	}
+	sort.Strings(versions)
+	return versions, nil
+}
+
+// relationshipColumns are the columns of the relationship table the repositories read and write.
+var relationshipColumns = []string{
+	"resource_type", "resource_id", "subject_type", "subject_id", "relation", "expires_at", "caveat_name", "caveat_context",
+}
+
+// VerifySchema checks that the relationship table exists with the expected columns, so that a database
+// missing migrations fails on startup rather than on every request. It reads no row.
+func VerifySchema(ctx context.Context) error {
+	query := "SELECT " + strings.Join(relationshipColumns, ", ") + " FROM relationship WHERE 1 = 0"
+	rows, err := DB.QueryContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("relationship table does not have the expected columns (%s): %w; "+
+			"apply the migrations with -migrate (or DB_MIGRATE=true)", strings.Join(relationshipColumns, ", "), err)
+	}
+	return rows.Close()
+}
@@ -0,0 +1,168 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMigrateTwiceIsNoOp(t *testing.T) {
+	Connect(DriverSQLite, "", "", ":memory:", "", "") // applies the migrations
+	defer DB.Close()
+	ctx := context.Background()
+
+	versions, err := migrationVersions(DriverSQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logs bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&logs)
+
+	if err := Migrate(ctx); err != nil {
+		t.Fatalf("Migrate() again failed: %v", err)
+	}
+	if strings.Contains(logs.String(), "applied migration") {
+		t.Errorf("Migrate() again applied migrations:\n%s", logs.String())
+	}
+	var applied int
+	if err := DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM schema_migrations").Scan(&applied); err != nil || applied != len(versions) {
+		t.Errorf("schema_migrations has %d versions, %v, want %d", applied, err, len(versions))
+	}
+}
+
+func TestMigrationVersionsMatchAcrossDrivers(t *testing.T) {
+	want, err := migrationVersions(DriverPostgres)
+	if err != nil || len(want) == 0 {
+		t.Fatalf("migrationVersions(postgres) = %v, %v", want, err)
+	}
+	for _, driver := range []string{DriverMySQL, DriverSQLite} {
+		got, err := migrationVersions(driver)
+		if err != nil || strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("migrationVersions(%s) = %v, %v, want %v", driver, got, err, want)
+		}
+	}
+	if _, err := migrationVersions("oracle"); err == nil {
+		t.Error("migrationVersions(oracle) = nil error, want no migrations")
+	}
+}
+
+func TestVerifySchema(t *testing.T) {
+	Connect(DriverSQLite, "", "", ":memory:", "", "") // applies the migrations
+	defer DB.Close()
+	ctx := context.Background()
+
+	if err := VerifySchema(ctx); err != nil {
+		t.Fatalf("VerifySchema() after migrations = %v, want nil", err)
+	}
+
+	// Like a database before 0003_caveats, whose triggers (updated by 0005_relationship_history) do not read the column
+	if _, err := DB.ExecContext(ctx, "DROP TRIGGER trg_relationship_insert; DROP TRIGGER trg_relationship_update"); err != nil {
+		t.Fatalf("drop triggers failed: %v", err)
+	}
+	if _, err := DB.ExecContext(ctx, "ALTER TABLE relationship DROP COLUMN caveat_context"); err != nil {
+		t.Fatalf("drop column failed: %v", err)
+	}
+	err := VerifySchema(ctx)
+	if err == nil || !strings.Contains(err.Error(), "caveat_context") || !strings.Contains(err.Error(), "-migrate") {
+		t.Errorf("VerifySchema() without caveat_context = %v, want an error suggesting migrations", err)
+	}
+}
+
+// TestHistoryBackfill checks that the relationship history records the relationships existing before it started,
+// with their expiry and caveat, which the changes previously recorded lack.
+func TestHistoryBackfill(t *testing.T) {
+	ctx := context.Background()
+	var err error
+	Driver = DriverSQLite
+	if DB, err = sql.Open(DriverSQLite, "file::memory:?_time_format=sqlite"); err != nil {
+		t.Fatal(err)
+	}
+	DB.SetMaxOpenConns(1)
+	defer DB.Close()
+
+	// Apply the migrations preceding the history, and write relationships fed as unconditional creations
+	versions, err := migrationVersions(DriverSQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DB.ExecContext(ctx, `CREATE TABLE schema_migrations (version VARCHAR(255) NOT NULL PRIMARY KEY, applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP)`); err != nil {
+		t.Fatal(err)
+	}
+	for _, version := range versions {
+		if version == "0005_relationship_history" {
+			break
+		}
+		if err := WithTransaction(ctx, func(ctx context.Context) error { return applyMigration(ctx, version) }); err != nil {
+			t.Fatalf("migration %s: %v", version, err)
+		}
+	}
+	if _, err := DB.ExecContext(ctx, `INSERT INTO relationship (resource_type, resource_id, relation, subject_type, subject_id, expires_at, caveat_name, caveat_context)
+		VALUES ('project', '1', 'reader', 'user', 'alice', '2999-01-01 00:00:00', 'on_weekdays', '{"day": 1}')`); err != nil {
+		t.Fatalf("insert failed: %v", err)
+	}
+
+	if err := Migrate(ctx); err != nil {
+		t.Fatalf("Migrate() failed: %v", err)
+	}
+	var changes, backfilled int
+	err = DB.QueryRowContext(ctx, `
+		SELECT COUNT(*), SUM(caveat_name = 'on_weekdays' AND expires_at IS NOT NULL
+			AND changed_at = (SELECT started_at FROM relationship_history))
+		FROM relationship_change WHERE action = 'create'`).Scan(&changes, &backfilled)
+	if err != nil || changes != 2 || backfilled != 1 {
+		t.Errorf("changes = %d, with %d backfilled at the start of the history (%v), want 2 with 1", changes, backfilled, err)
+	}
+}
+
+// TestMigrateFromBaselineSchema checks that the migrations upgrade a Postgres database created by the baseline
+// schema.sql, whose relationship table lacks expires_at. It runs in its own schema of AUTHZ_TEST_POSTGRES_URL.
+func TestMigrateFromBaselineSchema(t *testing.T) {
+	raw := os.Getenv("AUTHZ_TEST_POSTGRES_URL")
+	if raw == "" {
+		t.Skip("AUTHZ_TEST_POSTGRES_URL is not set")
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatalf("invalid AUTHZ_TEST_POSTGRES_URL: %v", err)
+	}
+	t.Setenv("DB_SCHEMA", "authz_baseline_test")
+	password, _ := u.User.Password()
+	Connect(DriverPostgres, u.Hostname(), u.Port(), strings.TrimPrefix(u.Path, "/"), u.User.Username(), password)
+	defer DB.Close()
+	ctx := context.Background()
+
+	baseline := `
+		DROP SCHEMA IF EXISTS authz_baseline_test CASCADE;
+		CREATE SCHEMA authz_baseline_test;
+		CREATE TABLE authz_baseline_test.relationship (
+			resource_id TEXT NOT NULL,
+			resource_type TEXT NOT NULL,
+			subject_id TEXT NOT NULL,
+			subject_type TEXT NOT NULL,
+			relation TEXT NOT NULL,
+			UNIQUE (resource_id, resource_type, subject_id, subject_type, relation)
+		);
+		INSERT INTO authz_baseline_test.relationship VALUES ('1', 'project', 'alice', 'user', 'reader');`
+	if _, err := DB.ExecContext(ctx, baseline); err != nil {
+		t.Fatalf("create baseline schema failed: %v", err)
+	}
+	defer DB.ExecContext(ctx, "DROP SCHEMA authz_baseline_test CASCADE")
+
+	if err := Migrate(ctx); err != nil {
+		t.Fatalf("Migrate() from the baseline schema failed: %v", err)
+	}
+	if err := VerifySchema(ctx); err != nil {
+		t.Errorf("VerifySchema() after migrations = %v, want nil", err)
+	}
+	var kept int
+	err = DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM relationship WHERE subject_id = 'alice' AND expires_at IS NULL").Scan(&kept)
+	if err != nil || kept != 1 {
+		t.Errorf("baseline relationships kept = %d (%v), want 1 that never expires", kept, err)
+	}
+}
@@ -0,0 +1,71 @@
+-- 0001_init.sql: MySQL 8 equivalent of the Postgres initial schema (tables live in the connected database)
+-- IDs and types are ASCII (see id_validation in the authz schema), which keeps unique keys within index limits.
+
+-- relationship
+-- Unlike Postgres (see its 0001_init.sql), the schema files preceding the migrations always defined expires_at:
+-- an existing table needs no upgrade, which is as well since ADD COLUMN has no IF NOT EXISTS here.
+CREATE TABLE IF NOT EXISTS relationship (
+    resource_id VARCHAR(256) CHARACTER SET ascii NOT NULL,
+    resource_type VARCHAR(64) CHARACTER SET ascii NOT NULL,
+    subject_id VARCHAR(256) CHARACTER SET ascii NOT NULL,
+    subject_type VARCHAR(64) CHARACTER SET ascii NOT NULL,
+    relation VARCHAR(64) CHARACTER SET ascii NOT NULL,
+    expires_at TIMESTAMP(6) NULL, -- NULL if the relationship never expires
+    UNIQUE KEY uq_relationship (resource_id, resource_type, subject_id, subject_type, relation),
+    KEY idx_relationship_subject (subject_type, subject_id),
+    KEY idx_relationship_resource (resource_type, resource_id),
+    KEY idx_relationship_expires_at (expires_at)
+);
+
+-- idempotency_key
+CREATE TABLE IF NOT EXISTS idempotency_key (
+    actor VARCHAR(255) NOT NULL,
+    `key` VARCHAR(255) NOT NULL,
+    request_hash CHAR(64) NOT NULL, -- hex SHA-256 of the write request
+    result TEXT NULL, -- WriteResult JSON, recorded with the write
+    created_at TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    PRIMARY KEY (actor, `key`),
+    KEY idx_idempotency_key_created_at (created_at)
+);
+
+-- relationship_change: change feed of the relationship table (polled, no notifications)
+CREATE TABLE IF NOT EXISTS relationship_change (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    action VARCHAR(16) NOT NULL, -- 'create' or 'delete'
+    resource_id VARCHAR(256) CHARACTER SET ascii NOT NULL,
+    resource_type VARCHAR(64) CHARACTER SET ascii NOT NULL,
+    subject_id VARCHAR(256) CHARACTER SET ascii NOT NULL,
+    subject_type VARCHAR(64) CHARACTER SET ascii NOT NULL,
+    relation VARCHAR(64) CHARACTER SET ascii NOT NULL,
+    changed_at TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)
+);
+
+DROP TRIGGER IF EXISTS trg_relationship_insert;
+CREATE TRIGGER trg_relationship_insert AFTER INSERT ON relationship FOR EACH ROW
+    INSERT INTO relationship_change (action, resource_id, resource_type, subject_id, subject_type, relation)
+    VALUES ('create', NEW.resource_id, NEW.resource_type, NEW.subject_id, NEW.subject_type, NEW.relation);
+
+-- An update changes the expiry of an existing relationship: it is fed as a new creation
+DROP TRIGGER IF EXISTS trg_relationship_update;
+CREATE TRIGGER trg_relationship_update AFTER UPDATE ON relationship FOR EACH ROW
+    INSERT INTO relationship_change (action, resource_id, resource_type, subject_id, subject_type, relation)
+    VALUES ('create', NEW.resource_id, NEW.resource_type, NEW.subject_id, NEW.subject_type, NEW.relation);
+
+DROP TRIGGER IF EXISTS trg_relationship_delete;
+CREATE TRIGGER trg_relationship_delete AFTER DELETE ON relationship FOR EACH ROW
+    INSERT INTO relationship_change (action, resource_id, resource_type, subject_id, subject_type, relation)
+    VALUES ('delete', OLD.resource_id, OLD.resource_type, OLD.subject_id, OLD.subject_type, OLD.relation);
+
+-- audit_log: who changed which relationship, and when
+CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    actor VARCHAR(255) NOT NULL,
+    action VARCHAR(16) NOT NULL, -- 'create' or 'delete'
+    resource_id VARCHAR(256) CHARACTER SET ascii NOT NULL,
+    resource_type VARCHAR(64) CHARACTER SET ascii NOT NULL,
+    subject_id VARCHAR(256) CHARACTER SET ascii NOT NULL,
+    subject_type VARCHAR(64) CHARACTER SET ascii NOT NULL,
+    relation VARCHAR(64) CHARACTER SET ascii NOT NULL,
+    created_at TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    KEY idx_audit_log_resource (resource_type, resource_id)
+);
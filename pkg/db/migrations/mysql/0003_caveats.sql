@@ -0,0 +1,6 @@
+-- 0003_caveats.sql: conditional relationships
+-- A relationship may name a caveat of the authz schema, with the JSON object of the parameters it fixes.
+
+ALTER TABLE relationship
+    ADD COLUMN caveat_name VARCHAR(64) NULL, -- NULL if the relationship is unconditional
+    ADD COLUMN caveat_context JSON NULL;
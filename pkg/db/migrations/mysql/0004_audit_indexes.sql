@@ -0,0 +1,8 @@
+-- 0004_audit_indexes.sql: indexes required by the filters of the audit log query (ListAuditEntries)
+-- Entries are listed by id (the primary key, also used by keyset pagination); each filter may search:
+-- - the resource with idx_audit_log_resource (resource_type, resource_id), created with the table by 0001_init
+-- - the subject, actor and creation time with the indexes below
+
+CREATE INDEX idx_audit_log_subject ON audit_log(subject_type, subject_id);
+CREATE INDEX idx_audit_log_actor ON audit_log(actor);
+CREATE INDEX idx_audit_log_created_at ON audit_log(created_at);
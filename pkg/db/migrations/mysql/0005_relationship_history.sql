@@ -0,0 +1,33 @@
+-- 0005_relationship_history.sql: relationship history, for checks as of a past time (TraversalRequest.AsOf)
+-- The change feed also records the expiry and caveat of each created relationship: the relationships valid
+-- at a time are the ones whose latest change until then is a creation, not expired at that time.
+-- Changes recorded before this migration have neither, and relationships written before the change feed have no
+-- change at all: the history starts with this migration, which records every existing relationship as created
+-- at its start (relationship_history), with its current expiry and caveat. Checks as of an earlier time are
+-- rejected. Watchers resuming from an earlier change receive these creations, restating existing relationships.
+
+ALTER TABLE relationship_change
+    ADD COLUMN expires_at TIMESTAMP(6) NULL,
+    ADD COLUMN caveat_name VARCHAR(64) NULL,
+    ADD COLUMN caveat_context JSON NULL;
+
+CREATE TABLE IF NOT EXISTS relationship_history (
+    started_at TIMESTAMP(6) NOT NULL
+);
+INSERT INTO relationship_history (started_at) VALUES (CURRENT_TIMESTAMP(6));
+
+INSERT INTO relationship_change (action, resource_id, resource_type, subject_id, subject_type, relation, expires_at, caveat_name, caveat_context, changed_at)
+SELECT 'create', resource_id, resource_type, subject_id, subject_type, relation, expires_at, caveat_name, caveat_context,
+    (SELECT started_at FROM relationship_history)
+FROM relationship;
+
+DROP TRIGGER IF EXISTS trg_relationship_insert;
+CREATE TRIGGER trg_relationship_insert AFTER INSERT ON relationship FOR EACH ROW
+    INSERT INTO relationship_change (action, resource_id, resource_type, subject_id, subject_type, relation, expires_at, caveat_name, caveat_context)
+    VALUES ('create', NEW.resource_id, NEW.resource_type, NEW.subject_id, NEW.subject_type, NEW.relation, NEW.expires_at, NEW.caveat_name, NEW.caveat_context);
+
+-- An update changes the expiry of an existing relationship: it is fed as a new creation
+DROP TRIGGER IF EXISTS trg_relationship_update;
+CREATE TRIGGER trg_relationship_update AFTER UPDATE ON relationship FOR EACH ROW
+    INSERT INTO relationship_change (action, resource_id, resource_type, subject_id, subject_type, relation, expires_at, caveat_name, caveat_context)
+    VALUES ('create', NEW.resource_id, NEW.resource_type, NEW.subject_id, NEW.subject_type, NEW.relation, NEW.expires_at, NEW.caveat_name, NEW.caveat_context);
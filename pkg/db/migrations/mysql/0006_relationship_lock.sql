@@ -0,0 +1,6 @@
+-- 0006_relationship_lock.sql: lock rows of the relationship writes (see LockRelationships)
+-- A write locks the rows its relationships hash to, created on first use, until the end of its transaction.
+
+CREATE TABLE IF NOT EXISTS relationship_lock (
+    slot INT UNSIGNED NOT NULL PRIMARY KEY
+);
@@ -0,0 +1,78 @@
+-- 0001_init.sql: initial schema
+-- Tables are created in the configured schema (DB_SCHEMA, default authz), which is the search_path of the connection.
+
+-- relationship
+CREATE TABLE IF NOT EXISTS relationship (
+    resource_id TEXT NOT NULL,
+    resource_type TEXT NOT NULL,
+    subject_id TEXT NOT NULL,
+    subject_type TEXT NOT NULL,
+    relation TEXT NOT NULL,
+    expires_at TIMESTAMPTZ NULL, -- NULL if the relationship never expires
+    UNIQUE (resource_id, resource_type, subject_id, subject_type, relation)
+);
+-- A table created by the baseline schema.sql, preceding the migrations, is kept as is: add its missing column
+ALTER TABLE relationship ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ NULL;
+CREATE INDEX IF NOT EXISTS idx_relationship_subject ON relationship(subject_type, subject_id);
+CREATE INDEX IF NOT EXISTS idx_relationship_resource ON relationship(resource_type, resource_id);
+CREATE INDEX IF NOT EXISTS idx_relationship_subject_type ON relationship(subject_type);
+CREATE INDEX IF NOT EXISTS idx_relationship_resource_type ON relationship(resource_type);
+CREATE INDEX IF NOT EXISTS idx_relationship_expires_at ON relationship(expires_at) WHERE expires_at IS NOT NULL;
+
+-- idempotency_key
+CREATE TABLE IF NOT EXISTS idempotency_key (
+    actor TEXT NOT NULL,
+    key TEXT NOT NULL,
+    request_hash TEXT NOT NULL, -- hex SHA-256 of the write request
+    result TEXT NULL, -- WriteResult JSON, recorded with the write
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (actor, key)
+);
+CREATE INDEX IF NOT EXISTS idx_idempotency_key_created_at ON idempotency_key(created_at);
+
+-- relationship_change: change feed of the relationship table
+CREATE TABLE IF NOT EXISTS relationship_change (
+    id BIGSERIAL PRIMARY KEY,
+    action TEXT NOT NULL, -- 'create' or 'delete'
+    resource_id TEXT NOT NULL,
+    resource_type TEXT NOT NULL,
+    subject_id TEXT NOT NULL,
+    subject_type TEXT NOT NULL,
+    relation TEXT NOT NULL,
+    changed_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE OR REPLACE FUNCTION record_relationship_change() RETURNS TRIGGER AS $$
+BEGIN
+    -- An update changes the expiry of an existing relationship: it is fed as a new creation
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        INSERT INTO relationship_change (action, resource_id, resource_type, subject_id, subject_type, relation)
+        VALUES ('create', NEW.resource_id, NEW.resource_type, NEW.subject_id, NEW.subject_type, NEW.relation);
+    ELSE
+        INSERT INTO relationship_change (action, resource_id, resource_type, subject_id, subject_type, relation)
+        VALUES ('delete', OLD.resource_id, OLD.resource_type, OLD.subject_id, OLD.subject_type, OLD.relation);
+    END IF;
+    -- Notifications are delivered on commit, and identical ones are merged within a transaction
+    PERFORM pg_notify('relationship_change', '');
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql SET search_path FROM CURRENT; -- tables resolve to the configured schema, whatever the caller's search_path
+
+DROP TRIGGER IF EXISTS trg_relationship_change ON relationship;
+CREATE TRIGGER trg_relationship_change
+    AFTER INSERT OR UPDATE OR DELETE ON relationship
+    FOR EACH ROW EXECUTE FUNCTION record_relationship_change();
+
+-- audit_log: who changed which relationship, and when
+CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    actor TEXT NOT NULL,
+    action TEXT NOT NULL, -- 'create' or 'delete'
+    resource_id TEXT NOT NULL,
+    resource_type TEXT NOT NULL,
+    subject_id TEXT NOT NULL,
+    subject_type TEXT NOT NULL,
+    relation TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_audit_log_resource ON audit_log(resource_type, resource_id);
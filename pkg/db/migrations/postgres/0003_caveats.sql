@@ -0,0 +1,5 @@
+-- 0003_caveats.sql: conditional relationships
+-- A relationship may name a caveat of the authz schema, with the JSON object of the parameters it fixes.
+
+ALTER TABLE relationship ADD COLUMN IF NOT EXISTS caveat_name TEXT NULL; -- NULL if the relationship is unconditional
+ALTER TABLE relationship ADD COLUMN IF NOT EXISTS caveat_context JSONB NULL;
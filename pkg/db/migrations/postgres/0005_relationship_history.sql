@@ -0,0 +1,37 @@
+-- 0005_relationship_history.sql: relationship history, for checks as of a past time (TraversalRequest.AsOf)
+-- The change feed also records the expiry and caveat of each created relationship: the relationships valid
+-- at a time are the ones whose latest change until then is a creation, not expired at that time.
+-- Changes recorded before this migration have neither, and relationships written before the change feed have no
+-- change at all: the history starts with this migration, which records every existing relationship as created
+-- at its start (relationship_history), with its current expiry and caveat. Checks as of an earlier time are
+-- rejected. Watchers resuming from an earlier change receive these creations, restating existing relationships.
+
+ALTER TABLE relationship_change ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ NULL;
+ALTER TABLE relationship_change ADD COLUMN IF NOT EXISTS caveat_name TEXT NULL;
+ALTER TABLE relationship_change ADD COLUMN IF NOT EXISTS caveat_context JSONB NULL;
+
+CREATE TABLE IF NOT EXISTS relationship_history (
+    started_at TIMESTAMPTZ NOT NULL
+);
+INSERT INTO relationship_history (started_at) VALUES (now());
+
+INSERT INTO relationship_change (action, resource_id, resource_type, subject_id, subject_type, relation, expires_at, caveat_name, caveat_context, changed_at)
+SELECT 'create', resource_id, resource_type, subject_id, subject_type, relation, expires_at, caveat_name, caveat_context,
+    (SELECT started_at FROM relationship_history)
+FROM relationship;
+
+CREATE OR REPLACE FUNCTION record_relationship_change() RETURNS TRIGGER AS $$
+BEGIN
+    -- An update changes the expiry of an existing relationship: it is fed as a new creation
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        INSERT INTO relationship_change (action, resource_id, resource_type, subject_id, subject_type, relation, expires_at, caveat_name, caveat_context)
+        VALUES ('create', NEW.resource_id, NEW.resource_type, NEW.subject_id, NEW.subject_type, NEW.relation, NEW.expires_at, NEW.caveat_name, NEW.caveat_context);
+    ELSE
+        INSERT INTO relationship_change (action, resource_id, resource_type, subject_id, subject_type, relation)
+        VALUES ('delete', OLD.resource_id, OLD.resource_type, OLD.subject_id, OLD.subject_type, OLD.relation);
+    END IF;
+    -- Notifications are delivered on commit, and identical ones are merged within a transaction
+    PERFORM pg_notify('relationship_change', '');
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql SET search_path FROM CURRENT; -- tables resolve to the configured schema, whatever the caller's search_path
@@ -0,0 +1,7 @@
+-- 0006_relationship_lock.sql: lock rows of the relationship writes (see LockRelationships)
+-- A write locks the rows its relationships hash to, created on first use, until the end of its transaction.
+-- The rows are bounded in number, unlike advisory locks on each relationship which could exhaust the lock table.
+
+CREATE TABLE IF NOT EXISTS relationship_lock (
+    slot INT NOT NULL PRIMARY KEY
+);
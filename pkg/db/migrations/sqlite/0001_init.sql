@@ -0,0 +1,73 @@
+-- 0001_init.sql: SQLite equivalent of the Postgres initial schema, applied on connection
+-- Timestamps are stored as text ("YYYY-MM-DD HH:MM:SS.SSS+HH:MM"), and compared with julianday().
+
+-- relationship
+-- Unlike Postgres (see its 0001_init.sql), the schema files preceding the migrations always defined expires_at:
+-- an existing table needs no upgrade, which is as well since ADD COLUMN has no IF NOT EXISTS here.
+CREATE TABLE IF NOT EXISTS relationship (
+    resource_id TEXT NOT NULL,
+    resource_type TEXT NOT NULL,
+    subject_id TEXT NOT NULL,
+    subject_type TEXT NOT NULL,
+    relation TEXT NOT NULL,
+    expires_at TIMESTAMP NULL, -- NULL if the relationship never expires
+    UNIQUE (resource_id, resource_type, subject_id, subject_type, relation)
+);
+CREATE INDEX IF NOT EXISTS idx_relationship_subject ON relationship(subject_type, subject_id);
+CREATE INDEX IF NOT EXISTS idx_relationship_resource ON relationship(resource_type, resource_id);
+CREATE INDEX IF NOT EXISTS idx_relationship_expires_at ON relationship(expires_at) WHERE expires_at IS NOT NULL;
+
+-- idempotency_key
+CREATE TABLE IF NOT EXISTS idempotency_key (
+    actor TEXT NOT NULL,
+    "key" TEXT NOT NULL,
+    request_hash TEXT NOT NULL, -- hex SHA-256 of the write request
+    result TEXT NULL, -- WriteResult JSON, recorded with the write
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (actor, "key")
+);
+
+-- relationship_change: change feed of the relationship table (polled, no notifications)
+CREATE TABLE IF NOT EXISTS relationship_change (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    action TEXT NOT NULL, -- 'create' or 'delete'
+    resource_id TEXT NOT NULL,
+    resource_type TEXT NOT NULL,
+    subject_id TEXT NOT NULL,
+    subject_type TEXT NOT NULL,
+    relation TEXT NOT NULL,
+    changed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TRIGGER IF NOT EXISTS trg_relationship_insert AFTER INSERT ON relationship
+BEGIN
+    INSERT INTO relationship_change (action, resource_id, resource_type, subject_id, subject_type, relation)
+    VALUES ('create', NEW.resource_id, NEW.resource_type, NEW.subject_id, NEW.subject_type, NEW.relation);
+END;
+
+-- An update changes the expiry of an existing relationship: it is fed as a new creation
+CREATE TRIGGER IF NOT EXISTS trg_relationship_update AFTER UPDATE ON relationship
+BEGIN
+    INSERT INTO relationship_change (action, resource_id, resource_type, subject_id, subject_type, relation)
+    VALUES ('create', NEW.resource_id, NEW.resource_type, NEW.subject_id, NEW.subject_type, NEW.relation);
+END;
+
+CREATE TRIGGER IF NOT EXISTS trg_relationship_delete AFTER DELETE ON relationship
+BEGIN
+    INSERT INTO relationship_change (action, resource_id, resource_type, subject_id, subject_type, relation)
+    VALUES ('delete', OLD.resource_id, OLD.resource_type, OLD.subject_id, OLD.subject_type, OLD.relation);
+END;
+
+-- audit_log: who changed which relationship, and when
+CREATE TABLE IF NOT EXISTS audit_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    actor TEXT NOT NULL,
+    action TEXT NOT NULL, -- 'create' or 'delete'
+    resource_id TEXT NOT NULL,
+    resource_type TEXT NOT NULL,
+    subject_id TEXT NOT NULL,
+    subject_type TEXT NOT NULL,
+    relation TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_audit_log_resource ON audit_log(resource_type, resource_id);
@@ -0,0 +1,8 @@
+-- 0002_traversal_indexes.sql: indexes required by the traversal queries (ListRelationships, ListPaths)
+-- Each recursion step looks up the relationships of the reached objects:
+-- - by resource when traversing backward, with idx_relationship_resource (resource_type, resource_id)
+-- - by subject when traversing forward, with idx_relationship_subject (subject_type, subject_id)
+-- Both were created with the relationship table by 0001_init: without them, each step is a full scan.
+-- Traversals restricted to a few relations (see TraversalRequest.Relations) can also use idx_relationship_relation.
+
+CREATE INDEX IF NOT EXISTS idx_relationship_relation ON relationship(relation);
@@ -0,0 +1,5 @@
+-- 0003_caveats.sql: conditional relationships
+-- A relationship may name a caveat of the authz schema, with the JSON object (as text) of the parameters it fixes.
+
+ALTER TABLE relationship ADD COLUMN caveat_name TEXT NULL; -- NULL if the relationship is unconditional
+ALTER TABLE relationship ADD COLUMN caveat_context TEXT NULL;
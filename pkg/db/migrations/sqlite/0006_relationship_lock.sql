@@ -0,0 +1,3 @@
+-- 0006_relationship_lock.sql: lock rows of the relationship writes (see LockRelationships)
+-- Nothing to do: the single connection to SQLite serializes the transactions, so that no lock is taken.
+-- The migration exists so that the versions match across drivers.
@@ -0,0 +1,22 @@
+package db
+
+import (
+	"net"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// mysqlConnString builds a MySQL connection string.
+// The database itself holds the authz tables (there is no separate schema as with Postgres).
+// Multiple statements per query are allowed, so that migrations are executed as a whole.
+func mysqlConnString(dbHost, dbPort, dbName, dbUser, dbPassword string) string {
+	cfg := mysql.NewConfig()
+	cfg.User = dbUser
+	cfg.Passwd = dbPassword
+	cfg.Net = "tcp"
+	cfg.Addr = net.JoinHostPort(dbHost, dbPort)
+	cfg.DBName = dbName
+	cfg.ParseTime = true
+	cfg.MultiStatements = true
+	return cfg.FormatDSN()
+}
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"regexp"
+	"strings"
 
 	_ "github.com/lib/pq"
 )
@@ -17,6 +19,19 @@ var txKey = txKeyType{}
 
 var DB *sql.DB
 
+// Supported database drivers.
+const (
+	DriverPostgres = "postgres"
+	DriverMySQL    = "mysql"
+	DriverSQLite   = "sqlite"
+)
+
+// Driver is the driver of DB.
+var Driver string
+
+// connString is the connection string of DB, reused to open dedicated listener connections.
+var connString string
+
 // Statement defines an interface for common database operations (implemented by *sql.DB and *sql.Tx)
 type Statement interface {
 	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
@@ -34,27 +49,38 @@ func GetStatement(ctx context.Context) Statement {
 	return DB // Default to global DB if no transaction in context
 }
 
-// InitDB initializes the database connection.
+// InitDB initializes the database connection, using the given driver (DriverPostgres, DriverMySQL or DriverSQLite).
 // It expects database connection details from environment variables:
-// DB_HOST, DB_PORT, DB_USER, DB_PASSWORD, DB_NAME, DB_SSLMODE
-func Connect(dbHost, dbPort, dbName, dbUser, dbPassword string) {
-	dbSSLMode := getEnv("DB_SSLMODE", "disable")
+// DB_HOST, DB_PORT, DB_USER, DB_PASSWORD, DB_NAME, DB_SSLMODE,
+// and optionally DB_SSLROOTCERT, DB_SSLCERT, DB_SSLKEY (Postgres certificate files), DB_SCHEMA (Postgres schema, default authz)
+// With SQLite, only DB_NAME is used: the database file, or ":memory:".
+func Connect(driver, dbHost, dbPort, dbName, dbUser, dbPassword string) {
+	if driver == DriverSQLite {
+		connectSQLite(dbName)
+		return
+	}
 
 	if dbHost == "" || dbPort == "" || dbName == "" || dbUser == "" || dbPassword == "" {
 		log.Fatal("Database environment variables (DB_HOST, DB_PORT, DB_NAME, DB_USER, DB_PASSWORD) are required.")
 	}
 
-	if dbSSLMode == "" {
-		dbSSLMode = "disable" // Default SSL mode
+	var connStr string
+	switch driver {
+	case DriverPostgres:
+		var err error
+		if connStr, err = postgresConnString(dbHost, dbPort, dbName, dbUser, dbPassword); err != nil {
+			log.Fatal("db config error:", err)
+		}
+	case DriverMySQL:
+		connStr = mysqlConnString(dbHost, dbPort, dbName, dbUser, dbPassword)
+	default:
+		log.Fatalf("Unsupported database driver: %q", driver)
 	}
 
-	// Build connection string
-	// - search_path option to use 'authz' schema as default
-	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s options='-c search_path=authz'",
-		dbHost, dbPort, dbUser, dbPassword, dbName, dbSSLMode)
-
 	var err error
-	DB, err = sql.Open("postgres", connStr)
+	Driver = driver
+	connString = connStr
+	DB, err = sql.Open(driver, connStr)
 	if err != nil {
 		log.Fatal("db connect error:", err)
 	}
@@ -63,6 +89,52 @@ func Connect(dbHost, dbPort, dbName, dbUser, dbPassword string) {
 	}
 }
 
+// schemaNamePattern matches the schema names accepted in the search_path option (unquoted identifiers).
+var schemaNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,62}$`)
+
+// postgresConnString builds a Postgres connection string.
+// Certificate files are only set if configured; verify-full requires a root certificate.
+func postgresConnString(dbHost, dbPort, dbName, dbUser, dbPassword string) (string, error) {
+	dbSSLMode := getEnv("DB_SSLMODE", "disable")
+
+	if dbSSLMode == "" {
+		dbSSLMode = "disable" // Default SSL mode
+	}
+
+	dbSSLRootCert := getEnv("DB_SSLROOTCERT", "")
+	dbSSLCert := getEnv("DB_SSLCERT", "")
+	dbSSLKey := getEnv("DB_SSLKEY", "")
+	if dbSSLMode == "verify-full" && dbSSLRootCert == "" {
+		return "", fmt.Errorf("DB_SSLROOTCERT is required with DB_SSLMODE=verify-full")
+	}
+	if (dbSSLCert == "") != (dbSSLKey == "") {
+		return "", fmt.Errorf("DB_SSLCERT and DB_SSLKEY must be set together")
+	}
+
+	dbSchema := getEnv("DB_SCHEMA", "authz")
+	if !schemaNamePattern.MatchString(dbSchema) {
+		return "", fmt.Errorf("DB_SCHEMA is invalid: %q must be a letter or underscore followed by letters, digits or underscores", dbSchema)
+	}
+
+	// Build connection string
+	// - user and password quoted, as they may contain spaces or quotes (e.g. read from secret files)
+	// - search_path option to use the configured schema as default
+	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s options='-c search_path=%s'",
+		dbHost, dbPort, pgQuote(dbUser), pgQuote(dbPassword), dbName, dbSSLMode, dbSchema)
+	if dbSSLRootCert != "" {
+		connStr += " sslrootcert=" + pgQuote(dbSSLRootCert)
+	}
+	if dbSSLCert != "" {
+		connStr += " sslcert=" + pgQuote(dbSSLCert) + " sslkey=" + pgQuote(dbSSLKey)
+	}
+	return connStr, nil
+}
+
+// pgQuote quotes a connection string value, so that it may contain spaces, quotes or backslashes.
+func pgQuote(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
+
 func getEnv(key, fallback string) string {
 	val := os.Getenv(key)
 	if val == "" {
@@ -75,17 +147,31 @@ func getEnv(key, fallback string) string {
 // If the function returns an error, the transaction is rolled back.
 // Otherwise, the transaction is committed.
 // Supports transaction propagation
-func WithTransaction(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+func WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return withTransaction(ctx, &sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  false,
+	}, fn)
+}
+
+// WithSnapshot executes the given function within a read-only transaction,
+// whose reads all observe the same snapshot of the database.
+// Supports transaction propagation (the existing transaction is then used as is).
+func WithSnapshot(ctx context.Context, fn func(ctx context.Context) error) error {
+	return withTransaction(ctx, &sql.TxOptions{
+		Isolation: sql.LevelRepeatableRead,
+		ReadOnly:  true,
+	}, fn)
+}
+
+func withTransaction(ctx context.Context, opts *sql.TxOptions, fn func(ctx context.Context) error) (err error) {
 	// Reuse existing transaction if any
 	if _, ok := getTx(ctx); ok {
 		return fn(ctx)
 	}
 
 	// Create new transaction
-	tx, err := DB.BeginTx(ctx, &sql.TxOptions{
-		Isolation: sql.LevelReadCommitted,
-		ReadOnly:  false,
-	})
+	tx, err := DB.BeginTx(ctx, opts)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,109 @@
+package db
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestPostgresConnStringSSL(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    []string
+		wantErr string
+	}{
+		{
+			name: "default",
+			want: []string{"host=db port=5432 user='authz' password='secret' dbname=authz sslmode=disable "},
+		},
+		{
+			name: "verify-full with certificates",
+			env: map[string]string{
+				"DB_SSLMODE":     "verify-full",
+				"DB_SSLROOTCERT": "/certs/root ca.pem",
+				"DB_SSLCERT":     "/certs/client.pem",
+				"DB_SSLKEY":      `/certs/o'key.pem`,
+			},
+			want: []string{"sslmode=verify-full ", " sslrootcert='/certs/root ca.pem'", ` sslcert='/certs/client.pem' sslkey='/certs/o\'key.pem'`},
+		},
+		{
+			name:    "verify-full without root certificate",
+			env:     map[string]string{"DB_SSLMODE": "verify-full"},
+			wantErr: "DB_SSLROOTCERT is required with DB_SSLMODE=verify-full",
+		},
+		{
+			name:    "certificate without key",
+			env:     map[string]string{"DB_SSLMODE": "require", "DB_SSLCERT": "/certs/client.pem"},
+			wantErr: "DB_SSLCERT and DB_SSLKEY must be set together",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"DB_SSLMODE", "DB_SSLROOTCERT", "DB_SSLCERT", "DB_SSLKEY", "DB_SCHEMA"} {
+				t.Setenv(key, tt.env[key])
+			}
+			connStr, err := postgresConnString("db", "5432", "authz", "authz", "secret")
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("postgresConnString() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("postgresConnString() failed: %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(connStr, want) {
+					t.Errorf("connection string %q does not contain %q", connStr, want)
+				}
+			}
+			if _, err := pq.NewConnector(connStr); err != nil {
+				t.Errorf("connection string %q does not parse: %v", connStr, err)
+			}
+			if tt.env["DB_SSLROOTCERT"] == "" && strings.Contains(connStr, "sslrootcert") {
+				t.Errorf("connection string %q sets sslrootcert, want none", connStr)
+			}
+		})
+	}
+}
+
+func TestPostgresConnStringCredentials(t *testing.T) {
+	t.Setenv("DB_SSLMODE", "")
+	t.Setenv("DB_SCHEMA", "")
+	connStr, err := postgresConnString("db", "5432", "authz", "o'ps admin", `s3cr3t pass\word sslmode=disable`)
+	if err != nil {
+		t.Fatalf("postgresConnString() failed: %v", err)
+	}
+	want := `user='o\'ps admin' password='s3cr3t pass\\word sslmode=disable' dbname=authz`
+	if !strings.Contains(connStr, want) {
+		t.Errorf("connection string %q does not contain %q", connStr, want)
+	}
+	if _, err := pq.NewConnector(connStr); err != nil {
+		t.Errorf("connection string %q does not parse: %v", connStr, err)
+	}
+}
+
+func TestPostgresConnStringSchema(t *testing.T) {
+	t.Setenv("DB_SSLMODE", "")
+	for _, tt := range []struct {
+		schema, want string
+	}{
+		{"", "options='-c search_path=authz'"},
+		{"tenant_42", "options='-c search_path=tenant_42'"},
+	} {
+		t.Setenv("DB_SCHEMA", tt.schema)
+		connStr, err := postgresConnString("db", "5432", "authz", "authz", "secret")
+		if err != nil || !strings.Contains(connStr, tt.want) {
+			t.Errorf("DB_SCHEMA=%q: connection string = %q, %v, want %q", tt.schema, connStr, err, tt.want)
+		}
+	}
+
+	for _, schema := range []string{"1tenant", "authz,public", "a' sslmode='disable", "tenant-42", strings.Repeat("s", 64)} {
+		t.Setenv("DB_SCHEMA", schema)
+		if _, err := postgresConnString("db", "5432", "authz", "authz", "secret"); err == nil || !strings.HasPrefix(err.Error(), "DB_SCHEMA is invalid") {
+			t.Errorf("DB_SCHEMA=%q: error = %v, want invalid", schema, err)
+		}
+	}
+}
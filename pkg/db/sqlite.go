@@ -0,0 +1,38 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/url"
+
+	_ "modernc.org/sqlite"
+)
+
+// connectSQLite opens a SQLite database file (created if needed), or an in-memory database for ":memory:".
+// Migrations are applied on connection, as SQLite databases are typically created on the fly.
+// All access goes through a single connection: SQLite serializes writes anyway,
+// and each connection to ":memory:" would otherwise open a distinct database.
+func connectSQLite(dbName string) {
+	if dbName == "" {
+		log.Fatal("Database name (DB_NAME) is required: a file path, or :memory:.")
+	}
+
+	// Times are written in a format understood by SQLite date functions
+	connStr := "file:" + dbName + "?" + url.Values{
+		"_time_format": {"sqlite"},
+		"_pragma":      {"busy_timeout(5000)"},
+	}.Encode()
+
+	var err error
+	Driver = DriverSQLite
+	connString = connStr
+	DB, err = sql.Open(DriverSQLite, connStr)
+	if err != nil {
+		log.Fatal("db connect error:", err)
+	}
+	DB.SetMaxOpenConns(1)
+	if err = Migrate(context.Background()); err != nil {
+		log.Fatal("db migration error:", err)
+	}
+}
@@ -0,0 +1,27 @@
+package db
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestConnectSQLiteFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "authz.db")
+	ctx := context.Background()
+
+	Connect(DriverSQLite, "", "", path, "", "")
+	if _, err := DB.ExecContext(ctx, `INSERT INTO relationship (resource_type, resource_id, relation, subject_type, subject_id)
+		VALUES ('project', '1', 'reader', 'user', 'alice')`); err != nil {
+		t.Fatalf("insert failed: %v", err)
+	}
+	DB.Close()
+
+	// Reopening the file keeps its relationships, and applies no migration twice
+	Connect(DriverSQLite, "", "", path, "", "")
+	defer DB.Close()
+	var n int
+	if err := DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM relationship`).Scan(&n); err != nil || n != 1 {
+		t.Errorf("relationships = %d, %v, want 1", n, err)
+	}
+}
@@ -0,0 +1,355 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+
+	"github.com/romrossi/authz-rebac/pkg/authz"
+	"github.com/romrossi/authz-rebac/pkg/router"
+)
+
+// Document builds the OpenAPI 3.0 document of the HTTP API.
+// Schemas are derived from the Go models, so they cannot drift from the JSON encoding.
+func Document() map[string]interface{} {
+	sr := newSchemaRegistry()
+	typeOf := func(v interface{}) reflect.Type { return reflect.TypeOf(v) }
+
+	paths := map[string]interface{}{
+		"/api/v1/permissions/{permission}": map[string]interface{}{
+			"get": operation("checkPermission", "Check a single permission of a subject on a resource",
+				[]Schema{
+					pathParam("permission", "Permission name, or comma-separated names (allowed if any is, detailed in permissions)"),
+					queryParam("resource", "Resource as \"type:id\"", true),
+					queryParam("subject", "Subject as \"type:id\"", true),
+					queryParam("at_least_as_fresh", "Consistency token returned by a write", false),
+					queryParam("as_of", "Past RFC 3339 time to check as of, from the relationship history (not with at_least_as_fresh)", false),
+					queryParam("caveat_context", "JSON object of the caveat parameters not set by relationships", false),
+					queryParam("exclude_relations", "Comma-separated relations to traverse as if they did not exist", false),
+					boolParam("show_matching_paths", "Include the paths granting the permission"),
+					boolParam("show_hops", "Annotate each path edge with its hop index from the resource, and whether it is the terminal edge"),
+					queryParam("path_format", "Format of the matching paths: \"full\" (default) or \"compact\" (matching_paths_compact)", false),
+					queryParam("max_paths", "Maximum number of matching paths shown (paths_truncated set if exceeded)", false),
+					boolParam("explain", "Include the reasoning behind the evaluation"),
+					boolParam("show_reason", "Include the reason code of a denial (no_path, excluded, no_matching_relation)"),
+				},
+				nil, sr.schemaOf(typeOf(authz.PermissionEval{}))),
+		},
+		"/api/v1/permissions/{permission}/count": map[string]interface{}{
+			"get": operation("countPermission", "Count the resources of a type on which a subject has a permission",
+				[]Schema{
+					pathParam("permission", "Permission name"),
+					queryParam("subject", "Subject as \"type:id\"", true),
+					queryParam("resource_type", "Type of the resources to count", true),
+					queryParam("at_least_as_fresh", "Consistency token returned by a write", false),
+					queryParam("max_results", "Maximum number of resources evaluated (Results-Truncated header set if exceeded)", false),
+					queryParam("caveat_context", "JSON object of the caveat parameters not set by relationships", false),
+					queryParam("exclude_relations", "Comma-separated relations to traverse as if they did not exist", false),
+				},
+				nil, sr.schemaOf(typeOf(authz.PermissionCount{}))),
+		},
+		"/api/v1/permissions": map[string]interface{}{
+			"get": operation("checkPermissions", "Check permissions between resources and subjects",
+				[]Schema{
+					queryParam("resource_filter", "Resource as \"type:id\" or \"type\" (required unless resource is set)", false),
+					queryParam("subject_filter", "Subject as \"type:id\" or \"type\" (comma-separated list accepted with a resource ID)", false),
+					queryParam("resource", "Resource \"type:id\" of an exact pair, instead of the filters: the response is its single item", false),
+					queryParam("subject", "Subject \"type:id\" of an exact pair, instead of the filters", false),
+					queryParam("permission", "Only evaluate this permission", false),
+					queryParam("permissions", "Only evaluate these comma-separated permissions", false),
+					queryParam("at_least_as_fresh", "Consistency token returned by a write", false),
+					queryParam("as_of", "Past RFC 3339 time to check as of, from the relationship history (not with at_least_as_fresh)", false),
+					queryParam("caveat_context", "JSON object of the caveat parameters not set by relationships", false),
+					queryParam("exclude_relations", "Comma-separated relations to traverse as if they did not exist", false),
+					queryParam("max_results", "Maximum number of resource-subject pairs (Results-Truncated header set if exceeded)", false),
+					boolParam("show_matching_paths", "Include the paths granting each permission"),
+					boolParam("show_hops", "Annotate each path edge with its hop index from the resource, and whether it is the terminal edge"),
+					queryParam("path_format", "Format of the matching paths: \"full\" (default) or \"compact\" (matching_paths_compact)", false),
+					queryParam("max_paths", "Maximum number of matching paths shown per permission (paths_truncated set if exceeded)", false),
+					boolParam("explain", "Include the reasoning behind each evaluation"),
+					boolParam("show_reason", "Include the reason code of each denial (excluded, no_matching_relation)"),
+				},
+				nil, Schema{"oneOf": []Schema{
+					sr.schemaOf(typeOf([]authz.PermissionCheckItem{})),
+					sr.schemaOf(typeOf(authz.PermissionCheckItem{})),
+				}}),
+		},
+		"/api/v1/permissions:warm": map[string]interface{}{
+			"post": operation("warmPaths", "Prime the effective paths of resources (no-op while no path cache is enabled)",
+				[]Schema{},
+				sr.schemaOf(typeOf(authz.WarmRequest{})), sr.schemaOf(typeOf(authz.WarmResult{}))),
+		},
+		"/api/v1/permissions/{permission}:checkSubjects": map[string]interface{}{
+			"post": operation("checkSubjects", "Check a permission on a resource for several subjects with one traversal",
+				[]Schema{
+					pathParam("permission", "Permission name"),
+					queryParam("at_least_as_fresh", "Consistency token returned by a write", false),
+				},
+				sr.schemaOf(typeOf(authz.SubjectsCheckRequest{})), sr.schemaOf(typeOf([]authz.SubjectCheck{}))),
+		},
+		"/api/v1/paths": map[string]interface{}{
+			"get": withNDJSON(operation("listPaths", "List effective relationship paths between resources and subjects",
+				[]Schema{
+					queryParam("resource_filter", "Resource as \"type:id\" or \"type\"", true),
+					queryParam("subject_filter", "Subject as \"type:id\" or \"type\" (comma-separated list accepted with a resource ID)", true),
+					queryParam("at_least_as_fresh", "Consistency token returned by a write", false),
+					queryParam("caveat_context", "JSON object of the caveat parameters not set by relationships", false),
+					queryParam("max_results", "Maximum number of resource-subject pairs (Results-Truncated header set if exceeded)", false),
+					boolParam("show_eliminated_paths", "Include the paths eliminated by precedence rules"),
+					boolParam("skip_precedence", "List every path, without applying deny overrides and precedence rules"),
+					boolParam("show_hops", "Annotate each path edge with its hop index from the resource, and whether it is the terminal edge"),
+				},
+				nil, sr.schemaOf(typeOf([]authz.TraversalResponseItem{}))),
+				sr.schemaOf(typeOf(authz.TraversalResponseItem{}))),
+		},
+		"/api/v1/resources/{resource}/relations": map[string]interface{}{
+			"get": withNDJSON(operation("listResourceRelations", "List relationships of a resource and its parents",
+				[]Schema{
+					pathParam("resource", "Resource as \"type:id\""),
+					queryParam("subject_types", "Comma-separated subject types to keep", false),
+					boolParam("must_exist", "Respond 404 instead of an empty list if the resource is in no relationship (Resource-Exists header)"),
+				},
+				nil, sr.schemaOf(typeOf([]authz.Relationship{}))),
+				sr.schemaOf(typeOf(authz.Relationship{}))),
+		},
+		"/api/v1/resources/{resource}/relations/count": map[string]interface{}{
+			"get": operation("countResourceRelations", "Count the relationships of a resource (not of its parents)",
+				[]Schema{
+					pathParam("resource", "Resource as \"type:id\""),
+					queryParam("relation", "Only count relationships of this relation (or its aliases)", false),
+				},
+				nil, sr.schemaOf(typeOf(authz.RelationshipCount{}))),
+		},
+		"/api/v1/resources/{resource}/relations/{relation}/subjects": map[string]interface{}{
+			"get": withNDJSON(operation("listResourceSubjects", "List the subjects directly related to a resource by a relation",
+				[]Schema{
+					pathParam("resource", "Resource as \"type:id\""),
+					pathParam("relation", "Relation name (or alias)"),
+				},
+				nil, sr.schemaOf(typeOf([]authz.Object{}))),
+				sr.schemaOf(typeOf(authz.Object{}))),
+		},
+		"/api/v1/subjects/{subject}/resources": map[string]interface{}{
+			"get": withNDJSON(operation("listSubjectResources", "List the resources a subject is granted permissions on, with the permissions",
+				[]Schema{
+					pathParam("subject", "Subject as \"type:id\""),
+					queryParam("resource_types", "Comma-separated resource types to traverse to (default: all types defining permissions)", false),
+					queryParam("at_least_as_fresh", "Consistency token returned by a write", false),
+					queryParam("caveat_context", "JSON object of the caveat parameters not set by relationships", false),
+					queryParam("max_results", "Maximum number of resources traversed to (Results-Truncated header set if exceeded)", false),
+				},
+				nil, sr.schemaOf(typeOf([]authz.ResourceAccess{}))),
+				sr.schemaOf(typeOf(authz.ResourceAccess{}))),
+		},
+		"/api/v1/relations": map[string]interface{}{
+			"post": withJSONError(operation("manageRelationships", "Delete then create relationships atomically",
+				[]Schema{
+					{"name": "Idempotency-Key", "in": "header", "required": false, "description": "Apply the write once: replays respond with the original result (422 if the key was used for another body)", "schema": Schema{"type": "string"}},
+					{"name": "X-Authz-Schema-Version", "in": "header", "required": false, "description": "Expected schema version (409 if the active one differs)", "schema": Schema{"type": "string"}},
+					boolParam("dry_run", "Simulate the write without persisting it"),
+					boolParam("schema_hints", "Report a relationship rejected by the schema as JSON, with the relations its resource type allows"),
+					boolParam("best_effort", "Write relationships one by one instead of all or nothing, and report the outcome of each"),
+				},
+				sr.schemaOf(typeOf(authz.WriteRequest{})), sr.schemaOf(typeOf(authz.WriteResult{}))),
+				sr.schemaOf(typeOf(authz.InvalidRelationshipError{}))),
+		},
+		"/api/v1/relations/watch": map[string]interface{}{
+			"get": map[string]interface{}{
+				"operationId": "watchRelations",
+				"summary":     "Stream relationship changes as Server-Sent Events",
+				"parameters": []Schema{
+					queryParam("since", "Change cursor to resume after", false),
+				},
+				"responses": map[string]interface{}{
+					"200": map[string]interface{}{
+						"description": "Stream of events, whose data is a RelationshipChange",
+						"content": map[string]interface{}{
+							"text/event-stream": map[string]interface{}{"schema": sr.schemaOf(typeOf(authz.RelationshipChange{}))},
+						},
+					},
+				},
+			},
+		},
+		"/api/v1/relations/{relation}/check": map[string]interface{}{
+			"get": operation("checkRelation", "Check whether an effective path from a resource to a subject contains a relation",
+				[]Schema{
+					pathParam("relation", "Relation name (or alias), of any object type"),
+					queryParam("resource", "Resource as \"type:id\"", true),
+					queryParam("subject", "Subject as \"type:id\"", true),
+					queryParam("at_least_as_fresh", "Consistency token returned by a write", false),
+					queryParam("as_of", "Past RFC 3339 time to check as of, from the relationship history (not with at_least_as_fresh)", false),
+					boolParam("show_matching_paths", "Include the paths containing the relation"),
+					boolParam("show_hops", "Annotate each path edge with its hop index from the resource, and whether it is the terminal edge"),
+					queryParam("path_format", "Format of the matching paths: \"full\" (default) or \"compact\" (matching_paths_compact)", false),
+				},
+				nil, sr.schemaOf(typeOf(authz.RelationCheck{}))),
+		},
+		"/api/v1/relations/export": map[string]interface{}{
+			"get": map[string]interface{}{
+				"operationId": "exportRelations",
+				"summary":     "Stream all relationships as newline-delimited JSON",
+				"parameters":  []Schema{},
+				"responses": map[string]interface{}{
+					"200": map[string]interface{}{
+						"description": "One Relationship per line",
+						"content": map[string]interface{}{
+							"application/x-ndjson": map[string]interface{}{"schema": sr.schemaOf(typeOf(authz.Relationship{}))},
+						},
+					},
+					"500": textResponse("Internal error"),
+				},
+			},
+		},
+		"/api/v1/audit": map[string]interface{}{
+			"get": operation("listAuditEntries", "List audit entries, oldest first",
+				[]Schema{
+					queryParam("resource", "Resource as \"type\" or \"type:id\"", false),
+					queryParam("subject", "Subject as \"type\" or \"type:id\"", false),
+					queryParam("actor", "Actor of the change", false),
+					queryParam("action", "\"create\" or \"delete\"", false),
+					queryParam("since", "Entries created at or after this RFC 3339 time", false),
+					queryParam("until", "Entries created before this RFC 3339 time", false),
+					queryParam("after", "ID of the last entry of the previous page", false),
+					queryParam("limit", "Maximum number of entries, 1000 by default (Results-Truncated header set if exceeded)", false),
+				},
+				nil, sr.schemaOf(typeOf([]authz.AuditEntry{}))),
+		},
+		"/api/v1/schema/objects": map[string]interface{}{
+			"get": operation("listObjectTypes", "List the object types of the schema",
+				[]Schema{},
+				nil, sr.schemaOf(typeOf([]string{}))),
+		},
+		"/api/v1/schema/objects/{type}": map[string]interface{}{
+			"get": operation("getObjectDefinition", "Get the relations and permissions of an object type",
+				[]Schema{
+					pathParam("type", "Object type"),
+					boolParam("include_rules", "Include the precedence rules"),
+				},
+				nil, sr.schemaOf(typeOf(authz.ObjectDefinition{}))),
+		},
+		"/api/v1/schema/objects/{type}/relations/{relation}": map[string]interface{}{
+			"get": operation("getRelationDefinition", "Get the subject types allowed by a relation of an object type",
+				[]Schema{
+					pathParam("type", "Object type"),
+					pathParam("relation", "Relation (or alias) of the type"),
+				},
+				nil, sr.schemaOf(typeOf(authz.RelationDefinition{}))),
+		},
+		"/api/v1/schema/validate": map[string]interface{}{
+			"post": map[string]interface{}{
+				"operationId": "validateSchema",
+				"summary":     "Validate a candidate schema without applying it",
+				"parameters":  []Schema{},
+				"requestBody": map[string]interface{}{
+					"required": true,
+					"content": map[string]interface{}{
+						"application/yaml": map[string]interface{}{"schema": Schema{"type": "string"}},
+					},
+				},
+				"responses": map[string]interface{}{
+					"200": map[string]interface{}{
+						"description": "Valid schema",
+						"content": map[string]interface{}{
+							"application/json": map[string]interface{}{"schema": sr.schemaOf(typeOf(authz.SchemaValidation{}))},
+						},
+					},
+					"422": map[string]interface{}{
+						"description": "Invalid schema, with every error found",
+						"content": map[string]interface{}{
+							"application/json": map[string]interface{}{"schema": sr.schemaOf(typeOf(authz.SchemaValidation{}))},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "authz-rebac",
+			"version": "1.0.0",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": sr.components,
+		},
+	}
+}
+
+// Handler serves the OpenAPI document as JSON.
+func Handler() router.HandlerFunc {
+	doc, err := json.Marshal(Document())
+	if err != nil {
+		panic("failed to build openapi document: " + err.Error())
+	}
+	return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(doc)
+	}
+}
+
+// operation describes an operation with JSON request (optional) and response bodies.
+func operation(id, summary string, params []Schema, requestBody, responseBody Schema) map[string]interface{} {
+	op := map[string]interface{}{
+		"operationId": id,
+		"summary":     summary,
+		"parameters":  params,
+		"responses": map[string]interface{}{
+			"200": map[string]interface{}{
+				"description": "OK",
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": responseBody},
+				},
+			},
+			"400": textResponse("Invalid request"),
+			"500": textResponse("Internal error"),
+		},
+	}
+	if requestBody != nil {
+		op["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": requestBody},
+			},
+		}
+	}
+	return op
+}
+
+// withNDJSON adds the newline-delimited JSON alternative of a list operation response,
+// returned when requested by the Accept header, with one item per line.
+func withNDJSON(op map[string]interface{}, itemSchema Schema) map[string]interface{} {
+	ok := op["responses"].(map[string]interface{})["200"].(map[string]interface{})
+	ok["content"].(map[string]interface{})["application/x-ndjson"] = map[string]interface{}{"schema": itemSchema}
+	return op
+}
+
+// withJSONError adds the JSON alternative of an operation's 400 response, returned when requested by a query parameter.
+func withJSONError(op map[string]interface{}, errorSchema Schema) map[string]interface{} {
+	invalid := op["responses"].(map[string]interface{})["400"].(map[string]interface{})
+	invalid["content"].(map[string]interface{})["application/json"] = map[string]interface{}{"schema": errorSchema}
+	return op
+}
+
+func textResponse(description string) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+		"content": map[string]interface{}{
+			"text/plain": map[string]interface{}{"schema": Schema{"type": "string"}},
+		},
+	}
+}
+
+func pathParam(name, description string) Schema {
+	return Schema{"name": name, "in": "path", "required": true, "description": description, "schema": Schema{"type": "string"}}
+}
+
+func queryParam(name, description string, required bool) Schema {
+	return Schema{"name": name, "in": "query", "required": required, "description": description, "schema": Schema{"type": "string"}}
+}
+
+func boolParam(name, description string) Schema {
+	return Schema{"name": name, "in": "query", "required": false, "description": description, "schema": Schema{"type": "boolean"}}
+}
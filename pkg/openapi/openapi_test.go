@@ -0,0 +1,262 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var (
+	versionPattern   = regexp.MustCompile(`^3\.0\.\d+$`)
+	templatePattern  = regexp.MustCompile(`\{([^}]+)\}`)
+	refPrefix        = "#/components/schemas/"
+	httpMethods      = map[string]bool{"get": true, "put": true, "post": true, "delete": true, "options": true, "head": true, "patch": true, "trace": true}
+	parameterIn      = map[string]bool{"path": true, "query": true, "header": true, "cookie": true}
+	schemaTypes      = map[string]bool{"string": true, "number": true, "integer": true, "boolean": true, "array": true, "object": true}
+	requiredSections = []string{"openapi", "info", "paths"}
+)
+
+// document returns Document() after a JSON round-trip, as a client would see it.
+func document(t *testing.T) map[string]interface{} {
+	t.Helper()
+	data, err := json.Marshal(Document())
+	if err != nil {
+		t.Fatalf("marshal document: %v", err)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("unmarshal document: %v", err)
+	}
+	return doc
+}
+
+// validator checks a decoded document against the structural rules of OpenAPI 3.0.
+type validator struct {
+	t          *testing.T
+	schemas    map[string]interface{}
+	operations map[string]string
+}
+
+func TestDocumentIsValid(t *testing.T) {
+	doc := document(t)
+	for _, section := range requiredSections {
+		if _, ok := doc[section]; !ok {
+			t.Fatalf("document has no %q", section)
+		}
+	}
+	if version, _ := doc["openapi"].(string); !versionPattern.MatchString(version) {
+		t.Errorf("openapi = %q, want 3.0.x", doc["openapi"])
+	}
+	info, _ := doc["info"].(map[string]interface{})
+	for _, field := range []string{"title", "version"} {
+		if s, _ := info[field].(string); s == "" {
+			t.Errorf("info.%s is missing", field)
+		}
+	}
+
+	components, _ := doc["components"].(map[string]interface{})
+	v := &validator{t: t, operations: map[string]string{}}
+	v.schemas, _ = components["schemas"].(map[string]interface{})
+	for name, schema := range v.schemas {
+		v.schema("components.schemas."+name, schema)
+	}
+
+	paths, _ := doc["paths"].(map[string]interface{})
+	if len(paths) == 0 {
+		t.Fatal("document has no paths")
+	}
+	for path, item := range paths {
+		v.path(path, item)
+	}
+}
+
+func (v *validator) path(path string, item interface{}) {
+	if !strings.HasPrefix(path, "/") {
+		v.t.Errorf("path %q does not start with /", path)
+	}
+	var templated []string
+	for _, m := range templatePattern.FindAllStringSubmatch(path, -1) {
+		templated = append(templated, m[1])
+	}
+	sort.Strings(templated)
+
+	operations, _ := item.(map[string]interface{})
+	if len(operations) == 0 {
+		v.t.Errorf("path %q has no operations", path)
+	}
+	for method, op := range operations {
+		where := strings.ToUpper(method) + " " + path
+		if !httpMethods[method] {
+			v.t.Errorf("%s: unknown method", where)
+			continue
+		}
+		operation, _ := op.(map[string]interface{})
+		v.operation(where, operation, templated)
+	}
+}
+
+func (v *validator) operation(where string, operation map[string]interface{}, templated []string) {
+	id, _ := operation["operationId"].(string)
+	if id == "" {
+		v.t.Errorf("%s: missing operationId", where)
+	} else if other, dup := v.operations[id]; dup {
+		v.t.Errorf("%s: operationId %q already used by %s", where, id, other)
+	} else {
+		v.operations[id] = where
+	}
+
+	var declared []string
+	seen := map[string]bool{}
+	parameters, _ := operation["parameters"].([]interface{})
+	for i, p := range parameters {
+		param, _ := p.(map[string]interface{})
+		name, _ := param["name"].(string)
+		in, _ := param["in"].(string)
+		pwhere := where + " parameter " + name
+		if name == "" || !parameterIn[in] {
+			v.t.Errorf("%s: parameter %d has name %q and in %q", where, i, name, in)
+			continue
+		}
+		if seen[in+":"+name] {
+			v.t.Errorf("%s: declared twice", pwhere)
+		}
+		seen[in+":"+name] = true
+		if _, ok := param["schema"]; !ok {
+			v.t.Errorf("%s: missing schema", pwhere)
+		} else {
+			v.schema(pwhere, param["schema"])
+		}
+		if in == "path" {
+			if required, _ := param["required"].(bool); !required {
+				v.t.Errorf("%s: path parameters must be required", pwhere)
+			}
+			declared = append(declared, name)
+		}
+	}
+	sort.Strings(declared)
+	if strings.Join(declared, ",") != strings.Join(templated, ",") {
+		v.t.Errorf("%s: path parameters %v, template has %v", where, declared, templated)
+	}
+
+	if body, ok := operation["requestBody"].(map[string]interface{}); ok {
+		v.content(where+" requestBody", body)
+	}
+
+	responses, _ := operation["responses"].(map[string]interface{})
+	if len(responses) == 0 {
+		v.t.Errorf("%s: no responses", where)
+	}
+	for status, r := range responses {
+		rwhere := where + " response " + status
+		response, _ := r.(map[string]interface{})
+		if d, _ := response["description"].(string); d == "" {
+			v.t.Errorf("%s: missing description", rwhere)
+		}
+		v.content(rwhere, response)
+		headers, _ := response["headers"].(map[string]interface{})
+		for name, h := range headers {
+			header, _ := h.(map[string]interface{})
+			v.schema(rwhere+" header "+name, header["schema"])
+		}
+	}
+}
+
+func (v *validator) content(where string, holder map[string]interface{}) {
+	content, _ := holder["content"].(map[string]interface{})
+	for mediaType, m := range content {
+		media, _ := m.(map[string]interface{})
+		if _, ok := media["schema"]; !ok {
+			v.t.Errorf("%s %s: missing schema", where, mediaType)
+			continue
+		}
+		v.schema(where+" "+mediaType, media["schema"])
+	}
+}
+
+func (v *validator) schema(where string, s interface{}) {
+	schema, ok := s.(map[string]interface{})
+	if !ok {
+		v.t.Errorf("%s: schema is %T, want an object", where, s)
+		return
+	}
+	if ref, ok := schema["$ref"].(string); ok {
+		if len(schema) > 1 {
+			v.t.Errorf("%s: $ref %q has siblings, which OpenAPI 3.0 ignores", where, ref)
+		}
+		if _, found := v.schemas[strings.TrimPrefix(ref, refPrefix)]; !strings.HasPrefix(ref, refPrefix) || !found {
+			v.t.Errorf("%s: unresolved $ref %q", where, ref)
+		}
+		return
+	}
+	if typ, ok := schema["type"]; ok {
+		if name, _ := typ.(string); !schemaTypes[name] {
+			v.t.Errorf("%s: invalid type %v", where, typ)
+		}
+	}
+	if schema["type"] == "array" {
+		if _, ok := schema["items"]; !ok {
+			v.t.Errorf("%s: array without items", where)
+		}
+	}
+	if items, ok := schema["items"]; ok {
+		v.schema(where+"[]", items)
+	}
+	if additional, ok := schema["additionalProperties"].(map[string]interface{}); ok {
+		v.schema(where+"{}", additional)
+	}
+	for _, keyword := range []string{"allOf", "oneOf", "anyOf"} {
+		list, _ := schema[keyword].([]interface{})
+		for _, sub := range list {
+			v.schema(where+" "+keyword, sub)
+		}
+	}
+	properties, _ := schema["properties"].(map[string]interface{})
+	for name, property := range properties {
+		v.schema(where+"."+name, property)
+	}
+	required, _ := schema["required"].([]interface{})
+	for _, r := range required {
+		if name, _ := r.(string); properties[name] == nil {
+			v.t.Errorf("%s: required property %q is not defined", where, r)
+		}
+	}
+}
+
+func TestHandlerServesDocument(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Handler()(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil), nil)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("body is not JSON: %v", err)
+	}
+	if doc["openapi"] != document(t)["openapi"] {
+		t.Errorf("served document differs from Document()")
+	}
+}
+
+func TestConflictPolicySchema(t *testing.T) {
+	components, _ := document(t)["components"].(map[string]interface{})
+	schemas, _ := components["schemas"].(map[string]interface{})
+	writeRequest, _ := schemas["WriteRequest"].(map[string]interface{})
+	properties, _ := writeRequest["properties"].(map[string]interface{})
+	policy, _ := properties["on_conflict"].(map[string]interface{})
+
+	enum, _ := json.Marshal(policy["enum"])
+	if string(enum) != `["ignore","upsert","error"]` {
+		t.Errorf("on_conflict enum = %s, want ignore, upsert and error", enum)
+	}
+	if description, _ := policy["description"].(string); !strings.Contains(description, `"ignore" (default)`) {
+		t.Errorf("on_conflict description = %q, want ignore as the default", description)
+	}
+}
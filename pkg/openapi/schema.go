@@ -0,0 +1,115 @@
+package openapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/romrossi/authz-rebac/pkg/authz"
+)
+
+// Schema is an OpenAPI schema object.
+type Schema map[string]interface{}
+
+var (
+	objectType    = reflect.TypeOf(authz.Object{})
+	conflictType  = reflect.TypeOf(authz.ConflictIgnore)
+	timeType      = reflect.TypeOf(time.Time{})
+	marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+// schemaRegistry derives schemas from Go types, collecting named struct types as components.
+type schemaRegistry struct {
+	components map[string]Schema
+}
+
+func newSchemaRegistry() *schemaRegistry {
+	return &schemaRegistry{components: make(map[string]Schema)}
+}
+
+// schemaOf derives the schema of a type, following its JSON encoding.
+// Struct types are registered as components, and referenced.
+func (sr *schemaRegistry) schemaOf(t reflect.Type) Schema {
+	switch {
+	case t == objectType:
+		return Schema{
+			"oneOf": []Schema{
+				{"type": "string", "pattern": "^[^:]+:.+$", "example": "project:42"},
+				{"type": "object", "properties": Schema{"type": Schema{"type": "string"}, "id": Schema{"type": "string"}}, "required": []string{"type", "id"}},
+			},
+			"description": "Object as \"type:id\", or as {\"type\", \"id\"} if the server is configured with the expanded object format",
+		}
+	case t == conflictType:
+		return Schema{
+			"type":        "string",
+			"enum":        []authz.ConflictPolicy{authz.ConflictIgnore, authz.ConflictUpsert, authz.ConflictError},
+			"description": "How creations of existing relationships are handled: \"ignore\" (default) leaves them unchanged, \"upsert\" replaces their expiry and caveat, \"error\" fails the write with 409",
+		}
+	case t == timeType:
+		return Schema{"type": "string", "format": "date-time"}
+	case t.Implements(marshalerType):
+		return Schema{"description": "Custom JSON encoding"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := sr.schemaOf(t.Elem())
+		if _, isRef := schema["$ref"]; isRef {
+			return Schema{"allOf": []Schema{schema}, "nullable": true}
+		}
+		schema["nullable"] = true
+		return schema
+	case reflect.Bool:
+		return Schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Schema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return Schema{"type": "number"}
+	case reflect.String:
+		return Schema{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return Schema{"type": "array", "items": sr.schemaOf(t.Elem())}
+	case reflect.Map:
+		return Schema{"type": "object", "additionalProperties": sr.schemaOf(t.Elem())}
+	case reflect.Struct:
+		name := t.Name()
+		if _, ok := sr.components[name]; !ok {
+			sr.components[name] = nil // placeholder, for recursive types
+			sr.components[name] = sr.structSchema(t)
+		}
+		return Schema{"$ref": "#/components/schemas/" + name}
+	}
+	return Schema{}
+}
+
+// structSchema derives the object schema of a struct from its exported fields and JSON tags.
+func (sr *schemaRegistry) structSchema(t reflect.Type) Schema {
+	properties := Schema{}
+	var required []string
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = sr.schemaOf(field.Type)
+		if !strings.Contains(opts, "omitempty") {
+			required = append(required, name)
+		}
+	}
+
+	schema := Schema{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
@@ -0,0 +1,70 @@
+package router
+
+import (
+	"log"
+	"net/http"
+	"time"
+)
+
+// AccessLog returns a middleware logging one line per request to logger, once its handler returns:
+// method, path (without the query string, which may carry object IDs), response status and body size
+// in bytes (as sent, i.e. compressed if it is registered before Gzip), duration, and X-Request-ID header.
+// Fields are logged as key=value pairs, strings quoted. The status of a response without explicit status is 200.
+func AccessLog(logger *log.Logger) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request, params map[string]string) {
+			start := time.Now()
+			aw := &accessLogResponseWriter{ResponseWriter: w}
+			defer func() {
+				logger.Printf("[INFO] access: method=%s path=%q status=%d bytes=%d duration=%s request_id=%q",
+					req.Method, req.URL.Path, aw.statusOrOK(), aw.bytes, time.Since(start), req.Header.Get(requestIDHeader))
+			}()
+			next(aw, req, params)
+		}
+	}
+}
+
+// accessLogResponseWriter records the status and the body size of a response.
+type accessLogResponseWriter struct {
+	http.ResponseWriter
+	status int // 0 until the response starts
+	bytes  int64
+}
+
+func (w *accessLogResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessLogResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *accessLogResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the underlying writer, for http.ResponseController.
+func (w *accessLogResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// statusOrOK returns the status of the response, or 200 if the handler wrote nothing (as net/http responds).
+func (w *accessLogResponseWriter) statusOrOK() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
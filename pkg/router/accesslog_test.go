@@ -0,0 +1,67 @@
+package router
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestAccessLog(t *testing.T) {
+	fails := func(w http.ResponseWriter, req *http.Request, params map[string]string) {
+		http.Error(w, "invalid parameter 'resource'", http.StatusBadRequest)
+	}
+	silent := func(w http.ResponseWriter, req *http.Request, params map[string]string) {}
+	tests := []struct {
+		name    string
+		handler HandlerFunc
+		status  string
+		bytes   string
+	}{
+		{"error", fails, "400", "29"},
+		{"explicit status", ok, "200", "0"},
+		{"no response", silent, "200", "0"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		serve(AccessLog(log.New(&buf, "", 0)), tt.handler, map[string]string{"X-Request-ID": "req-1"})
+
+		line := buf.String()
+		if strings.Count(line, "\n") != 1 {
+			t.Errorf("%s: logged %q, want one line", tt.name, line)
+			continue
+		}
+		fields := map[string]string{}
+		for _, match := range regexp.MustCompile(`(\w+)=("[^"]*"|\S+)`).FindAllStringSubmatch(line, -1) {
+			fields[match[1]] = match[2]
+		}
+		want := map[string]string{
+			"method":     "GET",
+			"path":       `"/api/v1/permissions"`,
+			"status":     tt.status,
+			"bytes":      tt.bytes,
+			"request_id": `"req-1"`,
+		}
+		for name, value := range want {
+			if fields[name] != value {
+				t.Errorf("%s: %s = %q, want %q (line: %s)", tt.name, name, fields[name], value, line)
+			}
+		}
+		if !regexp.MustCompile(`^[0-9.]+[µnm]?s$`).MatchString(fields["duration"]) {
+			t.Errorf("%s: duration = %q, want a duration", tt.name, fields["duration"])
+		}
+	}
+}
+
+func TestAccessLogRecordsRecoveredPanic(t *testing.T) {
+	var buf bytes.Buffer
+	panics := func(w http.ResponseWriter, req *http.Request, params map[string]string) {
+		panic("failure")
+	}
+	serve(AccessLog(log.New(&buf, "", 0)), Recover()(panics), nil)
+	if !strings.Contains(buf.String(), " status=500 ") {
+		t.Errorf("logged %q, want status=500", buf.String())
+	}
+}
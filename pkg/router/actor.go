@@ -0,0 +1,40 @@
+package router
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/romrossi/authz-rebac/pkg/actor"
+)
+
+// ActorFunc identifies who performs a request, or returns "" if unknown.
+type ActorFunc func(*http.Request) string
+
+// Actor returns a middleware storing the actor identified by actorFunc in the request context (see actor.FromContext).
+func Actor(actorFunc ActorFunc) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request, params map[string]string) {
+			if name := actorFunc(req); name != "" {
+				req = req.WithContext(actor.NewContext(req.Context(), name))
+			}
+			next(w, req, params)
+		}
+	}
+}
+
+// APIKeyActor identifies the actor by a fingerprint of its API key (see APIKeyAuth),
+// so that keys never appear in clear text where actors are recorded.
+func APIKeyActor(req *http.Request) string {
+	key := requestAPIKey(req)
+	if key == "" {
+		return ""
+	}
+	return "api-key:" + apiKeyFingerprint(key)
+}
+
+// apiKeyFingerprint returns a short hash identifying an API key without revealing it.
+func apiKeyFingerprint(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])[:12]
+}
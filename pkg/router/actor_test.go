@@ -0,0 +1,27 @@
+package router
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/romrossi/authz-rebac/pkg/actor"
+)
+
+func TestActorSetsContext(t *testing.T) {
+	var got string
+	handler := func(w http.ResponseWriter, req *http.Request, params map[string]string) {
+		got = actor.FromContext(req.Context())
+	}
+
+	rec := serve(Actor(APIKeyActor), handler, map[string]string{"X-API-Key": "secret-key"})
+	if rec.Code != http.StatusOK || !strings.HasPrefix(got, "api-key:") || strings.Contains(got, "secret-key") {
+		t.Errorf("actor = %q, want the fingerprint of the API key", got)
+	}
+
+	got = "unset"
+	serve(Actor(APIKeyActor), handler, nil)
+	if got != "" {
+		t.Errorf("actor = %q, want none without an API key", got)
+	}
+}
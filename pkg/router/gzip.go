@@ -0,0 +1,136 @@
+package router
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// gzipMinSize is the smallest response body compressed by Gzip: below it, compression saves little
+// (a gzip stream alone takes about 20 bytes) and costs CPU on every tiny check response.
+const gzipMinSize = 1024
+
+// Gzip returns a middleware compressing responses for clients sending "Accept-Encoding: gzip".
+// The body is buffered until it reaches gzipMinSize bytes, or the handler flushes it: smaller responses are sent
+// as is. Responses already carrying a Content-Encoding are never compressed.
+func Gzip() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request, params map[string]string) {
+			w.Header().Add("Vary", "Accept-Encoding")
+			if !acceptsGzip(req) {
+				next(w, req, params)
+				return
+			}
+			gw := &gzipResponseWriter{ResponseWriter: w, status: http.StatusOK}
+			defer gw.close()
+			next(gw, req, params)
+		}
+	}
+}
+
+// acceptsGzip reports whether the Accept-Encoding header of the request lists gzip, without "q=0".
+func acceptsGzip(req *http.Request) bool {
+	for _, coding := range strings.Split(req.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(coding, ";")
+		if !strings.EqualFold(strings.TrimSpace(name), "gzip") {
+			continue
+		}
+		if name, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(name) == "q" {
+			q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			return err == nil && q > 0
+		}
+		return true
+	}
+	return false
+}
+
+// gzipResponseWriter holds back the status and the start of the body until it knows whether to compress it.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	status  int
+	buf     []byte
+	started bool
+	gz      *gzip.Writer // nil if the body is sent uncompressed
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if !w.started {
+		w.status = status
+	}
+}
+
+func (w *gzipResponseWriter) Write(p []byte) (int, error) {
+	if !w.started {
+		w.buf = append(w.buf, p...)
+		if len(w.buf) < gzipMinSize {
+			return len(p), nil
+		}
+		if err := w.start(true); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if w.gz != nil {
+		return w.gz.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush sends what was written so far, compressed: a flushing handler is streaming, so its body may grow large.
+func (w *gzipResponseWriter) Flush() {
+	if !w.started {
+		if err := w.start(true); err != nil {
+			return
+		}
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the underlying writer, for http.ResponseController.
+func (w *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// start sends the status, then the buffered body, compressed if requested and possible.
+func (w *gzipResponseWriter) start(compress bool) error {
+	w.started = true
+	header := w.ResponseWriter.Header()
+	if compress && header.Get("Content-Encoding") == "" && bodyAllowed(w.status) {
+		header.Set("Content-Encoding", "gzip")
+		header.Del("Content-Length")
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	if len(w.buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.gz != nil {
+		_, err = w.gz.Write(w.buf)
+	} else {
+		_, err = w.ResponseWriter.Write(w.buf)
+	}
+	w.buf = nil
+	return err
+}
+
+// close sends a response too small to be compressed, or terminates the compressed stream.
+func (w *gzipResponseWriter) close() {
+	if !w.started {
+		w.start(false)
+	}
+	if w.gz != nil {
+		w.gz.Close()
+	}
+}
+
+// bodyAllowed reports whether a response with the given status may have a body.
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
@@ -0,0 +1,86 @@
+package router
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// respond returns a handler responding 200 with the given body.
+func respond(body string) HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request, params map[string]string) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, body)
+	}
+}
+
+func TestGzip(t *testing.T) {
+	large := `{"paths":[` + strings.Repeat(`"project:1#reader@user:alice",`, 100) + `""]}`
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		body     string
+		compress bool
+	}{
+		{"gzip accepted", map[string]string{"Accept-Encoding": "gzip, deflate"}, large, true},
+		{"no accept-encoding", nil, large, false},
+		{"other encoding", map[string]string{"Accept-Encoding": "br"}, large, false},
+		{"gzip refused", map[string]string{"Accept-Encoding": "gzip;q=0"}, large, false},
+		{"small body", map[string]string{"Accept-Encoding": "gzip"}, `{"allowed":true}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(Gzip(), respond(tt.body), tt.headers)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200", rec.Code)
+			}
+			if rec.Header().Get("Vary") != "Accept-Encoding" {
+				t.Errorf("Vary = %q, want Accept-Encoding", rec.Header().Get("Vary"))
+			}
+			body := rec.Body.String()
+			if tt.compress {
+				if rec.Header().Get("Content-Encoding") != "gzip" {
+					t.Fatalf("Content-Encoding = %q, want gzip", rec.Header().Get("Content-Encoding"))
+				}
+				zr, err := gzip.NewReader(rec.Body)
+				if err != nil {
+					t.Fatalf("invalid gzip body: %v", err)
+				}
+				raw, err := io.ReadAll(zr)
+				if err != nil {
+					t.Fatalf("invalid gzip body: %v", err)
+				}
+				body = string(raw)
+			} else if rec.Header().Get("Content-Encoding") != "" {
+				t.Errorf("Content-Encoding = %q, want identity", rec.Header().Get("Content-Encoding"))
+			}
+			if body != tt.body {
+				t.Errorf("body = %q, want %q", body, tt.body)
+			}
+		})
+	}
+}
+
+func TestGzipKeepsStatus(t *testing.T) {
+	notFound := func(w http.ResponseWriter, req *http.Request, params map[string]string) {
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+	rec := serve(Gzip(), notFound, map[string]string{"Accept-Encoding": "gzip"})
+	if rec.Code != http.StatusNotFound || rec.Body.String() != "not found\n" {
+		t.Errorf("status = %d (body: %q), want 404", rec.Code, rec.Body)
+	}
+}
+
+func TestGzipComposesWithAuth(t *testing.T) {
+	h := APIKeyAuth("key")(respond(strings.Repeat("a", gzipMinSize)))
+	rec := serve(Gzip(), h, map[string]string{"Accept-Encoding": "gzip", "X-API-Key": "key"})
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("status = %d, Content-Encoding = %q, want 200 gzip", rec.Code, rec.Header().Get("Content-Encoding"))
+	}
+	rec = serve(Gzip(), h, map[string]string{"Accept-Encoding": "gzip"})
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401 without a key", rec.Code)
+	}
+}
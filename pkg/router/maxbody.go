@@ -0,0 +1,21 @@
+package router
+
+import (
+	"net/http"
+)
+
+// MaxBodyBytes returns a middleware limiting request bodies to n bytes. Requests declaring a larger Content-Length
+// are rejected with 413 before reaching their handler; reading more than n bytes of other bodies (e.g. chunked ones)
+// fails with an *http.MaxBytesError, which handlers are expected to report with 413 too.
+func MaxBodyBytes(n int64) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request, params map[string]string) {
+			if req.ContentLength > n {
+				http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			req.Body = http.MaxBytesReader(w, req.Body, n)
+			next(w, req, params)
+		}
+	}
+}
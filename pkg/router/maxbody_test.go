@@ -0,0 +1,52 @@
+package router
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// readBody responds 413 if the body exceeds the limit, like the handlers decoding bodies.
+func readBody(w http.ResponseWriter, req *http.Request, params map[string]string) {
+	var maxErr *http.MaxBytesError
+	if _, err := io.ReadAll(req.Body); errors.As(err, &maxErr) {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestMaxBodyBytes(t *testing.T) {
+	tests := []struct {
+		name          string
+		body          string
+		contentLength int64
+		want          int
+	}{
+		{name: "within limit", body: strings.Repeat("a", 16), contentLength: 16, want: http.StatusOK},
+		{name: "oversized", body: strings.Repeat("a", 17), contentLength: 17, want: http.StatusRequestEntityTooLarge},
+		{name: "oversized without length", body: strings.Repeat("a", 1024), contentLength: -1, want: http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handlerCalled := false
+			handler := func(w http.ResponseWriter, req *http.Request, params map[string]string) {
+				handlerCalled = true
+				readBody(w, req, params)
+			}
+			req := httptest.NewRequest("POST", "/api/v1/relations", strings.NewReader(tt.body))
+			req.ContentLength = tt.contentLength
+			rec := httptest.NewRecorder()
+			MaxBodyBytes(16)(handler)(rec, req, nil)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if tt.contentLength > 16 && handlerCalled {
+				t.Error("handler called, want the request rejected on its Content-Length")
+			}
+		})
+	}
+}
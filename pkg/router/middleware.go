@@ -0,0 +1,46 @@
+package router
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// APIKeyAuth returns a middleware that accepts only requests carrying one of the given keys,
+// either as "Authorization: Bearer <key>" or as "X-API-Key: <key>".
+// Other requests are rejected with 401. With no keys, every request is rejected.
+func APIKeyAuth(keys ...string) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request, params map[string]string) {
+			if !validAPIKey(requestAPIKey(req), keys) {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next(w, req, params)
+		}
+	}
+}
+
+// requestAPIKey extracts the API key from the request headers, or returns "" if absent.
+func requestAPIKey(req *http.Request) string {
+	if auth := req.Header.Get("Authorization"); auth != "" {
+		if scheme, key, ok := strings.Cut(auth, " "); ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(key)
+		}
+	}
+	return req.Header.Get("X-API-Key")
+}
+
+// validAPIKey reports whether key matches one of the allowed keys.
+// Every allowed key is compared in constant time, so timing does not reveal which (if any) matched.
+func validAPIKey(key string, allowed []string) bool {
+	if key == "" {
+		return false
+	}
+	valid := 0
+	for _, k := range allowed {
+		valid |= subtle.ConstantTimeCompare([]byte(key), []byte(k))
+	}
+	return valid == 1
+}
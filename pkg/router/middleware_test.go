@@ -0,0 +1,86 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// ok is a handler responding 200.
+func ok(w http.ResponseWriter, req *http.Request, params map[string]string) {
+	w.WriteHeader(http.StatusOK)
+}
+
+// serve runs a request with the given headers through the middleware, and returns the recorded response.
+func serve(m Middleware, handler HandlerFunc, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/api/v1/permissions", nil)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+	m(handler)(rec, req, nil)
+	return rec
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	auth := APIKeyAuth("read-key", "write-key")
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    int
+	}{
+		{"missing", nil, http.StatusUnauthorized},
+		{"invalid bearer", map[string]string{"Authorization": "Bearer nope"}, http.StatusUnauthorized},
+		{"invalid header", map[string]string{"X-API-Key": "nope"}, http.StatusUnauthorized},
+		{"prefix of a key", map[string]string{"X-API-Key": "read"}, http.StatusUnauthorized},
+		{"other scheme", map[string]string{"Authorization": "Basic read-key"}, http.StatusUnauthorized},
+		{"valid bearer", map[string]string{"Authorization": "Bearer write-key"}, http.StatusOK},
+		{"valid bearer, lowercase scheme", map[string]string{"Authorization": "bearer read-key"}, http.StatusOK},
+		{"valid header", map[string]string{"X-API-Key": "read-key"}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(auth, ok, tt.headers)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") != "Bearer" {
+				t.Errorf("WWW-Authenticate = %q, want Bearer", rec.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestAPIKeyAuthWithoutKeys(t *testing.T) {
+	rec := serve(APIKeyAuth(), ok, map[string]string{"X-API-Key": ""})
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401 with no configured keys", rec.Code)
+	}
+}
+
+func TestAPIKeyAuthWriteRoutes(t *testing.T) {
+	// As in cmd/server: any key reads, only write keys write
+	r := NewRouter()
+	r.AddGlobalMiddleware(APIKeyAuth("read-key", "write-key"))
+	r.Handle("GET", "/relations", ok)
+	r.Handle("POST", "/relations", ok, APIKeyAuth("write-key"))
+
+	tests := []struct {
+		method, key string
+		want        int
+	}{
+		{"GET", "read-key", http.StatusOK},
+		{"GET", "write-key", http.StatusOK},
+		{"POST", "read-key", http.StatusUnauthorized},
+		{"POST", "write-key", http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "/relations", nil)
+		req.Header.Set("X-API-Key", tt.key)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s with %s: status = %d, want %d", tt.method, tt.key, rec.Code, tt.want)
+		}
+	}
+}
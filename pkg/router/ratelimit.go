@@ -0,0 +1,113 @@
+package router
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// limiterIdleTTL is how long an unused client bucket is kept before being evicted.
+const limiterIdleTTL = 10 * time.Minute
+
+// KeyFunc identifies the client a request belongs to; requests with the same key share a bucket.
+type KeyFunc func(*http.Request) string
+
+// ClientIPKey keys requests by the client IP address.
+func ClientIPKey(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// APIKeyKey returns a KeyFunc keying requests by the fingerprint of their API key if it is one of the given keys
+// (see APIKeyAuth), or else by client IP address: clients cannot get fresh buckets by sending made-up keys,
+// whether or not the rate limit applies before authentication.
+func APIKeyKey(keys ...string) KeyFunc {
+	return func(req *http.Request) string {
+		if key := requestAPIKey(req); validAPIKey(key, keys) {
+			return "key:" + apiKeyFingerprint(key)
+		}
+		return "ip:" + ClientIPKey(req)
+	}
+}
+
+// RateLimit returns a token-bucket middleware allowing each client rps requests per second,
+// with bursts of up to burst requests. Clients are identified by keyFunc (ClientIPKey if nil).
+// Rejected requests get 429 with a Retry-After header.
+func RateLimit(rps float64, burst int, keyFunc KeyFunc) Middleware {
+	if keyFunc == nil {
+		keyFunc = ClientIPKey
+	}
+	limiters := newLimiterSet(rate.Limit(rps), burst)
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request, params map[string]string) {
+			reservation := limiters.get(keyFunc(req)).Reserve()
+			if !reservation.OK() {
+				http.Error(w, "too many requests", http.StatusTooManyRequests)
+				return
+			}
+			if delay := reservation.Delay(); delay > 0 {
+				reservation.Cancel()
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+				http.Error(w, "too many requests", http.StatusTooManyRequests)
+				return
+			}
+			next(w, req, params)
+		}
+	}
+}
+
+// limiterSet holds one limiter per client key, evicting idle ones.
+type limiterSet struct {
+	mu        sync.Mutex
+	limit     rate.Limit
+	burst     int
+	clients   map[string]*clientLimiter
+	lastSweep time.Time
+}
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newLimiterSet(limit rate.Limit, burst int) *limiterSet {
+	return &limiterSet{
+		limit:     limit,
+		burst:     burst,
+		clients:   make(map[string]*clientLimiter),
+		lastSweep: time.Now(),
+	}
+}
+
+// get returns the limiter of a client, creating it if needed.
+func (s *limiterSet) get(key string) *rate.Limiter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.lastSweep) > limiterIdleTTL {
+		for k, c := range s.clients {
+			if now.Sub(c.lastSeen) > limiterIdleTTL {
+				delete(s.clients, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	c, ok := s.clients[key]
+	if !ok {
+		c = &clientLimiter{limiter: rate.NewLimiter(s.limit, s.burst)}
+		s.clients[key] = c
+	}
+	c.lastSeen = now
+	return c.limiter
+}
@@ -0,0 +1,93 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// limited sends a request from the client IP with the API key (if any) through the middleware,
+// and returns the response status.
+func limited(m Middleware, ip, key string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/api/v1/permissions", nil)
+	req.RemoteAddr = ip + ":51234"
+	if key != "" {
+		req.Header.Set("X-API-Key", key)
+	}
+	rec := httptest.NewRecorder()
+	m(ok)(rec, req, nil)
+	return rec
+}
+
+func TestRateLimitExhaustAndRecover(t *testing.T) {
+	limit := RateLimit(20, 2, nil)
+
+	for i := 0; i < 2; i++ {
+		if rec := limited(limit, "10.0.0.1", ""); rec.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d, want 200 within the burst", i, rec.Code)
+		}
+	}
+	rec := limited(limit, "10.0.0.1", "")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429 once the bucket is exhausted", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") != "1" {
+		t.Errorf("Retry-After = %q, want 1", rec.Header().Get("Retry-After"))
+	}
+
+	// Other clients have their own bucket
+	if rec := limited(limit, "10.0.0.2", ""); rec.Code != http.StatusOK {
+		t.Errorf("other client: status = %d, want 200", rec.Code)
+	}
+
+	// A token is added every 50ms
+	time.Sleep(60 * time.Millisecond)
+	if rec := limited(limit, "10.0.0.1", ""); rec.Code != http.StatusOK {
+		t.Errorf("after recovery: status = %d, want 200", rec.Code)
+	}
+}
+
+func TestRateLimitAPIKeyKey(t *testing.T) {
+	limit := RateLimit(0.001, 1, APIKeyKey("key-1", "key-2"))
+
+	// Made-up keys share the bucket of their client IP
+	if rec := limited(limit, "10.0.0.1", "made-up-1"); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if rec := limited(limit, "10.0.0.1", "made-up-2"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("status = %d, want 429 for another made-up key from the same IP", rec.Code)
+	}
+
+	// Valid keys have their own bucket, whatever the IP
+	if rec := limited(limit, "10.0.0.1", "key-1"); rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want 200 for a valid key", rec.Code)
+	}
+	if rec := limited(limit, "10.0.0.2", "key-1"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("status = %d, want 429 for the same key from another IP", rec.Code)
+	}
+	if rec := limited(limit, "10.0.0.1", "key-2"); rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want 200 for another valid key", rec.Code)
+	}
+}
+
+func TestAPIKeyKeyHashesKeys(t *testing.T) {
+	keyFunc := APIKeyKey("secret-key")
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.1:51234"
+	req.Header.Set("Authorization", "Bearer secret-key")
+
+	key := keyFunc(req)
+	if !strings.HasPrefix(key, "key:") || strings.Contains(key, "secret-key") {
+		t.Errorf("key = %q, want a fingerprint of the API key", key)
+	}
+	if key != "key:"+strings.TrimPrefix(APIKeyActor(req), "api-key:") {
+		t.Errorf("key = %q, want the fingerprint of APIKeyActor %q", key, APIKeyActor(req))
+	}
+
+	// Without configured keys, no key is trusted
+	if key := APIKeyKey()(req); key != "ip:10.0.0.1" {
+		t.Errorf("key without configured keys = %q, want ip:10.0.0.1", key)
+	}
+}
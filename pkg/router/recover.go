@@ -0,0 +1,68 @@
+package router
+
+import (
+	"log"
+	"net/http"
+	"runtime/debug"
+)
+
+// requestIDHeader is the header a client or proxy may set to correlate a request with server logs.
+const requestIDHeader = "X-Request-ID"
+
+// Recover returns a middleware recovering from panics in the handlers it wraps: the panic is logged
+// with the request (and its X-Request-ID header, if any) and a stack trace, and the client gets a 500
+// JSON error instead of a dropped connection. If the response had already started, it is left as is.
+// http.ErrAbortHandler is re-panicked, as it is meant to abort the response.
+func Recover() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request, params map[string]string) {
+			rw := &recoverResponseWriter{ResponseWriter: w}
+			defer func() {
+				p := recover()
+				if p == nil {
+					return
+				}
+				if p == http.ErrAbortHandler {
+					panic(p)
+				}
+				log.Printf("[ERROR] router.Recover: panic serving %s %s (request ID %q): %v\n%s",
+					req.Method, req.URL.Path, req.Header.Get(requestIDHeader), p, debug.Stack())
+				if rw.wroteHeader {
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(`{"error":"internal server error"}`))
+			}()
+			next(rw, req, params)
+		}
+	}
+}
+
+// recoverResponseWriter records whether the response has started.
+type recoverResponseWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *recoverResponseWriter) WriteHeader(status int) {
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recoverResponseWriter) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *recoverResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.wroteHeader = true
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the underlying writer, for http.ResponseController.
+func (w *recoverResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,43 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestRecover(t *testing.T) {
+	panics := func(w http.ResponseWriter, req *http.Request, params map[string]string) {
+		var paths []string
+		_ = paths[0]
+	}
+	rec := serve(Recover(), panics, map[string]string{"X-Request-ID": "req-1"})
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", rec.Code)
+	}
+	if rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", rec.Header().Get("Content-Type"))
+	}
+	var body map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body["error"] == "" {
+		t.Errorf("body = %s (%v), want a JSON error", rec.Body, err)
+	}
+}
+
+func TestRecoverWithoutPanic(t *testing.T) {
+	rec := serve(Recover(), ok, nil)
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want 200", rec.Code)
+	}
+}
+
+func TestRecoverAfterResponseStarted(t *testing.T) {
+	panics := func(w http.ResponseWriter, req *http.Request, params map[string]string) {
+		w.WriteHeader(http.StatusAccepted)
+		panic("late failure")
+	}
+	rec := serve(Recover(), panics, nil)
+	if rec.Code != http.StatusAccepted || rec.Body.Len() != 0 {
+		t.Errorf("status = %d (body: %s), want the started 202 response left as is", rec.Code, rec.Body)
+	}
+}
@@ -74,12 +74,17 @@ func match(pattern string, req *http.Request) (map[string]string, bool) {
 		return nil, false
 	}
 
-	// Extract path parameters
+	// Extract path parameters: a parameter may be followed by a literal suffix, as in "{permission}:check"
 	params := make(map[string]string)
 	for i := range patternParts {
-		if strings.HasPrefix(patternParts[i], "{") && strings.HasSuffix(patternParts[i], "}") {
-			paramName := patternParts[i][1 : len(patternParts[i])-1]
-			params[paramName] = pathParts[i]
+		end := strings.Index(patternParts[i], "}")
+		if strings.HasPrefix(patternParts[i], "{") && end > 0 {
+			paramName, suffix := patternParts[i][1:end], patternParts[i][end+1:]
+			value, found := strings.CutSuffix(pathParts[i], suffix)
+			if !found || value == "" && suffix != "" {
+				return nil, false
+			}
+			params[paramName] = value
 		} else if patternParts[i] != pathParts[i] {
 			return nil, false
 		}
@@ -0,0 +1,55 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouterParamSuffix(t *testing.T) {
+	var got map[string]string
+	r := NewRouter()
+	record := func(route string) HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request, params map[string]string) {
+			got = map[string]string{"route": route, "permission": params["permission"]}
+		}
+	}
+	r.Handle("POST", "/permissions/{permission}", record("plain"))
+	r.Handle("POST", "/permissions/{permission}:check", record("suffixed"))
+
+	tests := []struct {
+		path       string
+		route      string
+		permission string
+	}{
+		{"/permissions/read:check", "plain", "read:check"}, // routes are matched in order
+		{"/permissions/read", "plain", "read"},
+	}
+	for _, tt := range tests {
+		got = nil
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", tt.path, nil))
+		if got["route"] != tt.route || got["permission"] != tt.permission {
+			t.Errorf("%s: got %v, want route %s with permission %q", tt.path, got, tt.route, tt.permission)
+		}
+	}
+
+	// A suffixed parameter only matches paths with the suffix, and a value before it
+	r = NewRouter()
+	r.Handle("POST", "/permissions/{permission}:check", record("suffixed"))
+	for path, want := range map[string]int{
+		"/permissions/read:check": http.StatusOK,
+		"/permissions/read":       http.StatusNotFound,
+		"/permissions/:check":     http.StatusNotFound,
+		"/permissions/read:chec":  http.StatusNotFound,
+	} {
+		got = nil
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest("POST", path, nil))
+		if rec.Code != want {
+			t.Errorf("%s: status = %d, want %d", path, rec.Code, want)
+		}
+		if want == http.StatusOK && got["permission"] != "read" {
+			t.Errorf("%s: permission = %q, want read", path, got["permission"])
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Timeout returns a middleware limiting handlers to d: the request context is cancelled after d, which cancels
+// the database queries run with it, and the client gets a 503 JSON error as soon as d elapses, even if the handler
+// does not return yet. What the handler writes afterwards is discarded (writes fail with http.ErrHandlerTimeout).
+// If the response had already started, it is left as is: the handler is waited for, and is expected to stop
+// once the context is done. Streaming endpoints, which respond for as long as the client listens, should not use it.
+func Timeout(d time.Duration) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request, params map[string]string) {
+			ctx, cancel := context.WithTimeout(req.Context(), d)
+			defer cancel()
+
+			tw := &timeoutResponseWriter{w: w, header: make(http.Header)}
+			done := make(chan struct{})
+			panicked := make(chan interface{}, 1)
+			go func() {
+				defer func() {
+					if p := recover(); p != nil {
+						panicked <- p
+					}
+				}()
+				next(tw, req.WithContext(ctx), params)
+				close(done)
+			}()
+
+			select {
+			case <-done:
+			case p := <-panicked:
+				// Re-panic in the serving goroutine, for Recover
+				panic(p)
+			case <-ctx.Done():
+				select {
+				case <-done:
+					return
+				default:
+				}
+				tw.mu.Lock()
+				if tw.started {
+					tw.mu.Unlock()
+					select {
+					case <-done:
+					case p := <-panicked:
+						panic(p)
+					}
+					return
+				}
+				tw.timedOut = true
+				tw.mu.Unlock()
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write([]byte(`{"error":"request timed out"}`))
+			}
+		}
+	}
+}
+
+// timeoutResponseWriter forwards the response of a handler until its request times out.
+// The handler sets headers on its own map, copied when the response starts, so that it never
+// races with the timeout response.
+type timeoutResponseWriter struct {
+	w      http.ResponseWriter
+	header http.Header
+
+	mu       sync.Mutex
+	started  bool
+	timedOut bool
+}
+
+func (tw *timeoutResponseWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutResponseWriter) WriteHeader(status int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.started {
+		return
+	}
+	tw.start()
+	tw.w.WriteHeader(status)
+}
+
+func (tw *timeoutResponseWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if !tw.started {
+		tw.start()
+	}
+	return tw.w.Write(p)
+}
+
+func (tw *timeoutResponseWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return
+	}
+	if flusher, ok := tw.w.(http.Flusher); ok {
+		if !tw.started {
+			tw.start()
+		}
+		flusher.Flush()
+	}
+}
+
+// start copies the headers set by the handler to the response. It must be called with mu held.
+func (tw *timeoutResponseWriter) start() {
+	tw.started = true
+	dst := tw.w.Header()
+	for name, values := range tw.header {
+		dst[name] = values
+	}
+}
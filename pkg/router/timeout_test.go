@@ -0,0 +1,87 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTimeout(t *testing.T) {
+	handlerErr := make(chan error, 1)
+	slow := func(w http.ResponseWriter, req *http.Request, params map[string]string) {
+		<-req.Context().Done()
+		// Like a handler failing on its cancelled query
+		time.Sleep(10 * time.Millisecond)
+		w.WriteHeader(http.StatusInternalServerError)
+		_, err := w.Write([]byte("query cancelled"))
+		handlerErr <- errors.Join(req.Context().Err(), err)
+	}
+	rec := serve(Timeout(20*time.Millisecond), slow, nil)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503", rec.Code)
+	}
+	if rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", rec.Header().Get("Content-Type"))
+	}
+	var body map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body["error"] == "" {
+		t.Errorf("body = %s (%v), want a JSON error", rec.Body, err)
+	}
+
+	err := <-handlerErr
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, http.ErrHandlerTimeout) {
+		t.Errorf("handler errors = %v, want its context past deadline and its late write discarded", err)
+	}
+}
+
+func TestTimeoutDoesNotWaitForHandler(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	stuck := func(w http.ResponseWriter, req *http.Request, params map[string]string) {
+		<-release
+	}
+	start := time.Now()
+	rec := serve(Timeout(20*time.Millisecond), stuck, nil)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want 503", rec.Code)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("responded after %v, want as soon as the request timed out", elapsed)
+	}
+}
+
+func TestTimeoutWithinDeadline(t *testing.T) {
+	fast := func(w http.ResponseWriter, req *http.Request, params map[string]string) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("done"))
+	}
+	rec := serve(Timeout(time.Second), fast, nil)
+	if rec.Code != http.StatusAccepted || rec.Body.String() != "done" || rec.Header().Get("Content-Type") != "text/plain" {
+		t.Errorf("status = %d, Content-Type = %q (body: %s), want the handler response", rec.Code, rec.Header().Get("Content-Type"), rec.Body)
+	}
+}
+
+func TestTimeoutAfterResponseStarted(t *testing.T) {
+	streaming := func(w http.ResponseWriter, req *http.Request, params map[string]string) {
+		w.Write([]byte("partial"))
+		<-req.Context().Done()
+	}
+	rec := serve(Timeout(20*time.Millisecond), streaming, nil)
+	if rec.Code != http.StatusOK || rec.Body.String() != "partial" {
+		t.Errorf("status = %d (body: %s), want the started response left as is", rec.Code, rec.Body)
+	}
+}
+
+func TestTimeoutPanic(t *testing.T) {
+	panics := func(w http.ResponseWriter, req *http.Request, params map[string]string) {
+		panic("handler failure")
+	}
+	rec := serve(func(next HandlerFunc) HandlerFunc { return Recover()(Timeout(time.Second)(next)) }, panics, nil)
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500 from Recover", rec.Code)
+	}
+}